@@ -5,55 +5,144 @@ import (
 	"net/http"
 )
 
+// Data halaman login admin
+type adminLoginPage struct {
+	CSRFToken string
+	Next      string
+	Error     string
+}
+
 // Handler untuk halaman login admin
-func adminLoginHandler(w http.ResponseWriter, _ *http.Request) {
+func adminLoginHandler(w http.ResponseWriter, data adminLoginPage) {
 	tmpl := template.Must(template.New("login").Parse(`
 	<!DOCTYPE html>
 	<html><head><title>Admin Login</title></head><body>
 	<h2>Login Admin Panel</h2>
+	{{if .Error}}<p style="color: #dc3545;">{{.Error}}</p>{{end}}
 	<form method="POST" action="/admin/login">
+	  <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+	  <input type="hidden" name="next" value="{{.Next}}">
 	  <input type="text" name="username" placeholder="Username" required><br><br>
 	  <input type="password" name="password" placeholder="Password" required><br><br>
 	  <button type="submit">Login</button>
 	</form>
 	</body></html>
 	`))
-	if err := tmpl.Execute(w, nil); err != nil {
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Data halaman verifikasi dua langkah; Secret dan ProvisioningURI hanya diisi
+// saat admin wajib mendaftarkan aplikasi autentikator terlebih dahulu
+type adminTOTPPage struct {
+	CSRFToken       string
+	ChallengeToken  string
+	Next            string
+	Secret          string
+	ProvisioningURI string
+	Error           string
+}
+
+// Handler untuk halaman verifikasi dua langkah
+func adminTOTPHandler(w http.ResponseWriter, data adminTOTPPage) {
+	tmpl := template.Must(template.New("totp").Parse(`
+	<!DOCTYPE html>
+	<html><head><title>Verifikasi Dua Langkah</title></head><body>
+	<h2>Verifikasi Dua Langkah</h2>
+	{{if .Error}}<p style="color: #dc3545;">{{.Error}}</p>{{end}}
+	{{if .Secret}}
+	<p>Peran Anda mewajibkan verifikasi dua langkah. Tambahkan akun ini ke aplikasi autentikator,
+	lalu masukkan kode yang ditampilkan.</p>
+	<p>Secret: <code>{{.Secret}}</code></p>
+	<p>URI: <code>{{.ProvisioningURI}}</code></p>
+	{{else}}
+	<p>Masukkan kode dari aplikasi autentikator, atau salah satu kode pemulihan.</p>
+	{{end}}
+	<form method="POST" action="/admin/login/totp">
+	  <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+	  <input type="hidden" name="challenge_token" value="{{.ChallengeToken}}">
+	  <input type="hidden" name="next" value="{{.Next}}">
+	  {{if .Secret}}
+	  <input type="hidden" name="secret" value="{{.Secret}}">
+	  <input type="hidden" name="provisioning_uri" value="{{.ProvisioningURI}}">
+	  {{end}}
+	  <input type="text" name="code" placeholder="Kode autentikasi" inputmode="numeric" autocomplete="one-time-code" autofocus><br><br>
+	  {{if not .Secret}}
+	  <input type="text" name="recovery_code" placeholder="Kode pemulihan" autocomplete="off"><br><br>
+	  {{end}}
+	  <button type="submit">Verifikasi</button>
+	</form>
+	<p><a href="/admin/login">Kembali ke login</a></p>
+	</body></html>
+	`))
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Data halaman kode pemulihan yang ditampilkan sekali setelah pendaftaran
+type adminRecoveryCodesPage struct {
+	Codes []string
+	Next  string
+}
+
+// Handler untuk halaman kode pemulihan
+func adminRecoveryCodesHandler(w http.ResponseWriter, data adminRecoveryCodesPage) {
+	tmpl := template.Must(template.New("recovery-codes").Parse(`
+	<!DOCTYPE html>
+	<html><head><title>Kode Pemulihan</title></head><body>
+	<h2>Kode Pemulihan</h2>
+	<p>Verifikasi dua langkah sudah aktif. Simpan kode berikut di tempat aman; setiap kode hanya
+	bisa dipakai sekali jika aplikasi autentikator tidak tersedia. Kode ini tidak akan ditampilkan lagi.</p>
+	<ul>
+	{{range .Codes}}<li><code>{{.}}</code></li>{{end}}
+	</ul>
+	<a href="{{.Next}}">Lanjut ke Admin Panel</a>
+	</body></html>
+	`))
+	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 }
 
 // Handler untuk dashboard admin
-func adminDashboardHandler(w http.ResponseWriter, _ *http.Request) {
+func adminDashboardHandler(w http.ResponseWriter, csrfToken string) {
 	tmpl := template.Must(template.New("dashboard").Parse(`
 	<!DOCTYPE html>
 	<html><head><title>Admin Dashboard</title></head><body>
 	<h2>Dashboard Admin (Go)</h2>
 	<p>Selamat datang di admin panel sederhana berbasis Go.</p>
 	<a href="/admin/users">Kelola Users</a><br><br>
-	<a href="/admin/logout">Logout</a>
+	<form method="POST" action="/admin/logout">
+	  <input type="hidden" name="csrf_token" value="{{.}}">
+	  <button type="submit">Logout</button>
+	</form>
 	</body></html>
 	`))
-	if err := tmpl.Execute(w, nil); err != nil {
+	if err := tmpl.Execute(w, csrfToken); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 }
 
 // Handler untuk halaman admin users
-func adminUsersHandler(w http.ResponseWriter, _ *http.Request) {
+func adminUsersHandler(w http.ResponseWriter, csrfToken string) {
 	tmpl := template.Must(template.New("users").Parse(`
 	<!DOCTYPE html>
 	<html>
 	<head>
 		<title>Admin Users</title>
+		<meta name="csrf-token" content="{{.}}">
 		<style>
 			body { font-family: Arial, sans-serif; margin: 20px; }
 			table { border-collapse: collapse; width: 100%; }
 			th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
 			th { background-color: #f2f2f2; }
-			.btn { padding: 5px 10px; margin: 2px; text-decoration: none; border-radius: 3px; }
+			.btn { padding: 5px 10px; margin: 2px; text-decoration: none; border-radius: 3px; border: none; cursor: pointer; }
 			.btn-primary { background-color: #007bff; color: white; }
 			.btn-danger { background-color: #dc3545; color: white; }
 		</style>
@@ -67,49 +156,81 @@ func adminUsersHandler(w http.ResponseWriter, _ *http.Request) {
 		</div>
 
 		<script>
+			// Sesi panel dikirim lewat cookie; setiap perubahan wajib membawa token CSRF
+			const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
+
+			function api(url, options) {
+				options = options || {};
+				options.credentials = 'same-origin';
+				options.headers = Object.assign({ 'Accept': 'application/json', 'X-CSRF-Token': csrfToken }, options.headers);
+				return fetch(url, options).then(response => {
+					if (response.status === 401) {
+						// Token akses kedaluwarsa; memuat ulang halaman memperbarui sesi atau membuka halaman login
+						location.reload();
+						throw new Error('Sesi berakhir');
+					}
+					return response.json();
+				});
+			}
+
+			function cell(row, text) {
+				const td = document.createElement('td');
+				td.textContent = text;
+				row.appendChild(td);
+				return td;
+			}
+
+			function button(parent, label, className, onClick) {
+				const btn = document.createElement('button');
+				btn.type = 'button';
+				btn.className = 'btn ' + className;
+				btn.textContent = label;
+				btn.addEventListener('click', onClick);
+				parent.appendChild(btn);
+			}
+
 			// Fetch users from API
-			fetch('/api/users')
-				.then(response => response.json())
+			api('/api/users')
 				.then(data => {
 					const container = document.getElementById('users-container');
 					if (data.success && data.data && data.data.length > 0) {
-						let html = '<table><thead><tr><th>ID</th><th>Email</th><th>Full Name</th><th>Role</th><th>Active</th><th>Created At</th><th>Actions</th></tr></thead><tbody>';
+						const table = document.createElement('table');
+						table.innerHTML = '<thead><tr><th>ID</th><th>Email</th><th>Full Name</th><th>Role</th><th>Active</th><th>Created At</th><th>Actions</th></tr></thead>';
+						const body = document.createElement('tbody');
 						data.data.forEach(user => {
-							html += '<tr>';
-							html += '<td>' + user.id + '</td>';
-							html += '<td>' + user.email + '</td>';
-							html += '<td>' + user.full_name + '</td>';
-							html += '<td>' + user.role + '</td>';
-							html += '<td>' + (user.is_active ? 'Yes' : 'No') + '</td>';
-							html += '<td>' + new Date(user.created_at).toLocaleDateString() + '</td>';
-							html += '<td>';
-							html += '<a href="#" class="btn btn-primary" onclick="toggleUser(' + user.id + ')">Toggle Status</a> ';
-							html += '<a href="#" class="btn btn-danger" onclick="deleteUser(' + user.id + ')">Delete</a>';
-							html += '</td>';
-							html += '</tr>';
+							const row = document.createElement('tr');
+							cell(row, user.id);
+							cell(row, user.email);
+							cell(row, user.fullName);
+							cell(row, user.role);
+							cell(row, user.isActive ? 'Yes' : 'No');
+							cell(row, new Date(user.createdAt).toLocaleDateString());
+							const actions = cell(row, '');
+							button(actions, 'Toggle Status', 'btn-primary', () => toggleUser(user.id));
+							button(actions, 'Delete', 'btn-danger', () => deleteUser(user.id));
+							body.appendChild(row);
 						});
-						html += '</tbody></table>';
-						container.innerHTML = html;
+						table.appendChild(body);
+						container.replaceChildren(table);
 					} else {
 						container.innerHTML = '<p>No users found or error loading users.</p>';
 					}
 				})
 				.catch(error => {
 					console.error('Error:', error);
-					document.getElementById('users-container').innerHTML = '<p>Error loading users: ' + error.message + '</p>';
+					document.getElementById('users-container').textContent = 'Error loading users: ' + error.message;
 				});
 
 			function toggleUser(userId) {
 				if (confirm('Are you sure you want to toggle this user status?')) {
-					fetch('/api/users/' + userId + '/toggle-status', {
+					api('/api/users/' + encodeURIComponent(userId) + '/toggle-status', {
 						method: 'PATCH'
 					})
-					.then(response => response.json())
 					.then(data => {
 						if (data.success) {
 							location.reload();
 						} else {
-							alert('Error: ' + data.message);
+							alert('Error: ' + (data.message || data.error));
 						}
 					})
 					.catch(error => {
@@ -119,16 +240,15 @@ func adminUsersHandler(w http.ResponseWriter, _ *http.Request) {
 			}
 
 			function deleteUser(userId) {
-				if (confirm('Are you sure you want to delete this user? This action cannot be undone.')) {
-					fetch('/api/users/' + userId, {
+				if (confirm('Are you sure you want to delete this user? They can be restored until the retention period ends.')) {
+					api('/api/users/' + encodeURIComponent(userId), {
 						method: 'DELETE'
 					})
-					.then(response => response.json())
 					.then(data => {
 						if (data.success) {
 							location.reload();
 						} else {
-							alert('Error: ' + data.message);
+							alert('Error: ' + (data.message || data.error));
 						}
 					})
 					.catch(error => {
@@ -140,7 +260,7 @@ func adminUsersHandler(w http.ResponseWriter, _ *http.Request) {
 	</body>
 	</html>
 	`))
-	if err := tmpl.Execute(w, nil); err != nil {
+	if err := tmpl.Execute(w, csrfToken); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
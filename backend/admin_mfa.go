@@ -0,0 +1,816 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxMFAChallengeAttempts is how many wrong codes end a login challenge
+const maxMFAChallengeAttempts = 5
+
+var (
+	// ErrInvalidMFAChallenge is returned for an unknown, used or expired login challenge
+	ErrInvalidMFAChallenge = errors.New("invalid or expired login challenge")
+	// ErrInvalidMFACode is returned for a wrong TOTP or recovery code
+	ErrInvalidMFACode = errors.New("invalid authentication code")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user who already has TOTP
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnabled is returned for TOTP operations on a user without TOTP
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTOTPEnrollmentNotStarted is returned when confirming without a pending secret
+	ErrTOTPEnrollmentNotStarted = errors.New("two-factor enrolment has not been started")
+	// ErrTOTPRequired is returned when disabling TOTP for a role that requires it
+	ErrTOTPRequired = errors.New("two-factor authentication is required for this role")
+	// ErrAdminUserNotFound is returned when an admin user does not exist
+	ErrAdminUserNotFound = errors.New("admin user not found")
+)
+
+// LoginResult is the outcome of a correct password: either a session, or a
+// challenge to complete with a TOTP code first
+type LoginResult struct {
+	Tokens    *TokenPair
+	Challenge *MFAChallenge
+}
+
+// MFAChallenge is the second login step for a user with TOTP enabled or required
+type MFAChallenge struct {
+	Token     string    `json:"challengeToken"`
+	ExpiresAt time.Time `json:"challengeExpiresAt"`
+	// EnrollmentRequired means the user's role requires TOTP but none is set up;
+	// the challenge can then start enrolment, and the first code completes it
+	EnrollmentRequired bool `json:"enrollmentRequired"`
+}
+
+// MFALoginResult is returned when a challenge is completed
+type MFALoginResult struct {
+	Tokens *TokenPair
+	// RecoveryCodes is set when completing the challenge also finished enrolment
+	RecoveryCodes []string
+}
+
+// TOTPEnrollment is what an authenticator app needs to add the account
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// TOTPStatus describes a user's two-factor setup
+type TOTPStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabledAt"`
+	Required               bool       `json:"required"`
+	RecoveryCodesRemaining int        `json:"recoveryCodesRemaining"`
+}
+
+// totpState is the TOTP columns of an admin user
+type totpState struct {
+	secret        *string
+	pendingSecret *string
+	enabledAt     *time.Time
+	lastStep      int64
+}
+
+func (s *totpState) enabled() bool {
+	return s.secret != nil && s.enabledAt != nil
+}
+
+// parseTOTPRequiredRoles parses ADMIN_TOTP_REQUIRED_ROLES, a comma separated role list
+func parseTOTPRequiredRoles(value string) map[string]bool {
+	roles := make(map[string]bool)
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles[role] = true
+		}
+	}
+	return roles
+}
+
+// TOTPRequired reports whether role must use two-factor authentication
+func (a *AuthService) TOTPRequired(role string) bool {
+	return a.totpRequiredRoles[role]
+}
+
+// getTOTPState loads a user's TOTP columns, locking the row when forUpdate is set
+func getTOTPState(tx *sql.Tx, userID string, forUpdate bool) (*totpState, error) {
+	query := `
+		SELECT totp_secret, totp_pending_secret, totp_enabled_at, totp_last_step
+		FROM admin_users
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var state totpState
+	err := tx.QueryRow(query, userID).Scan(&state.secret, &state.pendingSecret, &state.enabledAt, &state.lastStep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TOTP state: %w", err)
+	}
+	return &state, nil
+}
+
+// withTx runs fn in a transaction, committing when it returns nil
+func (a *AuthService) withTx(name string, fn func(tx *sql.Tx) error) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in "+name, logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// completeLogin starts a session once every login step has passed
+func (a *AuthService) completeLogin(user *AdminUser, client SessionClient) (*TokenPair, error) {
+	if err := a.UpdateLastLogin(user.ID); err != nil {
+		// Log error but don't fail authentication
+		LogWarn("Failed to update last login", logrus.Fields{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+	}
+
+	tokens, err := a.StartSession(user, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	return tokens, nil
+}
+
+// passwordVerified decides the next login step for a user whose password was correct
+func (a *AuthService) passwordVerified(user *AdminUser, client SessionClient) (*LoginResult, error) {
+	var enabled bool
+	err := a.db.QueryRow(
+		"SELECT totp_secret IS NOT NULL AND totp_enabled_at IS NOT NULL FROM admin_users WHERE id = $1", user.ID,
+	).Scan(&enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TOTP state: %w", err)
+	}
+
+	if !enabled && !a.TOTPRequired(user.Role) {
+		tokens, err := a.completeLogin(user, client)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{Tokens: tokens}, nil
+	}
+
+	token, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate login challenge: %w", err)
+	}
+	challenge := &MFAChallenge{
+		Token:              token,
+		ExpiresAt:          time.Now().Add(a.mfaChallengeTTL),
+		EnrollmentRequired: !enabled,
+	}
+
+	query := `INSERT INTO admin_mfa_challenges (admin_user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	if _, err := a.db.Exec(query, user.ID, hashToken(token), challenge.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store login challenge: %w", err)
+	}
+	return &LoginResult{Challenge: challenge}, nil
+}
+
+// loadMFAChallenge locks a usable challenge and returns its ID, user and attempts
+func loadMFAChallenge(tx *sql.Tx, token string) (string, string, int, error) {
+	var id, userID string
+	var attempts int
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err := tx.QueryRow(`
+		SELECT id, admin_user_id, attempts, expires_at, used_at
+		FROM admin_mfa_challenges
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, hashToken(token)).Scan(&id, &userID, &attempts, &expiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", 0, ErrInvalidMFAChallenge
+		}
+		return "", "", 0, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	if usedAt != nil || !time.Now().Before(expiresAt) || attempts >= maxMFAChallengeAttempts {
+		return "", "", 0, ErrInvalidMFAChallenge
+	}
+	return id, userID, attempts, nil
+}
+
+// MFAChallengeUsername returns the username a usable challenge was issued to
+func (a *AuthService) MFAChallengeUsername(token string) (string, error) {
+	var username string
+	err := a.db.QueryRow(`
+		SELECT u.username
+		FROM admin_mfa_challenges c
+		JOIN admin_users u ON u.id = c.admin_user_id
+		WHERE c.token_hash = $1 AND c.used_at IS NULL AND c.expires_at > CURRENT_TIMESTAMP
+		AND c.attempts < $2 AND u.is_active = true
+	`, hashToken(token), maxMFAChallengeAttempts).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidMFAChallenge
+		}
+		return "", fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	return username, nil
+}
+
+// BeginChallengeEnrollment starts TOTP enrolment from a login challenge, for a
+// user whose role requires TOTP but who has none yet
+func (a *AuthService) BeginChallengeEnrollment(token string) (*TOTPEnrollment, error) {
+	var enrollment *TOTPEnrollment
+	err := a.withTx("BeginChallengeEnrollment", func(tx *sql.Tx) error {
+		_, userID, _, err := loadMFAChallenge(tx, token)
+		if err != nil {
+			return err
+		}
+		user, err := a.getActiveAdminUserByID(tx, userID)
+		if err != nil {
+			return ErrInvalidMFAChallenge
+		}
+		enrollment, err = a.setPendingTOTPSecret(tx, user)
+		return err
+	})
+	return enrollment, err
+}
+
+// VerifyMFAChallenge completes a login challenge with a TOTP code or a
+// recovery code. A wrong code counts against the challenge.
+func (a *AuthService) VerifyMFAChallenge(token, code, recoveryCode string, client SessionClient) (*MFALoginResult, error) {
+	var user *AdminUser
+	var recoveryCodes []string
+	var codeErr error
+	err := a.withTx("VerifyMFAChallenge", func(tx *sql.Tx) error {
+		challengeID, userID, _, err := loadMFAChallenge(tx, token)
+		if err != nil {
+			return err
+		}
+		user, err = a.getActiveAdminUserByID(tx, userID)
+		if err != nil {
+			return ErrInvalidMFAChallenge
+		}
+		state, err := getTOTPState(tx, userID, true)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case state.enabled() && recoveryCode != "":
+			codeErr = useRecoveryCode(tx, userID, recoveryCode)
+		case state.enabled():
+			codeErr = acceptTOTPCode(tx, userID, *state.secret, code, state.lastStep)
+		case state.pendingSecret != nil:
+			// Enrolment required at login: the first valid code confirms it
+			recoveryCodes, codeErr = a.enableTOTP(tx, userID, *state.pendingSecret, code)
+		default:
+			return ErrTOTPEnrollmentNotStarted
+		}
+
+		if codeErr != nil {
+			if !errors.Is(codeErr, ErrInvalidMFACode) {
+				return codeErr
+			}
+			_, err = tx.Exec("UPDATE admin_mfa_challenges SET attempts = attempts + 1 WHERE id = $1", challengeID)
+			if err != nil {
+				return fmt.Errorf("failed to count challenge attempt: %w", err)
+			}
+			return nil
+		}
+
+		if _, err = tx.Exec("UPDATE admin_mfa_challenges SET used_at = CURRENT_TIMESTAMP WHERE id = $1", challengeID); err != nil {
+			return fmt.Errorf("failed to mark login challenge used: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if codeErr != nil {
+		return nil, codeErr
+	}
+
+	if recoveryCode != "" {
+		LogSecurity("admin_recovery_code_used", logrus.Fields{
+			"admin_user_id": user.ID,
+			"username":      user.Username,
+		})
+	}
+
+	tokens, err := a.completeLogin(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &MFALoginResult{Tokens: tokens, RecoveryCodes: recoveryCodes}, nil
+}
+
+// acceptTOTPCode verifies a code and records its step so it cannot be reused
+func acceptTOTPCode(tx *sql.Tx, userID, secret, code string, lastStep int64) error {
+	step, ok := verifyTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if _, err := tx.Exec("UPDATE admin_users SET totp_last_step = $2 WHERE id = $1", userID, step); err != nil {
+		return fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+	return nil
+}
+
+// useRecoveryCode consumes one unused recovery code
+func useRecoveryCode(tx *sql.Tx, userID, code string) error {
+	result, err := tx.Exec(`
+		UPDATE admin_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE admin_user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes discards a user's recovery codes and stores a new set
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM admin_recovery_codes WHERE admin_user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err = tx.Exec("INSERT INTO admin_recovery_codes (admin_user_id, code_hash) VALUES ($1, $2)", userID, hashToken(code))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// setPendingTOTPSecret generates the secret for a new enrolment
+func (a *AuthService) setPendingTOTPSecret(tx *sql.Tx, user *AdminUser) (*TOTPEnrollment, error) {
+	state, err := getTOTPState(tx, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	if state.enabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	if _, err = tx.Exec("UPDATE admin_users SET totp_pending_secret = $2 WHERE id = $1", user.ID, secret); err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(a.totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// enableTOTP confirms a pending secret with its first code and issues recovery codes
+func (a *AuthService) enableTOTP(tx *sql.Tx, userID, pendingSecret, code string) ([]string, error) {
+	step, ok := verifyTOTP(pendingSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err := tx.Exec(`
+		UPDATE admin_users
+		SET totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+		    totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = $2
+		WHERE id = $1
+	`, userID, step)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	LogSecurity("admin_totp_enabled", logrus.Fields{
+		"admin_user_id": userID,
+	})
+	return codes, nil
+}
+
+// GetTOTPStatus describes the two-factor setup of user
+func (a *AuthService) GetTOTPStatus(userID, role string) (*TOTPStatus, error) {
+	status := &TOTPStatus{Required: a.TOTPRequired(role)}
+	err := a.db.QueryRow(`
+		SELECT totp_secret IS NOT NULL AND totp_enabled_at IS NOT NULL, totp_enabled_at,
+		       (SELECT COUNT(*) FROM admin_recovery_codes WHERE admin_user_id = $1 AND used_at IS NULL)
+		FROM admin_users
+		WHERE id = $1
+	`, userID).Scan(&status.Enabled, &status.EnabledAt, &status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TOTP status: %w", err)
+	}
+	return status, nil
+}
+
+// StartTOTPEnrollment begins enrolment for a signed-in user
+func (a *AuthService) StartTOTPEnrollment(userID string) (*TOTPEnrollment, error) {
+	var enrollment *TOTPEnrollment
+	err := a.withTx("StartTOTPEnrollment", func(tx *sql.Tx) error {
+		user, err := a.getActiveAdminUserByID(tx, userID)
+		if err != nil {
+			return err
+		}
+		enrollment, err = a.setPendingTOTPSecret(tx, user)
+		return err
+	})
+	return enrollment, err
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the first code from the app checks out
+func (a *AuthService) ConfirmTOTPEnrollment(userID, code string) ([]string, error) {
+	var codes []string
+	err := a.withTx("ConfirmTOTPEnrollment", func(tx *sql.Tx) error {
+		state, err := getTOTPState(tx, userID, true)
+		if err != nil {
+			return err
+		}
+		if state.enabled() {
+			return ErrTOTPAlreadyEnabled
+		}
+		if state.pendingSecret == nil {
+			return ErrTOTPEnrollmentNotStarted
+		}
+		codes, err = a.enableTOTP(tx, userID, *state.pendingSecret, code)
+		return err
+	})
+	return codes, err
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking a current TOTP code
+func (a *AuthService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	var codes []string
+	err := a.withTx("RegenerateRecoveryCodes", func(tx *sql.Tx) error {
+		state, err := getTOTPState(tx, userID, true)
+		if err != nil {
+			return err
+		}
+		if !state.enabled() {
+			return ErrTOTPNotEnabled
+		}
+		if err = acceptTOTPCode(tx, userID, *state.secret, code, state.lastStep); err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// clearTOTP removes a user's TOTP secret, recovery codes and open challenges
+func clearTOTP(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`
+		UPDATE admin_users
+		SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear TOTP: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM admin_recovery_codes WHERE admin_user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM admin_mfa_challenges WHERE admin_user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete login challenges: %w", err)
+	}
+	return nil
+}
+
+// DisableTOTP turns off TOTP for a signed-in user after checking a current code
+func (a *AuthService) DisableTOTP(userID, role, code string) error {
+	if a.TOTPRequired(role) {
+		return ErrTOTPRequired
+	}
+
+	err := a.withTx("DisableTOTP", func(tx *sql.Tx) error {
+		state, err := getTOTPState(tx, userID, true)
+		if err != nil {
+			return err
+		}
+		if !state.enabled() {
+			return ErrTOTPNotEnabled
+		}
+		if err = acceptTOTPCode(tx, userID, *state.secret, code, state.lastStep); err != nil {
+			return err
+		}
+		return clearTOTP(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	LogSecurity("admin_totp_disabled", logrus.Fields{
+		"admin_user_id": userID,
+	})
+	return nil
+}
+
+// ResetTOTP clears another admin's TOTP, for a lost device, and ends their
+// sessions. Their next login starts enrolment again if their role requires it.
+func (a *AuthService) ResetTOTP(userID, resetBy string) error {
+	err := a.withTx("ResetTOTP", func(tx *sql.Tx) error {
+		if _, err := getTOTPState(tx, userID, true); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrAdminUserNotFound
+			}
+			return err
+		}
+		return clearTOTP(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := a.RevokeAllUserTokens(userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	LogSecurity("admin_totp_reset", logrus.Fields{
+		"admin_user_id": userID,
+		"reset_by":      resetBy,
+	})
+	return nil
+}
+
+// AdminMFAHandler serves the TOTP login step and two-factor settings
+type AdminMFAHandler struct {
+	auth     *AuthService
+	throttle *LoginThrottle
+}
+
+// NewAdminMFAHandler creates a new AdminMFAHandler
+func NewAdminMFAHandler(auth *AuthService, throttle *LoginThrottle) *AdminMFAHandler {
+	return &AdminMFAHandler{auth: auth, throttle: throttle}
+}
+
+// respondMFAError maps two-factor errors to responses
+func respondMFAError(c *gin.Context, action string, err error) {
+	status := http.StatusInternalServerError
+	message := "Failed to " + action
+	switch {
+	case errors.Is(err, ErrInvalidMFAChallenge):
+		status, message = http.StatusUnauthorized, "Login challenge is invalid or expired, sign in again"
+	case errors.Is(err, ErrInvalidMFACode):
+		status, message = http.StatusUnauthorized, "Invalid authentication code"
+	case errors.Is(err, ErrTOTPAlreadyEnabled), errors.Is(err, ErrTOTPNotEnabled),
+		errors.Is(err, ErrTOTPEnrollmentNotStarted), errors.Is(err, ErrTOTPRequired):
+		status, message = http.StatusConflict, err.Error()
+	case errors.Is(err, ErrAdminUserNotFound):
+		status, message = http.StatusNotFound, "Admin user not found"
+	default:
+		LogError("Failed to "+action, logrus.Fields{
+			"error": err.Error(),
+		}, err)
+	}
+
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": message,
+	})
+}
+
+// LoginEnroll handles POST /admin/login/totp/enroll
+func (h *AdminMFAHandler) LoginEnroll(c *gin.Context) {
+	var body struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format",
+		})
+		return
+	}
+
+	enrollment, err := h.auth.BeginChallengeEnrollment(body.ChallengeToken)
+	if err != nil {
+		respondMFAError(c, "start two-factor enrolment", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    enrollment,
+	})
+}
+
+// LoginVerify handles POST /admin/login/totp, the second login step
+func (h *AdminMFAHandler) LoginVerify(c *gin.Context) {
+	var body struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || (body.Code == "" && body.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format",
+		})
+		return
+	}
+
+	username, err := h.auth.MFAChallengeUsername(body.ChallengeToken)
+	if err != nil {
+		respondMFAError(c, "verify login challenge", err)
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing codes locks the account like guessing passwords
+	attempt, throttleErr := h.throttle.Begin(username, c.ClientIP())
+	if throttleErr != nil {
+		var blocked *LoginBlockedError
+		if errors.As(throttleErr, &blocked) {
+			c.Header("Retry-After", retryAfterSeconds(blocked.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"success": false,
+				"message": "Too many login attempts, try again later",
+			})
+			return
+		}
+		respondMFAError(c, "check login attempts", throttleErr)
+		return
+	}
+
+	result, err := h.auth.VerifyMFAChallenge(body.ChallengeToken, body.Code, body.RecoveryCode, sessionClientFromRequest(c))
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if recordErr := h.throttle.RecordFailure(attempt); recordErr != nil {
+				LogError("Failed to record failed login", logrus.Fields{
+					"error": recordErr.Error(),
+				}, recordErr)
+			}
+		} else if releaseErr := h.throttle.Release(attempt); releaseErr != nil {
+			LogError("Failed to release login attempt", logrus.Fields{
+				"error": releaseErr.Error(),
+			}, releaseErr)
+		}
+		respondMFAError(c, "verify login challenge", err)
+		return
+	}
+	if recordErr := h.throttle.RecordSuccess(attempt); recordErr != nil {
+		LogError("Failed to record successful login", logrus.Fields{
+			"error": recordErr.Error(),
+		}, recordErr)
+	}
+
+	response := gin.H{
+		"success":          true,
+		"message":          "Authentication successful",
+		"token":            result.Tokens.AccessToken,
+		"expiresAt":        result.Tokens.ExpiresAt,
+		"refreshToken":     result.Tokens.RefreshToken,
+		"refreshExpiresAt": result.Tokens.RefreshExpiresAt,
+		"sessionExpiresAt": result.Tokens.SessionExpiresAt,
+	}
+	if result.RecoveryCodes != nil {
+		response["recoveryCodes"] = result.RecoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetStatus handles GET /api/admin/totp
+func (h *AdminMFAHandler) GetStatus(c *gin.Context) {
+	status, err := h.auth.GetTOTPStatus(c.GetString("user_id"), c.GetString("role"))
+	if err != nil {
+		respondMFAError(c, "get two-factor status", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// StartEnrollment handles POST /api/admin/totp/enroll
+func (h *AdminMFAHandler) StartEnrollment(c *gin.Context) {
+	enrollment, err := h.auth.StartTOTPEnrollment(c.GetString("user_id"))
+	if err != nil {
+		respondMFAError(c, "start two-factor enrolment", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    enrollment,
+	})
+}
+
+// bindTOTPCode reads {"code": "..."} from the request, writing a 400 when missing
+func bindTOTPCode(c *gin.Context) (string, bool) {
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "code is required",
+		})
+		return "", false
+	}
+	return body.Code, true
+}
+
+// ConfirmEnrollment handles POST /api/admin/totp/confirm
+func (h *AdminMFAHandler) ConfirmEnrollment(c *gin.Context) {
+	code, ok := bindTOTPCode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.auth.ConfirmTOTPEnrollment(c.GetString("user_id"), code)
+	if err != nil {
+		respondMFAError(c, "enable two-factor authentication", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication enabled",
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// RegenerateRecoveryCodes handles POST /api/admin/totp/recovery-codes
+func (h *AdminMFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	code, ok := bindTOTPCode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.auth.RegenerateRecoveryCodes(c.GetString("user_id"), code)
+	if err != nil {
+		respondMFAError(c, "regenerate recovery codes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// Disable handles DELETE /api/admin/totp
+func (h *AdminMFAHandler) Disable(c *gin.Context) {
+	code, ok := bindTOTPCode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DisableTOTP(c.GetString("user_id"), c.GetString("role"), code); err != nil {
+		respondMFAError(c, "disable two-factor authentication", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// Reset handles POST /api/admin/admin-users/:id/totp/reset
+func (h *AdminMFAHandler) Reset(c *gin.Context) {
+	if err := h.auth.ResetTOTP(c.Param("id"), c.GetString("username")); err != nil {
+		respondMFAError(c, "reset two-factor authentication", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication reset",
+	})
+}
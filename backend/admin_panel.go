@@ -0,0 +1,566 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/sirupsen/logrus"
+)
+
+// Cookies of the server-rendered admin panel. They are HttpOnly and
+// SameSite=Strict; the refresh cookie is only sent to the panel's pages, which
+// are the only requests that renew an expired access token.
+const (
+	adminAccessCookie    = "admin_access"
+	adminRefreshCookie   = "admin_refresh"
+	adminCSRFCookie      = "admin_csrf"
+	adminLoginCSRFCookie = "admin_login_csrf"
+
+	// csrfHeader carries the CSRF token on the panel's fetch calls
+	csrfHeader = "X-CSRF-Token"
+	// csrfFormField carries the CSRF token on the panel's forms
+	csrfFormField = "csrf_token"
+	// csrfContextKey is where AuthorizeRoutes leaves the token for the page being rendered
+	csrfContextKey = "csrf_token"
+)
+
+// ErrInvalidCSRFToken is returned when a cookie-authenticated request does not carry its session's CSRF token
+var ErrInvalidCSRFToken = errors.New("invalid CSRF token")
+
+// SetSessionCSRFToken generates a new CSRF token for a session
+func (a *AuthService) SetSessionCSRFToken(sessionID string) (string, error) {
+	token, err := generateRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+
+	query := `UPDATE admin_sessions SET csrf_token_hash = $2 WHERE id = $1 AND revoked_at IS NULL`
+	result, err := a.db.Exec(query, sessionID, hashToken(token))
+	if err != nil {
+		return "", fmt.Errorf("failed to store CSRF token: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "", ErrAdminSessionNotFound
+	}
+	return token, nil
+}
+
+// CheckSessionCSRFToken returns ErrInvalidCSRFToken unless token is the CSRF
+// token of an active session
+func (a *AuthService) CheckSessionCSRFToken(sessionID, token string) error {
+	if sessionID == "" || token == "" {
+		return ErrInvalidCSRFToken
+	}
+
+	var storedHash sql.NullString
+	query := `SELECT csrf_token_hash FROM admin_sessions WHERE id = $1 AND revoked_at IS NULL`
+	err := a.db.QueryRow(query, sessionID).Scan(&storedHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCSRFToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get CSRF token: %w", err)
+	}
+
+	if !storedHash.Valid || subtle.ConstantTimeCompare([]byte(storedHash.String), []byte(hashToken(token))) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// csrfExempt reports whether a method cannot change anything and so needs no CSRF token
+func csrfExempt(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// authenticatePanelRequest authenticates an API call made by the admin panel
+// with its session cookie. Writes must carry the session's CSRF token in the
+// X-CSRF-Token header. On failure it writes an error, aborts and returns false.
+func authenticatePanelRequest(authService *AuthService, c *gin.Context, tokenString string) bool {
+	claims, err := authService.ValidateToken(tokenString)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
+
+	if !csrfExempt(c.Request.Method) {
+		if err := authService.CheckSessionCSRFToken(claims.SessionID, c.GetHeader(csrfHeader)); err != nil {
+			if !errors.Is(err, ErrInvalidCSRFToken) {
+				LogError("Failed to check CSRF token", logrus.Fields{
+					"session_id": claims.SessionID,
+					"error":      err.Error(),
+				}, err)
+			}
+			LogSecurity("admin_csrf_rejected", logrus.Fields{
+				"user_id":   claims.UserID,
+				"method":    c.Request.Method,
+				"path":      c.Request.URL.Path,
+				"client_ip": c.ClientIP(),
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+			c.Abort()
+			return false
+		}
+	}
+
+	setAdminClaims(authService, c, claims)
+	return true
+}
+
+// AdminPanel serves the login, logout and session handling of the
+// server-rendered admin pages under /admin
+type AdminPanel struct {
+	auth     *AuthService
+	throttle *LoginThrottle
+	// secureCookies marks the panel's cookies Secure so they are only sent over HTTPS
+	secureCookies bool
+}
+
+// NewAdminPanel creates a new AdminPanel
+func NewAdminPanel(auth *AuthService, throttle *LoginThrottle) *AdminPanel {
+	return &AdminPanel{
+		auth:          auth,
+		throttle:      throttle,
+		secureCookies: getEnvWithDefault("ADMIN_COOKIE_SECURE", "true") != "false",
+	}
+}
+
+// isPanelForm reports whether a request was posted by one of the panel's HTML
+// forms rather than by an API client sending JSON
+func isPanelForm(c *gin.Context) bool {
+	return c.ContentType() == binding.MIMEPOSTForm
+}
+
+// hasPanelSession reports whether a request carries the panel's session cookies
+// and no credentials of its own
+func hasPanelSession(c *gin.Context) bool {
+	if c.GetHeader("Authorization") != "" {
+		return false
+	}
+	_, accessErr := c.Cookie(adminAccessCookie)
+	_, refreshErr := c.Cookie(adminRefreshCookie)
+	return accessErr == nil || refreshErr == nil
+}
+
+// setCookie writes one of the panel's cookies; a zero expiry deletes it
+func (p *AdminPanel) setCookie(c *gin.Context, name, value, path string, expiresAt time.Time) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   p.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if expiresAt.IsZero() {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expiresAt
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// setTokenCookies stores a token pair in the panel's cookies
+func (p *AdminPanel) setTokenCookies(c *gin.Context, tokens *TokenPair) {
+	p.setCookie(c, adminAccessCookie, tokens.AccessToken, "/", tokens.ExpiresAt)
+	p.setCookie(c, adminRefreshCookie, tokens.RefreshToken, "/admin", tokens.RefreshExpiresAt)
+}
+
+// clearSessionCookies removes the panel's session cookies
+func (p *AdminPanel) clearSessionCookies(c *gin.Context) {
+	p.setCookie(c, adminAccessCookie, "", "/", time.Time{})
+	p.setCookie(c, adminRefreshCookie, "", "/admin", time.Time{})
+	p.setCookie(c, adminCSRFCookie, "", "/", time.Time{})
+}
+
+// startSession stores the tokens of a completed login in cookies together with
+// a new CSRF token for the session
+func (p *AdminPanel) startSession(c *gin.Context, tokens *TokenPair) error {
+	claims, err := p.auth.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to read new session: %w", err)
+	}
+	csrfToken, err := p.auth.SetSessionCSRFToken(claims.SessionID)
+	if err != nil {
+		return err
+	}
+
+	p.setTokenCookies(c, tokens)
+	p.setCookie(c, adminCSRFCookie, csrfToken, "/", tokens.SessionExpiresAt)
+	p.setCookie(c, adminLoginCSRFCookie, "", "/admin/login", time.Time{})
+	return nil
+}
+
+// sessionClaims returns the claims of the panel's session, renewing an expired
+// access token with the refresh cookie. It returns nil without a usable session.
+func (p *AdminPanel) sessionClaims(c *gin.Context) *JWTClaims {
+	if accessToken, err := c.Cookie(adminAccessCookie); err == nil && accessToken != "" {
+		if claims, err := p.auth.ValidateToken(accessToken); err == nil {
+			return claims
+		}
+	}
+
+	refreshToken, err := c.Cookie(adminRefreshCookie)
+	if err != nil || refreshToken == "" {
+		return nil
+	}
+	tokens, err := p.auth.Refresh(refreshToken, sessionClientFromRequest(c))
+	if err != nil {
+		if !errors.Is(err, ErrInvalidRefreshToken) && !errors.Is(err, ErrRefreshTokenReused) && !errors.Is(err, ErrSessionExpired) {
+			LogError("Failed to refresh admin panel session", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+		}
+		return nil
+	}
+	claims, err := p.auth.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		LogError("Failed to validate refreshed admin panel token", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		return nil
+	}
+	p.setTokenCookies(c, tokens)
+	return claims
+}
+
+// authenticatePage admits a request for one of the panel's pages, redirecting
+// to the login page when there is no session. The page's CSRF token is left
+// in the context under csrfContextKey.
+func (p *AdminPanel) authenticatePage(c *gin.Context) bool {
+	c.Header("Cache-Control", "no-store")
+
+	claims := p.sessionClaims(c)
+	if claims == nil {
+		p.clearSessionCookies(c)
+		c.Redirect(http.StatusSeeOther, "/admin/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+		return false
+	}
+
+	// Sessions keep their CSRF token; a new one is only issued when the cookie went missing
+	csrfToken, err := c.Cookie(adminCSRFCookie)
+	if err != nil || p.auth.CheckSessionCSRFToken(claims.SessionID, csrfToken) != nil {
+		csrfToken, err = p.auth.SetSessionCSRFToken(claims.SessionID)
+		if err != nil {
+			LogError("Failed to issue admin panel CSRF token", logrus.Fields{
+				"session_id": claims.SessionID,
+				"error":      err.Error(),
+			}, err)
+			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Abort()
+			return false
+		}
+		p.setCookie(c, adminCSRFCookie, csrfToken, "/", time.Now().Add(p.auth.sessionLifetime))
+	}
+
+	setAdminClaims(p.auth, c, claims)
+	c.Set(csrfContextKey, csrfToken)
+	return true
+}
+
+// loginCSRFToken returns the token that ties the login forms to this browser,
+// setting its cookie when there is none yet. Before login there is no session
+// to bind a token to, so the form must echo the cookie's value.
+func (p *AdminPanel) loginCSRFToken(c *gin.Context) (string, error) {
+	if token, err := c.Cookie(adminLoginCSRFCookie); err == nil && token != "" {
+		return token, nil
+	}
+	token, err := generateRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	p.setCookie(c, adminLoginCSRFCookie, token, "/admin/login", time.Now().Add(time.Hour))
+	return token, nil
+}
+
+// checkLoginCSRF reports whether a login form carries this browser's login CSRF token
+func checkLoginCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(adminLoginCSRFCookie)
+	if err != nil || cookie == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(c.PostForm(csrfFormField))) == 1
+}
+
+// panelRedirectTarget returns where to go after login: next when it is one of
+// the panel's own pages, the dashboard otherwise
+func panelRedirectTarget(next string) string {
+	if next == "/admin" || (strings.HasPrefix(next, "/admin/") && !strings.HasPrefix(next, "/admin/login") &&
+		!strings.HasPrefix(next, "/admin/logout")) {
+		if parsed, err := url.Parse(next); err == nil && parsed.Host == "" && parsed.Scheme == "" {
+			return next
+		}
+	}
+	return "/admin"
+}
+
+// renderLogin shows the login form with an optional error
+func (p *AdminPanel) renderLogin(c *gin.Context, status int, next, message string) {
+	csrfToken, err := p.loginCSRFToken(c)
+	if err != nil {
+		LogError("Failed to render admin login page", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	adminLoginHandler(c.Writer, adminLoginPage{
+		CSRFToken: csrfToken,
+		Next:      next,
+		Error:     message,
+	})
+}
+
+// renderTOTP shows the second login step for a challenge
+func (p *AdminPanel) renderTOTP(c *gin.Context, status int, page adminTOTPPage) {
+	csrfToken, err := p.loginCSRFToken(c)
+	if err != nil {
+		LogError("Failed to render admin two-factor page", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	page.CSRFToken = csrfToken
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	adminTOTPHandler(c.Writer, page)
+}
+
+// finishLogin stores a completed login in cookies and continues to the panel
+func (p *AdminPanel) finishLogin(c *gin.Context, tokens *TokenPair, next string, recoveryCodes []string) {
+	if err := p.startSession(c, tokens); err != nil {
+		LogError("Failed to start admin panel session", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		p.renderLogin(c, http.StatusInternalServerError, next, "Login sedang tidak tersedia, coba lagi nanti.")
+		return
+	}
+
+	target := panelRedirectTarget(next)
+	if recoveryCodes != nil {
+		// Enrolment just finished; the codes are shown once before continuing
+		c.Header("Cache-Control", "no-store")
+		adminRecoveryCodesHandler(c.Writer, adminRecoveryCodesPage{Codes: recoveryCodes, Next: target})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+// checkThrottle refuses a login attempt that comes too soon after earlier
+// failures, rendering the login page; it returns nil when refused
+func (p *AdminPanel) checkThrottle(c *gin.Context, username, next string) *LoginAttempt {
+	clientIP := c.ClientIP()
+	attempt, throttleErr := p.throttle.Begin(username, clientIP)
+	if throttleErr == nil {
+		return attempt
+	}
+
+	var blocked *LoginBlockedError
+	if errors.As(throttleErr, &blocked) {
+		LogSecurity("admin_login_blocked", logrus.Fields{
+			"username":    username,
+			"client_ip":   clientIP,
+			"reason":      blocked.Reason,
+			"retry_after": blocked.RetryAfter.String(),
+		})
+		c.Header("Retry-After", retryAfterSeconds(blocked.RetryAfter))
+		p.renderLogin(c, http.StatusTooManyRequests, next, "Terlalu banyak percobaan login, coba lagi nanti.")
+		return nil
+	}
+
+	LogError("Failed to check login attempts", logrus.Fields{
+		"error": throttleErr.Error(),
+	}, throttleErr)
+	p.renderLogin(c, http.StatusInternalServerError, next, "Login sedang tidak tersedia, coba lagi nanti.")
+	return nil
+}
+
+// recordAttempt records how a login attempt ended for throttling
+func (p *AdminPanel) recordAttempt(attempt *LoginAttempt, success bool) {
+	var err error
+	if success {
+		err = p.throttle.RecordSuccess(attempt)
+	} else {
+		err = p.throttle.RecordFailure(attempt)
+	}
+	if err != nil {
+		LogError("Failed to record login attempt", logrus.Fields{
+			"success": success,
+			"error":   err.Error(),
+		}, err)
+	}
+}
+
+// releaseAttempt stops counting a login attempt that ended without a wrong password or code
+func (p *AdminPanel) releaseAttempt(attempt *LoginAttempt) {
+	if err := p.throttle.Release(attempt); err != nil {
+		LogError("Failed to release login attempt", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+	}
+}
+
+// LoginPage handles GET /admin/login
+func (p *AdminPanel) LoginPage(c *gin.Context) {
+	p.renderLogin(c, http.StatusOK, c.Query("next"), "")
+}
+
+// Login handles the login form posted to POST /admin/login
+func (p *AdminPanel) Login(c *gin.Context) {
+	next := c.PostForm("next")
+	if !checkLoginCSRF(c) {
+		p.renderLogin(c, http.StatusForbidden, next, "Formulir kedaluwarsa, silakan coba lagi.")
+		return
+	}
+
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+	if username == "" || password == "" {
+		p.renderLogin(c, http.StatusBadRequest, next, "Username dan password wajib diisi.")
+		return
+	}
+	attempt := p.checkThrottle(c, username, next)
+	if attempt == nil {
+		return
+	}
+
+	result, err := p.auth.Authenticate(username, password, sessionClientFromRequest(c))
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			p.recordAttempt(attempt, false)
+		} else {
+			LogError("Failed to authenticate admin user", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+			p.releaseAttempt(attempt)
+		}
+		p.renderLogin(c, http.StatusUnauthorized, next, "Username atau password salah.")
+		return
+	}
+
+	// Two-factor users continue at POST /admin/login/totp
+	if result.Challenge != nil {
+		p.releaseAttempt(attempt)
+		page := adminTOTPPage{
+			ChallengeToken: result.Challenge.Token,
+			Next:           next,
+		}
+		if result.Challenge.EnrollmentRequired {
+			enrollment, enrollErr := p.auth.BeginChallengeEnrollment(result.Challenge.Token)
+			if enrollErr != nil {
+				LogError("Failed to start two-factor enrolment", logrus.Fields{
+					"error": enrollErr.Error(),
+				}, enrollErr)
+				p.renderLogin(c, http.StatusInternalServerError, next, "Login sedang tidak tersedia, coba lagi nanti.")
+				return
+			}
+			page.Secret = enrollment.Secret
+			page.ProvisioningURI = enrollment.ProvisioningURI
+		}
+		p.renderTOTP(c, http.StatusOK, page)
+		return
+	}
+
+	p.recordAttempt(attempt, true)
+	p.finishLogin(c, result.Tokens, next, nil)
+}
+
+// LoginTOTP handles the two-factor form posted to POST /admin/login/totp
+func (p *AdminPanel) LoginTOTP(c *gin.Context) {
+	page := adminTOTPPage{
+		ChallengeToken:  c.PostForm("challenge_token"),
+		Next:            c.PostForm("next"),
+		Secret:          c.PostForm("secret"),
+		ProvisioningURI: c.PostForm("provisioning_uri"),
+	}
+	if !checkLoginCSRF(c) {
+		p.renderLogin(c, http.StatusForbidden, page.Next, "Formulir kedaluwarsa, silakan login lagi.")
+		return
+	}
+
+	code := strings.TrimSpace(c.PostForm("code"))
+	recoveryCode := strings.TrimSpace(c.PostForm("recovery_code"))
+	if code == "" && recoveryCode == "" {
+		page.Error = "Masukkan kode autentikasi atau kode pemulihan."
+		p.renderTOTP(c, http.StatusBadRequest, page)
+		return
+	}
+
+	username, err := p.auth.MFAChallengeUsername(page.ChallengeToken)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidMFAChallenge) {
+			LogError("Failed to verify login challenge", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+		}
+		p.renderLogin(c, http.StatusUnauthorized, page.Next, "Sesi login kedaluwarsa, silakan login lagi.")
+		return
+	}
+
+	// Wrong codes count as failed logins, as they do for the JSON login
+	attempt := p.checkThrottle(c, username, page.Next)
+	if attempt == nil {
+		return
+	}
+
+	result, err := p.auth.VerifyMFAChallenge(page.ChallengeToken, code, recoveryCode, sessionClientFromRequest(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidMFACode):
+			p.recordAttempt(attempt, false)
+			page.Error = "Kode autentikasi salah."
+			p.renderTOTP(c, http.StatusUnauthorized, page)
+		case errors.Is(err, ErrInvalidMFAChallenge):
+			p.releaseAttempt(attempt)
+			p.renderLogin(c, http.StatusUnauthorized, page.Next, "Sesi login kedaluwarsa, silakan login lagi.")
+		default:
+			LogError("Failed to verify login challenge", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+			p.releaseAttempt(attempt)
+			p.renderLogin(c, http.StatusInternalServerError, page.Next, "Login sedang tidak tersedia, coba lagi nanti.")
+		}
+		return
+	}
+
+	p.recordAttempt(attempt, true)
+	p.finishLogin(c, result.Tokens, page.Next, result.RecoveryCodes)
+}
+
+// Logout handles the logout form posted to POST /admin/logout
+func (p *AdminPanel) Logout(c *gin.Context) {
+	// An expired access token is renewed first so its session can be ended
+	if claims := p.sessionClaims(c); claims != nil {
+		if err := p.auth.CheckSessionCSRFToken(claims.SessionID, c.PostForm(csrfFormField)); err != nil {
+			c.String(http.StatusForbidden, "Invalid CSRF token")
+			return
+		}
+
+		// Revoke the token and its session's refresh tokens
+		if err := p.auth.EndSession(claims.TokenID); err != nil {
+			// The cookies are cleared below, so the browser is logged out either way
+			LogError("Failed to revoke token during logout", logrus.Fields{
+				"token_id": claims.TokenID,
+				"error":    err.Error(),
+			}, err)
+		}
+	}
+
+	p.clearSessionCookies(c)
+	c.Redirect(http.StatusSeeOther, "/admin/login")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionTouchInterval limits how often a session's last-used time is written
+const sessionTouchInterval = time.Minute
+
+// ErrAdminSessionNotFound is returned for a session that does not exist or belongs to another admin
+var ErrAdminSessionNotFound = errors.New("session not found")
+
+// SessionClient identifies where a session is used from
+type SessionClient struct {
+	IPAddress string
+	UserAgent string
+}
+
+// sessionClientFromRequest returns the client of the current request
+func sessionClientFromRequest(c *gin.Context) SessionClient {
+	return SessionClient{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// AdminSession is one signed-in device of an admin
+type AdminSession struct {
+	ID         string    `json:"id"`
+	IPAddress  *string   `json:"ipAddress"`
+	UserAgent  *string   `json:"userAgent"`
+	Device     *string   `json:"device"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current marks the session of the token that made the request
+	Current bool `json:"current"`
+}
+
+// userAgentBrowsers and userAgentPlatforms are matched in order, so more
+// specific tokens (Edge, which also claims Chrome) come first
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	}
+	userAgentPlatforms = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeDevice summarises a user agent as "Browser on Platform"
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser, platform := "", ""
+	for _, candidate := range userAgentBrowsers {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	for _, candidate := range userAgentPlatforms {
+		if strings.Contains(userAgent, candidate.token) {
+			platform = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+// TouchSession records that a session was just used. Writes are limited to
+// one per sessionTouchInterval so busy sessions do not update on every request.
+func (a *AuthService) TouchSession(sessionID, ipAddress string) error {
+	query := `
+		UPDATE admin_sessions SET last_used_at = CURRENT_TIMESTAMP, ip_address = $2
+		WHERE id = $1 AND last_used_at < $3
+	`
+	_, err := a.db.Exec(query, sessionID, ipAddress, time.Now().Add(-sessionTouchInterval))
+	if err != nil {
+		return fmt.Errorf("failed to update session last used time: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns a user's active sessions, most recently used first
+func (a *AuthService) ListSessions(userID, currentSessionID string) ([]AdminSession, error) {
+	query := `
+		SELECT id, ip_address, user_agent, device, created_at, last_used_at, expires_at
+		FROM admin_sessions
+		WHERE admin_user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := a.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []AdminSession{}
+	for rows.Next() {
+		var session AdminSession
+		err := rows.Scan(&session.ID, &session.IPAddress, &session.UserAgent, &session.Device,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one of a user's sessions
+func (a *AuthService) RevokeSession(userID, sessionID string) error {
+	return a.withTx("RevokeSession", func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM admin_sessions WHERE id::text = $1 AND admin_user_id = $2 AND revoked_at IS NULL)
+		`, sessionID, userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+		if !exists {
+			return ErrAdminSessionNotFound
+		}
+		return a.revokeFamily(tx, sessionID, RefreshRevokedLogout)
+	})
+}
+
+// RevokeOtherSessions ends every session of a user except the current one and
+// returns how many were ended
+func (a *AuthService) RevokeOtherSessions(userID, currentSessionID string) (int, error) {
+	var revoked int
+	err := a.withTx("RevokeOtherSessions", func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT id FROM admin_sessions
+			WHERE admin_user_id = $1 AND id::text <> $2 AND revoked_at IS NULL
+		`, userID, currentSessionID)
+		if err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+		var sessionIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan session: %w", err)
+			}
+			sessionIDs = append(sessionIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+
+		for _, id := range sessionIDs {
+			if err := a.revokeFamily(tx, id, RefreshRevokedLogout); err != nil {
+				return err
+			}
+		}
+		revoked = len(sessionIDs)
+		return nil
+	})
+	return revoked, err
+}
+
+// RunTokenCleanup periodically deletes expired admin, customer and portal
+// tokens, sessions and old login attempts
+func RunTokenCleanup(ctx context.Context, interval time.Duration, auth *AuthService, customerAuth *CustomerAuthService, portal *PortalService, throttle *LoginThrottle) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	LogInfo("Auth token cleanup started", logrus.Fields{"interval": interval.String()})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := auth.CleanupExpiredTokens(); err != nil {
+				LogError("Failed to clean up expired tokens", logrus.Fields{"error": err.Error()}, err)
+			}
+			if err := customerAuth.CleanupExpired(); err != nil {
+				LogError("Failed to clean up expired customer tokens", logrus.Fields{"error": err.Error()}, err)
+			}
+			if err := portal.CleanupExpired(); err != nil {
+				LogError("Failed to clean up expired portal links", logrus.Fields{"error": err.Error()}, err)
+			}
+			if err := throttle.Cleanup(); err != nil {
+				LogError("Failed to clean up login attempts", logrus.Fields{"error": err.Error()}, err)
+			}
+		}
+	}
+}
+
+// AdminSessionHandler serves an admin's own sessions
+type AdminSessionHandler struct {
+	auth *AuthService
+}
+
+// NewAdminSessionHandler creates a new AdminSessionHandler
+func NewAdminSessionHandler(auth *AuthService) *AdminSessionHandler {
+	return &AdminSessionHandler{auth: auth}
+}
+
+// List handles GET /admin/sessions
+func (h *AdminSessionHandler) List(c *gin.Context) {
+	sessions, err := h.auth.ListSessions(c.GetString("user_id"), c.GetString("session_id"))
+	if err != nil {
+		LogError("Failed to list admin sessions", logrus.Fields{
+			"user_id": c.GetString("user_id"),
+			"error":   err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// Revoke handles DELETE /admin/sessions/:id
+func (h *AdminSessionHandler) Revoke(c *gin.Context) {
+	sessionID := c.Param("id")
+	if err := h.auth.RevokeSession(c.GetString("user_id"), sessionID); err != nil {
+		if errors.Is(err, ErrAdminSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Session not found",
+			})
+			return
+		}
+		LogError("Failed to revoke admin session", logrus.Fields{
+			"user_id":    c.GetString("user_id"),
+			"session_id": sessionID,
+			"error":      err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke session",
+		})
+		return
+	}
+
+	LogSecurity("admin_session_revoked", logrus.Fields{
+		"user_id":    c.GetString("user_id"),
+		"session_id": sessionID,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session revoked",
+	})
+}
+
+// RevokeOthers handles POST /admin/sessions/revoke-others
+func (h *AdminSessionHandler) RevokeOthers(c *gin.Context) {
+	revoked, err := h.auth.RevokeOtherSessions(c.GetString("user_id"), c.GetString("session_id"))
+	if err != nil {
+		LogError("Failed to revoke other admin sessions", logrus.Fields{
+			"user_id": c.GetString("user_id"),
+			"error":   err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke sessions",
+		})
+		return
+	}
+
+	LogSecurity("admin_other_sessions_revoked", logrus.Fields{
+		"user_id": c.GetString("user_id"),
+		"revoked": revoked,
+		"kept":    c.GetString("session_id"),
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Other sessions revoked",
+		"data":    gin.H{"revoked": revoked},
+	})
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognise
+const apiKeyPrefix = "usk_"
+
+// apiKeyTouchInterval limits how often a key's last-used time is written
+const apiKeyTouchInterval = time.Minute
+
+var (
+	// ErrAPIKeyInvalid is returned for an unknown, revoked or expired key
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+	// ErrAPIKeyIPNotAllowed is returned when a key is used from outside its allowlist
+	ErrAPIKeyIPNotAllowed = errors.New("API key not allowed from this address")
+	// ErrAPIKeyNotFound is returned when revoking a key that does not exist
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKeyRequest wraps problems with the scopes, allowlist or expiry of a new key
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+)
+
+// APIKey is an API key without its secret
+type APIKey struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []Permission `json:"scopes"`
+	AllowedIPs []string     `json:"allowedIps"`
+	CreatedBy  string       `json:"createdBy"`
+	// CreatedByUsername is the creator's username, filled in lists
+	CreatedByUsername string     `json:"createdByUsername,omitempty"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	LastUsedAt        *time.Time `json:"lastUsedAt"`
+	LastUsedIP        *string    `json:"lastUsedIp"`
+	RevokedAt         *time.Time `json:"revokedAt"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+// APIKeyPrincipal is who an authenticated API key acts as: its creator, limited to its scopes
+type APIKeyPrincipal struct {
+	Key      APIKey
+	Username string
+	Role     string
+}
+
+// CreateAPIKeyRequest is the body of POST /api/admin/api-keys
+type CreateAPIKeyRequest struct {
+	Name       string    `json:"name" binding:"required,max=100"`
+	Scopes     []string  `json:"scopes" binding:"required,min=1"`
+	AllowedIPs []string  `json:"allowedIps"`
+	ExpiresAt  time.Time `json:"expiresAt" binding:"required"`
+}
+
+// APIKeyStore creates, authenticates and revokes API keys
+type APIKeyStore struct {
+	db *sql.DB
+	// maxTTL is the longest lifetime a new key may have
+	maxTTL time.Duration
+}
+
+// NewAPIKeyStore creates a new API key store
+func NewAPIKeyStore(db *sql.DB) *APIKeyStore {
+	return &APIKeyStore{
+		db:     db,
+		maxTTL: getEnvDurationWithDefault("API_KEY_MAX_TTL", 365*24*time.Hour),
+	}
+}
+
+// randomAlphanumeric returns n random lowercase letters and digits
+func randomAlphanumeric(n int) (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	for i := range raw {
+		raw[i] = alphabet[int(raw[i])%len(alphabet)]
+	}
+	return string(raw), nil
+}
+
+// splitAPIKey returns the prefix of a key in the usk_<prefix>_<secret> format
+func splitAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok || len(prefix) != 8 || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// validateAllowedIPs checks that every entry is an IP address or CIDR range
+func validateAllowedIPs(entries []string) ([]string, error) {
+	cleaned := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("%w: invalid CIDR range %q", ErrInvalidAPIKeyRequest, entry)
+			}
+		} else if net.ParseIP(entry) == nil {
+			return nil, fmt.Errorf("%w: invalid IP address %q", ErrInvalidAPIKeyRequest, entry)
+		}
+		cleaned = append(cleaned, entry)
+	}
+	return cleaned, nil
+}
+
+// ipAllowed reports whether ip matches the allowlist; an empty list allows any address
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if allowedAddr := net.ParseIP(entry); allowedAddr != nil && allowedAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScopes checks that every scope is a permission the creator's role
+// grants. Keys cannot manage keys, so a leaked key cannot mint new ones.
+func validateScopes(role string, scopes []string) ([]Permission, error) {
+	perms := make([]Permission, 0, len(scopes))
+	seen := make(map[Permission]bool, len(scopes))
+	for _, scope := range scopes {
+		perm := Permission(strings.TrimSpace(scope))
+		if seen[perm] {
+			continue
+		}
+		if perm == PermAPIKeysManage {
+			return nil, fmt.Errorf("%w: scope %s cannot be given to an API key", ErrInvalidAPIKeyRequest, perm)
+		}
+		if !RoleHasPermission(role, perm) {
+			return nil, fmt.Errorf("%w: scope %q is unknown or not granted to your role", ErrInvalidAPIKeyRequest, scope)
+		}
+		seen[perm] = true
+		perms = append(perms, perm)
+	}
+	return perms, nil
+}
+
+// Create stores a new key for the admin creatorID and returns it with the
+// plaintext key, which is shown only this once
+func (s *APIKeyStore) Create(creatorID, creatorRole string, req CreateAPIKeyRequest) (*APIKey, string, error) {
+	scopes, err := validateScopes(creatorRole, req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	allowedIPs, err := validateAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+	if !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidAPIKeyRequest)
+	}
+	if req.ExpiresAt.After(time.Now().Add(s.maxTTL)) {
+		return nil, "", fmt.Errorf("%w: expiresAt must be within %s", ErrInvalidAPIKeyRequest, s.maxTTL)
+	}
+
+	prefix, err := randomAlphanumeric(8)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate key prefix: %w", err)
+	}
+	secret, err := randomAlphanumeric(40)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	plaintext := apiKeyPrefix + prefix + "_" + secret
+
+	scopeNames := make([]string, len(scopes))
+	for i, scope := range scopes {
+		scopeNames[i] = string(scope)
+	}
+
+	key := &APIKey{
+		Name:       strings.TrimSpace(req.Name),
+		Prefix:     prefix,
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		CreatedBy:  creatorID,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	err = s.db.QueryRow(`
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, allowed_ips, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, key.Name, prefix, hashToken(plaintext), pq.Array(scopeNames), pq.Array(allowedIPs), creatorID, req.ExpiresAt).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return key, plaintext, nil
+}
+
+// scanAPIKey scans the columns of apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*APIKey, error) {
+	var key APIKey
+	var scopes, allowedIPs []string
+	dest := []interface{}{
+		&key.ID, &key.Name, &key.Prefix, pq.Array(&scopes), pq.Array(&allowedIPs), &key.CreatedBy,
+		&key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.RevokedAt, &key.CreatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	key.Scopes = make([]Permission, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = Permission(scope)
+	}
+	key.AllowedIPs = allowedIPs
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+	return &key, nil
+}
+
+const apiKeyColumns = `k.id, k.name, k.prefix, k.scopes, k.allowed_ips, k.created_by,
+	k.expires_at, k.last_used_at, k.last_used_ip, k.revoked_at, k.created_at`
+
+// List returns every key, newest first
+func (s *APIKeyStore) List() ([]APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT ` + apiKeyColumns + `, u.username
+		FROM api_keys k
+		JOIN admin_users u ON u.id = k.created_by
+		ORDER BY k.created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var username string
+		key, err := scanAPIKey(rows, &username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		key.CreatedByUsername = username
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables a key permanently
+func (s *APIKeyStore) Revoke(id string) error {
+	result, err := s.db.Exec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id::text = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext key used from ip. The key must be
+// unrevoked and unexpired, its creator still active, and ip on its allowlist.
+func (s *APIKeyStore) Authenticate(plaintext, ip string) (*APIKeyPrincipal, error) {
+	prefix, ok := splitAPIKey(plaintext)
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	var principal APIKeyPrincipal
+	var keyHash string
+	var creatorActive bool
+	key, err := scanAPIKey(s.db.QueryRow(`
+		SELECT `+apiKeyColumns+`, k.key_hash, u.username, u.role, COALESCE(u.is_active, true)
+		FROM api_keys k
+		JOIN admin_users u ON u.id = k.created_by
+		WHERE k.prefix = $1
+	`, prefix), &keyHash, &principal.Username, &principal.Role, &creatorActive)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(plaintext)), []byte(keyHash)) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.RevokedAt != nil || !key.ExpiresAt.After(time.Now()) || !creatorActive {
+		return nil, ErrAPIKeyInvalid
+	}
+	principal.Key = *key
+	if !ipAllowed(key.AllowedIPs, ip) {
+		return &principal, ErrAPIKeyIPNotAllowed
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyTouchInterval {
+		_, err := s.db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2 WHERE id = $1", key.ID, ip)
+		if err != nil {
+			LogWarn("Failed to update API key last used time", logrus.Fields{
+				"api_key_prefix": prefix,
+				"error":          err.Error(),
+			})
+		}
+	}
+	return &principal, nil
+}
+
+// authenticateAPIKeyRequest authenticates a request by its X-API-Key header.
+// The request acts as the key's creator, but checkPermission also requires
+// the permission to be one of the key's scopes.
+func authenticateAPIKeyRequest(store *APIKeyStore, c *gin.Context) bool {
+	principal, err := store.Authenticate(c.GetHeader("X-API-Key"), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAPIKeyIPNotAllowed):
+			LogSecurity("api_key_ip_rejected", logrus.Fields{
+				"api_key_prefix": principal.Key.Prefix,
+				"client_ip":      c.ClientIP(),
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key not allowed from this address"})
+		case errors.Is(err, ErrAPIKeyInvalid):
+			LogSecurity("api_key_rejected", logrus.Fields{
+				"client_ip": c.ClientIP(),
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+		default:
+			LogError("Failed to authenticate API key", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		}
+		c.Abort()
+		return false
+	}
+
+	c.Set("user_id", principal.Key.CreatedBy)
+	c.Set("username", principal.Username)
+	c.Set("role", principal.Role)
+	c.Set("api_key_id", principal.Key.ID)
+	c.Set("api_key_scopes", principal.Key.Scopes)
+	return true
+}
+
+// APIKeyHandler serves API key management in the admin panel
+type APIKeyHandler struct {
+	store *APIKeyStore
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(store *APIKeyStore) *APIKeyHandler {
+	return &APIKeyHandler{store: store}
+}
+
+// List handles GET /api/admin/api-keys
+func (h *APIKeyHandler) List(c *gin.Context) {
+	keys, err := h.store.List()
+	if err != nil {
+		LogError("Failed to list API keys", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list API keys",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    keys,
+	})
+}
+
+// Create handles POST /api/admin/api-keys. The response is the only time the
+// plaintext key is returned.
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	key, plaintext, err := h.store.Create(c.GetString("user_id"), c.GetString("role"), req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAPIKeyRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		LogError("Failed to create API key", logrus.Fields{
+			"user_id": c.GetString("user_id"),
+			"error":   err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create API key",
+		})
+		return
+	}
+
+	LogSecurity("api_key_created", logrus.Fields{
+		"user_id":        c.GetString("user_id"),
+		"api_key_id":     key.ID,
+		"api_key_prefix": key.Prefix,
+		"scopes":         key.Scopes,
+	})
+	setAuditResourceID(c, key.ID)
+	auditChange(c, nil, key)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "API key created; store it now, it will not be shown again",
+		"data": gin.H{
+			"apiKey": key,
+			"key":    plaintext,
+		},
+	})
+}
+
+// Revoke handles DELETE /api/admin/api-keys/:id
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.store.Revoke(id); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "API key not found",
+			})
+			return
+		}
+		LogError("Failed to revoke API key", logrus.Fields{
+			"api_key_id": id,
+			"error":      err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke API key",
+		})
+		return
+	}
+
+	LogSecurity("api_key_revoked", logrus.Fields{
+		"user_id":    c.GetString("user_id"),
+		"api_key_id": id,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "API key revoked",
+	})
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Context keys handlers use to add detail to their audit event
+const (
+	auditBeforeKey     = "audit_before"
+	auditAfterKey      = "audit_after"
+	auditResourceIDKey = "audit_resource_id"
+)
+
+// AuditAction names what a mutating admin route does and what it acts on
+type AuditAction struct {
+	Action       string
+	ResourceType string
+}
+
+// auditActions maps every mutating admin route, as "METHOD path", to its
+// audit action. StartServer refuses to start when such a route is missing, so
+// a new write endpoint is never left out of the audit log.
+var auditActions = map[string]AuditAction{
+	"DELETE /admin/sessions/:id":                               {"admin_session.revoke", "admin_session"},
+	"POST /admin/sessions/revoke-others":                       {"admin_session.revoke_others", "admin_session"},
+	"POST /api/products":                                       {"product.create", "product"},
+	"PUT /api/products/:id":                                    {"product.update", "product"},
+	"DELETE /api/products/:id":                                 {"product.delete", "product"},
+	"POST /api/products/:id/close":                             {"product.close", "product"},
+	"POST /api/products/:id/reopen":                            {"product.reopen", "product"},
+	"POST /api/products/:id/restore":                           {"product.restore", "product"},
+	"POST /api/orders":                                         {"order.create", "order"},
+	"PUT /api/orders/:id/status":                               {"order.update_status", "order"},
+	"POST /api/orders/:id/payment-link":                        {"payment.create_link", "order"},
+	"POST /api/orders/:id/payment/confirm":                     {"payment.confirm", "order"},
+	"POST /api/orders/:id/payment/cancel":                      {"payment.cancel", "order"},
+	"POST /api/orders/:id/payment/refund":                      {"payment.refund", "order"},
+	"PUT /api/orders/:id/installments":                         {"order.set_payment_schedule", "order"},
+	"POST /api/orders/:id/installments/:sequence/payment-link": {"installment.create_link", "order"},
+	"POST /api/orders/:id/installments/:sequence/confirm":      {"installment.confirm", "order"},
+	"POST /api/admin/login-lockouts/unlock":                    {"login_lockout.unlock", "login_lockout"},
+	"POST /api/admin/totp/enroll":                              {"admin_totp.enroll", "admin_user"},
+	"POST /api/admin/totp/confirm":                             {"admin_totp.confirm", "admin_user"},
+	"POST /api/admin/totp/recovery-codes":                      {"admin_totp.regenerate_recovery_codes", "admin_user"},
+	"DELETE /api/admin/totp":                                   {"admin_totp.disable", "admin_user"},
+	"POST /api/admin/admin-users/:id/totp/reset":               {"admin_totp.reset", "admin_user"},
+	"POST /api/admin/api-keys":                                 {"api_key.create", "api_key"},
+	"DELETE /api/admin/api-keys/:id":                           {"api_key.revoke", "api_key"},
+	"POST /api/privacy/exports":                                {"data_subject.export", "data_subject_request"},
+	"POST /api/privacy/erasures":                               {"data_subject.erase", "data_subject_request"},
+	"POST /api/users":                                          {"user.create", "user"},
+	"POST /api/users/import":                                   {"user.import", "user"},
+	"PUT /api/users/:id":                                       {"user.update", "user"},
+	"DELETE /api/users/:id":                                    {"user.delete", "user"},
+	"PATCH /api/users/:id/toggle-status":                       {"user.toggle_status", "user"},
+	"PATCH /api/users/:id/change-password":                     {"user.change_password", "user"},
+	"POST /api/users/:id/restore":                              {"user.restore", "user"},
+	"POST /api/dashboard/orders/bulk-update":                   {"order.bulk_update", "order"},
+	"POST /api/mayar/products/:id/close":                       {"mayar_product.close", "mayar_product"},
+	"POST /api/mayar/products/:id/reopen":                      {"mayar_product.reopen", "mayar_product"},
+	"POST /api/mayar/invoices":                                 {"mayar_invoice.create", "mayar_invoice"},
+	"PUT /api/mayar/invoices/:id":                              {"mayar_invoice.update", "mayar_invoice"},
+	"DELETE /api/mayar/invoices/:id":                           {"mayar_invoice.delete", "mayar_invoice"},
+	"POST /api/mayar/payment-requests":                         {"mayar_payment_request.create", "mayar_payment_request"},
+	"POST /api/mayar/customers":                                {"mayar_customer.create", "mayar_customer"},
+	"PUT /api/mayar/customers/:id":                             {"mayar_customer.update", "mayar_customer"},
+	"POST /api/mayar/webhooks/register":                        {"mayar_webhook.register", "mayar_webhook"},
+	"POST /api/mayar/webhooks/:id/test":                        {"mayar_webhook.test", "mayar_webhook"},
+	"POST /api/mayar/webhooks/retry/:historyId":                {"mayar_webhook.retry", "mayar_webhook"},
+	"POST /api/mayar/license/verify":                           {"mayar_license.verify", "mayar_license"},
+	"POST /api/mayar/license/activate":                         {"mayar_license.activate", "mayar_license"},
+	"POST /api/mayar/license/deactivate":                       {"mayar_license.deactivate", "mayar_license"},
+	"POST /api/mayar/coupons":                                  {"mayar_coupon.create", "mayar_coupon"},
+	"POST /api/mayar/coupons/apply":                            {"mayar_coupon.apply", "mayar_coupon"},
+	"POST /api/mayar/catalog/sync":                             {"catalog.sync", "catalog"},
+	"PUT /api/mayar/catalog/mappings/:productId":               {"catalog_mapping.update", "product"},
+	"DELETE /api/mayar/catalog/mappings/:productId":            {"catalog_mapping.delete", "product"},
+}
+
+// auditedRoute reports whether a route is an admin write that must be audited:
+// a mutating method behind an admin token or API key
+func auditedRoute(method, path string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return false
+	}
+	perm, ok := routePermission(method, path)
+	if !ok {
+		return false
+	}
+	return perm != AccessPublic && perm != AccessCustomer && perm != AccessPortal && perm != AccessAdminPanel
+}
+
+// UnauditedRoutes returns the registered admin write routes that have no entry in auditActions
+func UnauditedRoutes(routes gin.RoutesInfo) []string {
+	var missing []string
+	for _, route := range routes {
+		if !auditedRoute(route.Method, route.Path) {
+			continue
+		}
+		if _, ok := auditActions[route.Method+" "+route.Path]; !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	return missing
+}
+
+// RequestID is a middleware that gives every request an ID, taken from the
+// X-Request-ID header when the caller sent one, and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 100 {
+			requestID = GenerateRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+// requestIDFromContext returns the request's ID, generating one when the
+// RequestID middleware did not run
+func requestIDFromContext(c *gin.Context) string {
+	if requestID := c.GetString("request_id"); requestID != "" {
+		return requestID
+	}
+	requestID := GenerateRequestID()
+	c.Set("request_id", requestID)
+	return requestID
+}
+
+// auditChange records the state of the resource before and after the
+// request, for the audit event's diff. Either may be nil for creates and deletes.
+func auditChange(c *gin.Context, before, after interface{}) {
+	if before != nil {
+		c.Set(auditBeforeKey, before)
+	}
+	if after != nil {
+		c.Set(auditAfterKey, after)
+	}
+}
+
+// setAuditResourceID names the resource of the audit event when the route has
+// no :id, such as the ID of a newly created resource
+func setAuditResourceID(c *gin.Context, id string) {
+	c.Set(auditResourceIDKey, id)
+}
+
+// AuditEvent is one row of the audit log
+type AuditEvent struct {
+	ID           int64           `json:"id"`
+	OccurredAt   time.Time       `json:"occurredAt"`
+	ActorType    string          `json:"actorType"`
+	ActorID      *string         `json:"actorId"`
+	ActorName    *string         `json:"actorName"`
+	APIKeyID     *string         `json:"apiKeyId"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   *string         `json:"resourceId"`
+	Changes      json.RawMessage `json:"changes"`
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	StatusCode   int             `json:"statusCode"`
+	IPAddress    *string         `json:"ipAddress"`
+	RequestID    *string         `json:"requestId"`
+}
+
+// AuditFilter selects audit events; empty fields match everything
+type AuditFilter struct {
+	// Actor matches the actor's ID or name
+	Actor        string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	From         *time.Time
+	To           *time.Time
+}
+
+// where returns the WHERE clause and arguments for the filter
+func (f AuditFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Actor != "" {
+		add("(actor_id::text = $%[1]d OR actor_name = $%[1]d)", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.ResourceType != "" {
+		add("resource_type = $%d", f.ResourceType)
+	}
+	if f.ResourceID != "" {
+		add("resource_id = $%d", f.ResourceID)
+	}
+	if f.From != nil {
+		add("occurred_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("occurred_at < $%d", *f.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// auditEventColumns is the column list scanned by scanAuditEvent
+const auditEventColumns = `id, occurred_at, actor_type, actor_id, actor_name, api_key_id, action,
+	resource_type, resource_id, changes, method, path, status_code, ip_address, request_id`
+
+// scanAuditEvent scans one row selected with auditEventColumns
+func scanAuditEvent(rows *sql.Rows) (*AuditEvent, error) {
+	var event AuditEvent
+	var changes []byte
+	err := rows.Scan(
+		&event.ID, &event.OccurredAt, &event.ActorType, &event.ActorID, &event.ActorName, &event.APIKeyID, &event.Action,
+		&event.ResourceType, &event.ResourceID, &changes, &event.Method, &event.Path, &event.StatusCode, &event.IPAddress, &event.RequestID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan audit event: %w", err)
+	}
+	if len(changes) > 0 {
+		event.Changes = json.RawMessage(changes)
+	}
+	return &event, nil
+}
+
+// AuditLog stores and queries the append-only audit log
+type AuditLog struct {
+	db *sql.DB
+}
+
+// NewAuditLog creates a new AuditLog
+func NewAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{db: db}
+}
+
+// Record appends an event to the audit log
+func (l *AuditLog) Record(event *AuditEvent) error {
+	var changes interface{}
+	if len(event.Changes) > 0 {
+		changes = []byte(event.Changes)
+	}
+
+	query := `
+		INSERT INTO audit_events (actor_type, actor_id, actor_name, api_key_id, action, resource_type, resource_id,
+			changes, method, path, status_code, ip_address, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+	_, err := l.db.Exec(query,
+		event.ActorType, event.ActorID, event.ActorName, event.APIKeyID, event.Action, event.ResourceType, event.ResourceID,
+		changes, event.Method, event.Path, event.StatusCode, event.IPAddress, event.RequestID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// List returns one page of matching events, newest first, and the total number of matches
+func (l *AuditLog) List(filter AuditFilter, limit, offset int) ([]AuditEvent, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := l.db.QueryRow("SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM audit_events %s ORDER BY occurred_at DESC, id DESC LIMIT $%d OFFSET $%d",
+		auditEventColumns, where, len(args)+1, len(args)+2)
+	rows, err := l.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, *event)
+	}
+	return events, total, rows.Err()
+}
+
+// Each calls fn for every matching event, oldest first, without loading them all at once
+func (l *AuditLog) Each(filter AuditFilter, fn func(*AuditEvent) error) error {
+	where, args := filter.where()
+	query := fmt.Sprintf("SELECT %s FROM audit_events %s ORDER BY occurred_at, id", auditEventColumns, where)
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// auditRedactedFields are never written to the audit log; a change to one
+// shows as "[redacted]". Audit rows cannot be changed, so a data-subject
+// erasure could not remove personal data from them: contact details and notes
+// are redacted too, and people are known by their IDs.
+var auditRedactedFields = []string{
+	"password", "secret", "token", "hash", "recovery",
+	"email", "fullname", "phone", "address", "notes",
+}
+
+// redactAuditValue hides the value of a sensitive field
+func redactAuditValue(field string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	lower := strings.ToLower(field)
+	for _, sensitive := range auditRedactedFields {
+		if strings.Contains(lower, sensitive) {
+			return "[redacted]"
+		}
+	}
+	return value
+}
+
+// auditFields turns a resource into its JSON fields. Values that are not JSON
+// objects are stored under "value".
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return map[string]interface{}{"value": fmt.Sprintf("%v", v)}
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		var value interface{}
+		json.Unmarshal(data, &value)
+		return map[string]interface{}{"value": value}
+	}
+	return fields
+}
+
+// auditDiff returns {"field": {"before": ..., "after": ...}} for the fields
+// that differ between before and after, or nil when nothing was captured
+func auditDiff(before, after interface{}) map[string]interface{} {
+	if before == nil && after == nil {
+		return nil
+	}
+	beforeFields, afterFields := auditFields(before), auditFields(after)
+
+	changes := make(map[string]interface{})
+	seen := make(map[string]bool)
+	for _, fields := range []map[string]interface{}{beforeFields, afterFields} {
+		for field := range fields {
+			if seen[field] {
+				continue
+			}
+			seen[field] = true
+
+			oldValue, hadOld := beforeFields[field]
+			newValue, hasNew := afterFields[field]
+			if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+				continue
+			}
+			change := make(map[string]interface{})
+			if before != nil {
+				change["before"] = redactAuditValue(field, oldValue)
+			}
+			if after != nil {
+				change["after"] = redactAuditValue(field, newValue)
+			}
+			changes[field] = change
+		}
+	}
+	return changes
+}
+
+// stringOrNil returns a pointer to s, or nil when it is empty
+func stringOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// AuditTrail is a middleware that records every successful admin write in the
+// audit log once its handler has run. It must come after AuthorizeRoutes so
+// the actor is known. Failing to record is logged but does not fail the request.
+func AuditTrail(audit *AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		method, path := c.Request.Method, c.FullPath()
+		if !auditedRoute(method, path) || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		action, ok := auditActions[method+" "+path]
+		if !ok {
+			return
+		}
+
+		resourceID := c.GetString(auditResourceIDKey)
+		if resourceID == "" {
+			for _, param := range []string{"id", "productId", "historyId"} {
+				if resourceID = c.Param(param); resourceID != "" {
+					break
+				}
+			}
+		}
+
+		event := &AuditEvent{
+			ActorType:    "admin",
+			ActorID:      stringOrNil(c.GetString("user_id")),
+			ActorName:    stringOrNil(c.GetString("username")),
+			APIKeyID:     stringOrNil(c.GetString("api_key_id")),
+			Action:       action.Action,
+			ResourceType: action.ResourceType,
+			ResourceID:   stringOrNil(resourceID),
+			Method:       method,
+			Path:         c.Request.URL.Path,
+			StatusCode:   c.Writer.Status(),
+			IPAddress:    stringOrNil(c.ClientIP()),
+			RequestID:    stringOrNil(requestIDFromContext(c)),
+		}
+		if event.APIKeyID != nil {
+			event.ActorType = "api_key"
+		}
+
+		before, _ := c.Get(auditBeforeKey)
+		after, _ := c.Get(auditAfterKey)
+		if changes := auditDiff(before, after); changes != nil {
+			data, err := json.Marshal(changes)
+			if err == nil {
+				event.Changes = data
+			}
+		}
+
+		if err := audit.Record(event); err != nil {
+			LogError("Failed to record audit event", logrus.Fields{
+				"action":      event.Action,
+				"resource_id": resourceID,
+				"request_id":  event.RequestID,
+				"error":       err.Error(),
+			}, err)
+		}
+	}
+}
+
+// AuditHandler serves the audit log to admins
+type AuditHandler struct {
+	audit *AuditLog
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(audit *AuditLog) *AuditHandler {
+	return &AuditHandler{audit: audit}
+}
+
+// RegisterRoutes registers the audit log routes
+func (h *AuditHandler) RegisterRoutes(r *gin.Engine) {
+	auditGroup := r.Group("/api/audit")
+	{
+		auditGroup.GET("", h.List)
+		auditGroup.GET("/export", h.Export)
+	}
+}
+
+// parseAuditTime accepts RFC 3339 timestamps and plain dates
+func parseAuditTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q, use RFC 3339 or YYYY-MM-DD", value)
+	}
+	return &t, nil
+}
+
+// auditFilterFromQuery reads the filter query parameters shared by List and Export
+func auditFilterFromQuery(c *gin.Context) (AuditFilter, error) {
+	filter := AuditFilter{
+		Actor:        c.Query("actor"),
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resourceType"),
+		ResourceID:   c.Query("resourceId"),
+	}
+	var err error
+	if filter.From, err = parseAuditTime(c.Query("from")); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseAuditTime(c.Query("to")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// List handles GET /api/audit
+func (h *AuditHandler) List(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200 // Max limit
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	events, total, err := h.audit.List(filter, limit, offset)
+	if err != nil {
+		LogError("Failed to list audit events", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list audit events",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    events,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"total":  total,
+		},
+	})
+}
+
+// csvSafe stops spreadsheet programs from running a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// derefString returns the string s points to, or "" for nil
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Export handles GET /api/audit/export, streaming the matching events as CSV
+func (h *AuditHandler) Export(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-events-%s.csv", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{
+		"id", "occurred_at", "actor_type", "actor_id", "actor_name", "api_key_id", "action",
+		"resource_type", "resource_id", "method", "path", "status_code", "ip_address", "request_id", "changes",
+	})
+	err = h.audit.Each(filter, func(event *AuditEvent) error {
+		return writer.Write([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.OccurredAt.UTC().Format(time.RFC3339),
+			event.ActorType,
+			derefString(event.ActorID),
+			csvSafe(derefString(event.ActorName)),
+			derefString(event.APIKeyID),
+			event.Action,
+			event.ResourceType,
+			csvSafe(derefString(event.ResourceID)),
+			event.Method,
+			csvSafe(event.Path),
+			strconv.Itoa(event.StatusCode),
+			derefString(event.IPAddress),
+			csvSafe(derefString(event.RequestID)),
+			csvSafe(string(event.Changes)),
+		})
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		// The status is already sent, so the export ends early; the log says why
+		LogError("Failed to export audit events", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+	}
+}
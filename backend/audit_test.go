@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditDiffRedactsPersonalData(t *testing.T) {
+	before := User{ID: "user-1", Email: "old@example.com", FullName: "Old Name", Role: "customer"}
+	after := User{ID: "user-1", Email: "new@example.com", FullName: "New Name", Role: "admin"}
+
+	changes := auditDiff(before, after)
+	redacted := map[string]interface{}{"before": "[redacted]", "after": "[redacted]"}
+	for _, field := range []string{"email", "fullName"} {
+		if !reflect.DeepEqual(changes[field], redacted) {
+			t.Errorf("changes[%q] = %v, want %v", field, changes[field], redacted)
+		}
+	}
+	if want := map[string]interface{}{"before": "customer", "after": "admin"}; !reflect.DeepEqual(changes["role"], want) {
+		t.Errorf("changes[role] = %v, want %v", changes["role"], want)
+	}
+
+	// New notes are redacted as well, but a null value stays null
+	changes = auditDiff(nil, map[string]interface{}{"notes": "Ship to Jl. Merdeka 1", "customerPhone": nil})
+	if want := map[string]interface{}{"after": "[redacted]"}; !reflect.DeepEqual(changes["notes"], want) {
+		t.Errorf("changes[notes] = %v, want %v", changes["notes"], want)
+	}
+	if want := map[string]interface{}{"after": nil}; !reflect.DeepEqual(changes["customerPhone"], want) {
+		t.Errorf("changes[customerPhone] = %v, want %v", changes["customerPhone"], want)
+	}
+}
@@ -5,16 +5,17 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
 )
 
-// JWT Secret - in production, this should be from environment variable
-var jwtSecret = []byte("urgent-studio-jwt-secret-2025") // TODO: Move to .env
+// ErrInvalidCredentials is returned for an unknown username or a wrong password
+var ErrInvalidCredentials = errors.New("invalid credentials")
 
 // AdminUser represents an admin user
 type AdminUser struct {
@@ -46,36 +47,63 @@ type JWTClaims struct {
 	Username string `json:"username"`
 	Role     string `json:"role"`
 	TokenID  string `json:"token_id"`
+	// SessionID is the admin_sessions row the token belongs to; empty for
+	// tokens issued outside a session
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // AuthService handles authentication operations
 type AuthService struct {
-	db *sql.DB
+	db        *sql.DB
+	keys      *JWTKeyRing
+	passwords *PasswordService
+	tokenTTL  time.Duration
+	// refreshTTL is how long an unused refresh token stays valid
+	refreshTTL time.Duration
+	// sessionLifetime caps a session however often it is refreshed
+	sessionLifetime time.Duration
+	// totpRequiredRoles must complete TOTP at login, enrolling first if needed
+	totpRequiredRoles map[string]bool
+	totpIssuer        string
+	mfaChallengeTTL   time.Duration
 }
 
-// NewAuthService creates a new auth service
-func NewAuthService(db *sql.DB) *AuthService {
-	return &AuthService{db: db}
+// NewAuthService creates a new auth service that signs tokens with the current key of keys
+func NewAuthService(db *sql.DB, keys *JWTKeyRing, passwords *PasswordService) *AuthService {
+	return &AuthService{
+		db:                db,
+		keys:              keys,
+		passwords:         passwords,
+		tokenTTL:          getEnvDurationWithDefault("JWT_EXPIRATION", 15*time.Minute),
+		refreshTTL:        getEnvDurationWithDefault("JWT_REFRESH_EXPIRATION", 12*time.Hour),
+		sessionLifetime:   getEnvDurationWithDefault("ADMIN_SESSION_LIFETIME", 7*24*time.Hour),
+		totpRequiredRoles: parseTOTPRequiredRoles(getEnvWithDefault("ADMIN_TOTP_REQUIRED_ROLES", "")),
+		totpIssuer:        getEnvWithDefault("ADMIN_TOTP_ISSUER", "Urgent Studio"),
+		mfaChallengeTTL:   getEnvDurationWithDefault("ADMIN_MFA_CHALLENGE_TTL", 5*time.Minute),
+	}
 }
 
-// HashPassword hashes a password using bcrypt
+// HashPassword hashes a password with the configured bcrypt cost
 func (a *AuthService) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return a.passwords.Hash(password)
 }
 
 // CheckPassword verifies a password against its hash
 func (a *AuthService) CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	ok, _ := a.passwords.Verify(hash, password)
+	return ok
 }
 
-// CreateAdminUser creates a new admin user
+// CreateAdminUser creates a new admin user. The password must satisfy the password policy.
 func (a *AuthService) CreateAdminUser(username, email, password, fullName, role string) (*AdminUser, error) {
-	hashedPassword, err := a.HashPassword(password)
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid admin role: %s", role)
+	}
+
+	hashedPassword, err := a.passwords.HashNew(a.db, PasswordAccountAdmin, "", password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, err
 	}
 
 	query := `
@@ -93,6 +121,10 @@ func (a *AuthService) CreateAdminUser(username, email, password, fullName, role
 		return nil, fmt.Errorf("failed to create admin user: %w", err)
 	}
 
+	if err := a.passwords.Remember(a.db, PasswordAccountAdmin, user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
@@ -123,65 +155,156 @@ func (a *AuthService) UpdateLastLogin(userID string) error {
 	return err
 }
 
-// Authenticate authenticates a user and returns a JWT token
-func (a *AuthService) Authenticate(username, password string) (string, error) {
-	fmt.Printf("DEBUG: Authenticating user: %s\n", username)
+// ListAdminUsers returns every admin user, active or not, ordered by username
+func (a *AuthService) ListAdminUsers() ([]AdminUser, error) {
+	query := `
+		SELECT id, username, email, full_name, role, is_active, last_login, created_at, updated_at
+		FROM admin_users
+		ORDER BY username
+	`
 
-	// Get user by username
-	user, err := a.GetAdminUserByUsername(username)
+	rows, err := a.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []AdminUser
+	for rows.Next() {
+		var user AdminUser
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.Email, &user.FullName, &user.Role,
+			&user.IsActive, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan admin user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// adminUserID returns the ID of an admin user, active or not
+func (a *AuthService) adminUserID(username string) (string, error) {
+	var userID string
+	err := a.db.QueryRow(`SELECT id FROM admin_users WHERE username = $1`, username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("admin user %s not found", username)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get admin user: %w", err)
+	}
+	return userID, nil
+}
+
+// adminPasswordHash returns the stored password hash of an admin user, active or not
+func (a *AuthService) adminPasswordHash(username string) (string, error) {
+	var hash string
+	err := a.db.QueryRow(`SELECT password_hash FROM admin_users WHERE username = $1`, username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("admin user %s not found", username)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get admin user: %w", err)
+	}
+	return hash, nil
+}
+
+// SetAdminPassword replaces an admin user's password and revokes all of their
+// tokens and sessions. The password must satisfy the password policy.
+func (a *AuthService) SetAdminPassword(username, password string) error {
+	userID, err := a.adminUserID(username)
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to get user: %v\n", err)
-		return "", fmt.Errorf("invalid credentials")
+		return err
 	}
 
-	fmt.Printf("DEBUG: Found user: %s, hash: %s\n", user.Username, user.PasswordHash)
+	err = a.withTx("SetAdminPassword", func(tx *sql.Tx) error {
+		hashedPassword, err := a.passwords.HashNew(tx, PasswordAccountAdmin, userID, password)
+		if err != nil {
+			return err
+		}
 
-	// Check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+		query := `UPDATE admin_users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+		if _, err := tx.Exec(query, hashedPassword, userID); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		return a.passwords.Remember(tx, PasswordAccountAdmin, userID, hashedPassword)
+	})
 	if err != nil {
-		fmt.Printf("DEBUG: Password check failed: %v\n", err)
-		return "", fmt.Errorf("invalid credentials")
+		return err
 	}
 
-	fmt.Printf("DEBUG: Password check passed\n")
+	return a.RevokeAllUserTokens(userID)
+}
 
-	// Update last login
-	err = a.UpdateLastLogin(user.ID)
+// SetAdminActive enables or disables an admin user. Disabling also revokes
+// all of the user's tokens and sessions.
+func (a *AuthService) SetAdminActive(username string, active bool) error {
+	userID, err := a.adminUserID(username)
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to update last login: %v\n", err)
-		// Log error but don't fail authentication
-		// In production, you might want to log this properly
+		return err
 	}
 
-	// Generate token
-	token, err := a.GenerateToken(user)
+	query := `UPDATE admin_users SET is_active = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	if _, err := a.db.Exec(query, active, userID); err != nil {
+		return fmt.Errorf("failed to update admin user: %w", err)
+	}
+
+	if !active {
+		return a.RevokeAllUserTokens(userID)
+	}
+	return nil
+}
+
+// Authenticate checks a user's password and either starts a session or, when
+// TOTP is enabled or required for the user, returns a challenge for the code
+func (a *AuthService) Authenticate(username, password string, client SessionClient) (*LoginResult, error) {
+	// Get user by username. An unknown user still costs one bcrypt comparison
+	// so response times do not reveal which usernames exist.
+	user, err := a.GetAdminUserByUsername(username)
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to generate token: %v\n", err)
-		return "", fmt.Errorf("failed to generate token: %w", err)
+		a.passwords.CompareDummy(password)
+		return nil, ErrInvalidCredentials
 	}
 
-	fmt.Printf("DEBUG: Token generated successfully\n")
+	// Check password, upgrading a hash made with an older algorithm or cost
+	ok, needsRehash := a.passwords.Verify(user.PasswordHash, password)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		a.passwords.Rehash(a.db, PasswordAccountAdmin, user.ID, user.PasswordHash, password)
+	}
 
-	return token, nil
+	// Start a session, or ask for a TOTP code first
+	return a.passwordVerified(user, client)
 }
 
 // GenerateToken generates a JWT token for an admin user
 func (a *AuthService) GenerateToken(user *AdminUser) (string, error) {
+	tokenString, _, _, err := a.issueAccessToken(user, "")
+	return tokenString, err
+}
+
+// issueAccessToken signs and stores an access token for a session, returning it with its ID and expiry
+func (a *AuthService) issueAccessToken(user *AdminUser, sessionID string) (string, string, time.Time, error) {
 	// Generate a random UUID for token ID
 	tokenID, err := generateUUID()
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token ID: %w", err)
+		return "", "", time.Time{}, fmt.Errorf("failed to generate token ID: %w", err)
 	}
 
-	// Set token expiration (24 hours)
-	expiresAt := time.Now().Add(24 * time.Hour)
+	// Set token expiration (JWT_EXPIRATION, 15 minutes by default); sessions
+	// outlive it through refresh tokens
+	expiresAt := time.Now().Add(a.tokenTTL)
 
 	// Create JWT claims
 	claims := JWTClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
-		TokenID:  tokenID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		TokenID:   tokenID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -191,32 +314,33 @@ func (a *AuthService) GenerateToken(user *AdminUser) (string, error) {
 		},
 	}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	// Create token, naming the signing key in the kid header
+	key, err := a.keys.Current()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.signingKey())
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
 	}
 
 	// Store token hash in database
 	tokenHash := hashToken(tokenString)
 	err = a.storeToken(user.ID, tokenID, tokenHash, expiresAt)
 	if err != nil {
-		return "", fmt.Errorf("failed to store token: %w", err)
+		return "", "", time.Time{}, fmt.Errorf("failed to store token: %w", err)
 	}
 
-	return tokenString, nil
+	return tokenString, tokenID, expiresAt, nil
 }
 
 // ValidateToken validates a JWT token and returns the claims
 func (a *AuthService) ValidateToken(tokenString string) (*JWTClaims, error) {
-	// Parse token
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	// Parse token with the key named by its kid header; tokens signed with a
+	// key retired longer than the rotation window are rejected
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, a.keys.keyFunc)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -233,6 +357,12 @@ func (a *AuthService) ValidateToken(tokenString string) (*JWTClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	// Customer and portal tokens are signed with the same keys but carry an
+	// audience; admin tokens have none, so an audience never grants admin access
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("token with audience %v used for admin access", claims.Audience)
+	}
+
 	// Check if token exists in database and is not revoked
 	tokenHash := hashToken(tokenString)
 	isValid, err := a.isTokenValid(claims.TokenID, tokenHash)
@@ -254,16 +384,44 @@ func (a *AuthService) RevokeToken(tokenID string) error {
 	return err
 }
 
-// RevokeAllUserTokens revokes all tokens for a user
+// RevokeAllUserTokens revokes all tokens for a user, ending every session
 func (a *AuthService) RevokeAllUserTokens(userID string) error {
 	query := `UPDATE admin_tokens SET is_revoked = true WHERE admin_user_id = $1`
+	if _, err := a.db.Exec(query, userID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE admin_refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = $2
+		WHERE admin_user_id = $1 AND revoked_at IS NULL
+	`
+	if _, err := a.db.Exec(query, userID, RefreshRevokedAllSessions); err != nil {
+		return err
+	}
+
+	query = `UPDATE admin_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE admin_user_id = $1 AND revoked_at IS NULL`
 	_, err := a.db.Exec(query, userID)
 	return err
 }
 
-// CleanupExpiredTokens removes expired tokens from database
+// CleanupExpiredTokens removes expired and revoked tokens, sessions and login challenges from database
 func (a *AuthService) CleanupExpiredTokens() error {
-	query := `DELETE FROM admin_tokens WHERE expires_at < CURRENT_TIMESTAMP OR is_revoked = true`
+	query := `DELETE FROM admin_refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP`
+	if _, err := a.db.Exec(query); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM admin_tokens WHERE expires_at < CURRENT_TIMESTAMP OR is_revoked = true`
+	if _, err := a.db.Exec(query); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM admin_sessions WHERE expires_at < CURRENT_TIMESTAMP OR revoked_at IS NOT NULL`
+	if _, err := a.db.Exec(query); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM admin_mfa_challenges WHERE expires_at < CURRENT_TIMESTAMP`
 	_, err := a.db.Exec(query)
 	return err
 }
@@ -301,8 +459,6 @@ func hashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-
-
 // generateUUID generates a random UUID string
 func generateUUID() (string, error) {
 	bytes := make([]byte, 16)
@@ -321,37 +477,64 @@ func generateUUID() (string, error) {
 // AuthMiddleware is a middleware function to validate JWT tokens
 func AuthMiddleware(authService *AuthService) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		// Get token from Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(401, gin.H{"error": "Authorization header required"})
-			c.Abort()
+		if !authenticateRequest(authService, c) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Extract token from "Bearer <token>" format
-		if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-			c.JSON(401, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
-			return
+// authenticateRequest validates the bearer token and sets the user info in the
+// context. On failure it writes a 401, aborts and returns false.
+func authenticateRequest(authService *AuthService, c *gin.Context) bool {
+	// Get token from Authorization header
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		// The admin panel's pages call the API with their session cookie instead
+		if cookie, err := c.Cookie(adminAccessCookie); err == nil && cookie != "" {
+			return authenticatePanelRequest(authService, c, cookie)
 		}
+		c.JSON(401, gin.H{"error": "Authorization header required"})
+		c.Abort()
+		return false
+	}
 
-		tokenString := authHeader[7:]
+	// Extract token from "Bearer <token>" format
+	if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+		c.JSON(401, gin.H{"error": "Invalid authorization header format"})
+		c.Abort()
+		return false
+	}
 
-		// Validate token
-		claims, err := authService.ValidateToken(tokenString)
-		if err != nil {
-			c.JSON(401, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
-		}
+	tokenString := authHeader[7:]
 
-		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
-		c.Set("token_id", claims.TokenID)
+	// Validate token
+	claims, err := authService.ValidateToken(tokenString)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
 
-		c.Next()
+	setAdminClaims(authService, c, claims)
+	return true
+}
+
+// setAdminClaims sets the info of an authenticated admin in the context and
+// records that the session was used
+func setAdminClaims(authService *AuthService, c *gin.Context, claims *JWTClaims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("token_id", claims.TokenID)
+	c.Set("session_id", claims.SessionID)
+
+	if claims.SessionID != "" {
+		if err := authService.TouchSession(claims.SessionID, c.ClientIP()); err != nil {
+			LogWarn("Failed to update session last used time", logrus.Fields{
+				"session_id": claims.SessionID,
+				"error":      err.Error(),
+			})
+		}
 	}
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons a refresh token was revoked, stored in admin_refresh_tokens.revoked_reason
+const (
+	RefreshRevokedLogout      = "logout"
+	RefreshRevokedReuse       = "reuse_detected"
+	RefreshRevokedAllSessions = "all_sessions"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for an unknown, revoked or expired refresh token
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is presented a second time
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrSessionExpired is returned when a session reached its absolute lifetime
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// TokenPair is what a login or refresh returns: a short-lived access token and
+// the single-use refresh token that replaces it
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	SessionExpiresAt time.Time `json:"sessionExpiresAt"`
+}
+
+// generateRefreshToken returns a random opaque refresh token
+func generateRefreshToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// StartSession records a new session for user and issues its first token pair
+func (a *AuthService) StartSession(user *AdminUser, client SessionClient) (*TokenPair, error) {
+	familyID, err := generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	sessionExpiresAt := time.Now().Add(a.sessionLifetime)
+
+	query := `
+		INSERT INTO admin_sessions (id, admin_user_id, ip_address, user_agent, device, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = a.db.Exec(query, familyID, user.ID, client.IPAddress, client.UserAgent, describeDevice(client.UserAgent), sessionExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return a.issueTokenPair(user, familyID, sessionExpiresAt)
+}
+
+// issueTokenPair issues an access token and a refresh token in the given family.
+// The refresh token never outlives the session.
+func (a *AuthService) issueTokenPair(user *AdminUser, familyID string, sessionExpiresAt time.Time) (*TokenPair, error) {
+	accessToken, tokenID, expiresAt, err := a.issueAccessToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshExpiresAt := time.Now().Add(a.refreshTTL)
+	if refreshExpiresAt.After(sessionExpiresAt) {
+		refreshExpiresAt = sessionExpiresAt
+	}
+
+	query := `
+		INSERT INTO admin_refresh_tokens (admin_user_id, family_id, token_hash, access_token_id,
+		                                  expires_at, session_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = a.db.Exec(query, user.ID, familyID, hashToken(refreshToken), tokenID, refreshExpiresAt, sessionExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+		SessionExpiresAt: sessionExpiresAt,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same session.
+// Each refresh token works once: presenting a used token again means it was
+// stolen, so the whole session is revoked.
+func (a *AuthService) Refresh(refreshToken string, client SessionClient) (*TokenPair, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in Refresh", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	var id, userID, familyID string
+	var expiresAt, sessionExpiresAt time.Time
+	var usedAt, revokedAt *time.Time
+	err = tx.QueryRow(`
+		SELECT id, admin_user_id, family_id, expires_at, session_expires_at, used_at, revoked_at
+		FROM admin_refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, hashToken(refreshToken)).Scan(&id, &userID, &familyID, &expiresAt, &sessionExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if usedAt != nil {
+		if revokeErr := a.revokeFamily(tx, familyID, RefreshRevokedReuse); revokeErr != nil {
+			return nil, revokeErr
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
+		}
+		LogSecurity("refresh_token_reused", logrus.Fields{
+			"admin_user_id": userID,
+			"family_id":     familyID,
+			"used_at":       usedAt,
+		})
+		return nil, ErrRefreshTokenReused
+	}
+	if revokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	now := time.Now()
+	if !now.Before(sessionExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	if !now.Before(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := a.getActiveAdminUserByID(tx, userID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err = tx.Exec("UPDATE admin_refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1", id); err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE admin_sessions SET last_used_at = CURRENT_TIMESTAMP, ip_address = $2, user_agent = $3, device = $4
+		WHERE id = $1
+	`, familyID, client.IPAddress, client.UserAgent, describeDevice(client.UserAgent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	tokens, err := a.issueTokenPair(user, familyID, sessionExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Link the used token to its successor so a session's chain can be followed
+	_, linkErr := a.db.Exec(`
+		UPDATE admin_refresh_tokens SET replaced_by = (SELECT id FROM admin_refresh_tokens WHERE token_hash = $2)
+		WHERE id = $1
+	`, id, hashToken(tokens.RefreshToken))
+	if linkErr != nil {
+		LogWarn("Failed to link refresh token to its successor", logrus.Fields{
+			"refresh_token_id": id,
+			"error":            linkErr.Error(),
+		})
+	}
+
+	return tokens, nil
+}
+
+// getActiveAdminUserByID loads an active admin user inside a transaction
+func (a *AuthService) getActiveAdminUserByID(tx *sql.Tx, userID string) (*AdminUser, error) {
+	query := `
+		SELECT id, username, email, password_hash, full_name, role, is_active, last_login, created_at, updated_at
+		FROM admin_users
+		WHERE id = $1 AND is_active = true
+	`
+
+	var user AdminUser
+	err := tx.QueryRow(query, userID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName,
+		&user.Role, &user.IsActive, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get admin user: %w", err)
+	}
+	return &user, nil
+}
+
+// revokeFamily revokes every refresh token of a session and the access tokens issued with them
+func (a *AuthService) revokeFamily(tx *sql.Tx, familyID, reason string) error {
+	_, err := tx.Exec(`
+		UPDATE admin_tokens SET is_revoked = true
+		WHERE id IN (SELECT access_token_id FROM admin_refresh_tokens WHERE family_id = $1)
+	`, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session access tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE admin_refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = $2
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session refresh tokens: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE admin_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL", familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// EndSession revokes the session an access token belongs to, including its
+// refresh tokens, so logging out cannot be undone by refreshing
+func (a *AuthService) EndSession(tokenID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in EndSession", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	if _, err = tx.Exec("UPDATE admin_tokens SET is_revoked = true WHERE id = $1", tokenID); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	var familyID string
+	err = tx.QueryRow("SELECT family_id FROM admin_refresh_tokens WHERE access_token_id = $1", tokenID).Scan(&familyID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to find session: %w", err)
+	}
+	if familyID != "" {
+		if err = a.revokeFamily(tx, familyID, RefreshRevokedLogout); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
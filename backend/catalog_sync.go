@@ -0,0 +1,765 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// =============================================================================
+// PRODUCT MAPPINGS
+// =============================================================================
+
+// ProductMapping links a local product to its Mayar product
+type ProductMapping struct {
+	ID             string     `json:"id"`
+	ProductID      string     `json:"productId"`
+	MayarProductID string     `json:"mayarProductId"`
+	LastSyncedAt   *time.Time `json:"lastSyncedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// ProductMappingRepository handles database operations for product mappings
+type ProductMappingRepository struct {
+	db *sql.DB
+}
+
+// NewProductMappingRepository creates a new product mapping repository
+func NewProductMappingRepository(db *sql.DB) *ProductMappingRepository {
+	return &ProductMappingRepository{db: db}
+}
+
+const productMappingColumns = `id, product_id, mayar_product_id, last_synced_at, created_at, updated_at`
+
+func scanProductMapping(row interface{ Scan(...interface{}) error }) (*ProductMapping, error) {
+	var m ProductMapping
+	err := row.Scan(&m.ID, &m.ProductID, &m.MayarProductID, &m.LastSyncedAt, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetAllMappings retrieves every product mapping
+func (r *ProductMappingRepository) GetAllMappings() ([]ProductMapping, error) {
+	rows, err := r.db.Query("SELECT " + productMappingColumns + " FROM mayar_product_mappings ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product mappings: %w", err)
+	}
+	defer rows.Close()
+
+	mappings := []ProductMapping{}
+	for rows.Next() {
+		m, scanErr := scanProductMapping(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan product mapping: %w", scanErr)
+		}
+		mappings = append(mappings, *m)
+	}
+
+	return mappings, rows.Err()
+}
+
+// GetMappingByProductID retrieves the mapping of a local product
+func (r *ProductMappingRepository) GetMappingByProductID(productID string) (*ProductMapping, error) {
+	m, err := scanProductMapping(r.db.QueryRow(
+		"SELECT "+productMappingColumns+" FROM mayar_product_mappings WHERE product_id = $1", productID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("mapping not found")
+		}
+		return nil, fmt.Errorf("failed to get product mapping: %w", err)
+	}
+	return m, nil
+}
+
+// UpsertMapping links a local product to a Mayar product, replacing any previous link of the product
+func (r *ProductMappingRepository) UpsertMapping(productID, mayarProductID string) (*ProductMapping, error) {
+	query := `
+		INSERT INTO mayar_product_mappings (product_id, mayar_product_id)
+		VALUES ($1, $2)
+		ON CONFLICT (product_id) DO UPDATE
+		SET mayar_product_id = EXCLUDED.mayar_product_id, updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + productMappingColumns
+
+	m, err := scanProductMapping(r.db.QueryRow(query, productID, mayarProductID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save product mapping: %w", err)
+	}
+	return m, nil
+}
+
+// MarkSynced records when a mapped product was last synced
+func (r *ProductMappingRepository) MarkSynced(productID string, syncedAt time.Time) error {
+	_, err := r.db.Exec(
+		"UPDATE mayar_product_mappings SET last_synced_at = $2, updated_at = CURRENT_TIMESTAMP WHERE product_id = $1",
+		productID, syncedAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark product mapping synced: %w", err)
+	}
+	return nil
+}
+
+// DeleteMapping unlinks a local product from Mayar
+func (r *ProductMappingRepository) DeleteMapping(productID string) error {
+	result, err := r.db.Exec("DELETE FROM mayar_product_mappings WHERE product_id = $1", productID)
+	if err != nil {
+		return fmt.Errorf("failed to delete product mapping: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("mapping not found")
+	}
+	return nil
+}
+
+// =============================================================================
+// SYNC SERVICE
+// =============================================================================
+
+var (
+	// ErrCatalogSyncDisabled is returned when catalog sync runs without a Mayar service
+	ErrCatalogSyncDisabled = errors.New("catalog sync is disabled: Mayar is not configured")
+	// ErrCatalogSyncRunning is returned when a sync is requested while one is in progress
+	ErrCatalogSyncRunning = errors.New("catalog sync is already running")
+)
+
+// catalogSyncPageSize is the Mayar page size used when fetching the catalog
+const catalogSyncPageSize = 50
+
+// CatalogSyncChange describes one product changed (or, in a dry run, to be changed) by a sync
+type CatalogSyncChange struct {
+	Action         string   `json:"action"` // import, link or update
+	ProductID      string   `json:"productId,omitempty"`
+	MayarProductID string   `json:"mayarProductId"`
+	Name           string   `json:"name"`
+	Fields         []string `json:"fields,omitempty"`
+}
+
+// CatalogSyncResult summarises a sync run
+type CatalogSyncResult struct {
+	Trigger    string              `json:"trigger"`
+	DryRun     bool                `json:"dryRun"`
+	StartedAt  time.Time           `json:"startedAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	Imported   int                 `json:"imported"`
+	Linked     int                 `json:"linked"`
+	Updated    int                 `json:"updated"`
+	Unchanged  int                 `json:"unchanged"`
+	Skipped    int                 `json:"skipped"` // mapped to a deleted local product
+	Changes    []CatalogSyncChange `json:"changes"`
+	Errors     []string            `json:"errors"`
+}
+
+// CatalogDrift is a field whose value differs between a local product and its Mayar product
+type CatalogDrift struct {
+	ProductID      string      `json:"productId"`
+	MayarProductID string      `json:"mayarProductId"`
+	Name           string      `json:"name"`
+	Field          string      `json:"field"`
+	LocalValue     interface{} `json:"localValue"`
+	MayarValue     interface{} `json:"mayarValue"`
+}
+
+// CatalogProductRef identifies a product that exists on only one side
+type CatalogProductRef struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Price Money  `json:"price"`
+}
+
+// CatalogDiffReport compares the local catalog with Mayar
+type CatalogDiffReport struct {
+	GeneratedAt    time.Time           `json:"generatedAt"`
+	InSync         int                 `json:"inSync"`
+	Drift          []CatalogDrift      `json:"drift"`
+	UnmappedLocal  []CatalogProductRef `json:"unmappedLocal"`
+	UnmappedMayar  []CatalogProductRef `json:"unmappedMayar"`
+	MissingInMayar []ProductMapping    `json:"missingInMayar"`
+}
+
+// mayarOwnedChanges lists the fields an edit of a product changes that Mayar
+// is the source of truth for on mapped products: name, description and price.
+// The Mayar API cannot edit or create products, so these are changed in the
+// Mayar dashboard and reach the local product on the next sync.
+func mayarOwnedChanges(existing, updated Product) []string {
+	var fields []string
+	if existing.Name != updated.Name {
+		fields = append(fields, "name")
+	}
+	if existing.Description != updated.Description {
+		fields = append(fields, "description")
+	}
+	if !existing.Price.Equal(updated.Price) {
+		fields = append(fields, "price")
+	}
+	return fields
+}
+
+// CatalogSyncService keeps the local products table and the Mayar catalog in
+// step. Each field has one direction: name, description and price flow from
+// Mayar into mapped local products, and local edits of them are refused; every
+// other field is owned locally, and closing or reopening a local product is
+// pushed to Mayar.
+// Unmapped local products cannot be created in Mayar through its API; the diff
+// lists them so they can be created in the Mayar dashboard and linked.
+type CatalogSyncService struct {
+	mayar    *MayarService
+	products *ProductRepository
+	mappings *ProductMappingRepository
+
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *CatalogSyncResult
+}
+
+// NewCatalogSyncService creates a catalog sync service. A nil Mayar service disables syncing.
+func NewCatalogSyncService(mayar *MayarService, products *ProductRepository, mappings *ProductMappingRepository) *CatalogSyncService {
+	return &CatalogSyncService{
+		mayar:    mayar,
+		products: products,
+		mappings: mappings,
+	}
+}
+
+// Enabled reports whether the catalog can be synced
+func (s *CatalogSyncService) Enabled() bool {
+	return s != nil && s.mayar != nil
+}
+
+// LastResult returns the result of the most recent sync run, if any
+func (s *CatalogSyncService) LastResult() *CatalogSyncResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// fetchMayarProducts pages through the whole Mayar catalog
+func (s *CatalogSyncService) fetchMayarProducts(ctx context.Context) ([]Product, error) {
+	var products []Product
+	for page := 1; ; page++ {
+		result, err := s.mayar.GetProducts(ctx, ProductListParams{Page: page, PageSize: catalogSyncPageSize})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch Mayar products page %d: %w", page, err)
+		}
+		products = append(products, result.Data...)
+
+		if len(result.Data) < catalogSyncPageSize || (result.Meta.TotalPages > 0 && page >= result.Meta.TotalPages) {
+			return products, nil
+		}
+	}
+}
+
+// productDrift lists the synced fields whose values differ between a local and a Mayar product
+func productDrift(local, remote Product) []CatalogDrift {
+	var drift []CatalogDrift
+	add := func(field string, localValue, mayarValue interface{}) {
+		drift = append(drift, CatalogDrift{
+			ProductID:      local.ID,
+			MayarProductID: remote.ID,
+			Name:           local.Name,
+			Field:          field,
+			LocalValue:     localValue,
+			MayarValue:     mayarValue,
+		})
+	}
+
+	if local.Name != remote.Name {
+		add("name", local.Name, remote.Name)
+	}
+	if remote.Description != "" && local.Description != remote.Description {
+		add("description", local.Description, remote.Description)
+	}
+	if !local.Price.Equal(remote.Price) {
+		add("price", local.Price, remote.Price)
+	}
+	return drift
+}
+
+// Sync imports new Mayar products and updates mapped local products from Mayar.
+// Unmapped Mayar products are linked to a local product with the same product
+// code or name before a new local product is created. Mayar products mapped to
+// a deleted local product are left alone until it is restored or purged. A dry
+// run only reports.
+func (s *CatalogSyncService) Sync(ctx context.Context, trigger string, dryRun bool) (*CatalogSyncResult, error) {
+	if !s.Enabled() {
+		return nil, ErrCatalogSyncDisabled
+	}
+	if !s.running.TryLock() {
+		return nil, ErrCatalogSyncRunning
+	}
+	defer s.running.Unlock()
+
+	result := &CatalogSyncResult{
+		Trigger:   trigger,
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Changes:   []CatalogSyncChange{},
+		Errors:    []string{},
+	}
+
+	remoteProducts, err := s.fetchMayarProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted products are loaded too so their mappings are still recognised
+	localProducts, err := s.products.GetAllProducts(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local products: %w", err)
+	}
+	mappings, err := s.mappings.GetAllMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	localByID := make(map[string]Product, len(localProducts))
+	for _, p := range localProducts {
+		localByID[p.ID] = p
+	}
+	productByMayarID := make(map[string]string, len(mappings))
+	mappedLocal := make(map[string]bool, len(mappings))
+	for _, m := range mappings {
+		productByMayarID[m.MayarProductID] = m.ProductID
+		mappedLocal[m.ProductID] = true
+	}
+
+	for _, remote := range remoteProducts {
+		local, mapped := localByID[productByMayarID[remote.ID]]
+		action := "update"
+
+		if mapped && local.DeletedAt != nil {
+			result.Skipped++
+			continue
+		}
+
+		if !mapped {
+			// Link to an unmapped local product with the same code or name
+			for _, candidate := range localProducts {
+				if mappedLocal[candidate.ID] || candidate.DeletedAt != nil {
+					continue
+				}
+				sameCode := remote.ProductCode != "" && candidate.ProductCode == remote.ProductCode
+				if sameCode || strings.EqualFold(strings.TrimSpace(candidate.Name), strings.TrimSpace(remote.Name)) {
+					local, mapped, action = candidate, true, "link"
+					break
+				}
+			}
+		}
+
+		if !mapped {
+			result.Imported++
+			result.Changes = append(result.Changes, CatalogSyncChange{Action: "import", MayarProductID: remote.ID, Name: remote.Name})
+			if !dryRun {
+				if importErr := s.importProduct(remote); importErr != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("import %s: %v", remote.ID, importErr))
+				}
+			}
+			continue
+		}
+
+		drift := productDrift(local, remote)
+		var fields []string
+		for _, d := range drift {
+			fields = append(fields, d.Field)
+		}
+
+		switch {
+		case action == "link":
+			result.Linked++
+		case len(fields) > 0:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+		if action == "link" || len(fields) > 0 {
+			result.Changes = append(result.Changes, CatalogSyncChange{
+				Action:         action,
+				ProductID:      local.ID,
+				MayarProductID: remote.ID,
+				Name:           remote.Name,
+				Fields:         fields,
+			})
+		}
+		mappedLocal[local.ID] = true
+
+		if dryRun {
+			continue
+		}
+		if syncErr := s.syncMappedProduct(local, remote, action == "link", len(fields) > 0); syncErr != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s %s: %v", action, remote.ID, syncErr))
+		}
+	}
+
+	result.FinishedAt = time.Now()
+	if !dryRun {
+		s.mu.Lock()
+		s.last = result
+		s.mu.Unlock()
+	}
+
+	LogInfo("Catalog sync finished", logrus.Fields{
+		"trigger":   trigger,
+		"dry_run":   dryRun,
+		"imported":  result.Imported,
+		"linked":    result.Linked,
+		"updated":   result.Updated,
+		"unchanged": result.Unchanged,
+		"skipped":   result.Skipped,
+		"errors":    len(result.Errors),
+	})
+
+	return result, nil
+}
+
+// importProduct creates a local product for a Mayar product and maps them
+func (s *CatalogSyncService) importProduct(remote Product) error {
+	product := Product{
+		ProductCode:  remote.ProductCode,
+		Name:         remote.Name,
+		Description:  remote.Description,
+		Price:        remote.Price,
+		Category:     remote.Category,
+		ImageURL:     remote.ImageURL,
+		Features:     remote.Features,
+		DeliveryTime: remote.DeliveryTime,
+		Revisions:    remote.Revisions,
+	}
+	if product.ProductCode == "" {
+		product.ProductCode = "MAYAR-" + remote.ID
+	}
+	if product.Category == "" {
+		product.Category = "jasa-satuan"
+	}
+
+	created, err := s.products.CreateProduct(product)
+	if err != nil {
+		return err
+	}
+	if _, err := s.mappings.UpsertMapping(created.ID, remote.ID); err != nil {
+		return err
+	}
+	return s.mappings.MarkSynced(created.ID, time.Now())
+}
+
+// syncMappedProduct links and updates a local product from its Mayar product
+func (s *CatalogSyncService) syncMappedProduct(local, remote Product, link, changed bool) error {
+	if link {
+		if _, err := s.mappings.UpsertMapping(local.ID, remote.ID); err != nil {
+			return err
+		}
+	}
+	if changed {
+		local.Name = remote.Name
+		local.Price = remote.Price
+		if remote.Description != "" {
+			local.Description = remote.Description
+		}
+		if _, err := s.products.UpdateProduct(local); err != nil {
+			return err
+		}
+	}
+	return s.mappings.MarkSynced(local.ID, time.Now())
+}
+
+// Diff compares the local catalog with Mayar without changing anything
+func (s *CatalogSyncService) Diff(ctx context.Context) (*CatalogDiffReport, error) {
+	if !s.Enabled() {
+		return nil, ErrCatalogSyncDisabled
+	}
+
+	remoteProducts, err := s.fetchMayarProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	localProducts, err := s.products.GetAllProducts(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local products: %w", err)
+	}
+	mappings, err := s.mappings.GetAllMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CatalogDiffReport{
+		GeneratedAt:    time.Now(),
+		Drift:          []CatalogDrift{},
+		UnmappedLocal:  []CatalogProductRef{},
+		UnmappedMayar:  []CatalogProductRef{},
+		MissingInMayar: []ProductMapping{},
+	}
+
+	remoteByID := make(map[string]Product, len(remoteProducts))
+	for _, p := range remoteProducts {
+		remoteByID[p.ID] = p
+	}
+	localByID := make(map[string]Product, len(localProducts))
+	for _, p := range localProducts {
+		localByID[p.ID] = p
+	}
+
+	mappedLocal := make(map[string]bool, len(mappings))
+	mappedRemote := make(map[string]bool, len(mappings))
+	for _, m := range mappings {
+		mappedLocal[m.ProductID] = true
+		mappedRemote[m.MayarProductID] = true
+
+		remote, ok := remoteByID[m.MayarProductID]
+		if !ok {
+			report.MissingInMayar = append(report.MissingInMayar, m)
+			continue
+		}
+		// A deleted product's mapping is kept, but it is not compared
+		local, ok := localByID[m.ProductID]
+		if !ok || local.DeletedAt != nil {
+			continue
+		}
+
+		drift := productDrift(local, remote)
+		if len(drift) == 0 {
+			report.InSync++
+		}
+		report.Drift = append(report.Drift, drift...)
+	}
+
+	for _, p := range localProducts {
+		if !mappedLocal[p.ID] && p.DeletedAt == nil {
+			report.UnmappedLocal = append(report.UnmappedLocal, CatalogProductRef{ID: p.ID, Name: p.Name, Price: p.Price})
+		}
+	}
+	for _, p := range remoteProducts {
+		if !mappedRemote[p.ID] {
+			report.UnmappedMayar = append(report.UnmappedMayar, CatalogProductRef{ID: p.ID, Name: p.Name, Price: p.Price})
+		}
+	}
+
+	return report, nil
+}
+
+// IsMapped reports whether a local product is synced from a Mayar product.
+// Products are never mapped while syncing is disabled.
+func (s *CatalogSyncService) IsMapped(productID string) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+
+	if _, err := s.mappings.GetMappingByProductID(productID); err != nil {
+		if err.Error() == "mapping not found" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PropagateProductState closes or reopens the Mayar product mapped to a local
+// product. Unmapped products and a disabled sync are not an error; the result
+// reports whether Mayar was updated.
+func (s *CatalogSyncService) PropagateProductState(ctx context.Context, productID string, active bool) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+
+	mapping, err := s.mappings.GetMappingByProductID(productID)
+	if err != nil {
+		if err.Error() == "mapping not found" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if active {
+		err = s.mayar.ReopenProduct(ctx, mapping.MayarProductID)
+	} else {
+		err = s.mayar.CloseProduct(ctx, mapping.MayarProductID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update Mayar product %s: %w", mapping.MayarProductID, err)
+	}
+
+	LogInfo("Product state pushed to Mayar", logrus.Fields{
+		"product_id":       productID,
+		"mayar_product_id": mapping.MayarProductID,
+		"active":           active,
+	})
+	return true, nil
+}
+
+// RunScheduler syncs the catalog every interval until ctx is cancelled
+func (s *CatalogSyncService) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	LogInfo("Catalog sync scheduler started", logrus.Fields{"interval": interval.String()})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Sync(ctx, "schedule", false); err != nil {
+				LogError("Scheduled catalog sync failed", logrus.Fields{"error": err.Error()}, err)
+			}
+		}
+	}
+}
+
+// =============================================================================
+// HANDLERS
+// =============================================================================
+
+// CatalogSyncHandler handles HTTP requests for catalog sync
+type CatalogSyncHandler struct {
+	service *CatalogSyncService
+}
+
+// NewCatalogSyncHandler creates a new catalog sync handler
+func NewCatalogSyncHandler(service *CatalogSyncService) *CatalogSyncHandler {
+	return &CatalogSyncHandler{service: service}
+}
+
+// LinkProductRequest represents the request to map a local product to a Mayar product
+type LinkProductRequest struct {
+	MayarProductID string `json:"mayarProductId" binding:"required"`
+}
+
+// Sync handles POST /api/mayar/catalog/sync
+func (h *CatalogSyncHandler) Sync(c *gin.Context) {
+	dryRun := c.Query("dryRun") == "true"
+
+	result, err := h.service.Sync(c.Request.Context(), "manual", dryRun)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrCatalogSyncRunning) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Catalog sync failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetLastSync handles GET /api/mayar/catalog/sync/last
+func (h *CatalogSyncHandler) GetLastSync(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.service.LastResult(),
+	})
+}
+
+// GetDiff handles GET /api/mayar/catalog/diff
+func (h *CatalogSyncHandler) GetDiff(c *gin.Context) {
+	report, err := h.service.Diff(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"message": "Failed to build catalog diff",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// GetMappings handles GET /api/mayar/catalog/mappings
+func (h *CatalogSyncHandler) GetMappings(c *gin.Context) {
+	mappings, err := h.service.mappings.GetAllMappings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get product mappings",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    mappings,
+	})
+}
+
+// LinkProduct handles PUT /api/mayar/catalog/mappings/:productId
+func (h *CatalogSyncHandler) LinkProduct(c *gin.Context) {
+	productID := c.Param("productId")
+
+	var req LinkProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if _, err := h.service.products.GetProductByID(productID, false); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Product not found",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	mapping, err := h.service.mappings.UpsertMapping(productID, req.MayarProductID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to save product mapping",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Product mapped successfully",
+		"data":    mapping,
+	})
+}
+
+// UnlinkProduct handles DELETE /api/mayar/catalog/mappings/:productId
+func (h *CatalogSyncHandler) UnlinkProduct(c *gin.Context) {
+	if err := h.service.mappings.DeleteMapping(c.Param("productId")); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "mapping not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to delete product mapping",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Product mapping deleted successfully",
+	})
+}
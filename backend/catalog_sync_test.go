@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMayarOwnedChanges(t *testing.T) {
+	existing := Product{Name: "Landing page", Description: "One page site", Price: Rupiah(1500000), Category: "web"}
+
+	tests := []struct {
+		name   string
+		update func(p *Product)
+		want   []string
+	}{
+		{name: "locally owned fields only", update: func(p *Product) { p.Category = "design"; p.Revisions = 3 }, want: nil},
+		{name: "price", update: func(p *Product) { p.Price = Rupiah(1250000) }, want: []string{"price"}},
+		{name: "name and description", update: func(p *Product) { p.Name = "Landing"; p.Description = "" }, want: []string{"name", "description"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := existing
+			tt.update(&updated)
+			if got := mayarOwnedChanges(existing, updated); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mayarOwnedChanges = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductDrift(t *testing.T) {
+	local := Product{ID: "local", Name: "Logo", Description: "Logo design", Price: Rupiah(500000)}
+	remote := Product{ID: "remote", Name: "Logo", Description: "", Price: Rupiah(750000)}
+
+	// An empty Mayar description is not drift; the local one is kept
+	drift := productDrift(local, remote)
+	if len(drift) != 1 || drift[0].Field != "price" || drift[0].ProductID != "local" || drift[0].MayarProductID != "remote" {
+		t.Fatalf("drift = %+v, want only the price", drift)
+	}
+}
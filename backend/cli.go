@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errUsage marks command line mistakes; main exits with status 2 for them
+var errUsage = errors.New("usage error")
+
+const cliUsage = `Usage: backend <command> [flags]
+
+Commands:
+  serve                         Start the API server (the default without a command)
+  admin create                  Create an admin user
+  admin reset-password          Set an admin's password and sign them out everywhere
+  admin disable | enable        Disable or re-enable an admin user
+  admin verify-password         Check a password against an admin's stored hash
+  admin list                    List admin users
+  tokens cleanup                Delete expired tokens, sessions, links and old login attempts
+  deleted purge                 Permanently remove users and products deleted past the retention period
+  migrate up | status           Apply or list database migrations
+  seed                          Run seed SQL files
+  config check                  Validate configuration and connectivity
+  rotate-jwt-key [alg]          Add a new JWT signing key to JWT_KEYS_FILE
+  fake-mayar [addr]             Run the in-memory Mayar API for local development
+
+Run "backend <command> -h" for the flags of a command.
+`
+
+// RunCLI runs the command named by args. Every command loads .env and
+// connects to the database the same way StartServer does.
+func RunCLI(args []string) error {
+	if len(args) == 0 {
+		StartServer()
+		return nil
+	}
+
+	err := runCommand(args[0], args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// The flag package has already printed the command's flags
+		return nil
+	}
+	return err
+}
+
+// runCommand dispatches to a command. Command output goes to stdout and logs
+// to the logger, so scripts can read the former.
+func runCommand(command string, rest []string) error {
+	switch command {
+	case "serve":
+		StartServer()
+		return nil
+	case "admin":
+		return runAdminCommand(rest)
+	case "tokens":
+		return runTokensCommand(rest)
+	case "deleted":
+		return runDeletedCommand(rest)
+	case "migrate":
+		return runMigrateCommand(rest)
+	case "seed":
+		return runSeedCommand(rest)
+	case "config":
+		return runConfigCommand(rest)
+	case "rotate-jwt-key":
+		loadEnvFile()
+		return RunRotateJWTKeyCommand(rest)
+	case "fake-mayar":
+		addr := getEnvWithDefault("FAKE_MAYAR_ADDR", ":9090")
+		if len(rest) > 0 {
+			addr = rest[0]
+		}
+		return RunFakeMayarServer(addr)
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+}
+
+// newFlagSet creates a flag set for a subcommand that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseFlags parses args, turning flag errors into usage errors. It returns
+// flag.ErrHelp for -h, which RunCLI treats as success.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected arguments %v", errUsage, fs.Args())
+	}
+	return nil
+}
+
+// subcommand splits "<sub> [flags]" and rejects unknown subcommands
+func subcommand(command string, args []string, known ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("%w: %s needs a subcommand: %s", errUsage, command, strings.Join(known, ", "))
+	}
+	for _, name := range known {
+		if args[0] == name {
+			return name, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: unknown %s subcommand %q (want %s)", errUsage, command, args[0], strings.Join(known, ", "))
+}
+
+// openCLIDatabase loads .env and connects to the database
+func openCLIDatabase() (*sql.DB, error) {
+	loadEnvFile()
+	return connectDatabase()
+}
+
+// passwordFlags are the ways a command can be given a password without a prompt
+type passwordFlags struct {
+	password string
+	stdin    bool
+	generate bool
+}
+
+// register adds --password, --password-stdin and, when allowGenerate, --generate-password
+func (p *passwordFlags) register(fs *flag.FlagSet, allowGenerate bool) {
+	fs.StringVar(&p.password, "password", "", "password (visible in the process list; prefer --password-stdin)")
+	fs.BoolVar(&p.stdin, "password-stdin", false, "read the password from the first line of stdin")
+	if allowGenerate {
+		fs.BoolVar(&p.generate, "generate-password", false, "generate a random password and print it")
+	}
+}
+
+// resolve returns the password and whether it was generated
+func (p *passwordFlags) resolve(stdin io.Reader) (string, bool, error) {
+	sources := 0
+	for _, set := range []bool{p.password != "", p.stdin, p.generate} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", false, fmt.Errorf("%w: give exactly one of --password, --password-stdin or --generate-password", errUsage)
+	}
+
+	switch {
+	case p.generate:
+		password, err := randomAlphanumeric(20)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to generate password: %w", err)
+		}
+		return password, true, nil
+	case p.stdin:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), false, nil
+	default:
+		return p.password, false, nil
+	}
+}
+
+// runAdminCommand implements "admin create|reset-password|disable|enable|verify-password|list"
+func runAdminCommand(args []string) error {
+	sub, rest, err := subcommand("admin", args, "create", "reset-password", "disable", "enable", "verify-password", "list")
+	if err != nil {
+		return err
+	}
+
+	fs := newFlagSet("admin " + sub)
+	var username string
+	if sub != "list" {
+		fs.StringVar(&username, "username", "", "admin username (required)")
+	}
+	var passwordInput passwordFlags
+	var email, fullName, role string
+	var unlock bool
+	switch sub {
+	case "create":
+		fs.StringVar(&email, "email", "", "email address (required)")
+		fs.StringVar(&fullName, "full-name", "", "full name (defaults to the username)")
+		fs.StringVar(&role, "role", "", "role: owner, admin, designer, finance or viewer (required)")
+		passwordInput.register(fs, true)
+	case "reset-password":
+		passwordInput.register(fs, true)
+		fs.BoolVar(&unlock, "unlock", false, "also clear login lockouts for the username")
+	case "verify-password":
+		passwordInput.register(fs, false)
+	}
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+	if sub != "list" && username == "" {
+		return fmt.Errorf("%w: --username is required", errUsage)
+	}
+
+	var password string
+	var generated bool
+	if sub == "create" || sub == "reset-password" || sub == "verify-password" {
+		if password, generated, err = passwordInput.resolve(os.Stdin); err != nil {
+			return err
+		}
+	}
+	if sub == "create" {
+		if email == "" || role == "" {
+			return fmt.Errorf("%w: --email and --role are required", errUsage)
+		}
+		if !IsValidRole(role) {
+			return fmt.Errorf("%w: unknown role %q", errUsage, role)
+		}
+		if fullName == "" {
+			fullName = username
+		}
+	}
+
+	dbConn, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	passwords, err := NewPasswordService()
+	if err != nil {
+		return err
+	}
+	auth := NewAuthService(dbConn, nil, passwords)
+
+	switch sub {
+	case "create":
+		user, err := auth.CreateAdminUser(username, email, password, fullName, role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created admin user %s (id=%s, role=%s)\n", user.Username, user.ID, user.Role)
+	case "reset-password":
+		if err := auth.SetAdminPassword(username, password); err != nil {
+			return err
+		}
+		fmt.Printf("Password reset for %s; all of their sessions were signed out\n", username)
+		if unlock {
+			if err := NewLoginThrottle(dbConn).Unlock(username, "", "cli"); err != nil {
+				return err
+			}
+			fmt.Printf("Login lockouts cleared for %s\n", username)
+		}
+	case "disable", "enable":
+		active := sub == "enable"
+		if err := auth.SetAdminActive(username, active); err != nil {
+			return err
+		}
+		fmt.Printf("Admin user %s %sd\n", username, sub)
+	case "verify-password":
+		hash, err := auth.adminPasswordHash(username)
+		if err != nil {
+			return err
+		}
+		ok, needsRehash := passwords.Verify(hash, password)
+		if !ok {
+			return fmt.Errorf("password does not match for %s", username)
+		}
+		fmt.Printf("Password matches for %s\n", username)
+		if needsRehash {
+			fmt.Printf("The hash uses an outdated algorithm or cost and is upgraded at the next login\n")
+		}
+	case "list":
+		users, err := auth.ListAdminUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			status := "active"
+			if !user.IsActive {
+				status = "disabled"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", user.ID, user.Username, user.Email, user.Role, status)
+		}
+	}
+
+	if generated {
+		fmt.Printf("Generated password: %s\n", password)
+	}
+	return nil
+}
+
+// runTokensCommand implements "tokens cleanup", the same work RunTokenCleanup
+// does periodically, for running from cron
+func runTokensCommand(args []string) error {
+	_, rest, err := subcommand("tokens", args, "cleanup")
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(newFlagSet("tokens cleanup"), rest); err != nil {
+		return err
+	}
+
+	dbConn, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"admin tokens and sessions", NewAuthService(dbConn, nil, nil).CleanupExpiredTokens},
+		{"customer tokens and sessions", (&CustomerAuthService{db: dbConn}).CleanupExpired},
+		{"portal links and sessions", (&PortalService{db: dbConn}).CleanupExpired},
+		{"login attempts", NewLoginThrottle(dbConn).Cleanup},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return fmt.Errorf("failed to clean up %s: %w", step.name, err)
+		}
+		fmt.Printf("Cleaned up %s\n", step.name)
+	}
+	return nil
+}
+
+// runDeletedCommand implements "deleted purge", the same work
+// RunSoftDeletePurge does periodically, for running from cron
+func runDeletedCommand(args []string) error {
+	_, rest, err := subcommand("deleted", args, "purge")
+	if err != nil {
+		return err
+	}
+	fs := newFlagSet("deleted purge")
+	retention := fs.Duration("retention", 0, "purge rows deleted longer ago than this (default SOFT_DELETE_RETENTION)")
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+
+	dbConn, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	if *retention <= 0 {
+		*retention = getEnvDurationWithDefault("SOFT_DELETE_RETENTION", defaultSoftDeleteRetention)
+	}
+	purgedUsers, purgedProducts, err := PurgeDeleted(NewUserRepository(dbConn, nil), NewProductRepository(dbConn), *retention)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d users and %d products deleted more than %s ago\n", purgedUsers, purgedProducts, *retention)
+	return nil
+}
+
+// migrationFile is one .sql file of the migrations directory
+type migrationFile struct {
+	Version string
+	Path    string
+}
+
+// defaultMigrationsDir is where migrations live relative to the backend directory
+func defaultMigrationsDir() string {
+	return getEnvWithDefault("MIGRATIONS_DIR", filepath.Join("..", "database", "migrations"))
+}
+
+// listSQLFiles returns the .sql files in dir sorted by name
+func listSQLFiles(dir string) ([]migrationFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var files []migrationFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		files = append(files, migrationFile{Version: entry.Name(), Path: filepath.Join(dir, entry.Name())})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
+
+// ensureMigrationsTable creates the table recording applied migrations
+func ensureMigrationsTable(dbConn *sql.DB) error {
+	_, err := dbConn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns when each recorded migration was applied
+func appliedMigrations(dbConn *sql.DB) (map[string]time.Time, error) {
+	rows, err := dbConn.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]time.Time)
+	for rows.Next() {
+		var version string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// execSQLFile runs a SQL file in one transaction, recording it as a migration
+// when version is set
+func execSQLFile(dbConn *sql.DB, path, version string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(string(content)); err != nil {
+		return fmt.Errorf("failed to run %s: %w", filepath.Base(path), err)
+	}
+	if version != "" {
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// runMigrateCommand implements "migrate up|status"
+func runMigrateCommand(args []string) error {
+	sub, rest, err := subcommand("migrate", args, "up", "status")
+	if err != nil {
+		return err
+	}
+
+	fs := newFlagSet("migrate " + sub)
+	dir := fs.String("dir", defaultMigrationsDir(), "directory of .sql migrations (MIGRATIONS_DIR)")
+	var dryRun bool
+	var markAppliedThrough string
+	if sub == "up" {
+		fs.BoolVar(&dryRun, "dry-run", false, "list pending migrations without running them")
+		fs.StringVar(&markAppliedThrough, "mark-applied-through", "",
+			"record migrations up to this file name or number as applied without running them, for databases set up by hand")
+	}
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+
+	files, err := listSQLFiles(*dir)
+	if err != nil {
+		return err
+	}
+	dbConn, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	if err := ensureMigrationsTable(dbConn); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(dbConn)
+	if err != nil {
+		return err
+	}
+
+	if sub == "status" {
+		pending := 0
+		for _, file := range files {
+			if appliedAt, ok := applied[file.Version]; ok {
+				fmt.Printf("applied  %s  %s\n", appliedAt.Format(time.RFC3339), file.Version)
+			} else {
+				pending++
+				fmt.Printf("pending  %-25s  %s\n", "", file.Version)
+			}
+		}
+		fmt.Printf("%d applied, %d pending\n", len(files)-pending, pending)
+		return nil
+	}
+
+	ran := 0
+	for _, file := range files {
+		if _, ok := applied[file.Version]; ok {
+			continue
+		}
+		// "017" covers "017_customer_auth.sql" as well as everything before it
+		if markAppliedThrough != "" && (file.Version <= markAppliedThrough || strings.HasPrefix(file.Version, markAppliedThrough)) {
+			if dryRun {
+				fmt.Printf("would mark applied  %s\n", file.Version)
+				continue
+			}
+			if _, err := dbConn.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", file.Version); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", file.Version, err)
+			}
+			fmt.Printf("marked applied  %s\n", file.Version)
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would apply  %s\n", file.Version)
+			continue
+		}
+		if err := execSQLFile(dbConn, file.Path, file.Version); err != nil {
+			return err
+		}
+		ran++
+		fmt.Printf("applied  %s\n", file.Version)
+	}
+	if !dryRun {
+		fmt.Printf("%d migration(s) applied\n", ran)
+	}
+	return nil
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runSeedCommand implements "seed", running seed SQL files each in a transaction
+func runSeedCommand(args []string) error {
+	fs := newFlagSet("seed")
+	var files stringList
+	fs.Var(&files, "file", "seed SQL file to run; repeatable (default ../database/seed/real_products_data_fixed.sql)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		files = stringList{filepath.Join("..", "database", "seed", "real_products_data_fixed.sql")}
+	}
+
+	dbConn, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	for _, path := range files {
+		if err := execSQLFile(dbConn, path, ""); err != nil {
+			return err
+		}
+		fmt.Printf("seeded  %s\n", path)
+	}
+	return nil
+}
+
+// runConfigCommand implements "config check". It reports every problem it
+// finds and fails when any of them would stop the server from starting.
+func runConfigCommand(args []string) error {
+	_, rest, err := subcommand("config", args, "check")
+	if err != nil {
+		return err
+	}
+	fs := newFlagSet("config check")
+	skipDB := fs.Bool("skip-db", false, "do not connect to the database")
+	migrationsDir := fs.String("migrations-dir", defaultMigrationsDir(), "directory of .sql migrations to compare with the database")
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+	loadEnvFile()
+
+	failures := 0
+	report := func(status, area, detail string) {
+		if status == "FAIL" {
+			failures++
+		}
+		fmt.Printf("%-4s  %-10s  %s\n", status, area, detail)
+	}
+
+	jwtConfig := LoadJWTKeyConfig()
+	switch {
+	case jwtConfig.KeysFile != "":
+		if _, statErr := os.Stat(jwtConfig.KeysFile); errors.Is(statErr, os.ErrNotExist) {
+			report("warn", "jwt", fmt.Sprintf("%s does not exist yet; the server creates it on start", jwtConfig.KeysFile))
+		} else if ring, ringErr := NewJWTKeyRing(jwtConfig); ringErr != nil {
+			report("FAIL", "jwt", ringErr.Error())
+		} else if key, keyErr := ring.Current(); keyErr != nil {
+			report("FAIL", "jwt", keyErr.Error())
+		} else {
+			report("ok", "jwt", fmt.Sprintf("%d key(s) in %s, signing with kid=%s alg=%s", len(ring.Keys()), jwtConfig.KeysFile, key.ID, key.Algorithm))
+		}
+	case jwtConfig.Secret != "":
+		if _, keyErr := secretJWTKey(jwtConfig.Secret); keyErr != nil {
+			report("FAIL", "jwt", keyErr.Error())
+		} else {
+			report("ok", "jwt", "signing with JWT_SECRET (HS256)")
+		}
+	default:
+		report("warn", "jwt", "neither JWT_KEYS_FILE nor JWT_SECRET is set; tokens will not survive a restart")
+	}
+
+	if passwords, passwordErr := NewPasswordService(); passwordErr != nil {
+		report("FAIL", "passwords", passwordErr.Error())
+	} else {
+		policy := passwords.Policy()
+		report("ok", "passwords", fmt.Sprintf("min length %d, %d character classes, history %d, bcrypt cost %d",
+			policy.MinLength, policy.MinCharClasses, policy.History, passwords.cost))
+	}
+
+	if proxies := trustedProxies(); len(proxies) == 0 {
+		report("ok", "proxies", "X-Forwarded-For is ignored; set TRUSTED_PROXIES behind a reverse proxy")
+	} else if proxyErr := gin.New().SetTrustedProxies(proxies); proxyErr != nil {
+		report("FAIL", "proxies", proxyErr.Error())
+	} else {
+		report("ok", "proxies", "trusting X-Forwarded-For from "+strings.Join(proxies, ", "))
+	}
+
+	if _, mailErr := NewMailerFromEnv(); mailErr != nil {
+		report("FAIL", "mail", mailErr.Error())
+	} else {
+		report("ok", "mail", "driver "+getEnvWithDefault("MAIL_DRIVER", "file"))
+	}
+
+	if _, mayarErr := GetMayarConfig(); mayarErr != nil {
+		report("warn", "mayar", mayarErr.Error()+"; Mayar payments will be disabled")
+	} else {
+		report("ok", "mayar", "environment "+getEnvWithDefault("MAYAR_ENVIRONMENT", "sandbox"))
+	}
+
+	if *skipDB {
+		report("warn", "database", "skipped")
+	} else if dbConn, dbErr := connectDatabase(); dbErr != nil {
+		report("FAIL", "database", dbErr.Error())
+	} else {
+		defer dbConn.Close()
+		report("ok", "database", "connected")
+
+		files, filesErr := listSQLFiles(*migrationsDir)
+		if filesErr != nil {
+			report("warn", "migrations", filesErr.Error())
+		} else if ensureErr := ensureMigrationsTable(dbConn); ensureErr != nil {
+			report("FAIL", "migrations", ensureErr.Error())
+		} else if applied, appliedErr := appliedMigrations(dbConn); appliedErr != nil {
+			report("FAIL", "migrations", appliedErr.Error())
+		} else {
+			pending := 0
+			for _, file := range files {
+				if _, ok := applied[file.Version]; !ok {
+					pending++
+				}
+			}
+			if pending > 0 {
+				report("warn", "migrations", fmt.Sprintf("%d pending; run \"migrate up\"", pending))
+			} else {
+				report("ok", "migrations", "up to date")
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("configuration check found %d problem(s)", failures)
+	}
+	return nil
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// customerTokenAudience marks customer JWTs. Admin validation rejects tokens
+// with an audience and customer validation requires this one, so neither token
+// works in place of the other.
+const customerTokenAudience = "urgent-studio-customer"
+
+// CustomerRole is the users.role of self-registered customers
+const CustomerRole = "customer"
+
+// Purposes of customer_tokens rows
+const (
+	CustomerTokenVerifyEmail   = "verify_email"
+	CustomerTokenResetPassword = "reset_password"
+)
+
+var (
+	// ErrCustomerInvalidCredentials is returned for an unknown email or a wrong password
+	ErrCustomerInvalidCredentials = errors.New("invalid email or password")
+	// ErrCustomerEmailNotVerified is returned when logging in before verifying the email
+	ErrCustomerEmailNotVerified = errors.New("email address is not verified")
+	// ErrCustomerTokenInvalid is returned for an unknown, used or expired verification or reset token
+	ErrCustomerTokenInvalid = errors.New("invalid or expired token")
+)
+
+// CustomerClaims are the claims of a customer access token. The subject is the user ID.
+type CustomerClaims struct {
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// CustomerProfile is what a customer sees of their own account
+type CustomerProfile struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	FullName      string     `json:"fullName"`
+	EmailVerified bool       `json:"emailVerified"`
+	VerifiedAt    *time.Time `json:"emailVerifiedAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// CustomerLogin is the result of a successful customer login
+type CustomerLogin struct {
+	Token     string           `json:"token"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+	Customer  *CustomerProfile `json:"customer"`
+}
+
+// CustomerAuthService registers and signs in customers from the users table
+type CustomerAuthService struct {
+	db        *sql.DB
+	keys      *JWTKeyRing
+	mailer    Mailer
+	passwords *PasswordService
+	// appURL is the storefront base URL used in emailed links
+	appURL     string
+	sessionTTL time.Duration
+	verifyTTL  time.Duration
+	resetTTL   time.Duration
+	inviteTTL  time.Duration
+}
+
+// NewCustomerAuthService creates a new customer auth service
+func NewCustomerAuthService(db *sql.DB, keys *JWTKeyRing, mailer Mailer, passwords *PasswordService) *CustomerAuthService {
+	return &CustomerAuthService{
+		db:         db,
+		keys:       keys,
+		mailer:     mailer,
+		passwords:  passwords,
+		appURL:     strings.TrimRight(getEnvWithDefault("CUSTOMER_APP_URL", "http://localhost:3000"), "/"),
+		sessionTTL: getEnvDurationWithDefault("CUSTOMER_SESSION_TTL", 7*24*time.Hour),
+		verifyTTL:  getEnvDurationWithDefault("CUSTOMER_VERIFY_TOKEN_TTL", 48*time.Hour),
+		resetTTL:   getEnvDurationWithDefault("CUSTOMER_RESET_TOKEN_TTL", time.Hour),
+		inviteTTL:  getEnvDurationWithDefault("CUSTOMER_INVITE_TOKEN_TTL", 7*24*time.Hour),
+	}
+}
+
+// normalizeEmail lowercases an email address for lookups
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// customerAccount is a users row as customer auth needs it
+type customerAccount struct {
+	id           string
+	email        string
+	fullName     string
+	passwordHash string
+	isActive     bool
+	verifiedAt   *time.Time
+}
+
+// getCustomerAccount finds a user by email, case-insensitively. Deleted users
+// are found but inactive, so they cannot sign in or reset their password.
+func (s *CustomerAuthService) getCustomerAccount(email string) (*customerAccount, error) {
+	query := `
+		SELECT id, email, full_name, password_hash, COALESCE(is_active, true) AND deleted_at IS NULL, email_verified_at
+		FROM users
+		WHERE LOWER(email) = $1
+	`
+
+	var account customerAccount
+	err := s.db.QueryRow(query, normalizeEmail(email)).Scan(
+		&account.id, &account.email, &account.fullName, &account.passwordHash, &account.isActive, &account.verifiedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// issueCustomerToken creates a single-use token for purpose, replacing any
+// earlier unused token with the same purpose
+func (s *CustomerAuthService) issueCustomerToken(userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE customer_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate earlier tokens: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO customer_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// consumeCustomerToken marks a token used and returns its user. The update is
+// conditional, so concurrent requests cannot both use one token.
+func consumeCustomerToken(tx *sql.Tx, token, purpose string) (string, error) {
+	var userID string
+	err := tx.QueryRow(`
+		UPDATE customer_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id
+	`, hashToken(token), purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrCustomerTokenInvalid
+		}
+		return "", fmt.Errorf("failed to use token: %w", err)
+	}
+	return userID, nil
+}
+
+// sendMail sends an email, logging failures instead of returning them: the
+// endpoints that send mail answer the same way whether or not an account exists
+func (s *CustomerAuthService) sendMail(userID string, msg EmailMessage) {
+	if err := s.mailer.Send(msg); err != nil {
+		LogError("Failed to send customer email", logrus.Fields{
+			"user_id": userID,
+			"subject": msg.Subject,
+			"error":   err.Error(),
+		}, err)
+	}
+}
+
+// appLink builds a storefront link carrying a token
+func (s *CustomerAuthService) appLink(path, token string) string {
+	return s.appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail issues a verification token and emails its link
+func (s *CustomerAuthService) sendVerificationEmail(account *customerAccount) error {
+	token, err := s.issueCustomerToken(account.id, CustomerTokenVerifyEmail, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(account.id, EmailMessage{
+		To:      account.email,
+		Subject: "Verify your Urgent Studio email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to finish creating your account:\n\n%s\n\n"+
+			"The link expires in %s. If you did not sign up, you can ignore this email.\n",
+			account.fullName, s.appLink("/verify-email", token), s.verifyTTL),
+	})
+	return nil
+}
+
+// Register creates a customer account and emails a verification link. When
+// the email is already registered the owner is told by email instead, so the
+// response never reveals which addresses have accounts. The password is
+// checked against the policy first, for the same reason.
+func (s *CustomerAuthService) Register(email, password, fullName string) error {
+	if err := s.passwords.Validate(password); err != nil {
+		return err
+	}
+
+	existing, err := s.getCustomerAccount(email)
+	if err == nil {
+		s.sendMail(existing.id, EmailMessage{
+			To:      existing.email,
+			Subject: "You already have an Urgent Studio account",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone tried to sign up with this email address, which already has an account.\n\n"+
+				"If it was you, sign in or reset your password here:\n\n%s\n",
+				existing.fullName, s.appURL+"/forgot-password"),
+		})
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to look up customer: %w", err)
+	}
+
+	hashedPassword, err := s.passwords.HashNew(s.db, PasswordAccountUser, "", password)
+	if err != nil {
+		return err
+	}
+
+	account := &customerAccount{email: normalizeEmail(email), fullName: strings.TrimSpace(fullName)}
+	err = s.db.QueryRow(`
+		INSERT INTO users (email, password_hash, full_name, role, is_active)
+		VALUES ($1, $2, $3, $4, true)
+		RETURNING id
+	`, account.email, hashedPassword, account.fullName, CustomerRole).Scan(&account.id)
+	if err != nil {
+		// Registered concurrently; the earlier request sent the email
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil
+		}
+		return fmt.Errorf("failed to create customer: %w", err)
+	}
+	if err := s.passwords.Remember(s.db, PasswordAccountUser, account.id, hashedPassword); err != nil {
+		return err
+	}
+
+	LogInfo("Customer registered", logrus.Fields{
+		"user_id": account.id,
+	})
+	return s.sendVerificationEmail(account)
+}
+
+// ResendVerification emails a new verification link to an unverified account
+func (s *CustomerAuthService) ResendVerification(email string) error {
+	account, err := s.getCustomerAccount(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to look up customer: %w", err)
+	}
+	if account.verifiedAt != nil || !account.isActive {
+		return nil
+	}
+	return s.sendVerificationEmail(account)
+}
+
+// VerifyEmail marks the email of a verification token's account as verified
+func (s *CustomerAuthService) VerifyEmail(token string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in VerifyEmail", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	userID, err := consumeCustomerToken(tx, token, CustomerTokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ForgotPassword emails a password reset link when the account exists
+func (s *CustomerAuthService) ForgotPassword(email string) error {
+	account, err := s.getCustomerAccount(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to look up customer: %w", err)
+	}
+	if !account.isActive {
+		return nil
+	}
+
+	token, err := s.issueCustomerToken(account.id, CustomerTokenResetPassword, s.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(account.id, EmailMessage{
+		To:      account.email,
+		Subject: "Reset your Urgent Studio password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this link to choose a new password:\n\n%s\n\n"+
+			"The link expires in %s and works once. If you did not ask for this, you can ignore this email.\n",
+			account.fullName, s.appLink("/reset-password", token), s.resetTTL),
+	})
+	return nil
+}
+
+// SendInvite emails an imported user a link to choose their first password.
+// The link is a password reset token with a longer lifetime, so accepting the
+// invite also verifies the email address.
+func (s *CustomerAuthService) SendInvite(userID, email, fullName string) error {
+	token, err := s.issueCustomerToken(userID, CustomerTokenResetPassword, s.inviteTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(userID, EmailMessage{
+		To:      email,
+		Subject: "You have been invited to Urgent Studio",
+		Body: fmt.Sprintf("Hi %s,\n\nAn account has been created for you at Urgent Studio. Choose a password to start using it:\n\n%s\n\n"+
+			"The link expires in %s and works once. If you did not expect this, you can ignore this email.\n",
+			fullName, s.appLink("/reset-password", token), s.inviteTTL),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password from a reset token and signs out every
+// session. Receiving the link proves the email address, so it is verified too.
+// A password the policy rejects leaves the token unused.
+func (s *CustomerAuthService) ResetPassword(token, password string) error {
+	if err := s.passwords.Validate(password); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in ResetPassword", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	userID, err := consumeCustomerToken(tx, token, CustomerTokenResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.passwords.HashNew(tx, PasswordAccountUser, userID, password)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password_hash = $2, email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID, hashedPassword)
+	if err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+	if err := s.passwords.Remember(tx, PasswordAccountUser, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE customer_sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke customer sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	LogSecurity("customer_password_reset", logrus.Fields{
+		"user_id": userID,
+	})
+	return nil
+}
+
+// Login checks a customer's password and starts a session
+func (s *CustomerAuthService) Login(email, password string) (*CustomerLogin, error) {
+	account, err := s.getCustomerAccount(email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to look up customer: %w", err)
+		}
+		// Same bcrypt cost as a wrong password, so timing does not reveal accounts
+		s.passwords.CompareDummy(password)
+		return nil, ErrCustomerInvalidCredentials
+	}
+	ok, needsRehash := s.passwords.Verify(account.passwordHash, password)
+	if !ok || !account.isActive {
+		return nil, ErrCustomerInvalidCredentials
+	}
+	if account.verifiedAt == nil {
+		return nil, ErrCustomerEmailNotVerified
+	}
+	if needsRehash {
+		s.passwords.Rehash(s.db, PasswordAccountUser, account.id, account.passwordHash, password)
+	}
+
+	sessionID, err := generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	expiresAt := time.Now().Add(s.sessionTTL)
+	_, err = s.db.Exec("INSERT INTO customer_sessions (id, user_id, expires_at) VALUES ($1, $2, $3)",
+		sessionID, account.id, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store customer session: %w", err)
+	}
+
+	claims := CustomerClaims{
+		Email:     account.email,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   account.id,
+			Audience:  jwt.ClaimStrings{customerTokenAudience},
+			Issuer:    "urgent-studio",
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	key, err := s.keys.Current()
+	if err != nil {
+		return nil, err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.signingKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return &CustomerLogin{
+		Token:     tokenString,
+		ExpiresAt: expiresAt,
+		Customer: &CustomerProfile{
+			ID:            account.id,
+			Email:         account.email,
+			FullName:      account.fullName,
+			EmailVerified: true,
+			VerifiedAt:    account.verifiedAt,
+		},
+	}, nil
+}
+
+// ValidateToken checks a customer token and that its session is still active
+func (s *CustomerAuthService) ValidateToken(tokenString string) (*CustomerClaims, error) {
+	claims := &CustomerClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keys.keyFunc, jwt.WithAudience(customerTokenAudience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	if claims.Subject == "" || claims.SessionID == "" {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	var active bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM customer_sessions s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP
+			AND COALESCE(u.is_active, true) AND u.deleted_at IS NULL
+		)
+	`, claims.SessionID, claims.Subject).Scan(&active)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate customer session: %w", err)
+	}
+	if !active {
+		return nil, fmt.Errorf("customer session is revoked or expired")
+	}
+	return claims, nil
+}
+
+// Logout ends a customer session
+func (s *CustomerAuthService) Logout(sessionID string) error {
+	_, err := s.db.Exec("UPDATE customer_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL", sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke customer session: %w", err)
+	}
+	return nil
+}
+
+// GetProfile returns a customer's own account details
+func (s *CustomerAuthService) GetProfile(userID string) (*CustomerProfile, error) {
+	var profile CustomerProfile
+	err := s.db.QueryRow(`
+		SELECT id, email, full_name, email_verified_at, created_at
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&profile.ID, &profile.Email, &profile.FullName, &profile.VerifiedAt, &profile.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+	profile.EmailVerified = profile.VerifiedAt != nil
+	return &profile, nil
+}
+
+// CleanupExpired deletes expired customer tokens and sessions
+func (s *CustomerAuthService) CleanupExpired() error {
+	if _, err := s.db.Exec("DELETE FROM customer_tokens WHERE expires_at < CURRENT_TIMESTAMP OR used_at IS NOT NULL"); err != nil {
+		return fmt.Errorf("failed to delete customer tokens: %w", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM customer_sessions WHERE expires_at < CURRENT_TIMESTAMP OR revoked_at IS NOT NULL"); err != nil {
+		return fmt.Errorf("failed to delete customer sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// customerGenericMessage answers register, resend and forgot-password requests
+// the same way whether or not the email has an account
+const customerGenericMessage = "If the email address can be used, we have sent instructions to it"
+
+// CustomerRegisterRequest is the body of POST /api/auth/register
+type CustomerRegisterRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	FullName string `json:"fullName" binding:"required"`
+}
+
+// CustomerLoginRequest is the body of POST /api/auth/login
+type CustomerLoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// CustomerEmailRequest is the body of requests that only name an email address
+type CustomerEmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// CustomerTokenRequest is the body of POST /api/auth/verify-email
+type CustomerTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// CustomerResetPasswordRequest is the body of POST /api/auth/reset-password
+type CustomerResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// authenticateCustomer validates the customer bearer token of a request and
+// stores the customer in the context. It never sets the admin keys (user_id,
+// role), so customer requests cannot pass admin permission checks.
+func authenticateCustomer(customerAuth *CustomerAuthService, c *gin.Context) bool {
+	authHeader := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		c.Abort()
+		return false
+	}
+
+	claims, err := customerAuth.ValidateToken(authHeader[7:])
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("customer_id", claims.Subject)
+	c.Set("customer_email", claims.Email)
+	c.Set("customer_session_id", claims.SessionID)
+	return true
+}
+
+// CustomerAuthHandler serves customer sign-up, sign-in and account endpoints
+type CustomerAuthHandler struct {
+	auth   *CustomerAuthService
+	orders *OrderRepository
+}
+
+// NewCustomerAuthHandler creates a new CustomerAuthHandler
+func NewCustomerAuthHandler(auth *CustomerAuthService, orders *OrderRepository) *CustomerAuthHandler {
+	return &CustomerAuthHandler{auth: auth, orders: orders}
+}
+
+// RegisterRoutes registers the customer auth and account routes
+func (h *CustomerAuthHandler) RegisterRoutes(r *gin.Engine) {
+	authGroup := r.Group("/api/auth")
+	{
+		authGroup.POST("/register", h.Register)
+		authGroup.POST("/login", h.Login)
+		authGroup.POST("/verify-email", h.VerifyEmail)
+		authGroup.POST("/resend-verification", h.ResendVerification)
+		authGroup.POST("/forgot-password", h.ForgotPassword)
+		authGroup.POST("/reset-password", h.ResetPassword)
+		authGroup.POST("/logout", h.Logout)
+		authGroup.GET("/me", h.Me)
+	}
+
+	r.GET("/api/account/orders", h.Orders)
+}
+
+// internalError logs err and answers with a generic 500
+func (h *CustomerAuthHandler) internalError(c *gin.Context, logMessage, message string, err error) {
+	LogError(logMessage, logrus.Fields{
+		"client_ip": c.ClientIP(),
+		"error":     err.Error(),
+	}, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success": false,
+		"message": message,
+	})
+}
+
+// Register handles POST /api/auth/register
+func (h *CustomerAuthHandler) Register(c *gin.Context) {
+	var req CustomerRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := h.auth.Register(req.Email, req.Password, req.FullName); err != nil {
+		if errors.Is(err, ErrPasswordPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		h.internalError(c, "Failed to register customer", "Failed to register", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": customerGenericMessage,
+	})
+}
+
+// Login handles POST /api/auth/login
+func (h *CustomerAuthHandler) Login(c *gin.Context) {
+	var req CustomerLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format",
+		})
+		return
+	}
+
+	login, err := h.auth.Login(req.Email, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCustomerInvalidCredentials):
+			LogSecurity("customer_login_failed", logrus.Fields{
+				"client_ip": c.ClientIP(),
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid email or password",
+			})
+		case errors.Is(err, ErrCustomerEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Verify your email address before signing in",
+				"code":    "email_not_verified",
+			})
+		default:
+			h.internalError(c, "Failed to log in customer", "Failed to log in", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Login successful",
+		"data":    login,
+	})
+}
+
+// VerifyEmail handles POST /api/auth/verify-email
+func (h *CustomerAuthHandler) VerifyEmail(c *gin.Context) {
+	var req CustomerTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Token is required",
+		})
+		return
+	}
+
+	if err := h.auth.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, ErrCustomerTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The verification link is invalid or has expired",
+			})
+			return
+		}
+		h.internalError(c, "Failed to verify customer email", "Failed to verify email", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email address verified",
+	})
+}
+
+// ResendVerification handles POST /api/auth/resend-verification
+func (h *CustomerAuthHandler) ResendVerification(c *gin.Context) {
+	var req CustomerEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A valid email address is required",
+		})
+		return
+	}
+
+	if err := h.auth.ResendVerification(req.Email); err != nil {
+		h.internalError(c, "Failed to resend customer verification", "Failed to send verification email", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": customerGenericMessage,
+	})
+}
+
+// ForgotPassword handles POST /api/auth/forgot-password
+func (h *CustomerAuthHandler) ForgotPassword(c *gin.Context) {
+	var req CustomerEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A valid email address is required",
+		})
+		return
+	}
+
+	if err := h.auth.ForgotPassword(req.Email); err != nil {
+		h.internalError(c, "Failed to start customer password reset", "Failed to send password reset email", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": customerGenericMessage,
+	})
+}
+
+// ResetPassword handles POST /api/auth/reset-password
+func (h *CustomerAuthHandler) ResetPassword(c *gin.Context) {
+	var req CustomerResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := h.auth.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, ErrPasswordPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, ErrCustomerTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The reset link is invalid or has expired",
+			})
+			return
+		}
+		h.internalError(c, "Failed to reset customer password", "Failed to reset password", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password updated, please sign in again",
+	})
+}
+
+// Logout handles POST /api/auth/logout
+func (h *CustomerAuthHandler) Logout(c *gin.Context) {
+	if err := h.auth.Logout(c.GetString("customer_session_id")); err != nil {
+		h.internalError(c, "Failed to log out customer", "Failed to log out", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logout successful",
+	})
+}
+
+// Me handles GET /api/auth/me
+func (h *CustomerAuthHandler) Me(c *gin.Context) {
+	profile, err := h.auth.GetProfile(c.GetString("customer_id"))
+	if err != nil {
+		h.internalError(c, "Failed to get customer profile", "Failed to get account", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    profile,
+	})
+}
+
+// Orders handles GET /api/account/orders, the orders placed with the
+// customer's verified email address
+func (h *CustomerAuthHandler) Orders(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100 // Max limit
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	orders, err := h.orders.GetOrdersByCustomerEmail(c.GetString("customer_email"), limit, offset)
+	if err != nil {
+		h.internalError(c, "Failed to get customer orders", "Failed to get orders", err)
+		return
+	}
+
+	// Admin notes are internal
+	for i := range orders {
+		orders[i].AdminNotes = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    orders,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"count":  len(orders),
+		},
+	})
+}
@@ -373,6 +373,32 @@ func (h *RealDashboardHandler) GetRealSearchResults(c *gin.Context) {
 	})
 }
 
+// GetOutstandingBalances handles GET /api/dashboard/outstanding-balances
+func (h *RealDashboardHandler) GetOutstandingBalances(c *gin.Context) {
+	balances, err := NewOrderRepository(h.db).GetOutstandingBalances()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to load outstanding balances",
+		})
+		return
+	}
+
+	totalOutstanding := Rupiah(0)
+	for _, balance := range balances {
+		totalOutstanding = totalOutstanding.Add(balance.Outstanding)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"orders":           balances,
+			"count":            len(balances),
+			"totalOutstanding": totalOutstanding,
+		},
+	})
+}
+
 // RegisterRealDashboardRoutes registers real dashboard routes
 func RegisterRealDashboardRoutes(router *gin.Engine, handler *RealDashboardHandler) {
 	api := router.Group("/api/dashboard")
@@ -380,5 +406,6 @@ func RegisterRealDashboardRoutes(router *gin.Engine, handler *RealDashboardHandl
 		api.GET("/real-metrics", handler.GetRealMetrics)
 		api.GET("/real-orders/analytics", handler.GetRealOrderAnalytics)
 		api.GET("/real-search", handler.GetRealSearchResults)
+		api.GET("/outstanding-balances", handler.GetOutstandingBalances)
 	}
 }
\ No newline at end of file
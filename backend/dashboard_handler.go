@@ -2,12 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // DashboardMetrics represents the dashboard metrics data
@@ -52,13 +54,15 @@ type ActivityItem struct {
 // DashboardHandler handles dashboard-related requests
 type DashboardHandler struct {
 	serviceRepo *ServiceItemRepository
+	orderRepo   *OrderRepository
 	db          *sql.DB
 }
 
 // NewDashboardHandler creates a new dashboard handler
-func NewDashboardHandler(serviceRepo *ServiceItemRepository) *DashboardHandler {
+func NewDashboardHandler(serviceRepo *ServiceItemRepository, orderRepo *OrderRepository) *DashboardHandler {
 	return &DashboardHandler{
 		serviceRepo: serviceRepo,
+		orderRepo:   orderRepo,
 		db:          serviceRepo.db,
 	}
 }
@@ -67,7 +71,7 @@ func NewDashboardHandler(serviceRepo *ServiceItemRepository) *DashboardHandler {
 func (h *DashboardHandler) GetMetrics(c *gin.Context) {
 	// Get total services count from products table
 	var totalServices int
-	err := h.db.QueryRow("SELECT COUNT(*) FROM products").Scan(&totalServices)
+	err := h.db.QueryRow("SELECT COUNT(*) FROM products WHERE deleted_at IS NULL").Scan(&totalServices)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get services count"})
 		return
@@ -77,6 +81,7 @@ func (h *DashboardHandler) GetMetrics(c *gin.Context) {
 	rows, err := h.db.Query(`
 		SELECT category, COUNT(*) as count, AVG(price) as avg_price 
 		FROM products 
+		WHERE deleted_at IS NULL
 		GROUP BY category
 	`)
 	if err != nil {
@@ -290,11 +295,24 @@ func (h *DashboardHandler) GetActivityFeed(c *gin.Context) {
 	})
 }
 
-// BulkUpdateOrderStatus handles POST /api/dashboard/orders/bulk-update
+// maxBulkOrderUpdate caps the number of orders one bulk update may change
+const maxBulkOrderUpdate = 100
+
+// BulkOrderUpdateFailure explains why one order of a bulk update was not changed
+type BulkOrderUpdateFailure struct {
+	OrderID string `json:"orderId"`
+	Error   string `json:"error"`
+}
+
+// BulkUpdateOrderStatus handles POST /api/dashboard/orders/bulk-update. Each
+// order is updated on its own, like PUT /api/orders/:id/status, so a payment
+// schedule blocking one order does not stop the others.
 func (h *DashboardHandler) BulkUpdateOrderStatus(c *gin.Context) {
 	var request struct {
-		OrderIDs []string `json:"orderIds"`
-		Status   string   `json:"status"`
+		OrderIDs  []string `json:"orderIds" binding:"required"`
+		Status    string   `json:"status" binding:"required"`
+		Notes     *string  `json:"notes"`
+		ChangedBy *string  `json:"changedBy"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -304,13 +322,72 @@ func (h *DashboardHandler) BulkUpdateOrderStatus(c *gin.Context) {
 		})
 		return
 	}
+	if len(request.OrderIDs) == 0 || len(request.OrderIDs) > maxBulkOrderUpdate {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("orderIds must list between 1 and %d orders", maxBulkOrderUpdate),
+		})
+		return
+	}
+
+	update := &UpdateOrderStatusRequest{Status: request.Status, Notes: request.Notes, ChangedBy: request.ChangedBy}
+	before, after := gin.H{}, gin.H{}
+	updated := []string{}
+	failed := []BulkOrderUpdateFailure{}
+	internalError := false
+	for _, orderID := range request.OrderIDs {
+		previous, err := h.orderRepo.GetOrderByID(orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			failed = append(failed, BulkOrderUpdateFailure{OrderID: orderID, Error: "Order not found"})
+			continue
+		}
+		if err == nil {
+			err = h.orderRepo.UpdateOrderStatus(orderID, update)
+		}
+		if errors.Is(err, ErrDepositNotPaid) || errors.Is(err, ErrBalanceOutstanding) {
+			failed = append(failed, BulkOrderUpdateFailure{OrderID: orderID, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			LogError("Failed to update order status in bulk update", logrus.Fields{
+				"order_id": orderID,
+				"status":   request.Status,
+				"error":    err.Error(),
+			}, err)
+			failed = append(failed, BulkOrderUpdateFailure{OrderID: orderID, Error: "Failed to update order status"})
+			internalError = true
+			continue
+		}
+
+		updated = append(updated, orderID)
+		before[orderID] = previous.Status
+		after[orderID] = request.Status
+	}
 
-	// Mock bulk update
-	fmt.Printf("Bulk updating %d orders to status: %s\n", len(request.OrderIDs), request.Status)
+	LogInfo("Bulk order status update finished", logrus.Fields{
+		"status":  request.Status,
+		"updated": len(updated),
+		"failed":  len(failed),
+	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": fmt.Sprintf("Successfully updated %d orders", len(request.OrderIDs)),
+	// Nothing changed: a server error, or every order was missing or blocked
+	status := http.StatusOK
+	if len(updated) == 0 {
+		status = http.StatusConflict
+		if internalError {
+			status = http.StatusInternalServerError
+		}
+	} else {
+		auditChange(c, before, after)
+	}
+
+	c.JSON(status, gin.H{
+		"success": len(failed) == 0,
+		"message": fmt.Sprintf("Updated %d of %d orders", len(updated), len(request.OrderIDs)),
+		"data": gin.H{
+			"updated": updated,
+			"failed":  failed,
+		},
 	})
 }
 
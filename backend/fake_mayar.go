@@ -0,0 +1,1130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// FakeMayarServer is an in-memory stand-in for the Mayar.id API. It serves the
+// same paths MayarService calls, so pointing MAYAR_BASE_URL (or
+// MAYAR_SANDBOX_URL when MAYAR_ENVIRONMENT=sandbox) at it is enough. It can
+// run standalone via "go run . fake-mayar [addr]" or inside httptest:
+//
+//	fake := NewFakeMayarServer(webhookURL, webhookSecret)
+//	server := httptest.NewServer(fake.Handler())
+//
+// Payments are settled through CompletePayment/FailPayment/PayInvoice or the
+// /__fake control endpoints, which fire signed, timestamped webhooks back at
+// webhookURL. Each payload carries its history ID as the event id, so retrying
+// a delivered webhook exercises replay protection.
+type FakeMayarServer struct {
+	mu              sync.Mutex
+	products        map[string]*Product
+	closedProducts  map[string]bool
+	invoices        map[string]*Invoice
+	paymentRequests map[string]*fakePaymentRequest
+	customers       map[string]*Customer
+	transactions    []Transaction
+	webhooks        map[string]*WebhookRegistration
+	webhookHistory  []WebhookHistory
+	webhookPayloads map[string][]byte
+	licenses        map[string]*fakeLicense
+	coupons         map[string]*Coupon
+
+	injectedFailures int
+	injectedStatus   int
+
+	webhookURL    string
+	webhookSecret string
+	httpClient    *http.Client
+	deliveries    sync.WaitGroup
+}
+
+// fakePaymentRequest keeps the customer fields Mayar echoes back in webhooks
+type fakePaymentRequest struct {
+	PaymentRequest
+	Name   string
+	Email  string
+	Mobile string
+}
+
+// fakeLicense tracks activation state of a license code
+type fakeLicense struct {
+	ProductID string
+	Devices   map[string]bool
+	ExpiresAt time.Time
+}
+
+// NewFakeMayarServer creates an empty fake Mayar API that delivers webhooks to
+// webhookURL signed with webhookSecret
+func NewFakeMayarServer(webhookURL, webhookSecret string) *FakeMayarServer {
+	return &FakeMayarServer{
+		products:        make(map[string]*Product),
+		closedProducts:  make(map[string]bool),
+		invoices:        make(map[string]*Invoice),
+		paymentRequests: make(map[string]*fakePaymentRequest),
+		customers:       make(map[string]*Customer),
+		webhooks:        make(map[string]*WebhookRegistration),
+		webhookPayloads: make(map[string][]byte),
+		licenses:        make(map[string]*fakeLicense),
+		coupons:         make(map[string]*Coupon),
+		webhookURL:      webhookURL,
+		webhookSecret:   webhookSecret,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Handler returns the HTTP handler serving the fake API
+func (f *FakeMayarServer) Handler() http.Handler {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// Checkout page and simulation controls need no API key
+	r.GET("/pay/:id", f.checkoutPage)
+	control := r.Group("/__fake")
+	{
+		control.POST("/products", f.seedProduct)
+		control.POST("/licenses", f.seedLicense)
+		control.POST("/payment-requests/:id/complete", f.controlCompletePayment)
+		control.POST("/payment-requests/:id/fail", f.controlFailPayment)
+		control.POST("/invoices/:id/pay", f.controlPayInvoice)
+		control.POST("/failures", f.controlInjectFailures)
+	}
+
+	api := r.Group("/")
+	api.Use(f.requireAPIKey, f.failureInjection)
+	{
+		api.GET("/product", f.listProducts)
+		api.GET("/product/type/:type", f.listProducts)
+		api.GET("/product/:id", f.getProduct)
+		api.POST("/product/close/:id", f.setProductClosed(true))
+		api.POST("/product/open/:id", f.setProductClosed(false))
+
+		api.POST("/invoice", f.createInvoice)
+		api.GET("/invoice/:id", f.getInvoice)
+		api.PUT("/invoice/:id", f.updateInvoice)
+		api.DELETE("/invoice/:id", f.deleteInvoice)
+
+		api.POST("/request-payment", f.createPaymentRequest)
+		api.GET("/request-payment/:id", f.getPaymentRequest)
+
+		api.POST("/customer", f.createCustomer)
+		api.GET("/customer/:id", f.getCustomer)
+		api.PUT("/customer/:id", f.updateCustomer)
+
+		api.GET("/transactions", f.listTransactions)
+		api.GET("/transaction/:id", f.getTransaction)
+
+		api.POST("/webhook/register", f.registerWebhook)
+		api.GET("/webhook/history", f.getWebhookHistory)
+		api.POST("/webhook/test/:id", f.testWebhook)
+		api.POST("/webhook/retry/:id", f.retryWebhook)
+
+		api.POST("/license/verify", f.verifyLicense)
+		api.POST("/license/activate", f.activateLicense)
+		api.POST("/license/deactivate", f.deactivateLicense)
+
+		api.POST("/coupon", f.createCoupon)
+		api.POST("/coupon/apply", f.applyCoupon)
+		api.GET("/coupon/:code", f.getCoupon)
+	}
+
+	return r
+}
+
+// RunFakeMayarServer runs the fake Mayar API on addr until the process exits
+func RunFakeMayarServer(addr string) error {
+	webhookURL := getEnvWithDefault("FAKE_MAYAR_WEBHOOK_URL", "http://localhost:8080/api/mayar/webhooks/incoming")
+	fake := NewFakeMayarServer(webhookURL, os.Getenv("MAYAR_WEBHOOK_SECRET"))
+
+	LogInfo("Fake Mayar API listening", logrus.Fields{
+		"addr":        addr,
+		"webhook_url": webhookURL,
+	})
+	return http.ListenAndServe(addr, fake.Handler())
+}
+
+// =============================================================================
+// MIDDLEWARE
+// =============================================================================
+
+// requireAPIKey rejects calls without a bearer token, like the real API
+func (f *FakeMayarServer) requireAPIKey(c *gin.Context) {
+	if !strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, MayarErrorResponse{Message: "Unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// failureInjection answers the next injected number of calls with the injected status
+func (f *FakeMayarServer) failureInjection(c *gin.Context) {
+	f.mu.Lock()
+	status := 0
+	if f.injectedFailures > 0 {
+		f.injectedFailures--
+		status = f.injectedStatus
+	}
+	f.mu.Unlock()
+
+	if status != 0 {
+		c.AbortWithStatusJSON(status, MayarErrorResponse{Message: "Injected failure"})
+		return
+	}
+	c.Next()
+}
+
+// InjectFailures makes the next count API calls fail with statusCode
+func (f *FakeMayarServer) InjectFailures(count, statusCode int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.injectedFailures = count
+	f.injectedStatus = statusCode
+}
+
+// =============================================================================
+// PRODUCTS
+// =============================================================================
+
+// AddProduct seeds a product and returns it with an ID assigned
+func (f *FakeMayarServer) AddProduct(product Product) Product {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if product.ID == "" {
+		product.ID = uuid.New().String()
+	}
+	now := time.Now()
+	product.CreatedAt = now
+	product.UpdatedAt = now
+	f.products[product.ID] = &product
+	return product
+}
+
+// IsProductClosed reports whether a product has been closed
+func (f *FakeMayarServer) IsProductClosed(productID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closedProducts[productID]
+}
+
+func (f *FakeMayarServer) seedProduct(c *gin.Context) {
+	var product Product
+	if err := c.ShouldBindJSON(&product); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, ProductResponse{Success: true, Data: f.AddProduct(product)})
+}
+
+func (f *FakeMayarServer) listProducts(c *gin.Context) {
+	productType := c.Param("type")
+	search := strings.ToLower(c.Query("search"))
+
+	f.mu.Lock()
+	products := make([]Product, 0, len(f.products))
+	for _, product := range f.products {
+		if productType != "" && product.Category != productType {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(product.Name), search) {
+			continue
+		}
+		products = append(products, *product)
+	}
+	f.mu.Unlock()
+
+	page, pageSize := fakePagination(c)
+	total := len(products)
+	products = fakePage(products, page, pageSize)
+
+	c.JSON(http.StatusOK, ProductListResponse{
+		Success: true,
+		Data:    products,
+		Meta:    fakeMeta(page, pageSize, total),
+	})
+}
+
+func (f *FakeMayarServer) getProduct(c *gin.Context) {
+	f.mu.Lock()
+	product, ok := f.products[c.Param("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Product not found"})
+		return
+	}
+	c.JSON(http.StatusOK, ProductResponse{Success: true, Data: *product})
+}
+
+func (f *FakeMayarServer) setProductClosed(closed bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f.mu.Lock()
+		_, ok := f.products[c.Param("id")]
+		if ok {
+			f.closedProducts[c.Param("id")] = closed
+		}
+		f.mu.Unlock()
+
+		if !ok {
+			c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Product not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+// =============================================================================
+// INVOICES
+// =============================================================================
+
+func (f *FakeMayarServer) createInvoice(c *gin.Context) {
+	var req CreateInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	invoice := &Invoice{
+		ID:         uuid.New().String(),
+		CustomerID: req.CustomerID,
+		Currency:   "IDR",
+		Status:     "unpaid",
+		DueDate:    req.DueDate,
+		Items:      req.Items,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	invoice.Amount = fakeInvoiceTotal(invoice.Items)
+
+	f.mu.Lock()
+	invoice.Number = fmt.Sprintf("INV-%05d", len(f.invoices)+1)
+	f.invoices[invoice.ID] = invoice
+	f.mu.Unlock()
+
+	c.JSON(http.StatusCreated, InvoiceResponse{Success: true, Data: *invoice})
+}
+
+func (f *FakeMayarServer) getInvoice(c *gin.Context) {
+	f.mu.Lock()
+	invoice, ok := f.invoices[c.Param("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Invoice not found"})
+		return
+	}
+	c.JSON(http.StatusOK, InvoiceResponse{Success: true, Data: *invoice})
+}
+
+func (f *FakeMayarServer) updateInvoice(c *gin.Context) {
+	var req UpdateInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	invoice, ok := f.invoices[c.Param("id")]
+	if ok {
+		if len(req.Items) > 0 {
+			invoice.Items = req.Items
+			invoice.Amount = fakeInvoiceTotal(req.Items)
+		}
+		if !req.DueDate.IsZero() {
+			invoice.DueDate = req.DueDate
+		}
+		invoice.UpdatedAt = time.Now()
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Invoice not found"})
+		return
+	}
+	c.JSON(http.StatusOK, InvoiceResponse{Success: true, Data: *invoice})
+}
+
+func (f *FakeMayarServer) deleteInvoice(c *gin.Context) {
+	f.mu.Lock()
+	_, ok := f.invoices[c.Param("id")]
+	delete(f.invoices, c.Param("id"))
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Invoice not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// fakeInvoiceTotal sums invoice item totals, filling in missing item totals
+func fakeInvoiceTotal(items []InvoiceItem) Money {
+	total := Rupiah(0)
+	for i := range items {
+		if items[i].TotalPrice.IsZero() {
+			items[i].TotalPrice = items[i].UnitPrice.Mul(int64(items[i].Quantity))
+		}
+		total = total.Add(items[i].TotalPrice)
+	}
+	return total
+}
+
+// =============================================================================
+// PAYMENT REQUESTS
+// =============================================================================
+
+func (f *FakeMayarServer) createPaymentRequest(c *gin.Context) {
+	var req CreatePaymentRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+	if !req.Amount.IsPositive() {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "amount must be greater than 0"})
+		return
+	}
+
+	now := time.Now()
+	id := uuid.New().String()
+	expiresAt := req.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(24 * time.Hour)
+	}
+	currency := req.Currency
+	if currency == "" {
+		currency = "IDR"
+	}
+
+	paymentRequest := &fakePaymentRequest{
+		PaymentRequest: PaymentRequest{
+			ID:          id,
+			Amount:      req.Amount,
+			Currency:    currency,
+			Description: req.Description,
+			Status:      "unpaid",
+			PaymentURL:  fmt.Sprintf("http://%s/pay/%s", c.Request.Host, id),
+			ExpiresAt:   expiresAt,
+			Metadata:    req.Metadata,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		Name:   req.Name,
+		Email:  req.Email,
+		Mobile: req.Mobile,
+	}
+
+	f.mu.Lock()
+	f.paymentRequests[id] = paymentRequest
+	f.mu.Unlock()
+
+	c.JSON(http.StatusCreated, PaymentRequestResponse{Success: true, Data: paymentRequest.PaymentRequest})
+}
+
+func (f *FakeMayarServer) getPaymentRequest(c *gin.Context) {
+	f.mu.Lock()
+	paymentRequest, ok := f.paymentRequests[c.Param("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Payment request not found"})
+		return
+	}
+	c.JSON(http.StatusOK, PaymentRequestResponse{Success: true, Data: paymentRequest.PaymentRequest})
+}
+
+// CompletePayment marks a payment request as paid and sends a payment.completed
+// webhook after delay
+func (f *FakeMayarServer) CompletePayment(paymentRequestID, paymentMethod string, delay time.Duration) error {
+	return f.settlePayment(paymentRequestID, "paid", paymentMethod, delay)
+}
+
+// FailPayment marks a payment request as failed and sends a payment.failed
+// webhook after delay
+func (f *FakeMayarServer) FailPayment(paymentRequestID, paymentMethod string, delay time.Duration) error {
+	return f.settlePayment(paymentRequestID, "failed", paymentMethod, delay)
+}
+
+// settlePayment records the payment outcome and schedules the webhook
+func (f *FakeMayarServer) settlePayment(paymentRequestID, status, paymentMethod string, delay time.Duration) error {
+	f.mu.Lock()
+	paymentRequest, ok := f.paymentRequests[paymentRequestID]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("payment request not found")
+	}
+	if paymentRequest.Status == "paid" {
+		f.mu.Unlock()
+		return fmt.Errorf("payment request already paid")
+	}
+
+	now := time.Now()
+	paymentRequest.Status = status
+	paymentRequest.UpdatedAt = now
+	f.transactions = append(f.transactions, Transaction{
+		ID:            uuid.New().String(),
+		Type:          "payment_request",
+		Amount:        paymentRequest.Amount,
+		Currency:      paymentRequest.Currency,
+		Status:        status,
+		Description:   paymentRequest.Description,
+		PaymentMethod: paymentMethod,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+
+	data := map[string]interface{}{
+		"id":               paymentRequest.ID,
+		"paymentRequestId": paymentRequest.ID,
+		"amount":           paymentRequest.Amount,
+		"status":           status,
+		"description":      paymentRequest.Description,
+		"paymentMethod":    paymentMethod,
+		"metadata":         paymentRequest.Metadata,
+		"customerName":     paymentRequest.Name,
+		"customerEmail":    paymentRequest.Email,
+	}
+	event := "payment.failed"
+	if status == "paid" {
+		event = "payment.completed"
+		data["paidAt"] = now.Format(time.RFC3339)
+	} else {
+		data["failedAt"] = now.Format(time.RFC3339)
+	}
+	f.mu.Unlock()
+
+	f.sendWebhook(event, data, delay)
+	return nil
+}
+
+// PayInvoice marks an invoice as paid and sends an invoice.paid webhook after delay
+func (f *FakeMayarServer) PayInvoice(invoiceID, paymentMethod string, delay time.Duration) error {
+	f.mu.Lock()
+	invoice, ok := f.invoices[invoiceID]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("invoice not found")
+	}
+
+	now := time.Now()
+	invoice.Status = "paid"
+	invoice.UpdatedAt = now
+	data := map[string]interface{}{
+		"id":            invoice.ID,
+		"amount":        invoice.Amount,
+		"status":        "paid",
+		"description":   invoice.Number,
+		"paymentMethod": paymentMethod,
+		"paidAt":        now.Format(time.RFC3339),
+	}
+	f.mu.Unlock()
+
+	f.sendWebhook("invoice.paid", data, delay)
+	return nil
+}
+
+// fakeSettleRequest is the body of the /__fake settlement endpoints
+type fakeSettleRequest struct {
+	PaymentMethod string `json:"paymentMethod"`
+	Delay         string `json:"delay"`
+}
+
+// bindSettleRequest reads an optional settlement body from JSON or a form post
+func bindSettleRequest(c *gin.Context) (string, time.Duration, error) {
+	req := fakeSettleRequest{
+		PaymentMethod: c.PostForm("paymentMethod"),
+		Delay:         c.PostForm("delay"),
+	}
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return "", 0, err
+		}
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = "qris"
+	}
+
+	var delay time.Duration
+	if req.Delay != "" {
+		parsed, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid delay: %w", err)
+		}
+		delay = parsed
+	}
+	return req.PaymentMethod, delay, nil
+}
+
+func (f *FakeMayarServer) controlSettle(c *gin.Context, settle func(id, paymentMethod string, delay time.Duration) error) {
+	paymentMethod, delay, err := bindSettleRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+	if err := settle(c.Param("id"), paymentMethod, delay); err != nil {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "webhookDelay": delay.String()})
+}
+
+func (f *FakeMayarServer) controlCompletePayment(c *gin.Context) {
+	f.controlSettle(c, f.CompletePayment)
+}
+
+func (f *FakeMayarServer) controlFailPayment(c *gin.Context) {
+	f.controlSettle(c, f.FailPayment)
+}
+
+func (f *FakeMayarServer) controlPayInvoice(c *gin.Context) {
+	f.controlSettle(c, f.PayInvoice)
+}
+
+func (f *FakeMayarServer) controlInjectFailures(c *gin.Context) {
+	var req struct {
+		Count      int `json:"count"`
+		StatusCode int `json:"statusCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Count < 0 {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "count and statusCode are required"})
+		return
+	}
+	if req.StatusCode == 0 {
+		req.StatusCode = http.StatusServiceUnavailable
+	}
+	f.InjectFailures(req.Count, req.StatusCode)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+var fakeCheckoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html><head><title>Fake Mayar Checkout</title></head><body>
+<h2>Fake Mayar Checkout</h2>
+<p>{{.Description}}</p>
+<p>Amount: {{.Currency}} {{.Amount.Int64}} &mdash; status: {{.Status}}</p>
+<form method="POST" action="/__fake/payment-requests/{{.ID}}/complete"><button type="submit">Pay</button></form>
+<form method="POST" action="/__fake/payment-requests/{{.ID}}/fail"><button type="submit">Fail payment</button></form>
+</body></html>`))
+
+func (f *FakeMayarServer) checkoutPage(c *gin.Context) {
+	f.mu.Lock()
+	paymentRequest, ok := f.paymentRequests[c.Param("id")]
+	var data PaymentRequest
+	if ok {
+		data = paymentRequest.PaymentRequest
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		c.String(http.StatusNotFound, "payment request not found")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := fakeCheckoutTemplate.Execute(c.Writer, data); err != nil {
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// =============================================================================
+// CUSTOMERS
+// =============================================================================
+
+func (f *FakeMayarServer) createCustomer(c *gin.Context) {
+	var req CreateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "name and email are required"})
+		return
+	}
+
+	now := time.Now()
+	customer := &Customer{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		Address:   req.Address,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	f.mu.Lock()
+	f.customers[customer.ID] = customer
+	f.mu.Unlock()
+
+	c.JSON(http.StatusCreated, CustomerResponse{Success: true, Data: *customer})
+}
+
+func (f *FakeMayarServer) getCustomer(c *gin.Context) {
+	f.mu.Lock()
+	customer, ok := f.customers[c.Param("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Customer not found"})
+		return
+	}
+	c.JSON(http.StatusOK, CustomerResponse{Success: true, Data: *customer})
+}
+
+func (f *FakeMayarServer) updateCustomer(c *gin.Context) {
+	var req UpdateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	customer, ok := f.customers[c.Param("id")]
+	if ok {
+		if req.Name != "" {
+			customer.Name = req.Name
+		}
+		if req.Email != "" {
+			customer.Email = req.Email
+		}
+		if req.Phone != "" {
+			customer.Phone = req.Phone
+		}
+		if req.Address != "" {
+			customer.Address = req.Address
+		}
+		customer.UpdatedAt = time.Now()
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Customer not found"})
+		return
+	}
+	c.JSON(http.StatusOK, CustomerResponse{Success: true, Data: *customer})
+}
+
+// =============================================================================
+// TRANSACTIONS
+// =============================================================================
+
+func (f *FakeMayarServer) listTransactions(c *gin.Context) {
+	status := c.Query("status")
+
+	f.mu.Lock()
+	transactions := make([]Transaction, 0, len(f.transactions))
+	for _, transaction := range f.transactions {
+		if status != "" && transaction.Status != status {
+			continue
+		}
+		transactions = append(transactions, transaction)
+	}
+	f.mu.Unlock()
+
+	page, pageSize := fakePagination(c)
+	total := len(transactions)
+	c.JSON(http.StatusOK, TransactionListResponse{
+		Success: true,
+		Data:    fakePage(transactions, page, pageSize),
+		Meta:    fakeMeta(page, pageSize, total),
+	})
+}
+
+func (f *FakeMayarServer) getTransaction(c *gin.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, transaction := range f.transactions {
+		if transaction.ID == c.Param("id") {
+			c.JSON(http.StatusOK, TransactionResponse{Success: true, Data: transaction})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Transaction not found"})
+}
+
+// =============================================================================
+// WEBHOOKS
+// =============================================================================
+
+func (f *FakeMayarServer) registerWebhook(c *gin.Context) {
+	var req RegisterWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == "" {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "url is required"})
+		return
+	}
+
+	now := time.Now()
+	registration := &WebhookRegistration{
+		ID:        uuid.New().String(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	f.mu.Lock()
+	f.webhooks[registration.ID] = registration
+	f.webhookURL = req.URL
+	f.mu.Unlock()
+
+	c.JSON(http.StatusCreated, WebhookRegistrationResponse{Success: true, Data: *registration})
+}
+
+func (f *FakeMayarServer) getWebhookHistory(c *gin.Context) {
+	f.mu.Lock()
+	history := append([]WebhookHistory(nil), f.webhookHistory...)
+	f.mu.Unlock()
+
+	c.JSON(http.StatusOK, WebhookHistoryResponse{Success: true, Data: history})
+}
+
+func (f *FakeMayarServer) testWebhook(c *gin.Context) {
+	f.sendWebhook("testing", map[string]interface{}{"webhookId": c.Param("id")}, 0)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (f *FakeMayarServer) retryWebhook(c *gin.Context) {
+	f.mu.Lock()
+	payload, ok := f.webhookPayloads[c.Param("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Webhook history not found"})
+		return
+	}
+
+	f.deliveries.Add(1)
+	go func() {
+		defer f.deliveries.Done()
+		f.deliverWebhook(c.Param("id"), payload)
+	}()
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// sendWebhook delivers a signed event to the webhook URL after delay
+func (f *FakeMayarServer) sendWebhook(event string, data map[string]interface{}, delay time.Duration) {
+	historyID := uuid.New().String()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":        historyID,
+		"event":     event,
+		"data":      data,
+		"createdAt": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		LogError("Fake Mayar failed to encode webhook", logrus.Fields{"event": event}, err)
+		return
+	}
+
+	f.mu.Lock()
+	f.webhookPayloads[historyID] = payload
+	f.webhookHistory = append(f.webhookHistory, WebhookHistory{
+		ID:        historyID,
+		Event:     event,
+		Status:    "pending",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	f.mu.Unlock()
+
+	f.deliveries.Add(1)
+	go func() {
+		defer f.deliveries.Done()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		f.deliverWebhook(historyID, payload)
+	}()
+}
+
+// SignWebhookPayload returns the signature header value for a payload sent at timestamp
+func (f *FakeMayarServer) SignWebhookPayload(timestamp string, payload []byte) string {
+	return SignMayarWebhook(f.webhookSecret, timestamp, payload)
+}
+
+// deliverWebhook posts a payload and records the outcome in the webhook history
+func (f *FakeMayarServer) deliverWebhook(historyID string, payload []byte) {
+	f.mu.Lock()
+	webhookURL := f.webhookURL
+	f.mu.Unlock()
+
+	status := "failed"
+	responseCode := 0
+	if webhookURL != "" {
+		req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(MayarTimestampHeader, timestamp)
+			req.Header.Set(MayarSignatureHeader, f.SignWebhookPayload(timestamp, payload))
+			resp, doErr := f.httpClient.Do(req)
+			if doErr == nil {
+				resp.Body.Close()
+				responseCode = resp.StatusCode
+				if resp.StatusCode < 300 {
+					status = "success"
+				}
+			}
+		}
+	}
+
+	f.mu.Lock()
+	for i := range f.webhookHistory {
+		if f.webhookHistory[i].ID == historyID {
+			f.webhookHistory[i].Status = status
+			f.webhookHistory[i].ResponseCode = responseCode
+			f.webhookHistory[i].Attempts++
+			f.webhookHistory[i].UpdatedAt = time.Now()
+		}
+	}
+	f.mu.Unlock()
+
+	LogDebug("Fake Mayar webhook delivered", logrus.Fields{
+		"history_id":    historyID,
+		"status":        status,
+		"response_code": responseCode,
+	})
+}
+
+// WaitForWebhooks blocks until all scheduled webhook deliveries have finished
+func (f *FakeMayarServer) WaitForWebhooks() {
+	f.deliveries.Wait()
+}
+
+// WebhookHistory returns a copy of the webhook delivery history
+func (f *FakeMayarServer) WebhookHistory() []WebhookHistory {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]WebhookHistory(nil), f.webhookHistory...)
+}
+
+// =============================================================================
+// LICENSES
+// =============================================================================
+
+// AddLicense seeds a license code for a product
+func (f *FakeMayarServer) AddLicense(licenseCode, productID string, expiresAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.licenses[licenseCode] = &fakeLicense{
+		ProductID: productID,
+		Devices:   make(map[string]bool),
+		ExpiresAt: expiresAt,
+	}
+}
+
+func (f *FakeMayarServer) seedLicense(c *gin.Context) {
+	var req struct {
+		LicenseCode string    `json:"licenseCode"`
+		ProductID   string    `json:"productId"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.LicenseCode == "" {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "licenseCode is required"})
+		return
+	}
+	f.AddLicense(req.LicenseCode, req.ProductID, req.ExpiresAt)
+	c.JSON(http.StatusCreated, gin.H{"success": true})
+}
+
+func (f *FakeMayarServer) verifyLicense(c *gin.Context) {
+	var req VerifyLicenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	license, ok := f.licenses[req.LicenseCode]
+	active := ok && (req.ProductID == "" || license.ProductID == req.ProductID) &&
+		(license.ExpiresAt.IsZero() || time.Now().Before(license.ExpiresAt)) &&
+		(req.DeviceID == "" || license.Devices[req.DeviceID])
+	var expiresAt time.Time
+	if ok {
+		expiresAt = license.ExpiresAt
+	}
+	f.mu.Unlock()
+
+	resp := VerifyLicenseResponse{
+		Success:         true,
+		IsLicenseActive: active,
+		LicenseCode:     req.LicenseCode,
+		ProductID:       req.ProductID,
+		ExpiresAt:       expiresAt,
+	}
+	resp.Data.IsLicenseActive = active
+	resp.Data.LicenseCode = req.LicenseCode
+	resp.Data.ExpiresAt = expiresAt
+	c.JSON(http.StatusOK, resp)
+}
+
+func (f *FakeMayarServer) activateLicense(c *gin.Context) {
+	f.setLicenseDevice(c, true)
+}
+
+func (f *FakeMayarServer) deactivateLicense(c *gin.Context) {
+	f.setLicenseDevice(c, false)
+}
+
+// setLicenseDevice activates or deactivates a license on a device
+func (f *FakeMayarServer) setLicenseDevice(c *gin.Context, active bool) {
+	var req ActivateLicenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	license, ok := f.licenses[req.LicenseCode]
+	if ok {
+		if active {
+			license.Devices[req.DeviceID] = true
+		} else {
+			delete(license.Devices, req.DeviceID)
+		}
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "License not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// =============================================================================
+// COUPONS
+// =============================================================================
+
+func (f *FakeMayarServer) createCoupon(c *gin.Context) {
+	var req CreateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: "code is required"})
+		return
+	}
+
+	now := time.Now()
+	coupon := &Coupon{
+		ID:          uuid.New().String(),
+		Code:        req.Code,
+		Type:        req.Type,
+		Value:       req.Value,
+		MinAmount:   req.MinAmount,
+		MaxDiscount: req.MaxDiscount,
+		UsageLimit:  req.UsageLimit,
+		ExpiresAt:   req.ExpiresAt,
+		Active:      true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.coupons[req.Code]; exists {
+		c.JSON(http.StatusConflict, MayarErrorResponse{Message: "Coupon code already exists"})
+		return
+	}
+	f.coupons[req.Code] = coupon
+	c.JSON(http.StatusCreated, CouponResponse{Success: true, Data: *coupon})
+}
+
+func (f *FakeMayarServer) applyCoupon(c *gin.Context) {
+	var req ApplyCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, MayarErrorResponse{Message: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	coupon, ok := f.coupons[req.CouponCode]
+	valid := ok && coupon.Active &&
+		(coupon.ExpiresAt.IsZero() || time.Now().Before(coupon.ExpiresAt)) &&
+		(coupon.UsageLimit == 0 || coupon.UsageCount < coupon.UsageLimit) &&
+		!req.Amount.LessThan(coupon.MinAmount)
+
+	discount := Rupiah(0)
+	if valid {
+		if coupon.Type == "percentage" {
+			discount = req.Amount.Discount(coupon.Value.Int64()*100, coupon.MaxDiscount)
+		} else {
+			discount = coupon.Value
+			if coupon.MaxDiscount.IsPositive() {
+				discount = discount.Min(coupon.MaxDiscount)
+			}
+			discount = discount.Min(req.Amount)
+		}
+		coupon.UsageCount++
+	}
+	f.mu.Unlock()
+
+	resp := ApplyCouponResponse{
+		Success:        true,
+		IsValid:        valid,
+		DiscountAmount: discount,
+		FinalAmount:    req.Amount.Sub(discount),
+	}
+	resp.Data.IsValid = resp.IsValid
+	resp.Data.DiscountAmount = resp.DiscountAmount
+	resp.Data.FinalAmount = resp.FinalAmount
+	c.JSON(http.StatusOK, resp)
+}
+
+func (f *FakeMayarServer) getCoupon(c *gin.Context) {
+	f.mu.Lock()
+	coupon, ok := f.coupons[c.Param("code")]
+	f.mu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, MayarErrorResponse{Message: "Coupon not found"})
+		return
+	}
+	c.JSON(http.StatusOK, CouponResponse{Success: true, Data: *coupon})
+}
+
+// =============================================================================
+// PAGINATION HELPERS
+// =============================================================================
+
+// fakePagination reads page and pageSize query parameters
+func fakePagination(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+	return page, pageSize
+}
+
+// fakePage returns one page of items
+func fakePage[T any](items []T, page, pageSize int) []T {
+	start := (page - 1) * pageSize
+	if start >= len(items) {
+		return []T{}
+	}
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
+
+// fakeMeta builds pagination metadata
+func fakeMeta(page, pageSize, total int) PaginationMeta {
+	return PaginationMeta{
+		Page:       page,
+		PageSize:   pageSize,
+		TotalItems: total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver verifies the webhooks FakeMayarServer delivers the way the
+// backend does and records the outcome of each
+type webhookReceiver struct {
+	verifier *webhookVerifier
+
+	mu      sync.Mutex
+	results []error
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, err = r.verifier.Verify(req.Header, body, time.Now())
+	r.mu.Lock()
+	r.results = append(r.results, err)
+	r.mu.Unlock()
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Results returns the verification result of every webhook received so far
+func (r *webhookReceiver) Results() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.results...)
+}
+
+// testWebhookConfig accepts the given secrets and requires a signed timestamp
+func testWebhookConfig(secrets ...WebhookSecret) WebhookSecurityConfig {
+	return WebhookSecurityConfig{
+		Secrets:            secrets,
+		TimestampTolerance: 5 * time.Minute,
+		RequireTimestamp:   true,
+		ReplayTTL:          24 * time.Hour,
+	}
+}
+
+// newFakeMayarHarness starts a fake Mayar API signing with fakeSecret and a
+// receiver accepting secrets, and points the fake's webhooks at the receiver
+func newFakeMayarHarness(t *testing.T, fakeSecret string, secrets ...WebhookSecret) (*FakeMayarServer, *httptest.Server, *webhookReceiver) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	receiver := &webhookReceiver{verifier: newWebhookVerifier(testWebhookConfig(secrets...))}
+	hooks := httptest.NewServer(receiver)
+	t.Cleanup(hooks.Close)
+
+	fake := NewFakeMayarServer(hooks.URL, fakeSecret)
+	api := httptest.NewServer(fake.Handler())
+	t.Cleanup(api.Close)
+
+	return fake, api, receiver
+}
+
+// fakeAPIRequest calls the fake API with a bearer token and decodes the JSON response into target
+func fakeAPIRequest(t *testing.T, api *httptest.Server, method, path string, body, target interface{}) {
+	t.Helper()
+
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, api.URL+path, payload)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := api.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		t.Fatalf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	if target != nil {
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+}
+
+// createFakePaymentRequest creates a payment request through the fake API and returns its ID
+func createFakePaymentRequest(t *testing.T, api *httptest.Server) string {
+	t.Helper()
+
+	var created PaymentRequestResponse
+	fakeAPIRequest(t, api, http.MethodPost, "/request-payment", CreatePaymentRequestRequest{
+		Amount:      Rupiah(150000),
+		Description: "Website deposit",
+		Email:       "customer@example.com",
+	}, &created)
+	if created.Data.ID == "" {
+		t.Fatal("payment request has no ID")
+	}
+	return created.Data.ID
+}
+
+// rejectionReason returns the WebhookVerificationError reason of err, or "" when err is nil
+func rejectionReason(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var verifyErr *WebhookVerificationError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return verifyErr.Reason
+}
+
+// reasons returns the rejection reason of every received webhook, "" for accepted ones
+func reasons(t *testing.T, receiver *webhookReceiver) []string {
+	t.Helper()
+	var got []string
+	for _, err := range receiver.Results() {
+		got = append(got, rejectionReason(t, err))
+	}
+	return got
+}
+
+func TestFakeMayarCompletedPaymentWebhookIsAccepted(t *testing.T) {
+	fake, api, receiver := newFakeMayarHarness(t, "current", WebhookSecret{ID: "current", Value: "current"})
+
+	id := createFakePaymentRequest(t, api)
+	if err := fake.CompletePayment(id, "qris", 0); err != nil {
+		t.Fatalf("CompletePayment: %v", err)
+	}
+	fake.WaitForWebhooks()
+
+	if got := reasons(t, receiver); len(got) != 1 || got[0] != "" {
+		t.Fatalf("webhook results = %q, want one accepted webhook", got)
+	}
+	history := fake.WebhookHistory()
+	if len(history) != 1 || history[0].Event != "payment.completed" || history[0].Status != "success" {
+		t.Fatalf("webhook history = %+v, want one delivered payment.completed", history)
+	}
+
+	var status PaymentRequestResponse
+	fakeAPIRequest(t, api, http.MethodGet, "/request-payment/"+id, nil, &status)
+	if status.Data.Status != "paid" {
+		t.Fatalf("payment request status = %q, want paid", status.Data.Status)
+	}
+}
+
+func TestFakeMayarWebhookSecretWindow(t *testing.T) {
+	now := time.Now()
+	secrets := []WebhookSecret{
+		{ID: "retired", Value: "retired", ActiveUntil: now.Add(-time.Hour)},
+		{ID: "current", Value: "current", ActiveFrom: now.Add(-time.Hour)},
+		{ID: "next", Value: "next", ActiveFrom: now.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name       string
+		fakeSecret string
+		want       string
+	}{
+		{name: "active secret", fakeSecret: "current", want: ""},
+		{name: "retired secret", fakeSecret: "retired", want: WebhookRejectInvalidSignature},
+		{name: "secret not active yet", fakeSecret: "next", want: WebhookRejectInvalidSignature},
+		{name: "unknown secret", fakeSecret: "forged", want: WebhookRejectInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, api, receiver := newFakeMayarHarness(t, tt.fakeSecret, secrets...)
+
+			id := createFakePaymentRequest(t, api)
+			if err := fake.CompletePayment(id, "qris", 0); err != nil {
+				t.Fatalf("CompletePayment: %v", err)
+			}
+			fake.WaitForWebhooks()
+
+			if got := reasons(t, receiver); len(got) != 1 || got[0] != tt.want {
+				t.Fatalf("webhook results = %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeMayarRetriedWebhookIsRejectedAsReplay(t *testing.T) {
+	fake, api, receiver := newFakeMayarHarness(t, "current", WebhookSecret{ID: "current", Value: "current"})
+
+	id := createFakePaymentRequest(t, api)
+	if err := fake.CompletePayment(id, "qris", 0); err != nil {
+		t.Fatalf("CompletePayment: %v", err)
+	}
+	fake.WaitForWebhooks()
+
+	// A retry carries the same body under a fresh timestamp and signature
+	history := fake.WebhookHistory()
+	fakeAPIRequest(t, api, http.MethodPost, "/webhook/retry/"+history[0].ID, nil, nil)
+	fake.WaitForWebhooks()
+
+	want := []string{"", WebhookRejectReplayed}
+	if got := reasons(t, receiver); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("webhook results = %q, want %q", got, want)
+	}
+}
+
+// signedWebhookHeaders returns the headers Mayar sends with body signed by secret at now
+func signedWebhookHeaders(secret string, body []byte, now time.Time) http.Header {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	headers := http.Header{}
+	headers.Set(MayarTimestampHeader, timestamp)
+	headers.Set(MayarSignatureHeader, SignMayarWebhook(secret, timestamp, body))
+	return headers
+}
+
+func TestWebhookVerifierRejectsReplayWithChangedHeaders(t *testing.T) {
+	verifier := newWebhookVerifier(testWebhookConfig(WebhookSecret{ID: "current", Value: "current"}))
+	now := time.Now()
+	body := []byte(`{"id":"evt-1","event":"payment.completed","data":{"status":"paid"}}`)
+
+	if _, err := verifier.Verify(signedWebhookHeaders("current", body, now), body, now); err != nil {
+		t.Fatalf("first delivery rejected: %v", err)
+	}
+
+	// Headers are not signed, so neither a new timestamp nor an event ID header makes it a new event
+	later := now.Add(time.Minute)
+	headers := signedWebhookHeaders("current", body, later)
+	headers.Set("X-Mayar-Event-Id", "evt-2")
+	if _, err := verifier.Verify(headers, body, later); rejectionReason(t, err) != WebhookRejectReplayed {
+		t.Fatalf("replayed delivery: got %v, want %s", err, WebhookRejectReplayed)
+	}
+
+	other := []byte(`{"id":"evt-2","event":"payment.completed","data":{"status":"paid"}}`)
+	if _, err := verifier.Verify(signedWebhookHeaders("current", other, later), other, later); err != nil {
+		t.Fatalf("new event rejected: %v", err)
+	}
+}
+
+func TestWebhookVerifierRejectsTamperedAndStaleWebhooks(t *testing.T) {
+	verifier := newWebhookVerifier(testWebhookConfig(WebhookSecret{ID: "current", Value: "current"}))
+	now := time.Now()
+	body := []byte(`{"id":"evt-1","event":"payment.completed","data":{"amount":150000}}`)
+
+	tampered := []byte(`{"id":"evt-1","event":"payment.completed","data":{"amount":1}}`)
+	if _, err := verifier.Verify(signedWebhookHeaders("current", body, now), tampered, now); rejectionReason(t, err) != WebhookRejectInvalidSignature {
+		t.Fatalf("tampered body: got %v, want %s", err, WebhookRejectInvalidSignature)
+	}
+
+	stale := signedWebhookHeaders("current", body, now.Add(-10*time.Minute))
+	if _, err := verifier.Verify(stale, body, now); rejectionReason(t, err) != WebhookRejectStaleTimestamp {
+		t.Fatalf("stale timestamp: got %v, want %s", err, WebhookRejectStaleTimestamp)
+	}
+
+	unsigned := http.Header{}
+	unsigned.Set(MayarTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	if _, err := verifier.Verify(unsigned, body, now); rejectionReason(t, err) != WebhookRejectMissingSignature {
+		t.Fatalf("missing signature: got %v, want %s", err, WebhookRejectMissingSignature)
+	}
+
+	// Rejected deliveries must not use up the event ID
+	if _, err := verifier.Verify(signedWebhookHeaders("current", body, now), body, now); err != nil {
+		t.Fatalf("authentic delivery rejected: %v", err)
+	}
+}
+
+func TestWebhookVerifierAcceptsRetryOfForgottenEvent(t *testing.T) {
+	verifier := newWebhookVerifier(testWebhookConfig(WebhookSecret{ID: "current", Value: "current"}))
+	now := time.Now()
+	body := []byte(`{"id":"evt-1","event":"payment.completed","data":{"status":"paid"}}`)
+
+	verification, err := verifier.Verify(signedWebhookHeaders("current", body, now), body, now)
+	if err != nil {
+		t.Fatalf("first delivery rejected: %v", err)
+	}
+
+	// Processing failed, so the event is forgotten and Mayar's retry goes through
+	verifier.Forget(verification.EventID)
+	later := now.Add(time.Minute)
+	if _, err := verifier.Verify(signedWebhookHeaders("current", body, later), body, later); err != nil {
+		t.Fatalf("retry after a failed delivery rejected: %v", err)
+	}
+	if _, err := verifier.Verify(signedWebhookHeaders("current", body, later), body, later); rejectionReason(t, err) != WebhookRejectReplayed {
+		t.Fatalf("retry after a processed delivery: got %v, want %s", err, WebhookRejectReplayed)
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// JWT signing algorithms. HS256 keys are shared secrets; RS256 and EdDSA keys
+// let other services verify tokens with only the public key from the JWKS endpoint.
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgEdDSA = "EdDSA"
+)
+
+// minJWTSecretLength is the shortest HS256 secret accepted, in bytes
+const minJWTSecretLength = 32
+
+var (
+	// ErrNoJWTSigningKey is returned when every key in the ring is retired
+	ErrNoJWTSigningKey = errors.New("no active JWT signing key")
+	// ErrJWTKeysNotRotatable is returned when rotating keys that are not stored in a key file
+	ErrJWTKeysNotRotatable = errors.New("JWT keys can only be rotated when JWT_KEYS_FILE is set")
+)
+
+// JWTKey is one signing key, identified in tokens by the kid header
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiredAt is when the key stopped signing tokens; zero while it is current
+	RetiredAt time.Time
+
+	secret  []byte
+	private crypto.Signer
+}
+
+// method returns the jwt signing method for the key's algorithm
+func (k *JWTKey) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case JWTAlgRS256:
+		return jwt.SigningMethodRS256
+	case JWTAlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// signingKey returns the key material jwt signs with
+func (k *JWTKey) signingKey() interface{} {
+	if k.Algorithm == JWTAlgHS256 {
+		return k.secret
+	}
+	return k.private
+}
+
+// verificationKey returns the key material jwt verifies with
+func (k *JWTKey) verificationKey() interface{} {
+	if k.Algorithm == JWTAlgHS256 {
+		return k.secret
+	}
+	return k.private.Public()
+}
+
+// keyFunc resolves the verification key named by a token's kid header for jwt.Parse,
+// rejecting tokens whose algorithm does not match the key
+func (r *JWTKeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	key, ok := r.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	if token.Method.Alg() != key.method().Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verificationKey(), nil
+}
+
+// acceptedAt reports whether tokens signed with the key are still accepted at t.
+// A retired key is accepted for window after retirement so tokens it signed
+// stay valid until they expire.
+func (k *JWTKey) acceptedAt(t time.Time, window time.Duration) bool {
+	return k.RetiredAt.IsZero() || t.Before(k.RetiredAt.Add(window))
+}
+
+// newJWTKey generates a key for alg with a random kid
+func newJWTKey(alg string) (*JWTKey, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	key := &JWTKey{
+		ID:        hex.EncodeToString(idBytes),
+		Algorithm: alg,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	switch alg {
+	case JWTAlgHS256:
+		key.secret = make([]byte, 64)
+		if _, err := rand.Read(key.secret); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+	case JWTAlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		key.private = private
+	case JWTAlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		key.private = private
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+
+	return key, nil
+}
+
+// secretJWTKey wraps a configured HS256 secret. Its kid is derived from the
+// secret so every instance sharing JWT_SECRET agrees on it.
+func secretJWTKey(secret string) (*JWTKey, error) {
+	if len(secret) < minJWTSecretLength {
+		return nil, fmt.Errorf("JWT_SECRET must be at least %d bytes", minJWTSecretLength)
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return &JWTKey{
+		ID:        "env-" + hex.EncodeToString(sum[:4]),
+		Algorithm: JWTAlgHS256,
+		secret:    []byte(secret),
+	}, nil
+}
+
+// jwtKeyFileEntry is how a key is stored in JWT_KEYS_FILE
+type jwtKeyFileEntry struct {
+	ID         string     `json:"kid"`
+	Algorithm  string     `json:"alg"`
+	Secret     string     `json:"secret,omitempty"`     // base64, HS256 only
+	PrivateKey string     `json:"privateKey,omitempty"` // PKCS#8 PEM, RS256 and EdDSA
+	CreatedAt  time.Time  `json:"createdAt"`
+	RetiredAt  *time.Time `json:"retiredAt,omitempty"`
+}
+
+// jwtKeyFile is the JSON document stored in JWT_KEYS_FILE
+type jwtKeyFile struct {
+	Keys []jwtKeyFileEntry `json:"keys"`
+}
+
+func (k *JWTKey) toFileEntry() (jwtKeyFileEntry, error) {
+	entry := jwtKeyFileEntry{ID: k.ID, Algorithm: k.Algorithm, CreatedAt: k.CreatedAt}
+	if !k.RetiredAt.IsZero() {
+		retiredAt := k.RetiredAt
+		entry.RetiredAt = &retiredAt
+	}
+
+	if k.Algorithm == JWTAlgHS256 {
+		entry.Secret = base64.StdEncoding.EncodeToString(k.secret)
+		return entry, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return entry, fmt.Errorf("failed to encode key %s: %w", k.ID, err)
+	}
+	entry.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return entry, nil
+}
+
+func (e jwtKeyFileEntry) toKey() (*JWTKey, error) {
+	if e.ID == "" {
+		return nil, fmt.Errorf("key without kid")
+	}
+	key := &JWTKey{ID: e.ID, Algorithm: e.Algorithm, CreatedAt: e.CreatedAt}
+	if e.RetiredAt != nil {
+		key.RetiredAt = *e.RetiredAt
+	}
+
+	switch e.Algorithm {
+	case JWTAlgHS256:
+		secret, err := base64.StdEncoding.DecodeString(e.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret for key %s: %w", e.ID, err)
+		}
+		if len(secret) < minJWTSecretLength {
+			return nil, fmt.Errorf("secret for key %s is shorter than %d bytes", e.ID, minJWTSecretLength)
+		}
+		key.secret = secret
+	case JWTAlgRS256, JWTAlgEdDSA:
+		block, _ := pem.Decode([]byte(e.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("invalid private key PEM for key %s", e.ID)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key for key %s: %w", e.ID, err)
+		}
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			if e.Algorithm != JWTAlgRS256 {
+				return nil, fmt.Errorf("key %s is an RSA key but alg is %s", e.ID, e.Algorithm)
+			}
+			key.private = private
+		case ed25519.PrivateKey:
+			if e.Algorithm != JWTAlgEdDSA {
+				return nil, fmt.Errorf("key %s is an Ed25519 key but alg is %s", e.ID, e.Algorithm)
+			}
+			key.private = private
+		default:
+			return nil, fmt.Errorf("unsupported private key type for key %s", e.ID)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q for key %s", e.Algorithm, e.ID)
+	}
+
+	return key, nil
+}
+
+// JWTKeyConfig holds JWT key settings
+type JWTKeyConfig struct {
+	// KeysFile stores the key ring; keys can only be rotated when it is set
+	KeysFile string
+	// Secret is a single HS256 key used when KeysFile is not set
+	Secret string
+	// Algorithm is used for keys generated in KeysFile
+	Algorithm string
+	// RotationWindow is how long a retired key is still accepted. It should be
+	// at least the token lifetime so rotation does not log anyone out.
+	RotationWindow time.Duration
+}
+
+// LoadJWTKeyConfig loads JWT key settings from environment variables
+func LoadJWTKeyConfig() JWTKeyConfig {
+	return JWTKeyConfig{
+		KeysFile:       os.Getenv("JWT_KEYS_FILE"),
+		Secret:         os.Getenv("JWT_SECRET"),
+		Algorithm:      getEnvWithDefault("JWT_SIGNING_ALG", JWTAlgHS256),
+		RotationWindow: getEnvDurationWithDefault("JWT_KEY_ROTATION_WINDOW", getEnvDurationWithDefault("JWT_EXPIRATION", 24*time.Hour)),
+	}
+}
+
+// JWTKeyRing holds the keys tokens are signed and verified with. The newest
+// unretired key signs; every key still inside the rotation window verifies.
+type JWTKeyRing struct {
+	mu      sync.RWMutex
+	keys    []*JWTKey
+	config  JWTKeyConfig
+	modTime time.Time
+}
+
+// NewJWTKeyRing loads keys from the key file, creating it with a fresh key if
+// it does not exist yet, or else uses JWT_SECRET. Without either it generates a
+// temporary key, so tokens do not survive a restart.
+func NewJWTKeyRing(config JWTKeyConfig) (*JWTKeyRing, error) {
+	ring := &JWTKeyRing{config: config}
+
+	if config.KeysFile != "" {
+		if _, err := os.Stat(config.KeysFile); errors.Is(err, os.ErrNotExist) {
+			if _, rotateErr := ring.Rotate(config.Algorithm); rotateErr != nil {
+				return nil, rotateErr
+			}
+			LogInfo("JWT key file created", logrus.Fields{"path": config.KeysFile})
+			return ring, nil
+		}
+		if err := ring.load(); err != nil {
+			return nil, err
+		}
+		return ring, nil
+	}
+
+	if config.Secret != "" {
+		key, err := secretJWTKey(config.Secret)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys = []*JWTKey{key}
+		return ring, nil
+	}
+
+	key, err := newJWTKey(JWTAlgHS256)
+	if err != nil {
+		return nil, err
+	}
+	ring.keys = []*JWTKey{key}
+	LogWarn("JWT_SECRET and JWT_KEYS_FILE are not set, using a temporary signing key", logrus.Fields{
+		"kid": key.ID,
+	})
+	return ring, nil
+}
+
+// readKeyFile parses the key file
+func (r *JWTKeyRing) readKeyFile() ([]*JWTKey, time.Time, error) {
+	info, err := os.Stat(r.config.KeysFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to stat JWT key file: %w", err)
+	}
+	data, err := os.ReadFile(r.config.KeysFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read JWT key file: %w", err)
+	}
+
+	var file jwtKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse JWT key file: %w", err)
+	}
+
+	keys := make([]*JWTKey, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		key, err := entry.toKey()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid JWT key file: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, info.ModTime(), nil
+}
+
+// load replaces the ring's keys with those in the key file
+func (r *JWTKeyRing) load() error {
+	keys, modTime, err := r.readKeyFile()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// writeKeyFile stores keys atomically, readable only by the owner
+func (r *JWTKeyRing) writeKeyFile(keys []*JWTKey) error {
+	file := jwtKeyFile{Keys: make([]jwtKeyFileEntry, 0, len(keys))}
+	for _, key := range keys {
+		entry, err := key.toFileEntry()
+		if err != nil {
+			return err
+		}
+		file.Keys = append(file.Keys, entry)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JWT key file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.config.KeysFile), ".jwt-keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.config.KeysFile); err != nil {
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	return nil
+}
+
+// Reload picks up keys rotated by another process. It only reads the file when
+// its modification time changed and reports whether the keys were reloaded.
+func (r *JWTKeyRing) Reload() (bool, error) {
+	if r.config.KeysFile == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(r.config.KeysFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat JWT key file: %w", err)
+	}
+	r.mu.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	if err := r.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rotate generates a new signing key for alg and retires the current one. The
+// retired key keeps verifying tokens for the rotation window; keys retired
+// longer than that are dropped from the file.
+func (r *JWTKeyRing) Rotate(alg string) (*JWTKey, error) {
+	if r.config.KeysFile == "" {
+		return nil, ErrJWTKeysNotRotatable
+	}
+	if alg == "" {
+		alg = r.config.Algorithm
+	}
+
+	var keys []*JWTKey
+	if _, err := os.Stat(r.config.KeysFile); err == nil {
+		keys, _, err = r.readKeyFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := newJWTKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	kept := []*JWTKey{key}
+	for _, existing := range keys {
+		if existing.RetiredAt.IsZero() {
+			existing.RetiredAt = now
+		}
+		if existing.acceptedAt(now, r.config.RotationWindow) {
+			kept = append(kept, existing)
+		}
+	}
+
+	if err := r.writeKeyFile(kept); err != nil {
+		return nil, err
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	LogSecurity("jwt_key_rotated", logrus.Fields{
+		"kid":          key.ID,
+		"alg":          key.Algorithm,
+		"retired_keys": len(kept) - 1,
+	})
+	return key, nil
+}
+
+// Current returns the key new tokens are signed with
+func (r *JWTKeyRing) Current() (*JWTKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var current *JWTKey
+	for _, key := range r.keys {
+		if key.RetiredAt.IsZero() && (current == nil || key.CreatedAt.After(current.CreatedAt)) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, ErrNoJWTSigningKey
+	}
+	return current, nil
+}
+
+// Lookup returns the key with the given kid if tokens signed with it are still
+// accepted. An unknown kid triggers a reload in case the keys were just rotated.
+func (r *JWTKeyRing) Lookup(kid string) (*JWTKey, bool) {
+	if key, ok := r.lookup(kid); ok {
+		return key, true
+	}
+	if reloaded, err := r.Reload(); err != nil || !reloaded {
+		return nil, false
+	}
+	return r.lookup(kid)
+}
+
+func (r *JWTKeyRing) lookup(kid string) (*JWTKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range r.keys {
+		if key.ID == kid && key.acceptedAt(now, r.config.RotationWindow) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Keys returns every key still accepted, newest first
+func (r *JWTKeyRing) Keys() []*JWTKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*JWTKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		if key.acceptedAt(now, r.config.RotationWindow) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys
+}
+
+// RunReloader reloads the key file every interval until ctx is cancelled, so
+// keys rotated from the command line are picked up without a restart
+func (r *JWTKeyRing) RunReloader(ctx context.Context, interval time.Duration) {
+	if r.config.KeysFile == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				LogError("Failed to reload JWT keys", logrus.Fields{"path": r.config.KeysFile}, err)
+			} else if reloaded {
+				LogInfo("JWT keys reloaded", logrus.Fields{"path": r.config.KeysFile})
+			}
+		}
+	}
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// PublicJWKs returns the public halves of the accepted asymmetric keys.
+// HS256 keys are secret and never published.
+func (r *JWTKeyRing) PublicJWKs() []JWK {
+	jwks := []JWK{}
+	for _, key := range r.Keys() {
+		switch public := key.verificationKey().(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return jwks
+}
+
+// JWKSHandler handles GET /.well-known/jwks.json
+func JWKSHandler(ring *JWTKeyRing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": ring.PublicJWKs()})
+	}
+}
+
+// RunRotateJWTKeyCommand implements "rotate-jwt-key [alg]": it adds a new
+// signing key to JWT_KEYS_FILE and retires the current one. Running servers
+// pick the new key up on their next reload.
+func RunRotateJWTKeyCommand(args []string) error {
+	config := LoadJWTKeyConfig()
+	if config.KeysFile == "" {
+		return ErrJWTKeysNotRotatable
+	}
+
+	ring := &JWTKeyRing{config: config}
+	alg := ""
+	if len(args) > 0 {
+		alg = strings.TrimSpace(args[0])
+	}
+	key, err := ring.Rotate(alg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated JWT signing key: kid=%s alg=%s\n", key.ID, key.Algorithm)
+	fmt.Printf("Previous keys stay valid for %s\n", config.RotationWindow)
+	return nil
+}
@@ -115,6 +115,17 @@ func LogDebug(message string, fields logrus.Fields) {
 	}
 }
 
+// LogSecurity records a security event (rejected webhooks, lockouts, ...) so they
+// can be filtered by the log_type field
+func LogSecurity(event string, fields logrus.Fields) {
+	if logger != nil {
+		logger.WithFields(fields).WithFields(logrus.Fields{
+			"log_type":       "security",
+			"security_event": event,
+		}).Warn("Security event: " + event)
+	}
+}
+
 // GenerateRequestID generates a unique request ID
 func GenerateRequestID() string {
 	return fmt.Sprintf("%d_%s", time.Now().UnixNano(), randomString(8))
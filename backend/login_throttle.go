@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Outcomes stored in admin_login_attempts.outcome
+const (
+	LoginOutcomeSuccess  = "success"
+	LoginOutcomeFailure  = "failure"
+	LoginOutcomeUnlocked = "unlocked"
+)
+
+// Reasons a login attempt is refused before the password is checked
+const (
+	LoginBlockThrottled = "throttled"
+	LoginBlockLocked    = "locked"
+)
+
+// LoginBlockedError is returned when a login attempt arrives too soon after
+// earlier failures or while the username or client IP is locked out
+type LoginBlockedError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("login %s, retry after %s", e.Reason, e.RetryAfter)
+}
+
+// LoginThrottle tracks admin login attempts per username and per client IP.
+// Each failure doubles the wait before the username may try again; after
+// maxFailures within the window the username is locked out, and after
+// ipMaxFailures the client IP is. Unknown usernames are tracked exactly like
+// existing ones so responses do not reveal which accounts exist.
+type LoginThrottle struct {
+	db            *sql.DB
+	maxFailures   int
+	ipMaxFailures int
+	window        time.Duration
+	lockout       time.Duration
+	baseDelay     time.Duration
+	maxDelay      time.Duration
+	// retention is how long attempts are kept for review before cleanup deletes them
+	retention time.Duration
+}
+
+// LoginLockout is a username or client IP that is currently locked out
+type LoginLockout struct {
+	Username    string    `json:"username,omitempty"`
+	ClientIP    string    `json:"clientIp,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// loginFailureState is the failure count of a username or IP since it was last reset
+type loginFailureState struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// NewLoginThrottle creates a LoginThrottle configured from the environment
+func NewLoginThrottle(db *sql.DB) *LoginThrottle {
+	return &LoginThrottle{
+		db:            db,
+		maxFailures:   getEnvIntWithDefault("ADMIN_LOGIN_MAX_FAILURES", 5),
+		ipMaxFailures: getEnvIntWithDefault("ADMIN_LOGIN_IP_MAX_FAILURES", 20),
+		window:        getEnvDurationWithDefault("ADMIN_LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		lockout:       getEnvDurationWithDefault("ADMIN_LOGIN_LOCKOUT", 15*time.Minute),
+		baseDelay:     getEnvDurationWithDefault("ADMIN_LOGIN_BASE_DELAY", time.Second),
+		maxDelay:      getEnvDurationWithDefault("ADMIN_LOGIN_MAX_DELAY", 30*time.Second),
+		retention:     getEnvDurationWithDefault("ADMIN_LOGIN_ATTEMPT_RETENTION", 30*24*time.Hour),
+	}
+}
+
+// normalizeLoginUsername lowercases a username so case variants share one counter
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// failureDelay is how long to wait after the given number of consecutive failures
+func (t *LoginThrottle) failureDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := float64(t.baseDelay) * math.Pow(2, float64(failures-1))
+	if delay > float64(t.maxDelay) {
+		return t.maxDelay
+	}
+	return time.Duration(delay)
+}
+
+// failureState counts the failures for a username or client IP inside the
+// window and after the last reset. A success resets a username; only an
+// admin unlock resets an IP, so one valid account cannot clear an attacker's IP.
+func (t *LoginThrottle) failureState(q dbExecutor, column, value string, resets ...string) (*loginFailureState, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), MAX(created_at)
+		FROM admin_login_attempts
+		WHERE %[1]s = $1 AND outcome = $2 AND created_at > $3
+		AND created_at > COALESCE((
+			SELECT MAX(created_at) FROM admin_login_attempts
+			WHERE %[1]s = $1 AND outcome = ANY($4)
+		), '-infinity')
+	`, column)
+
+	var state loginFailureState
+	var lastFailure sql.NullTime
+	err := q.QueryRow(query, value, LoginOutcomeFailure, time.Now().Add(-t.window), pq.Array(resets)).
+		Scan(&state.failures, &lastFailure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count login failures: %w", err)
+	}
+	if lastFailure.Valid {
+		state.lastFailure = lastFailure.Time
+	}
+	return &state, nil
+}
+
+// checkBlocked returns a *LoginBlockedError when username or clientIP may not attempt a login now
+func (t *LoginThrottle) checkBlocked(q dbExecutor, username, clientIP string) error {
+	now := time.Now()
+
+	ipState, err := t.failureState(q, "client_ip", clientIP, LoginOutcomeUnlocked)
+	if err != nil {
+		return err
+	}
+	if ipState.failures >= t.ipMaxFailures {
+		if until := ipState.lastFailure.Add(t.lockout); now.Before(until) {
+			return &LoginBlockedError{Reason: LoginBlockLocked, RetryAfter: until.Sub(now)}
+		}
+	}
+
+	userState, err := t.failureState(q, "username", username, LoginOutcomeSuccess, LoginOutcomeUnlocked)
+	if err != nil {
+		return err
+	}
+	if userState.failures >= t.maxFailures {
+		if until := userState.lastFailure.Add(t.lockout); now.Before(until) {
+			return &LoginBlockedError{Reason: LoginBlockLocked, RetryAfter: until.Sub(now)}
+		}
+	} else if until := userState.lastFailure.Add(t.failureDelay(userState.failures)); now.Before(until) {
+		return &LoginBlockedError{Reason: LoginBlockThrottled, RetryAfter: until.Sub(now)}
+	}
+
+	return nil
+}
+
+// LoginAttempt is a login attempt that Begin has counted as a failure until
+// it is recorded as a success or released
+type LoginAttempt struct {
+	id       string
+	username string
+	clientIP string
+}
+
+// Begin returns a *LoginBlockedError when username or clientIP may not
+// attempt a login now. Otherwise it counts the attempt as a failure in the
+// same transaction, holding a lock on the IP and the username, so parallel
+// guesses each see the ones before them and cannot get past the limit.
+func (t *LoginThrottle) Begin(username, clientIP string) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{username: normalizeLoginUsername(username), clientIP: clientIP}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback login attempt transaction", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	// The IP lock is always taken first so two attempts cannot deadlock
+	for _, key := range []string{"admin_login_ip:" + attempt.clientIP, "admin_login_username:" + attempt.username} {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+			return nil, fmt.Errorf("failed to lock login attempts: %w", err)
+		}
+	}
+
+	if err := t.checkBlocked(tx, attempt.username, attempt.clientIP); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO admin_login_attempts (username, client_ip, outcome) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRow(query, attempt.username, attempt.clientIP, LoginOutcomeFailure).Scan(&attempt.id); err != nil {
+		return nil, fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit login attempt: %w", err)
+	}
+	return attempt, nil
+}
+
+// recordAttempt stores one login attempt outcome
+func (t *LoginThrottle) recordAttempt(username, clientIP, outcome string) error {
+	var user, ip *string
+	if username != "" {
+		normalized := normalizeLoginUsername(username)
+		user = &normalized
+	}
+	if clientIP != "" {
+		ip = &clientIP
+	}
+
+	query := `INSERT INTO admin_login_attempts (username, client_ip, outcome) VALUES ($1, $2, $3)`
+	if _, err := t.db.Exec(query, user, ip, outcome); err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// RecordSuccess marks attempt as a success, resetting the failure count of its username
+func (t *LoginThrottle) RecordSuccess(attempt *LoginAttempt) error {
+	query := `UPDATE admin_login_attempts SET outcome = $1 WHERE id = $2`
+	if _, err := t.db.Exec(query, LoginOutcomeSuccess, attempt.id); err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// Release forgets an attempt that ended neither in a success nor in a wrong
+// password or code, such as one that moved on to the two-factor step
+func (t *LoginThrottle) Release(attempt *LoginAttempt) error {
+	query := `DELETE FROM admin_login_attempts WHERE id = $1`
+	if _, err := t.db.Exec(query, attempt.id); err != nil {
+		return fmt.Errorf("failed to release login attempt: %w", err)
+	}
+	return nil
+}
+
+// RecordFailure keeps attempt counted as a failure and writes a security
+// event when it locks out the username or the client IP
+func (t *LoginThrottle) RecordFailure(attempt *LoginAttempt) error {
+	// Attempts are refused while locked, so every failure at the limit starts a new lockout
+	userState, err := t.failureState(t.db, "username", attempt.username, LoginOutcomeSuccess, LoginOutcomeUnlocked)
+	if err != nil {
+		return err
+	}
+	if userState.failures >= t.maxFailures {
+		LogSecurity("admin_login_locked", logrus.Fields{
+			"username":     attempt.username,
+			"client_ip":    attempt.clientIP,
+			"failures":     userState.failures,
+			"locked_until": userState.lastFailure.Add(t.lockout),
+		})
+	}
+
+	ipState, err := t.failureState(t.db, "client_ip", attempt.clientIP, LoginOutcomeUnlocked)
+	if err != nil {
+		return err
+	}
+	if ipState.failures >= t.ipMaxFailures {
+		LogSecurity("admin_login_ip_locked", logrus.Fields{
+			"username":     attempt.username,
+			"client_ip":    attempt.clientIP,
+			"failures":     ipState.failures,
+			"locked_until": ipState.lastFailure.Add(t.lockout),
+		})
+	}
+	return nil
+}
+
+// Unlock clears the failures of a username or a client IP
+func (t *LoginThrottle) Unlock(username, clientIP, unlockedBy string) error {
+	if username != "" {
+		if err := t.recordAttempt(username, "", LoginOutcomeUnlocked); err != nil {
+			return err
+		}
+	}
+	if clientIP != "" {
+		if err := t.recordAttempt("", clientIP, LoginOutcomeUnlocked); err != nil {
+			return err
+		}
+	}
+
+	LogSecurity("admin_login_unlocked", logrus.Fields{
+		"username":    normalizeLoginUsername(username),
+		"client_ip":   clientIP,
+		"unlocked_by": unlockedBy,
+	})
+	return nil
+}
+
+// Cleanup deletes attempts older than the retention period. Attempts still
+// inside the failure window are always kept.
+func (t *LoginThrottle) Cleanup() error {
+	retention := t.retention
+	if retention < t.window {
+		retention = t.window
+	}
+
+	query := `DELETE FROM admin_login_attempts WHERE created_at < $1`
+	if _, err := t.db.Exec(query, time.Now().Add(-retention)); err != nil {
+		return fmt.Errorf("failed to delete old login attempts: %w", err)
+	}
+	return nil
+}
+
+// lockouts lists the values of column that are locked out now
+func (t *LoginThrottle) lockouts(column string, maxFailures int, resets ...string) ([]LoginLockout, error) {
+	query := fmt.Sprintf(`
+		SELECT a.%[1]s, COUNT(*), MAX(a.created_at)
+		FROM admin_login_attempts a
+		WHERE a.%[1]s IS NOT NULL AND a.outcome = $1 AND a.created_at > $2
+		AND a.created_at > COALESCE((
+			SELECT MAX(r.created_at) FROM admin_login_attempts r
+			WHERE r.%[1]s = a.%[1]s AND r.outcome = ANY($3)
+		), '-infinity')
+		GROUP BY a.%[1]s
+		HAVING COUNT(*) >= $4 AND MAX(a.created_at) > $5
+		ORDER BY MAX(a.created_at) DESC
+	`, column)
+
+	now := time.Now()
+	rows, err := t.db.Query(query, LoginOutcomeFailure, now.Add(-t.window), pq.Array(resets), maxFailures, now.Add(-t.lockout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	var lockouts []LoginLockout
+	for rows.Next() {
+		var value string
+		var lockout LoginLockout
+		var lastFailure time.Time
+		if err := rows.Scan(&value, &lockout.Failures, &lastFailure); err != nil {
+			return nil, fmt.Errorf("failed to scan login lockout: %w", err)
+		}
+		if column == "username" {
+			lockout.Username = value
+		} else {
+			lockout.ClientIP = value
+		}
+		lockout.LockedUntil = lastFailure.Add(t.lockout)
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, rows.Err()
+}
+
+// Lockouts lists the usernames and client IPs that are locked out now
+func (t *LoginThrottle) Lockouts() ([]LoginLockout, error) {
+	users, err := t.lockouts("username", t.maxFailures, LoginOutcomeSuccess, LoginOutcomeUnlocked)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := t.lockouts("client_ip", t.ipMaxFailures, LoginOutcomeUnlocked)
+	if err != nil {
+		return nil, err
+	}
+	return append(users, ips...), nil
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for the Retry-After header
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// ListLoginLockouts handles GET /api/admin/login-lockouts
+func ListLoginLockouts(throttle *LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lockouts, err := throttle.Lockouts()
+		if err != nil {
+			LogError("Failed to list login lockouts", logrus.Fields{
+				"error": err.Error(),
+			}, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to list login lockouts",
+			})
+			return
+		}
+		if lockouts == nil {
+			lockouts = []LoginLockout{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    lockouts,
+		})
+	}
+}
+
+// UnlockLogin handles POST /api/admin/login-lockouts/unlock
+func UnlockLogin(throttle *LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username"`
+			ClientIP string `json:"clientIp"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil || (body.Username == "" && body.ClientIP == "") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "username or clientIp is required",
+			})
+			return
+		}
+
+		if err := throttle.Unlock(body.Username, body.ClientIP, c.GetString("username")); err != nil {
+			LogError("Failed to unlock login", logrus.Fields{
+				"username":  body.Username,
+				"client_ip": body.ClientIP,
+				"error":     err.Error(),
+			}, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to unlock login",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Login unlocked",
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EmailMessage is a plain text email
+type EmailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. SMTPMailer delivers through a mail server; FileMailer
+// writes messages to a directory for local development.
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// NewMailerFromEnv creates the mailer selected by MAIL_DRIVER ("smtp" or "file")
+func NewMailerFromEnv() (Mailer, error) {
+	from := getEnvWithDefault("MAIL_FROM", "Urgent Studio <no-reply@localhost>")
+
+	switch driver := getEnvWithDefault("MAIL_DRIVER", "file"); driver {
+	case "smtp":
+		host := getEnvWithDefault("SMTP_HOST", "")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required when MAIL_DRIVER=smtp")
+		}
+		return &SMTPMailer{
+			host:     host,
+			port:     getEnvIntWithDefault("SMTP_PORT", 587),
+			username: getEnvWithDefault("SMTP_USERNAME", ""),
+			password: getEnvWithDefault("SMTP_PASSWORD", ""),
+			from:     from,
+		}, nil
+	case "file":
+		dir := getEnvWithDefault("MAIL_FILE_DIR", "mail-outbox")
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %w", err)
+		}
+		return &FileMailer{dir: dir, from: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// formatEmail renders msg as an RFC 5322 message
+func formatEmail(from string, msg EmailMessage) []byte {
+	var buf bytes.Buffer
+	// Header values come from our own templates and the recipient address;
+	// strip line breaks so neither can inject headers
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	fmt.Fprintf(&buf, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// SMTPMailer sends email through an SMTP server, using STARTTLS when offered
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+// Send delivers msg
+func (m *SMTPMailer) Send(msg EmailMessage) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	sender := m.from
+	if start := strings.LastIndex(sender, "<"); start >= 0 {
+		sender = strings.TrimSuffix(sender[start+1:], ">")
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.host, m.port)
+	if err := smtp.SendMail(addr, auth, sender, []string{msg.To}, formatEmail(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// FileMailer writes each message to a .eml file instead of sending it
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// Send writes msg to the mail directory
+func (m *FileMailer) Send(msg EmailMessage) error {
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405.000000000"), randomString(6))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, formatEmail(m.from, msg), 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	LogInfo("Email written to file", logrus.Fields{
+		"path":    path,
+		"subject": msg.Subject,
+	})
+	return nil
+}
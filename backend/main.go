@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,110 +17,208 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func StartServer() {
-	// Initialize logger first
-	InitLogger()
-	
-	LogInfo("Starting Urgent Studio Backend Server", logrus.Fields{
-		"version": "1.0.0",
-		"port":    "8080",
-	})
-
-	// Load environment variables
+// loadEnvFile loads .env when there is one; the environment wins otherwise
+func loadEnvFile() {
 	if err := godotenv.Load(); err != nil {
 		LogWarn("No .env file found, using environment variables", logrus.Fields{})
 	}
+}
 
-	// Initialize database connection
-	dbHost := os.Getenv("DB_HOST")
-	if dbHost == "" {
-		dbHost = "localhost"
-	}
-	dbPort := os.Getenv("DB_PORT")
-	if dbPort == "" {
-		dbPort = "5432"
-	}
-	dbUser := os.Getenv("DB_USER")
-	if dbUser == "" {
-		dbUser = "postgres"
-	}
-	dbPassword := os.Getenv("DB_PASSWORD")
-	if dbPassword == "" {
-		dbPassword = "password"
-	}
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "urgent_studio"
-	}
+// connectDatabase opens and pings the database named by the DB_* variables.
+// The server and the CLI commands share it.
+func connectDatabase() (*sql.DB, error) {
+	dbHost := getEnvWithDefault("DB_HOST", "localhost")
+	dbPort := getEnvWithDefault("DB_PORT", "5432")
+	dbUser := getEnvWithDefault("DB_USER", "postgres")
+	dbPassword := getEnvWithDefault("DB_PASSWORD", "password")
+	dbName := getEnvWithDefault("DB_NAME", "urgent_studio")
 
 	// Create database connection string
 	dbConnStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
 
-	// Connect to database
-	var err error
 	dbConn, err := sql.Open("postgres", dbConnStr)
 	if err != nil {
 		LogError("Failed to connect to database", logrus.Fields{
 			"error": err.Error(),
 		}, err)
-		panic(err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	defer dbConn.Close()
 
 	// Test database connection
 	if pingErr := dbConn.Ping(); pingErr != nil {
 		LogError("Failed to ping database", logrus.Fields{
 			"error": pingErr.Error(),
 		}, pingErr)
-		panic(pingErr)
+		dbConn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", pingErr)
 	}
 
 	LogInfo("Database connected successfully", logrus.Fields{
-		"host": dbHost,
-		"port": dbPort,
+		"host":     dbHost,
+		"port":     dbPort,
 		"database": dbName,
 	})
+	return dbConn, nil
+}
+
+// trustedProxies returns the proxies named by TRUSTED_PROXIES (comma separated
+// IPs or CIDRs). Only they may set X-Forwarded-For; by default no one may, so
+// c.ClientIP() is the address the connection came from.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// allowedOrigins returns the browser origins named by ALLOWED_ORIGINS (comma
+// separated) that may call the API and open the WebSocket, defaulting to the
+// local frontends
+func allowedOrigins() []string {
+	value := getEnvWithDefault("ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:9005,http://localhost:9006")
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func StartServer() {
+	// Initialize logger first
+	InitLogger()
+	
+	LogInfo("Starting Urgent Studio Backend Server", logrus.Fields{
+		"version": "1.0.0",
+		"port":    "8080",
+	})
+
+	loadEnvFile()
+
+	dbConn, err := connectDatabase()
+	if err != nil {
+		panic(err)
+	}
+	defer dbConn.Close()
+
+	// JWT signing keys come from JWT_KEYS_FILE or JWT_SECRET
+	jwtKeys, err := NewJWTKeyRing(LoadJWTKeyConfig())
+	if err != nil {
+		LogError("Failed to load JWT signing keys", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		panic(err)
+	}
+	go jwtKeys.RunReloader(context.Background(), getEnvDurationWithDefault("JWT_KEYS_RELOAD_INTERVAL", 30*time.Second))
+
+	// Password policy and hashing shared by admin users and customers
+	passwords, err := NewPasswordService()
+	if err != nil {
+		LogError("Failed to configure password policy", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		panic(err)
+	}
 
 	// Initialize repositories and handlers
-	authService := NewAuthService(dbConn)
+	authService := NewAuthService(dbConn, jwtKeys, passwords)
+	loginThrottle := NewLoginThrottle(dbConn)
+	adminMFAHandler := NewAdminMFAHandler(authService, loginThrottle)
+	adminSessionHandler := NewAdminSessionHandler(authService)
+	adminPanel := NewAdminPanel(authService, loginThrottle)
 	orderRepo := NewOrderRepository(dbConn)
-	orderHandler := NewOrderHandler(orderRepo)
+
+	// Customer accounts; MAIL_DRIVER selects how their emails are sent
+	mailer, err := NewMailerFromEnv()
+	if err != nil {
+		LogError("Failed to configure mailer", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		panic(err)
+	}
+	customerAuth := NewCustomerAuthService(dbConn, jwtKeys, mailer, passwords)
+	customerAuthHandler := NewCustomerAuthHandler(customerAuth, orderRepo)
+	portalService := NewPortalService(dbConn, jwtKeys, mailer, orderRepo)
+	portalHandler := NewPortalHandler(portalService)
+	apiKeyStore := NewAPIKeyStore(dbConn)
+	apiKeyHandler := NewAPIKeyHandler(apiKeyStore)
+	go RunTokenCleanup(context.Background(), getEnvDurationWithDefault("ADMIN_TOKEN_CLEANUP_INTERVAL", time.Hour), authService, customerAuth, portalService, loginThrottle)
+
+	// Mayar is optional: without it orders fall back to the other payment gateways
+	mayarService, mayarErr := NewMayarService()
+	if mayarErr != nil {
+		LogWarn("Mayar service unavailable, Mayar payments disabled", logrus.Fields{
+			"error": mayarErr.Error(),
+		})
+	}
+	paymentGateways := NewPaymentGatewaysFromEnv(mayarService, orderRepo)
+	paymentService := NewPaymentService(paymentGateways, orderRepo)
+	orderHandler := NewOrderHandler(orderRepo, paymentService)
 	productRepo := NewProductRepository(dbConn)
 	serviceRepo := NewServiceItemRepository(dbConn)
-	productHandler := NewProductHandler(productRepo)
-	dashboardHandler := NewDashboardHandler(serviceRepo)
+	catalogSync := NewCatalogSyncService(mayarService, productRepo, NewProductMappingRepository(dbConn))
+	productHandler := NewProductHandler(productRepo, catalogSync)
+	dashboardHandler := NewDashboardHandler(serviceRepo, orderRepo)
 	realDashboardHandler := NewRealDashboardHandler(dbConn)
-	userRepo := NewUserRepository(dbConn)
-	userHandler := NewUserHandler(userRepo)
+	userRepo := NewUserRepository(dbConn, passwords)
+	userHandler := NewUserHandler(userRepo, customerAuth)
+	go RunSoftDeletePurge(context.Background(), getEnvDurationWithDefault("SOFT_DELETE_PURGE_INTERVAL", 24*time.Hour),
+		getEnvDurationWithDefault("SOFT_DELETE_RETENTION", defaultSoftDeleteRetention), userRepo, productRepo)
+	auditLog := NewAuditLog(dbConn)
+	auditHandler := NewAuditHandler(auditLog)
+	privacyHandler := NewPrivacyHandler(NewPrivacyService(dbConn))
 
 	// Start WebSocket hub
 	StartWebSocketHub()
 
 	r := gin.Default()
 
-	// Add logging middleware
+	// API key allowlists and login throttling key on c.ClientIP(), so a client
+	// must not be able to choose it with X-Forwarded-For
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		LogError("Invalid TRUSTED_PROXIES", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		panic(err)
+	}
+
+	// Add request ID and logging middleware
+	r.Use(RequestID())
 	r.Use(GinLogger())
 	r.Use(GinRecovery())
 
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:9005", "http://localhost:9006"},
+		AllowOrigins:     allowedOrigins(),
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Every route is checked against routePolicies; this must come before the routes
+	r.Use(AuthorizeRoutes(authService, customerAuth, portalService, apiKeyStore, adminPanel))
+	// Successful admin writes are recorded in the audit log; after AuthorizeRoutes so the actor is known
+	r.Use(AuditTrail(auditLog))
+
 	// Admin panel routes
 	r.GET("/admin", func(c *gin.Context) {
-		adminDashboardHandler(c.Writer, c.Request)
-	})
-	r.GET("/admin/login", func(c *gin.Context) {
-		adminLoginHandler(c.Writer, c.Request)
+		adminDashboardHandler(c.Writer, c.GetString(csrfContextKey))
 	})
+	r.GET("/admin/login", adminPanel.LoginPage)
 	r.POST("/admin/login", func(c *gin.Context) {
+		// The panel's login form gets session cookies; API clients get tokens as JSON
+		if isPanelForm(c) {
+			adminPanel.Login(c)
+			return
+		}
+
 		// Parse login credentials
 		var credentials struct {
 			Username string `json:"username"`
@@ -132,9 +233,54 @@ func StartServer() {
 			return
 		}
 
+		// Refuse attempts that come too soon after earlier failures, before checking the password
+		clientIP := c.ClientIP()
+		attempt, throttleErr := loginThrottle.Begin(credentials.Username, clientIP)
+		if throttleErr != nil {
+			var blocked *LoginBlockedError
+			if errors.As(throttleErr, &blocked) {
+				LogSecurity("admin_login_blocked", logrus.Fields{
+					"username":    credentials.Username,
+					"client_ip":   clientIP,
+					"reason":      blocked.Reason,
+					"retry_after": blocked.RetryAfter.String(),
+				})
+				c.Header("Retry-After", retryAfterSeconds(blocked.RetryAfter))
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"success": false,
+					"message": "Too many login attempts, try again later",
+				})
+				return
+			}
+			LogError("Failed to check login attempts", logrus.Fields{
+				"error": throttleErr.Error(),
+			}, throttleErr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Login is temporarily unavailable",
+			})
+			return
+		}
+
 		// Authenticate user with JWT
-		token, authErr := authService.Authenticate(credentials.Username, credentials.Password)
+		result, authErr := authService.Authenticate(credentials.Username, credentials.Password, sessionClientFromRequest(c))
 		if authErr != nil {
+			if errors.Is(authErr, ErrInvalidCredentials) {
+				if recordErr := loginThrottle.RecordFailure(attempt); recordErr != nil {
+					LogError("Failed to record failed login", logrus.Fields{
+						"error": recordErr.Error(),
+					}, recordErr)
+				}
+			} else {
+				LogError("Failed to authenticate admin user", logrus.Fields{
+					"error": authErr.Error(),
+				}, authErr)
+				if releaseErr := loginThrottle.Release(attempt); releaseErr != nil {
+					LogError("Failed to release login attempt", logrus.Fields{
+						"error": releaseErr.Error(),
+					}, releaseErr)
+				}
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"message": "Invalid credentials",
@@ -142,14 +288,97 @@ func StartServer() {
 			return
 		}
 
-		// Return success with JWT token
+		// Two-factor users get a challenge to complete at POST /admin/login/totp;
+		// the login only counts as successful once that step passes
+		if result.Challenge != nil {
+			if releaseErr := loginThrottle.Release(attempt); releaseErr != nil {
+				LogError("Failed to release login attempt", logrus.Fields{
+					"error": releaseErr.Error(),
+				}, releaseErr)
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success":            true,
+				"message":            "Two-factor authentication required",
+				"mfaRequired":        true,
+				"challengeToken":     result.Challenge.Token,
+				"challengeExpiresAt": result.Challenge.ExpiresAt,
+				"enrollmentRequired": result.Challenge.EnrollmentRequired,
+			})
+			return
+		}
+		if recordErr := loginThrottle.RecordSuccess(attempt); recordErr != nil {
+			LogError("Failed to record successful login", logrus.Fields{
+				"error": recordErr.Error(),
+			}, recordErr)
+		}
+
+		// Return success with the access token and the refresh token that renews it
+		tokens := result.Tokens
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "Authentication successful",
-			"token":   token,
+			"success":          true,
+			"message":          "Authentication successful",
+			"token":            tokens.AccessToken,
+			"expiresAt":        tokens.ExpiresAt,
+			"refreshToken":     tokens.RefreshToken,
+			"refreshExpiresAt": tokens.RefreshExpiresAt,
+			"sessionExpiresAt": tokens.SessionExpiresAt,
+		})
+	})
+	r.POST("/admin/login/totp", func(c *gin.Context) {
+		if isPanelForm(c) {
+			adminPanel.LoginTOTP(c)
+			return
+		}
+		adminMFAHandler.LoginVerify(c)
+	})
+	r.POST("/admin/login/totp/enroll", adminMFAHandler.LoginEnroll)
+	r.POST("/admin/refresh", func(c *gin.Context) {
+		var body struct {
+			RefreshToken string `json:"refreshToken" binding:"required"`
+		}
+
+		if bindErr := c.ShouldBindJSON(&body); bindErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid request format",
+			})
+			return
+		}
+
+		// Exchange the single-use refresh token for a new pair
+		tokens, refreshErr := authService.Refresh(body.RefreshToken, sessionClientFromRequest(c))
+		if refreshErr != nil {
+			message := "Invalid refresh token"
+			if errors.Is(refreshErr, ErrSessionExpired) {
+				message = "Session expired"
+			} else if !errors.Is(refreshErr, ErrInvalidRefreshToken) && !errors.Is(refreshErr, ErrRefreshTokenReused) {
+				LogError("Failed to refresh admin session", logrus.Fields{
+					"error": refreshErr.Error(),
+				}, refreshErr)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": message,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":          true,
+			"token":            tokens.AccessToken,
+			"expiresAt":        tokens.ExpiresAt,
+			"refreshToken":     tokens.RefreshToken,
+			"refreshExpiresAt": tokens.RefreshExpiresAt,
+			"sessionExpiresAt": tokens.SessionExpiresAt,
 		})
 	})
 	r.POST("/admin/logout", func(c *gin.Context) {
+		// The panel's logout form ends the cookie session
+		if hasPanelSession(c) {
+			adminPanel.Logout(c)
+			return
+		}
+
 		// Get token from Authorization header
 		token := c.GetHeader("Authorization")
 		if token != "" && len(token) > 7 && token[:7] == "Bearer " {
@@ -167,8 +396,8 @@ func StartServer() {
 				return
 			}
 			
-			// Revoke the token
-			if err := authService.RevokeToken(claims.TokenID); err != nil {
+			// Revoke the token and its session's refresh tokens
+			if err := authService.EndSession(claims.TokenID); err != nil {
 				// Log the error but don't fail the logout
 				// The user should still be logged out even if token revocation fails
 				LogError("Failed to revoke token during logout", logrus.Fields{
@@ -184,6 +413,11 @@ func StartServer() {
 		})
 	})
 	
+	// The signed-in admin's sessions, one per device
+	r.GET("/admin/sessions", adminSessionHandler.List)
+	r.DELETE("/admin/sessions/:id", adminSessionHandler.Revoke)
+	r.POST("/admin/sessions/revoke-others", adminSessionHandler.RevokeOthers)
+
 	r.GET("/admin/logout", func(c *gin.Context) {
 		// For backward compatibility
 		c.Redirect(http.StatusFound, "/admin/login")
@@ -191,7 +425,7 @@ func StartServer() {
 	
 	// Admin users page
 	r.GET("/admin/users", func(c *gin.Context) {
-		adminUsersHandler(c.Writer, c.Request)
+		adminUsersHandler(c.Writer, c.GetString(csrfContextKey))
 	})
 
 	// API routes
@@ -199,7 +433,7 @@ func StartServer() {
 	{
 		// Health check endpoint
 		api.GET("/health", func(c *gin.Context) {
-			requestID := GenerateRequestID()
+			requestID := requestIDFromContext(c)
 			
 			LogInfo("Health check requested", logrus.Fields{
 				"request_id": requestID,
@@ -247,31 +481,71 @@ func StartServer() {
 			})
 		})
 
-		// Protected orders endpoints
+		// Gateways a checkout can choose from
+		api.GET("/payment-gateways", orderHandler.GetPaymentGateways)
+
+		// Orders endpoints
 		ordersGroup := api.Group("/orders")
-		ordersGroup.Use(AuthMiddleware(authService))
 		{
 			ordersGroup.GET("", orderHandler.GetOrders)
 			ordersGroup.GET("/:id", orderHandler.GetOrderByID)
 			ordersGroup.POST("", orderHandler.CreateOrder)
 			ordersGroup.PUT("/:id/status", orderHandler.UpdateOrderStatus)
+			ordersGroup.POST("/:id/payment-link", orderHandler.CreatePaymentLink)
+			ordersGroup.GET("/:id/payment", orderHandler.GetPaymentStatus)
+			ordersGroup.POST("/:id/payment/confirm", orderHandler.ConfirmPayment)
+			ordersGroup.POST("/:id/payment/cancel", orderHandler.CancelPayment)
+			ordersGroup.POST("/:id/payment/refund", orderHandler.RefundPayment)
+			ordersGroup.GET("/:id/payments", orderHandler.GetOrderPayments)
+			ordersGroup.GET("/:id/installments", orderHandler.GetInstallments)
+			ordersGroup.PUT("/:id/installments", orderHandler.SetPaymentSchedule)
+			ordersGroup.POST("/:id/installments/:sequence/payment-link", orderHandler.CreateInstallmentPaymentLink)
+			ordersGroup.POST("/:id/installments/:sequence/confirm", orderHandler.ConfirmInstallmentPayment)
 			ordersGroup.GET("/status/:status", orderHandler.GetOrdersByStatus)
 			ordersGroup.GET("/analytics", orderHandler.GetOrderAnalytics)
 		}
 
-		// Users endpoints (temporarily without auth for testing)
+		// Admin: the caller's permissions, every payment attempt with its gateway reference,
+		// login lockouts, two-factor settings and API keys
+		adminGroup := api.Group("/admin")
+		{
+			adminGroup.GET("/permissions", GetMyPermissions)
+			adminGroup.GET("/payments", orderHandler.ListPayments)
+			adminGroup.GET("/login-lockouts", ListLoginLockouts(loginThrottle))
+			adminGroup.POST("/login-lockouts/unlock", UnlockLogin(loginThrottle))
+			adminGroup.GET("/totp", adminMFAHandler.GetStatus)
+			adminGroup.POST("/totp/enroll", adminMFAHandler.StartEnrollment)
+			adminGroup.POST("/totp/confirm", adminMFAHandler.ConfirmEnrollment)
+			adminGroup.POST("/totp/recovery-codes", adminMFAHandler.RegenerateRecoveryCodes)
+			adminGroup.DELETE("/totp", adminMFAHandler.Disable)
+			adminGroup.POST("/admin-users/:id/totp/reset", adminMFAHandler.Reset)
+			adminGroup.GET("/api-keys", apiKeyHandler.List)
+			adminGroup.POST("/api-keys", apiKeyHandler.Create)
+			adminGroup.DELETE("/api-keys/:id", apiKeyHandler.Revoke)
+		}
+
+		// Users endpoints
 		usersGroup := api.Group("/users")
 		{
 			usersGroup.GET("", userHandler.GetUsers)
+			usersGroup.GET("/export", userHandler.ExportUsers)
+			usersGroup.POST("/import", userHandler.ImportUsers)
 			usersGroup.GET("/:id", userHandler.GetUser)
 			usersGroup.POST("", userHandler.CreateUser)
 			usersGroup.PUT("/:id", userHandler.UpdateUser)
 			usersGroup.DELETE("/:id", userHandler.DeleteUser)
 			usersGroup.PATCH("/:id/toggle-status", userHandler.ToggleUserStatus)
 			usersGroup.PATCH("/:id/change-password", userHandler.ChangeUserPassword)
+			usersGroup.POST("/:id/restore", userHandler.RestoreUser)
 		}
 	}
 
+	// Register customer account routes
+	customerAuthHandler.RegisterRoutes(r)
+	portalHandler.RegisterRoutes(r)
+	auditHandler.RegisterRoutes(r)
+	privacyHandler.RegisterRoutes(r)
+
 	// Register product routes
 	productHandler.RegisterRoutes(r)
 
@@ -280,15 +554,43 @@ func StartServer() {
 	RegisterRealDashboardRoutes(r, realDashboardHandler)
 
 	// Initialize Mayar.id integration
-	if err := InitializeMayarIntegration(r, orderRepo); err != nil {
+	if mayarService != nil {
+		InitializeMayarIntegration(r, mayarService, orderRepo, catalogSync)
+
+		// Periodic catalog sync; MAYAR_CATALOG_SYNC_INTERVAL=0 disables it
+		if interval := getEnvDurationWithDefault("MAYAR_CATALOG_SYNC_INTERVAL", 0); interval > 0 {
+			go catalogSync.RunScheduler(context.Background(), interval)
+		}
+	} else {
 		LogError("Failed to initialize Mayar integration", logrus.Fields{
-			"error": err.Error(),
-		}, err)
+			"error": mayarErr.Error(),
+		}, mayarErr)
 		// Continue running even if Mayar integration fails
 	}
 
-	// Register WebSocket route
-	r.GET("/ws", handleWebSocket)
+	// Register WebSocket route; admins only, from the allowed origins
+	r.GET("/ws", WebSocketHandler(allowedOrigins()))
+
+	// Public keys for services that verify our RS256/EdDSA tokens
+	r.GET("/.well-known/jwks.json", JWKSHandler(jwtKeys))
+
+	// Refuse to start with a route that has no access policy
+	if missing := UnprotectedRoutes(r.Routes()); len(missing) > 0 {
+		err := fmt.Errorf("routes without an access policy: %v", missing)
+		LogError("Route access policy is incomplete", logrus.Fields{
+			"routes": missing,
+		}, err)
+		panic(err)
+	}
+
+	// Refuse to start with an admin write route the audit log does not know
+	if missing := UnauditedRoutes(r.Routes()); len(missing) > 0 {
+		err := fmt.Errorf("admin routes without an audit action: %v", missing)
+		LogError("Audit actions are incomplete", logrus.Fields{
+			"routes": missing,
+		}, err)
+		panic(err)
+	}
 
 	LogInfo("All routes registered successfully", logrus.Fields{
 		"routes": []string{"/admin", "/api/health", "/api/ping", "/api/orders", "/api/users", "/api/products", "/api/mayar", "/ws"},
@@ -311,12 +613,13 @@ func StartServer() {
 func main() {
 	// Initialize logger first
 	InitLogger()
-	
-	LogInfo("Urgent Studio Backend starting...", logrus.Fields{
-		"version": "1.0.0",
-		"mode":    gin.Mode(),
-	})
-	
-	// Start the server
-	StartServer()
+
+	// Without a command the server starts, as before; see cliUsage for the rest
+	if err := RunCLI(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
 }
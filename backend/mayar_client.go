@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMayarCircuitOpen is returned while the circuit breaker rejects calls to Mayar
+var ErrMayarCircuitOpen = errors.New("mayar API circuit breaker is open")
+
+// =============================================================================
+// RATE LIMITER
+// =============================================================================
+
+// rateLimiter is a token bucket that refills evenly over one minute
+type rateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	perSec   float64
+	last     time.Time
+}
+
+// newRateLimiter creates a limiter allowing requestsPerMinute calls per minute
+func newRateLimiter(requestsPerMinute int) *rateLimiter {
+	capacity := float64(requestsPerMinute)
+	return &rateLimiter{
+		tokens:   capacity,
+		capacity: capacity,
+		perSec:   capacity / 60,
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or the context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.perSec
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.perSec * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// =============================================================================
+// CIRCUIT BREAKER
+// =============================================================================
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// circuitBreaker opens after consecutive failures and lets a single trial call
+// through once the open duration has passed
+type circuitBreaker struct {
+	mu               sync.Mutex
+	state            string
+	failures         int
+	openedAt         time.Time
+	trialInFlight    bool
+	failureThreshold int
+	openDuration     time.Duration
+}
+
+// newCircuitBreaker creates a closed circuit breaker
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		state:            circuitClosed,
+		failureThreshold: config.FailureThreshold,
+		openDuration:     config.OpenDuration,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.openDuration {
+			return ErrMayarCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		b.trialInFlight = true
+		return nil
+	case circuitHalfOpen:
+		if b.trialInFlight {
+			return ErrMayarCircuitOpen
+		}
+		b.trialInFlight = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// RecordSuccess closes the circuit
+func (b *circuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = circuitClosed
+	b.failures = 0
+	b.trialInFlight = false
+}
+
+// RecordFailure counts a failure and opens the circuit when the threshold is reached
+func (b *circuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trialInFlight = false
+	if b.state == circuitHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != circuitOpen {
+			LogWarn("Mayar API circuit breaker opened", logrus.Fields{
+				"consecutive_failures": b.failures,
+				"open_duration":        b.openDuration.String(),
+			})
+		}
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Abandon releases a half-open trial whose outcome is unknown
+func (b *circuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialInFlight = false
+}
+
+// State returns the current breaker state
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// =============================================================================
+// METRICS
+// =============================================================================
+
+// MayarEndpointMetrics holds call statistics for one Mayar endpoint
+type MayarEndpointMetrics struct {
+	Endpoint        string    `json:"endpoint"`
+	Calls           int64     `json:"calls"`
+	Successes       int64     `json:"successes"`
+	Failures        int64     `json:"failures"`
+	Retries         int64     `json:"retries"`
+	RateLimited     int64     `json:"rateLimited"`
+	CircuitRejected int64     `json:"circuitRejected"`
+	AvgLatencyMs    float64   `json:"avgLatencyMs"`
+	LastStatusCode  int       `json:"lastStatusCode"`
+	LastCalledAt    time.Time `json:"lastCalledAt"`
+
+	totalLatency time.Duration
+}
+
+// MayarClientMetrics is a snapshot of the Mayar client state
+type MayarClientMetrics struct {
+	CircuitState string                 `json:"circuitState"`
+	Endpoints    []MayarEndpointMetrics `json:"endpoints"`
+	Webhooks     *WebhookMetrics        `json:"webhooks,omitempty"`
+}
+
+// mayarMetrics collects per-endpoint statistics
+type mayarMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*MayarEndpointMetrics
+}
+
+// record applies an update to the metrics of one endpoint
+func (m *mayarMetrics) record(endpoint string, update func(*MayarEndpointMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics, ok := m.endpoints[endpoint]
+	if !ok {
+		metrics = &MayarEndpointMetrics{Endpoint: endpoint}
+		m.endpoints[endpoint] = metrics
+	}
+	update(metrics)
+}
+
+// snapshot returns a copy of all endpoint metrics sorted by endpoint
+func (m *mayarMetrics) snapshot() []MayarEndpointMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]MayarEndpointMetrics, 0, len(m.endpoints))
+	for _, metrics := range m.endpoints {
+		copied := *metrics
+		if copied.Calls > 0 {
+			copied.AvgLatencyMs = copied.totalLatency.Seconds() * 1000 / float64(copied.Calls)
+		}
+		result = append(result, copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+	return result
+}
+
+// mayarStaticPathSegments are the path segments that identify an endpoint;
+// any other segment is an ID and is collapsed to ":id" in metric names
+var mayarStaticPathSegments = map[string]bool{
+	"product": true, "type": true, "close": true, "open": true,
+	"invoice": true, "request-payment": true, "customer": true,
+	"transactions": true, "transaction": true,
+	"webhook": true, "history": true, "register": true, "test": true, "retry": true,
+	"license": true, "verify": true, "activate": true, "deactivate": true,
+	"coupon": true, "apply": true,
+}
+
+// mayarEndpointName normalizes a request path into a metric name such as "GET /invoice/:id"
+func mayarEndpointName(method, endpoint string) string {
+	path := endpoint
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		path = path[:idx]
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if !mayarStaticPathSegments[segment] {
+			segments[i] = ":id"
+		}
+	}
+	return method + " /" + strings.Join(segments, "/")
+}
+
+// =============================================================================
+// CLIENT
+// =============================================================================
+
+// mayarClient performs rate limited, retried and circuit broken calls to the Mayar API
+type mayarClient struct {
+	config  *MayarConfig
+	http    *http.Client
+	limiter *rateLimiter
+	breaker *circuitBreaker
+	metrics *mayarMetrics
+}
+
+// newMayarClient creates a Mayar API client from configuration
+func newMayarClient(config *MayarConfig) *mayarClient {
+	return &mayarClient{
+		config:  config,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		limiter: newRateLimiter(config.RateLimit.RequestsPerMinute),
+		breaker: newCircuitBreaker(config.Circuit),
+		metrics: &mayarMetrics{endpoints: make(map[string]*MayarEndpointMetrics)},
+	}
+}
+
+// isIdempotentMethod reports whether a request may be safely retried
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryableStatus reports whether a response status is worth retrying
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// backoffDelay returns a full-jitter exponential backoff for the given attempt,
+// honoring a Retry-After header when the server sends one
+func (c *mayarClient) backoffDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			delay := time.Duration(seconds) * time.Second
+			if delay > c.config.Retry.MaxDelay {
+				delay = c.config.Retry.MaxDelay
+			}
+			return delay
+		}
+	}
+
+	ceiling := c.config.Retry.BaseDelay << uint(attempt)
+	if ceiling <= 0 || ceiling > c.config.Retry.MaxDelay {
+		ceiling = c.config.Retry.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do sends a request to the Mayar API. Idempotent requests are retried on
+// network errors, 429 and 5xx responses. The caller owns the response body.
+func (c *mayarClient) Do(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	name := mayarEndpointName(method, endpoint)
+	requestURL := c.config.GetMayarBaseURL() + endpoint
+
+	if err := c.breaker.Allow(); err != nil {
+		c.metrics.record(name, func(m *MayarEndpointMetrics) { m.CircuitRejected++ })
+		return nil, err
+	}
+
+	// A call abandoned by its context must not leave a half-open trial pending
+	outcomeRecorded := false
+	defer func() {
+		if !outcomeRecorded {
+			c.breaker.Abandon()
+		}
+	}()
+
+	maxAttempts := 1
+	if isIdempotentMethod(method) && c.config.Retry.MaxAttempts > 1 {
+		maxAttempts = c.config.Retry.MaxAttempts
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			c.metrics.record(name, func(m *MayarEndpointMetrics) { m.Retries++ })
+		}
+
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, requestURL, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for key, value := range c.config.GetAPIHeaders() {
+			req.Header.Set(key, value)
+		}
+
+		start := time.Now()
+		resp, err := c.http.Do(req)
+		latency := time.Since(start)
+
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		c.metrics.record(name, func(m *MayarEndpointMetrics) {
+			m.Calls++
+			m.totalLatency += latency
+			m.LastStatusCode = statusCode
+			m.LastCalledAt = start
+			if statusCode == http.StatusTooManyRequests {
+				m.RateLimited++
+			}
+		})
+
+		LogDebug("Mayar API call", logrus.Fields{
+			"endpoint":    name,
+			"attempt":     attempt + 1,
+			"status_code": statusCode,
+			"latency_ms":  latency.Milliseconds(),
+		})
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = redactURLError(err)
+		} else if !isRetryableStatus(resp.StatusCode) {
+			outcomeRecorded = true
+			c.breaker.RecordSuccess()
+			c.metrics.record(name, func(m *MayarEndpointMetrics) { m.Successes++ })
+			return resp, nil
+		} else {
+			lastErr = fmt.Errorf("HTTP %d from Mayar API", resp.StatusCode)
+			if attempt == maxAttempts-1 {
+				// Hand the final response to the caller so it can surface the API error
+				outcomeRecorded = true
+				c.breaker.RecordFailure()
+				c.metrics.record(name, func(m *MayarEndpointMetrics) { m.Failures++ })
+				return resp, nil
+			}
+		}
+
+		delay := c.backoffDelay(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if attempt == maxAttempts-1 {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	outcomeRecorded = true
+	c.breaker.RecordFailure()
+	c.metrics.record(name, func(m *MayarEndpointMetrics) { m.Failures++ })
+	LogWarn("Mayar API call failed", logrus.Fields{
+		"endpoint": name,
+		"attempts": maxAttempts,
+		"error":    lastErr.Error(),
+	})
+	return nil, lastErr
+}
+
+// Metrics returns a snapshot of client metrics
+func (c *mayarClient) Metrics() MayarClientMetrics {
+	return MayarClientMetrics{
+		CircuitState: c.breaker.State(),
+		Endpoints:    c.metrics.snapshot(),
+	}
+}
+
+// redactURLError strips query strings from URL errors so they never reach logs
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if parsed, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			parsed.RawQuery = ""
+			return &url.Error{Op: urlErr.Op, URL: parsed.String(), Err: urlErr.Err}
+		}
+	}
+	return err
+}
+
+// redactSecret keeps only enough of a secret to tell keys apart in logs
+func redactSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
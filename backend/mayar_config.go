@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -21,6 +22,15 @@ type MayarConfig struct {
 	Environment   string
 	SandboxURL    string
 	RateLimit     RateLimitConfig
+	Retry         RetryConfig
+	Circuit       CircuitBreakerConfig
+
+	// PaymentLinkTTL is how long an order payment link stays valid
+	PaymentLinkTTL time.Duration
+	// PaymentCallbackURL is where Mayar redirects the customer after paying
+	PaymentCallbackURL string
+	// Webhook holds signature, timestamp and replay settings for incoming webhooks
+	Webhook WebhookSecurityConfig
 }
 
 // RateLimitConfig holds rate limiting configuration
@@ -29,6 +39,19 @@ type RateLimitConfig struct {
 	WindowMs          time.Duration
 }
 
+// RetryConfig holds retry configuration for idempotent Mayar API calls
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// CircuitBreakerConfig holds circuit breaker configuration for the Mayar API
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
 // GetMayarConfig loads Mayar configuration from environment variables
 func GetMayarConfig() (*MayarConfig, error) {
 	config := &MayarConfig{
@@ -39,16 +62,59 @@ func GetMayarConfig() (*MayarConfig, error) {
 		Environment:   getEnvWithDefault("MAYAR_ENVIRONMENT", "sandbox"),
 		SandboxURL:    getEnvWithDefault("MAYAR_SANDBOX_URL", MayarSandboxURL),
 		RateLimit: RateLimitConfig{
-			RequestsPerMinute: 60,
-			WindowMs:          60000,
+			RequestsPerMinute: getEnvIntWithDefault("MAYAR_RATE_LIMIT_PER_MINUTE", 60),
+			WindowMs:          time.Minute,
+		},
+		Retry: RetryConfig{
+			MaxAttempts: getEnvIntWithDefault("MAYAR_RETRY_MAX_ATTEMPTS", 3),
+			BaseDelay:   getEnvDurationWithDefault("MAYAR_RETRY_BASE_DELAY", 200*time.Millisecond),
+			MaxDelay:    getEnvDurationWithDefault("MAYAR_RETRY_MAX_DELAY", 5*time.Second),
 		},
+		Circuit: CircuitBreakerConfig{
+			FailureThreshold: getEnvIntWithDefault("MAYAR_CIRCUIT_FAILURE_THRESHOLD", 5),
+			OpenDuration:     getEnvDurationWithDefault("MAYAR_CIRCUIT_OPEN_DURATION", 30*time.Second),
+		},
+		PaymentLinkTTL:     24 * time.Hour,
+		PaymentCallbackURL: os.Getenv("MAYAR_PAYMENT_CALLBACK_URL"),
+		Webhook: WebhookSecurityConfig{
+			TimestampTolerance: getEnvDurationWithDefault("MAYAR_WEBHOOK_TIMESTAMP_TOLERANCE", 5*time.Minute),
+			RequireTimestamp:   os.Getenv("MAYAR_WEBHOOK_REQUIRE_TIMESTAMP") == "true",
+			ReplayTTL:          getEnvDurationWithDefault("MAYAR_WEBHOOK_REPLAY_TTL", 24*time.Hour),
+		},
+	}
+
+	// The primary secret stays active until MAYAR_WEBHOOK_SECRET_ACTIVE_UNTIL;
+	// MAYAR_WEBHOOK_SECRETS adds secrets for rotation
+	if config.WebhookSecret != "" {
+		primary := WebhookSecret{ID: "primary", Value: config.WebhookSecret}
+		if until := os.Getenv("MAYAR_WEBHOOK_SECRET_ACTIVE_UNTIL"); until != "" {
+			parsed, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				return nil, fmt.Errorf("invalid MAYAR_WEBHOOK_SECRET_ACTIVE_UNTIL: %q", until)
+			}
+			primary.ActiveUntil = parsed
+		}
+		config.Webhook.Secrets = append(config.Webhook.Secrets, primary)
+	}
+	extraSecrets, err := parseWebhookSecrets(os.Getenv("MAYAR_WEBHOOK_SECRETS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAYAR_WEBHOOK_SECRETS: %w", err)
+	}
+	config.Webhook.Secrets = append(config.Webhook.Secrets, extraSecrets...)
+
+	if ttl := os.Getenv("MAYAR_PAYMENT_LINK_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid MAYAR_PAYMENT_LINK_TTL: %q", ttl)
+		}
+		config.PaymentLinkTTL = parsed
 	}
 
 	// Validate required fields
 	if config.APIKey == "" {
 		return nil, fmt.Errorf("MAYAR_API_KEY is required")
 	}
-	if config.WebhookSecret == "" {
+	if len(config.Webhook.Secrets) == 0 {
 		return nil, fmt.Errorf("MAYAR_WEBHOOK_SECRET is required")
 	}
 
@@ -107,6 +173,26 @@ func getEnvWithDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvIntWithDefault returns a positive integer environment variable or default if unset or invalid
+func getEnvIntWithDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDurationWithDefault returns a positive duration environment variable or default if unset or invalid
+func getEnvDurationWithDefault(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 // MayarEndpoints contains all Mayar API endpoints
 type MayarEndpoints struct {
 	// Product endpoints
@@ -49,7 +49,7 @@ func (h *MayarHandler) GetProducts(c *gin.Context) {
 	params.Search = c.Query("search")
 	
 	// Call service
-	result, err := h.service.GetProducts(params)
+	result, err := h.service.GetProducts(c.Request.Context(), params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -82,7 +82,7 @@ func (h *MayarHandler) GetProductsByType(c *gin.Context) {
 	}
 	
 	// Call service
-	result, err := h.service.GetProductsByType(productType, params)
+	result, err := h.service.GetProductsByType(c.Request.Context(), productType, params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -99,7 +99,7 @@ func (h *MayarHandler) GetProductsByType(c *gin.Context) {
 func (h *MayarHandler) GetProduct(c *gin.Context) {
 	productID := c.Param("id")
 	
-	result, err := h.service.GetProduct(productID)
+	result, err := h.service.GetProduct(c.Request.Context(), productID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -116,7 +116,7 @@ func (h *MayarHandler) GetProduct(c *gin.Context) {
 func (h *MayarHandler) CloseProduct(c *gin.Context) {
 	productID := c.Param("id")
 	
-	err := h.service.CloseProduct(productID)
+	err := h.service.CloseProduct(c.Request.Context(), productID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -136,7 +136,7 @@ func (h *MayarHandler) CloseProduct(c *gin.Context) {
 func (h *MayarHandler) ReopenProduct(c *gin.Context) {
 	productID := c.Param("id")
 	
-	err := h.service.ReopenProduct(productID)
+	err := h.service.ReopenProduct(c.Request.Context(), productID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -169,7 +169,7 @@ func (h *MayarHandler) CreateInvoice(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.CreateInvoice(req)
+	result, err := h.service.CreateInvoice(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -186,7 +186,7 @@ func (h *MayarHandler) CreateInvoice(c *gin.Context) {
 func (h *MayarHandler) GetInvoice(c *gin.Context) {
 	invoiceID := c.Param("id")
 	
-	result, err := h.service.GetInvoice(invoiceID)
+	result, err := h.service.GetInvoice(c.Request.Context(), invoiceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -213,7 +213,7 @@ func (h *MayarHandler) UpdateInvoice(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.UpdateInvoice(invoiceID, req)
+	result, err := h.service.UpdateInvoice(c.Request.Context(), invoiceID, req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -222,6 +222,8 @@ func (h *MayarHandler) UpdateInvoice(c *gin.Context) {
 		})
 		return
 	}
+	// The invoice lives in Mayar, so the audit log records the requested changes
+	auditChange(c, nil, req)
 	
 	c.JSON(http.StatusOK, result)
 }
@@ -229,8 +231,11 @@ func (h *MayarHandler) UpdateInvoice(c *gin.Context) {
 // DeleteInvoice handles DELETE /api/mayar/invoices/:id
 func (h *MayarHandler) DeleteInvoice(c *gin.Context) {
 	invoiceID := c.Param("id")
-	
-	err := h.service.DeleteInvoice(invoiceID)
+
+	// Keep the invoice as it was for the audit log; it only exists in Mayar
+	before, getErr := h.service.GetInvoice(c.Request.Context(), invoiceID)
+
+	err := h.service.DeleteInvoice(c.Request.Context(), invoiceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -240,6 +245,10 @@ func (h *MayarHandler) DeleteInvoice(c *gin.Context) {
 		return
 	}
 	
+	if getErr == nil {
+		auditChange(c, before, nil)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Invoice deleted successfully",
@@ -263,7 +272,7 @@ func (h *MayarHandler) CreatePaymentRequest(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.CreatePaymentRequest(req)
+	result, err := h.service.CreatePaymentRequest(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -280,7 +289,7 @@ func (h *MayarHandler) CreatePaymentRequest(c *gin.Context) {
 func (h *MayarHandler) GetPaymentRequest(c *gin.Context) {
 	requestID := c.Param("id")
 	
-	result, err := h.service.GetPaymentRequest(requestID)
+	result, err := h.service.GetPaymentRequest(c.Request.Context(), requestID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -310,7 +319,7 @@ func (h *MayarHandler) CreateCustomer(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.CreateCustomer(req)
+	result, err := h.service.CreateCustomer(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -327,7 +336,7 @@ func (h *MayarHandler) CreateCustomer(c *gin.Context) {
 func (h *MayarHandler) GetCustomer(c *gin.Context) {
 	customerID := c.Param("id")
 	
-	result, err := h.service.GetCustomer(customerID)
+	result, err := h.service.GetCustomer(c.Request.Context(), customerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -354,7 +363,7 @@ func (h *MayarHandler) UpdateCustomer(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.UpdateCustomer(customerID, req)
+	result, err := h.service.UpdateCustomer(c.Request.Context(), customerID, req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -393,7 +402,7 @@ func (h *MayarHandler) GetTransactions(c *gin.Context) {
 	params.EndDate = c.Query("endDate")
 	
 	// Call service
-	result, err := h.service.GetTransactions(params)
+	result, err := h.service.GetTransactions(c.Request.Context(), params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -410,7 +419,7 @@ func (h *MayarHandler) GetTransactions(c *gin.Context) {
 func (h *MayarHandler) GetTransaction(c *gin.Context) {
 	transactionID := c.Param("id")
 	
-	result, err := h.service.GetTransaction(transactionID)
+	result, err := h.service.GetTransaction(c.Request.Context(), transactionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -440,7 +449,7 @@ func (h *MayarHandler) RegisterWebhook(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.RegisterWebhook(req)
+	result, err := h.service.RegisterWebhook(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -455,7 +464,7 @@ func (h *MayarHandler) RegisterWebhook(c *gin.Context) {
 
 // GetWebhookHistory handles GET /api/mayar/webhooks/history
 func (h *MayarHandler) GetWebhookHistory(c *gin.Context) {
-	result, err := h.service.GetWebhookHistory()
+	result, err := h.service.GetWebhookHistory(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -472,7 +481,7 @@ func (h *MayarHandler) GetWebhookHistory(c *gin.Context) {
 func (h *MayarHandler) TestWebhook(c *gin.Context) {
 	webhookID := c.Param("id")
 	
-	err := h.service.TestWebhook(webhookID)
+	err := h.service.TestWebhook(c.Request.Context(), webhookID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -492,7 +501,7 @@ func (h *MayarHandler) TestWebhook(c *gin.Context) {
 func (h *MayarHandler) RetryWebhook(c *gin.Context) {
 	historyID := c.Param("historyId")
 	
-	err := h.service.RetryWebhook(historyID)
+	err := h.service.RetryWebhook(c.Request.Context(), historyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -510,16 +519,6 @@ func (h *MayarHandler) RetryWebhook(c *gin.Context) {
 
 // HandleWebhook handles incoming webhook from Mayar.id
 func (h *MayarHandler) HandleWebhook(c *gin.Context) {
-	// Get signature from header
-	signature := c.GetHeader("X-Mayar-Signature")
-	if signature == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"message": "Missing webhook signature",
-		})
-		return
-	}
-	
 	// Read raw body
 	body, err := c.GetRawData()
 	if err != nil {
@@ -530,15 +529,51 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 	
-	// Verify signature
-	if !h.service.VerifyWebhookSignature(body, signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{
+	// Verify signature, timestamp and replay
+	verification, err := h.service.VerifyWebhook(c.Request.Header, body)
+	if err != nil {
+		reason := "unknown"
+		if verifyErr, ok := err.(*WebhookVerificationError); ok {
+			reason = verifyErr.Reason
+		}
+		fields := logrus.Fields{
+			"reason":     reason,
+			"detail":     err.Error(),
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+			"body_bytes": len(body),
+		}
+		if verification != nil {
+			fields["event_id"] = verification.EventID
+			fields["secret_id"] = verification.SecretID
+		}
+		LogSecurity("mayar_webhook_rejected", fields)
+		
+		// A replay is authentic, so acknowledge it to stop Mayar from retrying
+		if reason == WebhookRejectReplayed {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Duplicate webhook ignored",
+			})
+			return
+		}
+		
+		status := http.StatusUnauthorized
+		if reason == WebhookRejectMissingSignature || reason == WebhookRejectMissingTimestamp {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
 			"success": false,
 			"message": "Invalid webhook signature",
 		})
 		return
 	}
 	
+	LogDebug("Mayar webhook verified", logrus.Fields{
+		"event_id":  verification.EventID,
+		"secret_id": verification.SecretID,
+	})
+	
 	// Parse webhook payload
 	var payload map[string]interface{}
 	if err := json.Unmarshal(body, &payload); err != nil {
@@ -559,7 +594,9 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 	
-	// Handle different webhook events
+	// Handle different webhook events; processErr is set when an event could not
+	// be recorded, so Mayar retries it instead of the payment being lost
+	var processErr error
 	switch eventType {
 	case "payment.completed":
 		// Handle payment completion
@@ -576,22 +613,7 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 		paymentStatus, _ := paymentData["status"].(string)
 		paymentMethod, _ := paymentData["paymentMethod"].(string)
 		
-		// Extract reference ID (order ID) from metadata or description
-		var orderID string
-		if metadata, ok := paymentData["metadata"].(map[string]interface{}); ok {
-			orderID, _ = metadata["order_id"].(string)
-		}
-		
-		// If order ID not found in metadata, try to extract from description
-		if orderID == "" {
-			description, _ := paymentData["description"].(string)
-			// Try to extract order ID from description (assuming format like "Payment for Order #123456")
-			parts := strings.Split(description, "#")
-			if len(parts) > 1 {
-				orderID = strings.TrimSpace(parts[1])
-			}
-		}
-		
+		orderID := h.resolveWebhookOrderID(paymentData)
 		if orderID == "" {
 			LogError("Cannot determine order ID from payment.completed webhook", logrus.Fields{
 				"payment_id": paymentID,
@@ -600,35 +622,30 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 			break
 		}
 		
-		// Extract paid_at timestamp
-		var paidAt *time.Time
-		if paidAtStr, ok := paymentData["paidAt"].(string); ok && paidAtStr != "" {
-			parsedTime, err := time.Parse(time.RFC3339, paidAtStr)
-			if err == nil {
-				paidAt = &parsedTime
-			}
-		}
+		paidAt := webhookTimestamp(paymentData, "paidAt")
 		
-		// If paidAt is not available, use current time
-		if paidAt == nil {
-			now := time.Now()
-			paidAt = &now
+		// A completed payment with no recognisable status is treated as paid
+		attemptStatus := mayarPaymentStatus(paymentStatus)
+		if attemptStatus == PaymentStatusPending {
+			attemptStatus = PaymentStatusPaid
 		}
 		
-		// Set payment method pointer
-		paymentMethodPtr := &paymentMethod
-		if paymentMethod == "" {
-			paymentMethodPtr = nil
-		}
-		
-		// Update order payment status
-		err := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, paymentMethodPtr, paidAt)
+		// Record the attempt; the order's payment status is derived from its attempts
+		err := h.orderRepo.RecordPaymentAttempt(orderID, PaymentAttemptUpdate{
+			Gateway:       GatewayMayar,
+			ExternalID:    webhookPaymentReference(paymentData),
+			Status:        attemptStatus,
+			PaymentMethod: optionalString(paymentMethod),
+			At:            paidAt,
+			RawResponse:   webhookRawData(paymentData),
+		})
 		if err != nil {
 			LogError("Failed to update order payment status", logrus.Fields{
 				"order_id": orderID,
 				"payment_id": paymentID,
 				"error": err.Error(),
 			}, err)
+			processErr = err
 		} else {
 			LogInfo("Order payment status updated successfully", logrus.Fields{
 				"order_id": orderID,
@@ -651,22 +668,7 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 		paymentStatus := "failed" // Explicitly set to failed
 		paymentMethod, _ := paymentData["paymentMethod"].(string)
 		
-		// Extract reference ID (order ID) from metadata or description
-		var orderID string
-		if metadata, ok := paymentData["metadata"].(map[string]interface{}); ok {
-			orderID, _ = metadata["order_id"].(string)
-		}
-		
-		// If order ID not found in metadata, try to extract from description
-		if orderID == "" {
-			description, _ := paymentData["description"].(string)
-			// Try to extract order ID from description (assuming format like "Payment for Order #123456")
-			parts := strings.Split(description, "#")
-			if len(parts) > 1 {
-				orderID = strings.TrimSpace(parts[1])
-			}
-		}
-		
+		orderID := h.resolveWebhookOrderID(paymentData)
 		if orderID == "" {
 			LogError("Cannot determine order ID from payment.failed webhook", logrus.Fields{
 				"payment_id": paymentID,
@@ -675,63 +677,30 @@ func (h *MayarHandler) HandleWebhook(c *gin.Context) {
 			break
 		}
 		
-		// Extract failed_at timestamp or use current time
-		var failedAt *time.Time
-		if failedAtStr, ok := paymentData["failedAt"].(string); ok && failedAtStr != "" {
-			parsedTime, err := time.Parse(time.RFC3339, failedAtStr)
-			if err == nil {
-				failedAt = &parsedTime
-			}
-		}
-		
-		// If failedAt is not available, use current time
-		if failedAt == nil {
-			now := time.Now()
-			failedAt = &now
-		}
+		failedAt := webhookTimestamp(paymentData, "failedAt")
 		
-		// Set payment method pointer
-		paymentMethodPtr := &paymentMethod
-		if paymentMethod == "" {
-			paymentMethodPtr = nil
-		}
-		
-		// Update order payment status
-		err := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, paymentMethodPtr, failedAt)
+		// Record the failed attempt; an earlier or later successful attempt still wins
+		err := h.orderRepo.RecordPaymentAttempt(orderID, PaymentAttemptUpdate{
+			Gateway:       GatewayMayar,
+			ExternalID:    webhookPaymentReference(paymentData),
+			Status:        paymentStatus,
+			PaymentMethod: optionalString(paymentMethod),
+			At:            failedAt,
+			RawResponse:   webhookRawData(paymentData),
+		})
 		if err != nil {
 			LogError("Failed to update order payment status to failed", logrus.Fields{
 				"order_id": orderID,
 				"payment_id": paymentID,
 				"error": err.Error(),
 			}, err)
+			processErr = err
 		} else {
 			LogInfo("Order payment status updated to failed", logrus.Fields{
 				"order_id": orderID,
 				"payment_id": paymentID,
 			})
 		}
-		
-		// Set payment method pointer
-		paymentMethodPtr := &paymentMethod
-		if paymentMethod == "" {
-			paymentMethodPtr = nil
-		}
-		
-		// Update order payment status
-if updateErr := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, paymentMethodPtr, failedAt); updateErr != nil {
-		if err != nil {
-			LogError("Failed to update order payment status to failed", logrus.Fields{
-				"order_id": orderID,
-				"payment_id": paymentID,
-				"error": err.Error(),
-			}, err)
-		} else {
-			LogInfo("Order payment status updated successfully", logrus.Fields{
-				"order_id": orderID,
-				"payment_id": paymentID,
-				"status": paymentStatus,
-			})
-		}
 	case "invoice.paid":
 		// Handle invoice payment
 		invoiceData, ok := payload["data"].(map[string]interface{})
@@ -747,22 +716,7 @@ if updateErr := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, pay
 		paymentStatus := "paid" // Explicitly set to paid for invoice.paid event
 		paymentMethod, _ := invoiceData["paymentMethod"].(string)
 		
-		// Extract reference ID (order ID) from metadata or description
-		var orderID string
-		if metadata, ok := invoiceData["metadata"].(map[string]interface{}); ok {
-			orderID, _ = metadata["order_id"].(string)
-		}
-		
-		// If order ID not found in metadata, try to extract from description
-		if orderID == "" {
-			description, _ := invoiceData["description"].(string)
-			// Try to extract order ID from description (assuming format like "Invoice for Order #123456")
-			parts := strings.Split(description, "#")
-			if len(parts) > 1 {
-				orderID = strings.TrimSpace(parts[1])
-			}
-		}
-		
+		orderID := h.resolveWebhookOrderID(invoiceData)
 		if orderID == "" {
 			LogError("Cannot determine order ID from invoice.paid webhook", logrus.Fields{
 				"invoice_id": invoiceID,
@@ -771,34 +725,23 @@ if updateErr := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, pay
 			break
 		}
 		
-		// Get paid timestamp
-		var paidAt *time.Time
-		if paidAtStr, ok := invoiceData["paidAt"].(string); ok && paidAtStr != "" {
-			parsedTime, err := time.Parse(time.RFC3339, paidAtStr)
-			if err == nil {
-				paidAt = &parsedTime
-			}
-		}
-		
-		// If paidAt is not available, use current time
-		if paidAt == nil {
-			now := time.Now()
-			paidAt = &now
-		}
-		
-		// Update order payment status
-		paymentMethodPtr := &paymentMethod
-		if paymentMethod == "" {
-			paymentMethodPtr = nil
-		}
+		paidAt := webhookTimestamp(invoiceData, "paidAt")
 		
-		err := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, paymentMethodPtr, paidAt)
+		err := h.orderRepo.RecordPaymentAttempt(orderID, PaymentAttemptUpdate{
+			Gateway:       GatewayMayar,
+			ExternalID:    webhookPaymentReference(invoiceData),
+			Status:        paymentStatus,
+			PaymentMethod: optionalString(paymentMethod),
+			At:            paidAt,
+			RawResponse:   webhookRawData(invoiceData),
+		})
 		if err != nil {
 			LogError("Failed to update order payment status from invoice", logrus.Fields{
 				"order_id": orderID,
 				"invoice_id": invoiceID,
 				"error": err.Error(),
 			}, err)
+			processErr = err
 		} else {
 			LogInfo("Order payment status updated successfully", logrus.Fields{
 				"order_id": orderID,
@@ -832,12 +775,100 @@ if updateErr := h.orderRepo.UpdateOrderPaymentStatus(orderID, paymentStatus, pay
 		})
 	}
 	
+	if processErr != nil {
+		// Forget the event so the retry is not refused as a replay
+		h.service.ForgetWebhook(verification.EventID)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to process webhook",
+		})
+		return
+	}
+	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Webhook processed successfully",
 	})
 }
 
+// resolveWebhookOrderID finds the order a webhook refers to. It prefers the
+// order_id we put in the payment request metadata, then the payment request ID
+// stored as the order's payment token, and only then the legacy description format.
+func (h *MayarHandler) resolveWebhookOrderID(data map[string]interface{}) string {
+	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+		if orderID, _ := metadata["order_id"].(string); orderID != "" {
+			return orderID
+		}
+	}
+	
+	for _, key := range []string{"paymentRequestId", "paymentLinkId", "id"} {
+		paymentToken, _ := data[key].(string)
+		if paymentToken == "" {
+			continue
+		}
+		if orderID, err := h.orderRepo.GetOrderIDByPaymentToken(paymentToken); err == nil {
+			return orderID
+		}
+	}
+	
+	// Legacy format: "Payment for Order #<order id>"
+	description, _ := data["description"].(string)
+	parts := strings.Split(description, "#")
+	if len(parts) > 1 {
+		return strings.TrimSpace(parts[1])
+	}
+	
+	return ""
+}
+
+// webhookPaymentReference returns the payment request ID a webhook refers to,
+// which is the external ID of the payment attempt
+func webhookPaymentReference(data map[string]interface{}) string {
+	for _, key := range []string{"paymentRequestId", "paymentLinkId", "id"} {
+		if reference, _ := data[key].(string); reference != "" {
+			return reference
+		}
+	}
+	return ""
+}
+
+// webhookRawData re-encodes webhook data for storage on the payment attempt
+func webhookRawData(data map[string]interface{}) json.RawMessage {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// webhookTimestamp parses an RFC3339 timestamp from webhook data, defaulting to now
+func webhookTimestamp(data map[string]interface{}, key string) *time.Time {
+	if value, ok := data[key].(string); ok && value != "" {
+		if parsedTime, err := time.Parse(time.RFC3339, value); err == nil {
+			return &parsedTime
+		}
+	}
+	
+	now := time.Now()
+	return &now
+}
+
+// optionalString returns nil for an empty string
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// stringValue returns the empty string for nil
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
 // =============================================================================
 // LICENSE HANDLERS
 // =============================================================================
@@ -855,7 +886,7 @@ func (h *MayarHandler) VerifyLicense(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.VerifyLicense(req)
+	result, err := h.service.VerifyLicense(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -881,7 +912,7 @@ func (h *MayarHandler) ActivateLicense(c *gin.Context) {
 		return
 	}
 	
-	err := h.service.ActivateLicense(req)
+	err := h.service.ActivateLicense(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -910,7 +941,7 @@ func (h *MayarHandler) DeactivateLicense(c *gin.Context) {
 		return
 	}
 	
-	err := h.service.DeactivateLicense(req)
+	err := h.service.DeactivateLicense(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -943,7 +974,7 @@ func (h *MayarHandler) CreateCoupon(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.CreateCoupon(req)
+	result, err := h.service.CreateCoupon(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -969,7 +1000,7 @@ func (h *MayarHandler) ApplyCoupon(c *gin.Context) {
 		return
 	}
 	
-	result, err := h.service.ApplyCoupon(req)
+	result, err := h.service.ApplyCoupon(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -986,7 +1017,7 @@ func (h *MayarHandler) ApplyCoupon(c *gin.Context) {
 func (h *MayarHandler) GetCoupon(c *gin.Context) {
 	couponCode := c.Param("code")
 	
-	result, err := h.service.GetCoupon(couponCode)
+	result, err := h.service.GetCoupon(c.Request.Context(), couponCode)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -997,4 +1028,15 @@ func (h *MayarHandler) GetCoupon(c *gin.Context) {
 	}
 	
 	c.JSON(http.StatusOK, result)
-}
\ No newline at end of file
+}
+// =============================================================================
+// CLIENT METRICS HANDLERS
+// =============================================================================
+
+// GetClientMetrics handles GET /api/mayar/metrics
+func (h *MayarHandler) GetClientMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.service.Metrics(),
+	})
+}
@@ -91,6 +91,24 @@ func SetupMayarRoutes(router *gin.Engine, handler *MayarHandler) {
 		coupons.POST("/apply", handler.ApplyCoupon)      // POST /api/mayar/coupons/apply
 		coupons.GET("/:code", handler.GetCoupon)         // GET /api/mayar/coupons/:code
 	}
+	
+	// =============================================================================
+	// CLIENT METRICS
+	// =============================================================================
+	api.GET("/metrics", handler.GetClientMetrics) // GET /api/mayar/metrics
+}
+
+// SetupCatalogSyncRoutes sets up the Mayar catalog sync routes
+func SetupCatalogSyncRoutes(router *gin.Engine, handler *CatalogSyncHandler) {
+	catalog := router.Group("/api/mayar/catalog")
+	{
+		catalog.POST("/sync", handler.Sync)                             // POST /api/mayar/catalog/sync?dryRun=true
+		catalog.GET("/sync/last", handler.GetLastSync)                  // GET /api/mayar/catalog/sync/last
+		catalog.GET("/diff", handler.GetDiff)                           // GET /api/mayar/catalog/diff
+		catalog.GET("/mappings", handler.GetMappings)                   // GET /api/mayar/catalog/mappings
+		catalog.PUT("/mappings/:productId", handler.LinkProduct)        // PUT /api/mayar/catalog/mappings/:productId
+		catalog.DELETE("/mappings/:productId", handler.UnlinkProduct)   // DELETE /api/mayar/catalog/mappings/:productId
+	}
 }
 
 // SetupMayarMiddleware sets up middleware for Mayar routes
@@ -113,28 +131,12 @@ func SetupMayarMiddleware(router *gin.Engine) {
 		c.Next()
 	})
 	
-	// Add rate limiting middleware for Mayar routes
-	router.Use(func(c *gin.Context) {
-		// Skip rate limiting for webhook endpoints
-		if c.Request.URL.Path == "/api/mayar/webhooks/incoming" {
-			c.Next()
-			return
-		}
-		
-		// TODO: Implement rate limiting logic
-		// For now, just continue
-		c.Next()
-	})
+	// Outbound calls are rate limited, retried and circuit broken by mayarClient
+	// using MayarConfig.RateLimit, so no inbound throttling is applied here
 }
 
 // InitializeMayarIntegration initializes the complete Mayar.id integration
-func InitializeMayarIntegration(router *gin.Engine, orderRepo *OrderRepository) error {
-	// Create Mayar service
-	service, err := NewMayarService()
-	if err != nil {
-		return err
-	}
-	
+func InitializeMayarIntegration(router *gin.Engine, service *MayarService, orderRepo *OrderRepository, catalogSync *CatalogSyncService) {
 	// Create Mayar handler
 	handler := NewMayarHandler(service, orderRepo)
 	
@@ -143,6 +145,5 @@ func InitializeMayarIntegration(router *gin.Engine, orderRepo *OrderRepository)
 	
 	// Setup routes
 	SetupMayarRoutes(router, handler)
-	
-	return nil
+	SetupCatalogSyncRoutes(router, NewCatalogSyncHandler(catalogSync))
 }
\ No newline at end of file
@@ -1,23 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // MayarService handles all Mayar.id API interactions
 type MayarService struct {
-	config *MayarConfig
-	client *http.Client
+	config   *MayarConfig
+	client   *mayarClient
+	webhooks *webhookVerifier
 }
 
 // NewMayarService creates a new Mayar service instance
@@ -27,50 +26,45 @@ func NewMayarService() (*MayarService, error) {
 		return nil, fmt.Errorf("failed to load Mayar config: %w", err)
 	}
 	
+	LogInfo("Mayar service initialized", logrus.Fields{
+		"environment":         config.Environment,
+		"base_url":            config.GetMayarBaseURL(),
+		"api_key":             redactSecret(config.APIKey),
+		"requests_per_minute": config.RateLimit.RequestsPerMinute,
+		"webhook_secrets":     len(config.Webhook.Secrets),
+	})
+	
 	return &MayarService{
-		config: config,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		config:   config,
+		client:   newMayarClient(config),
+		webhooks: newWebhookVerifier(config.Webhook),
 	}, nil
 }
 
+// Metrics returns call metrics for the Mayar API client and webhook verification
+func (s *MayarService) Metrics() MayarClientMetrics {
+	metrics := s.client.Metrics()
+	webhooks := s.webhooks.Metrics()
+	metrics.Webhooks = &webhooks
+	return metrics
+}
+
 // =============================================================================
 // HTTP CLIENT METHODS
 // =============================================================================
 
 // makeRequest performs HTTP request to Mayar API
-func (s *MayarService) makeRequest(method, endpoint string, body interface{}) (*http.Response, error) {
-	url := s.config.GetMayarBaseURL() + endpoint
-	
-	// Debug logging
-	log.Printf("DEBUG: Making request to URL: %s", url)
-	log.Printf("DEBUG: Base URL: %s", s.config.GetMayarBaseURL())
-	log.Printf("DEBUG: Environment: %s", s.config.Environment)
-	log.Printf("DEBUG: API Key (first 10 chars): %s", s.config.APIKey[:10]+"...")
-	
-	var reqBody io.Reader
+func (s *MayarService) makeRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	var jsonData []byte
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		var err error
+		jsonData, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
-	}
-	
-	req, err := http.NewRequest(method, url, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	// Add headers
-	headers := s.config.GetAPIHeaders()
-	for key, value := range headers {
-		req.Header.Set(key, value)
-		log.Printf("DEBUG: Header %s: %s", key, value)
 	}
 	
-	return s.client.Do(req)
+	return s.client.Do(ctx, method, endpoint, jsonData)
 }
 
 // parseResponse parses HTTP response into target struct
@@ -82,8 +76,10 @@ func (s *MayarService) parseResponse(resp *http.Response, target interface{}) er
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 	
-	// Debug logging
-	log.Printf("Mayar API Response - Status: %d, Body: %s", resp.StatusCode, string(body))
+	LogDebug("Mayar API response", logrus.Fields{
+		"status_code": resp.StatusCode,
+		"body_bytes":  len(body),
+	})
 	
 	if resp.StatusCode >= 400 {
 		var errorResp MayarErrorResponse
@@ -110,7 +106,7 @@ func (s *MayarService) parseResponse(resp *http.Response, target interface{}) er
 // =============================================================================
 
 // GetProducts retrieves all products with pagination
-func (s *MayarService) GetProducts(params ProductListParams) (*ProductListResponse, error) {
+func (s *MayarService) GetProducts(ctx context.Context, params ProductListParams) (*ProductListResponse, error) {
 	endpoint := "/product"
 	
 	// Build query parameters
@@ -129,7 +125,7 @@ func (s *MayarService) GetProducts(params ProductListParams) (*ProductListRespon
 		endpoint += "?" + joinStrings(query, "&")
 	}
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +139,7 @@ func (s *MayarService) GetProducts(params ProductListParams) (*ProductListRespon
 }
 
 // GetProductsByType retrieves products by type
-func (s *MayarService) GetProductsByType(productType string, params ProductListParams) (*ProductListResponse, error) {
+func (s *MayarService) GetProductsByType(ctx context.Context, productType string, params ProductListParams) (*ProductListResponse, error) {
 	endpoint := fmt.Sprintf("/product/type/%s", productType)
 	
 	// Build query parameters
@@ -159,7 +155,7 @@ func (s *MayarService) GetProductsByType(productType string, params ProductListP
 		endpoint += "?" + joinStrings(query, "&")
 	}
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -173,10 +169,10 @@ func (s *MayarService) GetProductsByType(productType string, params ProductListP
 }
 
 // GetProduct retrieves a single product by ID
-func (s *MayarService) GetProduct(productID string) (*ProductResponse, error) {
+func (s *MayarService) GetProduct(ctx context.Context, productID string) (*ProductResponse, error) {
 	endpoint := fmt.Sprintf("/product/%s", productID)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -190,10 +186,10 @@ func (s *MayarService) GetProduct(productID string) (*ProductResponse, error) {
 }
 
 // CloseProduct closes a product
-func (s *MayarService) CloseProduct(productID string) error {
+func (s *MayarService) CloseProduct(ctx context.Context, productID string) error {
 	endpoint := fmt.Sprintf("/product/close/%s", productID)
 	
-	resp, err := s.makeRequest("POST", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -202,10 +198,10 @@ func (s *MayarService) CloseProduct(productID string) error {
 }
 
 // ReopenProduct reopens a closed product
-func (s *MayarService) ReopenProduct(productID string) error {
+func (s *MayarService) ReopenProduct(ctx context.Context, productID string) error {
 	endpoint := fmt.Sprintf("/product/open/%s", productID)
 	
-	resp, err := s.makeRequest("POST", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -218,8 +214,8 @@ func (s *MayarService) ReopenProduct(productID string) error {
 // =============================================================================
 
 // CreateInvoice creates a new invoice
-func (s *MayarService) CreateInvoice(req CreateInvoiceRequest) (*InvoiceResponse, error) {
-	resp, err := s.makeRequest("POST", "/invoice", req)
+func (s *MayarService) CreateInvoice(ctx context.Context, req CreateInvoiceRequest) (*InvoiceResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/invoice", req)
 	if err != nil {
 		return nil, err
 	}
@@ -233,10 +229,10 @@ func (s *MayarService) CreateInvoice(req CreateInvoiceRequest) (*InvoiceResponse
 }
 
 // GetInvoice retrieves an invoice by ID
-func (s *MayarService) GetInvoice(invoiceID string) (*InvoiceResponse, error) {
+func (s *MayarService) GetInvoice(ctx context.Context, invoiceID string) (*InvoiceResponse, error) {
 	endpoint := fmt.Sprintf("/invoice/%s", invoiceID)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -250,10 +246,10 @@ func (s *MayarService) GetInvoice(invoiceID string) (*InvoiceResponse, error) {
 }
 
 // UpdateInvoice updates an existing invoice
-func (s *MayarService) UpdateInvoice(invoiceID string, req UpdateInvoiceRequest) (*InvoiceResponse, error) {
+func (s *MayarService) UpdateInvoice(ctx context.Context, invoiceID string, req UpdateInvoiceRequest) (*InvoiceResponse, error) {
 	endpoint := fmt.Sprintf("/invoice/%s", invoiceID)
 	
-	resp, err := s.makeRequest("PUT", endpoint, req)
+	resp, err := s.makeRequest(ctx, "PUT", endpoint, req)
 	if err != nil {
 		return nil, err
 	}
@@ -267,10 +263,10 @@ func (s *MayarService) UpdateInvoice(invoiceID string, req UpdateInvoiceRequest)
 }
 
 // DeleteInvoice deletes an invoice
-func (s *MayarService) DeleteInvoice(invoiceID string) error {
+func (s *MayarService) DeleteInvoice(ctx context.Context, invoiceID string) error {
 	endpoint := fmt.Sprintf("/invoice/%s", invoiceID)
 	
-	resp, err := s.makeRequest("DELETE", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "DELETE", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -283,8 +279,8 @@ func (s *MayarService) DeleteInvoice(invoiceID string) error {
 // =============================================================================
 
 // CreatePaymentRequest creates a new payment request
-func (s *MayarService) CreatePaymentRequest(req CreatePaymentRequestRequest) (*PaymentRequestResponse, error) {
-	resp, err := s.makeRequest("POST", "/request-payment", req)
+func (s *MayarService) CreatePaymentRequest(ctx context.Context, req CreatePaymentRequestRequest) (*PaymentRequestResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/request-payment", req)
 	if err != nil {
 		return nil, err
 	}
@@ -298,10 +294,10 @@ func (s *MayarService) CreatePaymentRequest(req CreatePaymentRequestRequest) (*P
 }
 
 // GetPaymentRequest retrieves a payment request by ID
-func (s *MayarService) GetPaymentRequest(requestID string) (*PaymentRequestResponse, error) {
+func (s *MayarService) GetPaymentRequest(ctx context.Context, requestID string) (*PaymentRequestResponse, error) {
 	endpoint := fmt.Sprintf("/request-payment/%s", requestID)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -319,8 +315,8 @@ func (s *MayarService) GetPaymentRequest(requestID string) (*PaymentRequestRespo
 // =============================================================================
 
 // CreateCustomer creates a new customer
-func (s *MayarService) CreateCustomer(req CreateCustomerRequest) (*CustomerResponse, error) {
-	resp, err := s.makeRequest("POST", "/customer", req)
+func (s *MayarService) CreateCustomer(ctx context.Context, req CreateCustomerRequest) (*CustomerResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/customer", req)
 	if err != nil {
 		return nil, err
 	}
@@ -334,10 +330,10 @@ func (s *MayarService) CreateCustomer(req CreateCustomerRequest) (*CustomerRespo
 }
 
 // GetCustomer retrieves a customer by ID
-func (s *MayarService) GetCustomer(customerID string) (*CustomerResponse, error) {
+func (s *MayarService) GetCustomer(ctx context.Context, customerID string) (*CustomerResponse, error) {
 	endpoint := fmt.Sprintf("/customer/%s", customerID)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -351,10 +347,10 @@ func (s *MayarService) GetCustomer(customerID string) (*CustomerResponse, error)
 }
 
 // UpdateCustomer updates an existing customer
-func (s *MayarService) UpdateCustomer(customerID string, req UpdateCustomerRequest) (*CustomerResponse, error) {
+func (s *MayarService) UpdateCustomer(ctx context.Context, customerID string, req UpdateCustomerRequest) (*CustomerResponse, error) {
 	endpoint := fmt.Sprintf("/customer/%s", customerID)
 	
-	resp, err := s.makeRequest("PUT", endpoint, req)
+	resp, err := s.makeRequest(ctx, "PUT", endpoint, req)
 	if err != nil {
 		return nil, err
 	}
@@ -372,7 +368,7 @@ func (s *MayarService) UpdateCustomer(customerID string, req UpdateCustomerReque
 // =============================================================================
 
 // GetTransactions retrieves transactions with filters
-func (s *MayarService) GetTransactions(params TransactionListParams) (*TransactionListResponse, error) {
+func (s *MayarService) GetTransactions(ctx context.Context, params TransactionListParams) (*TransactionListResponse, error) {
 	endpoint := "/transactions"
 	
 	// Build query parameters
@@ -397,7 +393,7 @@ func (s *MayarService) GetTransactions(params TransactionListParams) (*Transacti
 		endpoint += "?" + joinStrings(query, "&")
 	}
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -411,10 +407,10 @@ func (s *MayarService) GetTransactions(params TransactionListParams) (*Transacti
 }
 
 // GetTransaction retrieves a transaction by ID
-func (s *MayarService) GetTransaction(transactionID string) (*TransactionResponse, error) {
+func (s *MayarService) GetTransaction(ctx context.Context, transactionID string) (*TransactionResponse, error) {
 	endpoint := fmt.Sprintf("/transaction/%s", transactionID)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -432,8 +428,8 @@ func (s *MayarService) GetTransaction(transactionID string) (*TransactionRespons
 // =============================================================================
 
 // RegisterWebhook registers a new webhook URL
-func (s *MayarService) RegisterWebhook(req RegisterWebhookRequest) (*WebhookRegistrationResponse, error) {
-	resp, err := s.makeRequest("POST", "/webhook/register", req)
+func (s *MayarService) RegisterWebhook(ctx context.Context, req RegisterWebhookRequest) (*WebhookRegistrationResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/webhook/register", req)
 	if err != nil {
 		return nil, err
 	}
@@ -447,8 +443,8 @@ func (s *MayarService) RegisterWebhook(req RegisterWebhookRequest) (*WebhookRegi
 }
 
 // GetWebhookHistory retrieves webhook delivery history
-func (s *MayarService) GetWebhookHistory() (*WebhookHistoryResponse, error) {
-	resp, err := s.makeRequest("GET", "/webhook/history", nil)
+func (s *MayarService) GetWebhookHistory(ctx context.Context) (*WebhookHistoryResponse, error) {
+	resp, err := s.makeRequest(ctx, "GET", "/webhook/history", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -462,10 +458,10 @@ func (s *MayarService) GetWebhookHistory() (*WebhookHistoryResponse, error) {
 }
 
 // TestWebhook tests a webhook URL
-func (s *MayarService) TestWebhook(webhookID string) error {
+func (s *MayarService) TestWebhook(ctx context.Context, webhookID string) error {
 	endpoint := fmt.Sprintf("/webhook/test/%s", webhookID)
 	
-	resp, err := s.makeRequest("POST", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -474,10 +470,10 @@ func (s *MayarService) TestWebhook(webhookID string) error {
 }
 
 // RetryWebhook retries a failed webhook delivery
-func (s *MayarService) RetryWebhook(historyID string) error {
+func (s *MayarService) RetryWebhook(ctx context.Context, historyID string) error {
 	endpoint := fmt.Sprintf("/webhook/retry/%s", historyID)
 	
-	resp, err := s.makeRequest("POST", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -485,13 +481,14 @@ func (s *MayarService) RetryWebhook(historyID string) error {
 	return s.parseResponse(resp, nil)
 }
 
-// VerifyWebhookSignature verifies webhook signature
-func (s *MayarService) VerifyWebhookSignature(payload []byte, signature string) bool {
-	mac := hmac.New(sha256.New, []byte(s.config.WebhookSecret))
-	mac.Write(payload)
-	expectedSignature := hex.EncodeToString(mac.Sum(nil))
-	
-	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+// VerifyWebhook checks the signature, timestamp and event ID of an incoming webhook
+func (s *MayarService) VerifyWebhook(headers http.Header, payload []byte) (*WebhookVerification, error) {
+	return s.webhooks.Verify(headers, payload, time.Now())
+}
+
+// ForgetWebhook lets a webhook that failed to process be delivered again
+func (s *MayarService) ForgetWebhook(eventID string) {
+	s.webhooks.Forget(eventID)
 }
 
 // =============================================================================
@@ -499,8 +496,8 @@ func (s *MayarService) VerifyWebhookSignature(payload []byte, signature string)
 // =============================================================================
 
 // VerifyLicense verifies a software license
-func (s *MayarService) VerifyLicense(req VerifyLicenseRequest) (*VerifyLicenseResponse, error) {
-	resp, err := s.makeRequest("POST", "/license/verify", req)
+func (s *MayarService) VerifyLicense(ctx context.Context, req VerifyLicenseRequest) (*VerifyLicenseResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/license/verify", req)
 	if err != nil {
 		return nil, err
 	}
@@ -514,8 +511,8 @@ func (s *MayarService) VerifyLicense(req VerifyLicenseRequest) (*VerifyLicenseRe
 }
 
 // ActivateLicense activates a software license
-func (s *MayarService) ActivateLicense(req ActivateLicenseRequest) error {
-	resp, err := s.makeRequest("POST", "/license/activate", req)
+func (s *MayarService) ActivateLicense(ctx context.Context, req ActivateLicenseRequest) error {
+	resp, err := s.makeRequest(ctx, "POST", "/license/activate", req)
 	if err != nil {
 		return err
 	}
@@ -524,8 +521,8 @@ func (s *MayarService) ActivateLicense(req ActivateLicenseRequest) error {
 }
 
 // DeactivateLicense deactivates a software license
-func (s *MayarService) DeactivateLicense(req DeactivateLicenseRequest) error {
-	resp, err := s.makeRequest("POST", "/license/deactivate", req)
+func (s *MayarService) DeactivateLicense(ctx context.Context, req DeactivateLicenseRequest) error {
+	resp, err := s.makeRequest(ctx, "POST", "/license/deactivate", req)
 	if err != nil {
 		return err
 	}
@@ -538,8 +535,8 @@ func (s *MayarService) DeactivateLicense(req DeactivateLicenseRequest) error {
 // =============================================================================
 
 // CreateCoupon creates a new coupon
-func (s *MayarService) CreateCoupon(req CreateCouponRequest) (*CouponResponse, error) {
-	resp, err := s.makeRequest("POST", "/coupon", req)
+func (s *MayarService) CreateCoupon(ctx context.Context, req CreateCouponRequest) (*CouponResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/coupon", req)
 	if err != nil {
 		return nil, err
 	}
@@ -553,8 +550,8 @@ func (s *MayarService) CreateCoupon(req CreateCouponRequest) (*CouponResponse, e
 }
 
 // ApplyCoupon applies a coupon to calculate discount
-func (s *MayarService) ApplyCoupon(req ApplyCouponRequest) (*ApplyCouponResponse, error) {
-	resp, err := s.makeRequest("POST", "/coupon/apply", req)
+func (s *MayarService) ApplyCoupon(ctx context.Context, req ApplyCouponRequest) (*ApplyCouponResponse, error) {
+	resp, err := s.makeRequest(ctx, "POST", "/coupon/apply", req)
 	if err != nil {
 		return nil, err
 	}
@@ -568,10 +565,10 @@ func (s *MayarService) ApplyCoupon(req ApplyCouponRequest) (*ApplyCouponResponse
 }
 
 // GetCoupon retrieves a coupon by code
-func (s *MayarService) GetCoupon(couponCode string) (*CouponResponse, error) {
+func (s *MayarService) GetCoupon(ctx context.Context, couponCode string) (*CouponResponse, error) {
 	endpoint := fmt.Sprintf("/coupon/%s", couponCode)
 	
-	resp, err := s.makeRequest("GET", endpoint, nil)
+	resp, err := s.makeRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +54,7 @@ type Invoice struct {
 	ID          string    `json:"id"`
 	Number      string    `json:"number"`
 	CustomerID  string    `json:"customerId"`
-	Amount      float64   `json:"amount"`
+	Amount      Money     `json:"amount"`
 	Currency    string    `json:"currency"`
 	Status      string    `json:"status"`
 	DueDate     time.Time `json:"dueDate"`
@@ -68,8 +68,8 @@ type InvoiceItem struct {
 	ProductID   string  `json:"productId"`
 	ProductName string  `json:"productName"`
 	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unitPrice"`
-	TotalPrice  float64 `json:"totalPrice"`
+	UnitPrice   Money   `json:"unitPrice"`
+	TotalPrice  Money   `json:"totalPrice"`
 }
 
 // CreateInvoiceRequest represents request to create invoice
@@ -100,23 +100,28 @@ type InvoiceResponse struct {
 // PaymentRequest represents a payment request
 type PaymentRequest struct {
 	ID          string    `json:"id"`
-	Amount      float64   `json:"amount"`
+	Amount      Money     `json:"amount"`
 	Currency    string    `json:"currency"`
 	Description string    `json:"description"`
 	Status      string    `json:"status"`
 	PaymentURL  string    `json:"paymentUrl"`
 	ExpiresAt   time.Time `json:"expiresAt"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 // CreatePaymentRequestRequest represents request to create payment request
 type CreatePaymentRequestRequest struct {
-	Amount      float64   `json:"amount"`
+	Amount      Money     `json:"amount"`
 	Currency    string    `json:"currency"`
 	Description string    `json:"description"`
 	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
 	CallbackURL string    `json:"callbackUrl,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Mobile      string    `json:"mobile,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
 // PaymentRequestResponse represents response for payment request operations
@@ -170,7 +175,7 @@ type CustomerResponse struct {
 type Transaction struct {
 	ID            string    `json:"id"`
 	Type          string    `json:"type"`
-	Amount        float64   `json:"amount"`
+	Amount        Money     `json:"amount"`
 	Currency      string    `json:"currency"`
 	Status        string    `json:"status"`
 	Description   string    `json:"description"`
@@ -286,14 +291,15 @@ type DeactivateLicenseRequest struct {
 // COUPON TYPES
 // =============================================================================
 
-// Coupon represents a discount coupon
+// Coupon represents a discount coupon. Value is a rupiah amount for fixed
+// coupons and a whole percentage for percentage coupons.
 type Coupon struct {
 	ID           string    `json:"id"`
 	Code         string    `json:"code"`
 	Type         string    `json:"type"`
-	Value        float64   `json:"value"`
-	MinAmount    float64   `json:"minAmount"`
-	MaxDiscount  float64   `json:"maxDiscount"`
+	Value        Money     `json:"value"`
+	MinAmount    Money     `json:"minAmount"`
+	MaxDiscount  Money     `json:"maxDiscount"`
 	UsageLimit   int       `json:"usageLimit"`
 	UsageCount   int       `json:"usageCount"`
 	ExpiresAt    time.Time `json:"expiresAt"`
@@ -306,9 +312,9 @@ type Coupon struct {
 type CreateCouponRequest struct {
 	Code        string    `json:"code"`
 	Type        string    `json:"type"`
-	Value       float64   `json:"value"`
-	MinAmount   float64   `json:"minAmount,omitempty"`
-	MaxDiscount float64   `json:"maxDiscount,omitempty"`
+	Value       Money     `json:"value"`
+	MinAmount   Money     `json:"minAmount,omitzero"`
+	MaxDiscount Money     `json:"maxDiscount,omitzero"`
 	UsageLimit  int       `json:"usageLimit,omitempty"`
 	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
 }
@@ -316,19 +322,19 @@ type CreateCouponRequest struct {
 // ApplyCouponRequest represents request to apply coupon
 type ApplyCouponRequest struct {
 	CouponCode string  `json:"couponCode"`
-	Amount     float64 `json:"amount"`
+	Amount     Money   `json:"amount"`
 }
 
 // ApplyCouponResponse represents response for applying coupon
 type ApplyCouponResponse struct {
 	Success        bool    `json:"success"`
 	IsValid        bool    `json:"isValid"`
-	DiscountAmount float64 `json:"discountAmount"`
-	FinalAmount    float64 `json:"finalAmount"`
+	DiscountAmount Money   `json:"discountAmount"`
+	FinalAmount    Money   `json:"finalAmount"`
 	Data           struct {
 		IsValid        bool    `json:"isValid"`
-		DiscountAmount float64 `json:"discountAmount"`
-		FinalAmount    float64 `json:"finalAmount"`
+		DiscountAmount Money   `json:"discountAmount"`
+		FinalAmount    Money   `json:"finalAmount"`
 	} `json:"data"`
 }
 
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook headers sent by Mayar (and by FakeMayarServer)
+const (
+	MayarSignatureHeader = "X-Mayar-Signature"
+	MayarTimestampHeader = "X-Mayar-Timestamp"
+)
+
+// Webhook rejection reasons, used in security logs and metrics
+const (
+	WebhookRejectMissingSignature = "missing_signature"
+	WebhookRejectInvalidSignature = "invalid_signature"
+	WebhookRejectMissingTimestamp = "missing_timestamp"
+	WebhookRejectInvalidTimestamp = "invalid_timestamp"
+	WebhookRejectStaleTimestamp   = "stale_timestamp"
+	WebhookRejectReplayed         = "replayed"
+)
+
+// WebhookSecret is a signing secret accepted between ActiveFrom and ActiveUntil.
+// Zero times leave the window open on that side.
+type WebhookSecret struct {
+	ID          string
+	Value       string
+	ActiveFrom  time.Time
+	ActiveUntil time.Time
+}
+
+// activeAt reports whether the secret is accepted at t
+func (s WebhookSecret) activeAt(t time.Time) bool {
+	if !s.ActiveFrom.IsZero() && t.Before(s.ActiveFrom) {
+		return false
+	}
+	if !s.ActiveUntil.IsZero() && !t.Before(s.ActiveUntil) {
+		return false
+	}
+	return true
+}
+
+// WebhookSecurityConfig holds webhook verification settings
+type WebhookSecurityConfig struct {
+	// Secrets are tried in order; several may be active while a secret is rotated
+	Secrets []WebhookSecret
+	// TimestampTolerance is how far a signed timestamp may drift from now
+	TimestampTolerance time.Duration
+	// RequireTimestamp rejects webhooks without a signed timestamp
+	RequireTimestamp bool
+	// ReplayTTL is how long seen event IDs are remembered
+	ReplayTTL time.Duration
+}
+
+// parseWebhookSecrets parses MAYAR_WEBHOOK_SECRETS entries of the form
+// "id,secret[,activeFrom[,activeUntil]]" separated by semicolons, with RFC 3339 times
+func parseWebhookSecrets(value string) ([]WebhookSecret, error) {
+	var secrets []WebhookSecret
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ",")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid webhook secret entry %q", parts[0])
+		}
+
+		secret := WebhookSecret{ID: strings.TrimSpace(parts[0]), Value: strings.TrimSpace(parts[1])}
+		if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
+			activeFrom, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[2]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid activeFrom for webhook secret %q: %w", secret.ID, err)
+			}
+			secret.ActiveFrom = activeFrom
+		}
+		if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
+			activeUntil, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[3]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid activeUntil for webhook secret %q: %w", secret.ID, err)
+			}
+			secret.ActiveUntil = activeUntil
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// SignMayarWebhook returns the signature for a webhook body. A non-empty
+// timestamp is signed together with the body as "timestamp.body".
+func SignMayarWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	if timestamp != "" {
+		mac.Write([]byte(timestamp))
+		mac.Write([]byte("."))
+	}
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookVerificationError explains why a webhook was rejected
+type WebhookVerificationError struct {
+	Reason string
+	Detail string
+}
+
+func (e *WebhookVerificationError) Error() string {
+	return fmt.Sprintf("webhook rejected (%s): %s", e.Reason, e.Detail)
+}
+
+// WebhookVerification describes an accepted webhook
+type WebhookVerification struct {
+	SecretID string
+	EventID  string
+}
+
+// WebhookMetrics counts accepted and rejected webhooks
+type WebhookMetrics struct {
+	Accepted        int64            `json:"accepted"`
+	Rejected        map[string]int64 `json:"rejected"`
+	ReplayCacheSize int              `json:"replayCacheSize"`
+}
+
+// webhookVerifier checks webhook signatures, timestamps and replays
+type webhookVerifier struct {
+	config WebhookSecurityConfig
+	replay *webhookReplayCache
+
+	mu       sync.Mutex
+	accepted int64
+	rejected map[string]int64
+}
+
+// newWebhookVerifier creates a verifier for the given configuration
+func newWebhookVerifier(config WebhookSecurityConfig) *webhookVerifier {
+	return &webhookVerifier{
+		config:   config,
+		replay:   newWebhookReplayCache(config.ReplayTTL),
+		rejected: make(map[string]int64),
+	}
+}
+
+// Verify checks a webhook request. Signatures are checked against every secret
+// active now; a present timestamp must be signed and within tolerance; the event
+// ID (payload eventId or id, or body hash) must not have been seen before. An
+// accepted event ID is remembered at once, so a concurrent retry is refused
+// while the first delivery is processed; Forget releases it when that fails.
+func (v *webhookVerifier) Verify(headers http.Header, body []byte, now time.Time) (*WebhookVerification, error) {
+	verification, err := v.verify(headers, body, now)
+
+	v.mu.Lock()
+	if err != nil {
+		if verifyErr, ok := err.(*WebhookVerificationError); ok {
+			v.rejected[verifyErr.Reason]++
+		}
+	} else {
+		v.accepted++
+	}
+	v.mu.Unlock()
+
+	return verification, err
+}
+
+func (v *webhookVerifier) verify(headers http.Header, body []byte, now time.Time) (*WebhookVerification, error) {
+	signature := headers.Get(MayarSignatureHeader)
+	if signature == "" {
+		return nil, &WebhookVerificationError{Reason: WebhookRejectMissingSignature, Detail: "signature header is missing"}
+	}
+
+	timestamp := headers.Get(MayarTimestampHeader)
+	if timestamp == "" {
+		if v.config.RequireTimestamp {
+			return nil, &WebhookVerificationError{Reason: WebhookRejectMissingTimestamp, Detail: "timestamp header is missing"}
+		}
+	} else {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, &WebhookVerificationError{Reason: WebhookRejectInvalidTimestamp, Detail: "timestamp is not a unix time"}
+		}
+		drift := now.Sub(time.Unix(unix, 0))
+		if drift < 0 {
+			drift = -drift
+		}
+		if drift > v.config.TimestampTolerance {
+			return nil, &WebhookVerificationError{
+				Reason: WebhookRejectStaleTimestamp,
+				Detail: fmt.Sprintf("timestamp is %s away from server time", drift.Round(time.Second)),
+			}
+		}
+	}
+
+	secretID := ""
+	for _, secret := range v.config.Secrets {
+		if !secret.activeAt(now) {
+			continue
+		}
+		expected := SignMayarWebhook(secret.Value, timestamp, body)
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			secretID = secret.ID
+			break
+		}
+	}
+	if secretID == "" {
+		return nil, &WebhookVerificationError{Reason: WebhookRejectInvalidSignature, Detail: "signature does not match any active secret"}
+	}
+
+	// Only authentic webhooks reach the replay cache, so it cannot be filled by forgeries
+	eventID := webhookEventID(body)
+	if v.replay.Seen(eventID, now) {
+		return &WebhookVerification{SecretID: secretID, EventID: eventID},
+			&WebhookVerificationError{Reason: WebhookRejectReplayed, Detail: "event was already received"}
+	}
+
+	return &WebhookVerification{SecretID: secretID, EventID: eventID}, nil
+}
+
+// webhookEventID identifies a webhook for replay detection: an eventId or id
+// field in the payload, then a hash of the body. Only the signed body is used;
+// a header could be changed to slip a captured webhook past the cache.
+func webhookEventID(body []byte) string {
+	var envelope struct {
+		ID      string `json:"id"`
+		EventID string `json:"eventId"`
+	}
+	if json.Unmarshal(body, &envelope) == nil {
+		if envelope.EventID != "" {
+			return envelope.EventID
+		}
+		if envelope.ID != "" {
+			return envelope.ID
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Forget drops an accepted event ID from the replay cache, so a retry of a
+// webhook that could not be processed is accepted again
+func (v *webhookVerifier) Forget(eventID string) {
+	v.replay.Forget(eventID)
+}
+
+// Metrics returns webhook verification counters
+func (v *webhookVerifier) Metrics() WebhookMetrics {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	rejected := make(map[string]int64, len(v.rejected))
+	for reason, count := range v.rejected {
+		rejected[reason] = count
+	}
+	return WebhookMetrics{
+		Accepted:        v.accepted,
+		Rejected:        rejected,
+		ReplayCacheSize: v.replay.Len(),
+	}
+}
+
+// ActiveSecretIDs returns the IDs of secrets accepted at t, for diagnostics
+func (v *webhookVerifier) ActiveSecretIDs(t time.Time) []string {
+	var ids []string
+	for _, secret := range v.config.Secrets {
+		if secret.activeAt(t) {
+			ids = append(ids, secret.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// webhookReplayCache remembers event IDs until they expire
+type webhookReplayCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]time.Time
+	lastPrune time.Time
+}
+
+// newWebhookReplayCache creates a cache remembering IDs for ttl
+func newWebhookReplayCache(ttl time.Duration) *webhookReplayCache {
+	return &webhookReplayCache{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Seen records id and reports whether it was already recorded and not yet expired
+func (c *webhookReplayCache) Seen(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > time.Minute {
+		for key, expiresAt := range c.entries {
+			if now.After(expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if expiresAt, ok := c.entries[id]; ok && now.Before(expiresAt) {
+		return true
+	}
+	c.entries[id] = now.Add(c.ttl)
+	return false
+}
+
+// Forget removes id from the cache
+func (c *webhookReplayCache) Forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, id)
+}
+
+// Len returns the number of remembered IDs
+func (c *webhookReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
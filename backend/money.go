@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of every amount the shop handles
+const DefaultCurrency = "IDR"
+
+// Money is an amount in the currency's smallest unit used by the business.
+// For rupiah that is whole rupiah: amounts are never fractional, so exact
+// comparisons (such as matching a bank transfer by amount) are safe.
+//
+// Money scans from DECIMAL/NUMERIC columns, is stored as an integer, and
+// encodes to JSON as a plain number so API responses keep their shape.
+// The zero value is zero rupiah.
+type Money struct {
+	amount   int64
+	currency string
+}
+
+// NewMoney creates an amount in the given currency; an empty currency means DefaultCurrency
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{amount: amount, currency: strings.ToUpper(currency)}
+}
+
+// Rupiah creates an amount in rupiah
+func Rupiah(amount int64) Money {
+	return Money{amount: amount, currency: DefaultCurrency}
+}
+
+// MoneyFromFloat converts a floating point amount, rounding half away from zero.
+// It is meant for boundaries that only offer floats, such as parsed user input.
+func MoneyFromFloat(amount float64, currency string) Money {
+	return NewMoney(int64(math.Round(amount)), currency)
+}
+
+// ParseMoney parses a decimal string such as "150000" or "150000.00",
+// rounding any fraction half away from zero
+func ParseMoney(value, currency string) (Money, error) {
+	amount, err := parseDecimalAmount(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(amount, currency), nil
+}
+
+// parseDecimalAmount parses a decimal string of the form [+-]digits[.digits]
+// into whole units without going through float64
+func parseDecimalAmount(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+
+	digits := value
+	negative := false
+	if digits != "" && (digits[0] == '-' || digits[0] == '+') {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	if !isDigits(whole) || (hasFraction && !isDigits(fraction)) {
+		return 0, fmt.Errorf("invalid money amount %q", value)
+	}
+	amount, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money amount %q: %w", value, err)
+	}
+	if hasFraction && fraction[0] >= '5' {
+		if amount == math.MaxInt64 {
+			return 0, fmt.Errorf("invalid money amount %q: out of range", value)
+		}
+		amount++
+	}
+
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Int64 returns the amount in whole units
+func (m Money) Int64() int64 {
+	return m.amount
+}
+
+// Float64 returns the amount as a float, for display and reporting only
+func (m Money) Float64() float64 {
+	return float64(m.amount)
+}
+
+// Currency returns the ISO 4217 currency code
+func (m Money) Currency() string {
+	if m.currency == "" {
+		return DefaultCurrency
+	}
+	return m.currency
+}
+
+// String formats the amount as "IDR 150000"
+func (m Money) String() string {
+	return fmt.Sprintf("%s %d", m.Currency(), m.amount)
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.amount > 0
+}
+
+// IsNegative reports whether the amount is less than zero
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+// mustMatch panics when two amounts are in different currencies; mixing
+// currencies is a programming error, not a runtime condition
+func (m Money) mustMatch(other Money) {
+	if m.Currency() != other.Currency() {
+		panic(fmt.Sprintf("money: currency mismatch %s and %s", m.Currency(), other.Currency()))
+	}
+}
+
+// Equal reports whether both amounts and currencies are equal
+func (m Money) Equal(other Money) bool {
+	return m.amount == other.amount && m.Currency() == other.Currency()
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than other
+func (m Money) Cmp(other Money) int {
+	m.mustMatch(other)
+	switch {
+	case m.amount < other.amount:
+		return -1
+	case m.amount > other.amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// GreaterThan reports whether m is greater than other
+func (m Money) GreaterThan(other Money) bool {
+	return m.Cmp(other) > 0
+}
+
+// LessThan reports whether m is less than other
+func (m Money) LessThan(other Money) bool {
+	return m.Cmp(other) < 0
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) Money {
+	m.mustMatch(other)
+	return Money{amount: m.amount + other.amount, currency: m.Currency()}
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) Money {
+	m.mustMatch(other)
+	return Money{amount: m.amount - other.amount, currency: m.Currency()}
+}
+
+// Mul returns m multiplied by a quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{amount: m.amount * quantity, currency: m.Currency()}
+}
+
+// Min returns the smaller of m and other
+func (m Money) Min(other Money) Money {
+	if other.LessThan(m) {
+		return other
+	}
+	return m
+}
+
+// Percent returns basisPoints/10000 of m (1100 is 11%), rounded half away from zero
+func (m Money) Percent(basisPoints int64) Money {
+	product := m.amount * basisPoints
+	quotient, remainder := product/10000, product%10000
+	if remainder >= 5000 {
+		quotient++
+	} else if remainder <= -5000 {
+		quotient--
+	}
+	return Money{amount: quotient, currency: m.Currency()}
+}
+
+// Tax returns the tax on m at rateBasisPoints (1100 for 11% PPN)
+func (m Money) Tax(rateBasisPoints int64) Money {
+	return m.Percent(rateBasisPoints)
+}
+
+// Discount returns a percentage discount on m at rateBasisPoints, capped at
+// maxDiscount when it is positive and never more than m itself
+func (m Money) Discount(rateBasisPoints int64, maxDiscount Money) Money {
+	discount := m.Percent(rateBasisPoints)
+	if maxDiscount.IsPositive() {
+		discount = discount.Min(maxDiscount)
+	}
+	return discount.Min(m)
+}
+
+// MarshalJSON encodes the amount as a plain JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(m.amount, 10)), nil
+}
+
+// UnmarshalJSON accepts a JSON number or numeric string, rounding any fraction.
+// This is also the conversion point for amounts received from Mayar, which
+// sends them as JSON numbers.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*m = Money{}
+		return nil
+	}
+
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+
+	if strings.ContainsAny(text, "eE") {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid money amount %q: %w", text, err)
+		}
+		if math.Abs(f) >= math.MaxInt64 {
+			return fmt.Errorf("invalid money amount %q: out of range", text)
+		}
+		*m = MoneyFromFloat(f, m.currency)
+		return nil
+	}
+
+	amount, err := parseDecimalAmount(text)
+	if err != nil {
+		return err
+	}
+	*m = NewMoney(amount, m.currency)
+	return nil
+}
+
+// Scan implements sql.Scanner for DECIMAL, NUMERIC and integer columns
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Rupiah(0)
+	case int64:
+		*m = Rupiah(v)
+	case float64:
+		*m = MoneyFromFloat(v, DefaultCurrency)
+	case []byte:
+		amount, err := parseDecimalAmount(string(v))
+		if err != nil {
+			return err
+		}
+		*m = Rupiah(amount)
+	case string:
+		amount, err := parseDecimalAmount(v)
+		if err != nil {
+			return err
+		}
+		*m = Rupiah(amount)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer; amounts are stored as whole units
+func (m Money) Value() (driver.Value, error) {
+	return m.amount, nil
+}
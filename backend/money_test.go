@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDecimalAmount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "150000", want: 150000},
+		{value: "150000.00", want: 150000},
+		{value: " 150000.49 ", want: 150000},
+		{value: "150000.5", want: 150001},
+		{value: "+42", want: 42},
+		{value: "-42.5", want: -43},
+		{value: "-0.4", want: 0},
+		{value: "0", want: 0},
+		{value: "9223372036854775807", want: 9223372036854775807},
+		{value: "", wantErr: true},
+		{value: "-", wantErr: true},
+		{value: "+", wantErr: true},
+		{value: ".", wantErr: true},
+		{value: ".5", wantErr: true},
+		{value: "5.", wantErr: true},
+		{value: "--5", wantErr: true},
+		{value: "+-5", wantErr: true},
+		{value: "-+5", wantErr: true},
+		{value: "1.2.3", wantErr: true},
+		{value: "1,000", wantErr: true},
+		{value: "12a", wantErr: true},
+		{value: "1.5x", wantErr: true},
+		{value: "1 000", wantErr: true},
+		{value: "9223372036854775808", wantErr: true},
+		{value: "9223372036854775807.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDecimalAmount(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDecimalAmount(%q) = %d, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseDecimalAmount(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyPercent(t *testing.T) {
+	tests := []struct {
+		amount      int64
+		basisPoints int64
+		want        int64
+	}{
+		{amount: 1000000, basisPoints: 1100, want: 110000},
+		{amount: 150, basisPoints: 1000, want: 15},
+		{amount: 5, basisPoints: 1000, want: 1},   // 0.5 rounds up
+		{amount: 4, basisPoints: 1000, want: 0},   // 0.4 rounds down
+		{amount: -5, basisPoints: 1000, want: -1}, // away from zero
+		{amount: -4, basisPoints: 1000, want: 0},
+		{amount: 1000001, basisPoints: 3000, want: 300000},
+		{amount: 999, basisPoints: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := Rupiah(tt.amount).Percent(tt.basisPoints); got.Int64() != tt.want {
+			t.Errorf("Rupiah(%d).Percent(%d) = %d, want %d", tt.amount, tt.basisPoints, got.Int64(), tt.want)
+		}
+	}
+}
+
+func TestMoneyDiscount(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      int64
+		basisPoints int64
+		max         int64
+		want        int64
+	}{
+		{name: "uncapped", amount: 200000, basisPoints: 1500, want: 30000},
+		{name: "rounded", amount: 333, basisPoints: 1500, want: 50},
+		{name: "below the cap", amount: 200000, basisPoints: 1500, max: 50000, want: 30000},
+		{name: "capped", amount: 200000, basisPoints: 5000, max: 50000, want: 50000},
+		{name: "never more than the amount", amount: 1000, basisPoints: 15000, want: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Rupiah(tt.amount).Discount(tt.basisPoints, Rupiah(tt.max))
+			if got.Int64() != tt.want {
+				t.Fatalf("Discount = %d, want %d", got.Int64(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    int64
+		wantErr bool
+	}{
+		{json: `150000`, want: 150000},
+		{json: `150000.5`, want: 150001},
+		{json: `"150000.00"`, want: 150000},
+		{json: `-2500`, want: -2500},
+		{json: `1.5e5`, want: 150000},
+		{json: `"2E3"`, want: 2000},
+		{json: `null`, want: 0},
+		{json: `""`, wantErr: true},
+		{json: `"-"`, wantErr: true},
+		{json: `"."`, wantErr: true},
+		{json: `"--5"`, wantErr: true},
+		{json: `"+-5"`, wantErr: true},
+		{json: `"Rp 150000"`, wantErr: true},
+		{json: `1e30`, wantErr: true},
+		{json: `true`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tt.json), &m)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %s, want an error", tt.json, m)
+			}
+			continue
+		}
+		if err != nil || !m.Equal(Rupiah(tt.want)) {
+			t.Errorf("Unmarshal(%s) = %s, %v, want %s", tt.json, m, err, Rupiah(tt.want))
+		}
+	}
+}
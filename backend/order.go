@@ -20,20 +20,24 @@ type OrderModel struct {
 
 	// Order details
 	Status         string  `json:"status" db:"status"`
-	TotalAmount    float64 `json:"totalAmount" db:"total_amount"`
-	Subtotal       float64 `json:"subtotal" db:"subtotal"`
-	TaxAmount      float64 `json:"taxAmount" db:"tax_amount"`
-	DiscountAmount float64 `json:"discountAmount" db:"discount_amount"`
-	HandlingFee    float64 `json:"handlingFee" db:"handling_fee"`
+	TotalAmount    Money   `json:"totalAmount" db:"total_amount"`
+	Subtotal       Money   `json:"subtotal" db:"subtotal"`
+	TaxAmount      Money   `json:"taxAmount" db:"tax_amount"`
+	DiscountAmount Money   `json:"discountAmount" db:"discount_amount"`
+	HandlingFee    Money   `json:"handlingFee" db:"handling_fee"`
 	UniqueCode     *int    `json:"uniqueCode" db:"unique_code"`
 
 	// Payment details
+	PaymentGateway *string   `json:"paymentGateway" db:"payment_gateway"`
 	PaymentMethod *string    `json:"paymentMethod" db:"payment_method"`
 	PaymentStatus string     `json:"paymentStatus" db:"payment_status"`
 	PaymentToken  *string    `json:"paymentToken" db:"payment_token"`
 	PaymentURL    *string    `json:"paymentUrl" db:"payment_url"`
 	PaidAt        *time.Time `json:"paidAt" db:"paid_at"`
 
+	// PaymentExpiresAt is when the current payment link stops accepting payments
+	PaymentExpiresAt *time.Time `json:"paymentExpiresAt" db:"payment_expires_at"`
+
 	// Order metadata
 	Notes      *string `json:"notes" db:"notes"`
 	AdminNotes *string `json:"adminNotes" db:"admin_notes"`
@@ -48,6 +52,8 @@ type OrderModel struct {
 
 	// Related data
 	Items []OrderItem `json:"items,omitempty"`
+	// Installments is the payment schedule; empty when the order is paid in full
+	Installments []OrderInstallment `json:"installments,omitempty"`
 }
 
 // OrderItem represents an item in an order
@@ -61,8 +67,8 @@ type OrderItem struct {
 	ItemName        string  `json:"itemName" db:"item_name"`
 	ItemDescription *string `json:"itemDescription" db:"item_description"`
 	Quantity        int     `json:"quantity" db:"quantity"`
-	UnitPrice       float64 `json:"unitPrice" db:"unit_price"`
-	TotalPrice      float64 `json:"totalPrice" db:"total_price"`
+	UnitPrice       Money   `json:"unitPrice" db:"unit_price"`
+	TotalPrice      Money   `json:"totalPrice" db:"total_price"`
 
 	// Service specific fields
 	BriefDetails  *string    `json:"briefDetails" db:"brief_details"`
@@ -84,6 +90,20 @@ type OrderStatusHistory struct {
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
+// OrderFile represents a brief, reference, deliverable or revision attached to an order
+type OrderFile struct {
+	ID           string    `json:"id" db:"id"`
+	OrderID      string    `json:"orderId" db:"order_id"`
+	OrderItemID  *string   `json:"orderItemId" db:"order_item_id"`
+	FileName     string    `json:"fileName" db:"file_name"`
+	FilePath     string    `json:"filePath" db:"file_path"`
+	FileType     *string   `json:"fileType" db:"file_type"`
+	FileSize     *int64    `json:"fileSize" db:"file_size"`
+	FileCategory *string   `json:"fileCategory" db:"file_category"`
+	UploadedBy   *string   `json:"uploadedBy" db:"uploaded_by"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
 // CreateOrderRequest represents the request to create a new order
 type CreateOrderRequest struct {
 	CustomerName    string             `json:"customerName" binding:"required"`
@@ -92,6 +112,10 @@ type CreateOrderRequest struct {
 	CustomerAddress *string            `json:"customerAddress"`
 	Notes           *string            `json:"notes"`
 	Items           []OrderItemRequest `json:"items" binding:"required,min=1"`
+	// PaymentGateway selects the gateway for this checkout; empty uses the default
+	PaymentGateway  string             `json:"paymentGateway"`
+	// PaymentSchedule splits payment into installments; when empty the products' deposit applies
+	PaymentSchedule *PaymentScheduleRequest `json:"paymentSchedule"`
 }
 
 // OrderItemRequest represents an item in the create order request
@@ -101,7 +125,7 @@ type OrderItemRequest struct {
 	ItemName        string  `json:"itemName" binding:"required"`
 	ItemDescription *string `json:"itemDescription"`
 	Quantity        int     `json:"quantity" binding:"required,min=1"`
-	UnitPrice       float64 `json:"unitPrice" binding:"required,min=0"`
+	UnitPrice       Money   `json:"unitPrice"` // must not be negative, checked by the handler
 	BriefDetails    *string `json:"briefDetails"`
 	DeliveryDate    *string `json:"deliveryDate"` // ISO date string
 }
@@ -152,9 +176,9 @@ func (r *OrderRepository) CreateOrder(req *CreateOrderRequest) (*OrderModel, err
 	orderNumber := fmt.Sprintf("ORD-%s", time.Now().Format("20060102150405"))
 
 	// Calculate totals
-	var subtotal float64
+	subtotal := Rupiah(0)
 	for _, item := range req.Items {
-		subtotal += item.UnitPrice * float64(item.Quantity)
+		subtotal = subtotal.Add(item.UnitPrice.Mul(int64(item.Quantity)))
 	}
 
 	// For now, no tax or handling fee
@@ -163,15 +187,20 @@ func (r *OrderRepository) CreateOrder(req *CreateOrderRequest) (*OrderModel, err
 	// Insert order
 	orderQuery := `
 		INSERT INTO orders (order_number, customer_name, customer_email, customer_phone, customer_address, 
-		                   status, subtotal, total_amount, notes, priority, source)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		                   status, subtotal, total_amount, notes, priority, source, payment_gateway)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING id, created_at, updated_at
 	`
 
+	var paymentGateway *string
+	if req.PaymentGateway != "" {
+		paymentGateway = &req.PaymentGateway
+	}
+
 	var order OrderModel
 	err = tx.QueryRow(orderQuery, orderNumber, req.CustomerName, req.CustomerEmail,
 		req.CustomerPhone, req.CustomerAddress, "pending", subtotal, totalAmount,
-		req.Notes, "normal", "website").Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+		req.Notes, "normal", "website", paymentGateway).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create order: %w", err)
 	}
@@ -189,11 +218,12 @@ func (r *OrderRepository) CreateOrder(req *CreateOrderRequest) (*OrderModel, err
 	order.Priority = "normal"
 	order.Source = "website"
 	order.PaymentStatus = "pending"
+	order.PaymentGateway = paymentGateway
 
 	// Insert order items
 	for _, itemReq := range req.Items {
 		itemID := uuid.New().String()
-		totalPrice := itemReq.UnitPrice * float64(itemReq.Quantity)
+		totalPrice := itemReq.UnitPrice.Mul(int64(itemReq.Quantity))
 
 		var deliveryDate *time.Time
 		if itemReq.DeliveryDate != nil && *itemReq.DeliveryDate != "" {
@@ -242,6 +272,30 @@ func (r *OrderRepository) CreateOrder(req *CreateOrderRequest) (*OrderModel, err
 		order.Items = append(order.Items, item)
 	}
 
+	// Split payment into installments when requested or when a product asks for a deposit
+	schedule := req.PaymentSchedule
+	if schedule.IsEmpty() {
+		var productIDs []string
+		for _, itemReq := range req.Items {
+			if itemReq.ProductID != nil {
+				productIDs = append(productIDs, *itemReq.ProductID)
+			}
+		}
+		depositPercent, depositErr := productDepositPercent(tx, productIDs)
+		if depositErr != nil {
+			return nil, depositErr
+		}
+		schedule = &PaymentScheduleRequest{DepositPercent: depositPercent}
+	}
+	installments, err := BuildInstallments(totalAmount, schedule)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.insertInstallments(tx, order.ID, installments); err != nil {
+		return nil, err
+	}
+	order.Installments = installments
+
 	// Add initial status history
 	err = r.addStatusHistory(tx, order.ID, "pending", nil, nil)
 	if err != nil {
@@ -261,7 +315,7 @@ func (r *OrderRepository) GetAllOrders(limit int, offset int) ([]OrderModel, err
 	query := `
 		SELECT id, order_number, customer_name, customer_email, customer_phone, customer_address,
 		       status, total_amount, subtotal, tax_amount, discount_amount, handling_fee, unique_code,
-		       payment_method, payment_status, payment_token, payment_url, paid_at,
+		       payment_gateway, payment_method, payment_status, payment_token, payment_url, paid_at, payment_expires_at,
 		       notes, admin_notes, priority, source,
 		       created_at, updated_at, completed_at, cancelled_at
 		FROM orders
@@ -283,8 +337,8 @@ func (r *OrderRepository) GetAllOrders(limit int, offset int) ([]OrderModel, err
 			&order.ID, &order.OrderNumber, &order.CustomerName, &order.CustomerEmail,
 			&order.CustomerPhone, &order.CustomerAddress, &order.Status, &order.TotalAmount,
 			&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.HandlingFee,
-			&order.UniqueCode, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
-			&order.PaymentURL, &order.PaidAt, &order.Notes, &order.AdminNotes, &order.Priority,
+			&order.UniqueCode, &order.PaymentGateway, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
+			&order.PaymentURL, &order.PaidAt, &order.PaymentExpiresAt, &order.Notes, &order.AdminNotes, &order.Priority,
 			&order.Source, &order.CreatedAt, &order.UpdatedAt, &order.CompletedAt, &order.CancelledAt,
 		)
 		if scanErr != nil {
@@ -302,7 +356,7 @@ func (r *OrderRepository) GetOrderByID(id string) (*OrderModel, error) {
 	orderQuery := `
 		SELECT id, order_number, customer_name, customer_email, customer_phone, customer_address,
 		       status, total_amount, subtotal, tax_amount, discount_amount, handling_fee, unique_code,
-		       payment_method, payment_status, payment_token, payment_url, paid_at,
+		       payment_gateway, payment_method, payment_status, payment_token, payment_url, paid_at, payment_expires_at,
 		       notes, admin_notes, priority, source,
 		       created_at, updated_at, completed_at, cancelled_at
 		FROM orders
@@ -314,8 +368,8 @@ func (r *OrderRepository) GetOrderByID(id string) (*OrderModel, error) {
 		&order.ID, &order.OrderNumber, &order.CustomerName, &order.CustomerEmail,
 		&order.CustomerPhone, &order.CustomerAddress, &order.Status, &order.TotalAmount,
 		&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.HandlingFee,
-		&order.UniqueCode, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
-		&order.PaymentURL, &order.PaidAt, &order.Notes, &order.AdminNotes, &order.Priority,
+		&order.UniqueCode, &order.PaymentGateway, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
+		&order.PaymentURL, &order.PaidAt, &order.PaymentExpiresAt, &order.Notes, &order.AdminNotes, &order.Priority,
 		&order.Source, &order.CreatedAt, &order.UpdatedAt, &order.CompletedAt, &order.CancelledAt,
 	)
 	if err != nil {
@@ -352,6 +406,12 @@ func (r *OrderRepository) GetOrderByID(id string) (*OrderModel, error) {
 		order.Items = append(order.Items, item)
 	}
 
+	installments, err := r.GetInstallments(id)
+	if err != nil {
+		return nil, err
+	}
+	order.Installments = installments
+
 	return &order, nil
 }
 
@@ -378,6 +438,15 @@ func (r *OrderRepository) UpdateOrderStatus(id string, req *UpdateOrderStatusReq
 		}
 	}()
 
+	// Work and delivery wait for the deposit and the balance of scheduled orders
+	installments, err := getInstallments(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkScheduleAllowsStatus(installments, req.Status); err != nil {
+		return err
+	}
+
 	// Update order status
 	var completedAt, cancelledAt *time.Time
 	now := time.Now()
@@ -445,12 +514,71 @@ func (r *OrderRepository) addStatusHistory(tx *sql.Tx, orderID, status string, n
 	return err
 }
 
+// GetStatusHistory returns an order's status changes, oldest first
+func (r *OrderRepository) GetStatusHistory(orderID string) ([]OrderStatusHistory, error) {
+	query := `
+		SELECT id, order_id, status, notes, changed_by, created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]OrderStatusHistory, 0)
+	for rows.Next() {
+		var entry OrderStatusHistory
+		if err := rows.Scan(&entry.ID, &entry.OrderID, &entry.Status, &entry.Notes, &entry.ChangedBy, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order status history: %w", err)
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+// GetOrderFiles returns the files attached to an order, oldest first
+func (r *OrderRepository) GetOrderFiles(orderID string) ([]OrderFile, error) {
+	query := `
+		SELECT id, order_id, order_item_id, file_name, file_path, file_type, file_size,
+		       file_category, uploaded_by, created_at
+		FROM order_files
+		WHERE order_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order files: %w", err)
+	}
+	defer rows.Close()
+
+	files := make([]OrderFile, 0)
+	for rows.Next() {
+		var file OrderFile
+		err := rows.Scan(
+			&file.ID, &file.OrderID, &file.OrderItemID, &file.FileName, &file.FilePath, &file.FileType,
+			&file.FileSize, &file.FileCategory, &file.UploadedBy, &file.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order file: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
 // GetOrdersByStatus retrieves orders by status
 func (r *OrderRepository) GetOrdersByStatus(status string, limit, offset int) ([]OrderModel, error) {
 	query := `
 		SELECT id, order_number, customer_name, customer_email, customer_phone, customer_address,
 		       status, total_amount, subtotal, tax_amount, discount_amount, handling_fee, unique_code,
-		       payment_method, payment_status, payment_token, payment_url, paid_at,
+		       payment_gateway, payment_method, payment_status, payment_token, payment_url, paid_at, payment_expires_at,
 		       notes, admin_notes, priority, source,
 		       created_at, updated_at, completed_at, cancelled_at
 		FROM orders
@@ -473,8 +601,49 @@ func (r *OrderRepository) GetOrdersByStatus(status string, limit, offset int) ([
 			&order.ID, &order.OrderNumber, &order.CustomerName, &order.CustomerEmail,
 			&order.CustomerPhone, &order.CustomerAddress, &order.Status, &order.TotalAmount,
 			&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.HandlingFee,
-			&order.UniqueCode, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
-			&order.PaymentURL, &order.PaidAt, &order.Notes, &order.AdminNotes, &order.Priority,
+			&order.UniqueCode, &order.PaymentGateway, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
+			&order.PaymentURL, &order.PaidAt, &order.PaymentExpiresAt, &order.Notes, &order.AdminNotes, &order.Priority,
+			&order.Source, &order.CreatedAt, &order.UpdatedAt, &order.CompletedAt, &order.CancelledAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// GetOrdersByCustomerEmail retrieves the orders placed with an email address, newest first
+func (r *OrderRepository) GetOrdersByCustomerEmail(email string, limit, offset int) ([]OrderModel, error) {
+	query := `
+		SELECT id, order_number, customer_name, customer_email, customer_phone, customer_address,
+		       status, total_amount, subtotal, tax_amount, discount_amount, handling_fee, unique_code,
+		       payment_gateway, payment_method, payment_status, payment_token, payment_url, paid_at, payment_expires_at,
+		       notes, admin_notes, priority, source,
+		       created_at, updated_at, completed_at, cancelled_at
+		FROM orders
+		WHERE LOWER(customer_email) = LOWER($1)
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(query, email, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders by customer email: %w", err)
+	}
+	defer rows.Close()
+
+	// Initialize with empty slice to ensure it's never nil
+	orders := make([]OrderModel, 0)
+	for rows.Next() {
+		var order OrderModel
+		err := rows.Scan(
+			&order.ID, &order.OrderNumber, &order.CustomerName, &order.CustomerEmail,
+			&order.CustomerPhone, &order.CustomerAddress, &order.Status, &order.TotalAmount,
+			&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.HandlingFee,
+			&order.UniqueCode, &order.PaymentGateway, &order.PaymentMethod, &order.PaymentStatus, &order.PaymentToken,
+			&order.PaymentURL, &order.PaidAt, &order.PaymentExpiresAt, &order.Notes, &order.AdminNotes, &order.Priority,
 			&order.Source, &order.CreatedAt, &order.UpdatedAt, &order.CompletedAt, &order.CancelledAt,
 		)
 		if err != nil {
@@ -499,7 +668,7 @@ func (r *OrderRepository) GetOrderAnalytics() (map[string]interface{}, error) {
 	analytics["totalOrders"] = totalOrders
 
 	// Total revenue
-	var totalRevenue float64
+	var totalRevenue Money
 	err = r.db.QueryRow("SELECT COALESCE(SUM(total_amount), 0) FROM orders WHERE status = 'completed'").Scan(&totalRevenue)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total revenue: %w", err)
@@ -539,7 +708,7 @@ func (r *OrderRepository) GetOrderAnalytics() (map[string]interface{}, error) {
 	analytics["todayOrders"] = todayOrders
 
 	// Today's revenue
-	var todayRevenue float64
+	var todayRevenue Money
 	err = r.db.QueryRow("SELECT COALESCE(SUM(total_amount), 0) FROM orders WHERE DATE(created_at) = CURRENT_DATE AND status = 'completed'").Scan(&todayRevenue)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get today's revenue: %w", err)
@@ -549,8 +718,10 @@ func (r *OrderRepository) GetOrderAnalytics() (map[string]interface{}, error) {
 	return analytics, nil
 }
 
-// UpdateOrderPaymentStatus updates the payment status of an order
-func (r *OrderRepository) UpdateOrderPaymentStatus(id string, paymentStatus string, paymentMethod *string, paidAt *time.Time) error {
+// RecordPaymentAttempt records a status change of one of the order's payment
+// attempts and updates the order's payment status, method and paid time from
+// all of its attempts
+func (r *OrderRepository) RecordPaymentAttempt(id string, update PaymentAttemptUpdate) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -560,18 +731,44 @@ func (r *OrderRepository) UpdateOrderPaymentStatus(id string, paymentStatus stri
 			// Rollback error is expected when transaction was committed successfully
 			// Only log if it's not a "transaction has already been committed or rolled back" error
 			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
-				LogError("Failed to rollback transaction in UpdateOrderPaymentStatus", logrus.Fields{
+				LogError("Failed to rollback transaction in RecordPaymentAttempt", logrus.Fields{
 					"error": rollbackErr.Error(),
 				}, rollbackErr)
 			}
 		} else {
 			// Log successful transaction rollback (for debugging purposes)
 			LogDebug("Transaction rollback completed successfully", logrus.Fields{
-				"operation": "UpdateOrderPaymentStatus",
+				"operation": "RecordPaymentAttempt",
 			})
 		}
 	}()
 
+	if err = r.applyPaymentAttemptUpdate(tx, id, update); err != nil {
+		return err
+	}
+
+	attempts, err := getPaymentAttempts(tx, id)
+	if err != nil {
+		return err
+	}
+	paymentStatus, paymentMethod, paidAt := derivePaymentStatus(attempts)
+
+	// Orders with installments are paid once every installment is
+	installments, err := getInstallments(tx, id)
+	if err != nil {
+		return err
+	}
+	if len(installments) > 0 {
+		balance := installmentBalance(installments)
+		switch {
+		case balance.FullyPaid:
+			paymentStatus = PaymentStatusPaid
+		case balance.Paid.IsPositive():
+			paymentStatus = PaymentStatusPartiallyPaid
+			paidAt = nil
+		}
+	}
+
 	// Update order payment status
 	updateQuery := `
 		UPDATE orders 
@@ -586,8 +783,12 @@ func (r *OrderRepository) UpdateOrderPaymentStatus(id string, paymentStatus stri
 
 	// Add status history note about payment status change
 	notes := "Payment status updated to " + paymentStatus
-	if paymentStatus == "paid" {
+	if update.Status == PaymentStatusRefunded && paymentStatus != PaymentStatusRefunded {
+		notes = "Partial refund of " + update.RefundedAmount.String() + " recorded"
+	} else if paymentStatus == "paid" {
 		notes = "Payment received"
+	} else if paymentStatus == PaymentStatusPartiallyPaid {
+		notes = "Installment payment received"
 	} else if paymentStatus == "failed" {
 		notes = "Payment failed"
 	}
@@ -628,3 +829,104 @@ func (r *OrderRepository) UpdateOrderPaymentStatus(id string, paymentStatus stri
 
 	return nil
 }
+
+// SetOrderPayment stores the payment created by a gateway on an order and
+// records it as a new payment attempt
+func (r *OrderRepository) SetOrderPayment(id string, gateway string, payment *GatewayPayment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in SetOrderPayment", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	query := `
+		UPDATE orders 
+		SET payment_gateway = $1, payment_token = $2, payment_url = $3, payment_expires_at = $4,
+		    unique_code = $5, payment_status = 'pending', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+	`
+
+	var paymentURL *string
+	if payment.PaymentURL != "" {
+		paymentURL = &payment.PaymentURL
+	}
+
+	result, err := tx.Exec(query, gateway, payment.Reference, paymentURL, payment.ExpiresAt, payment.UniqueCode, id)
+	if err != nil {
+		return fmt.Errorf("failed to update order payment: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("order not found")
+	}
+
+	if err := r.insertPaymentAttempt(tx, id, "", gateway, payment); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// IsPayableAmountInUse reports whether another unpaid order or installment
+// already expects a transfer of exactly amount (total plus unique code)
+func (r *OrderRepository) IsPayableAmountInUse(amount Money, excludeOrderID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM orders
+			WHERE unique_code IS NOT NULL
+			AND total_amount + unique_code = $1
+			AND payment_status = 'pending'
+			AND id <> $2
+		) OR EXISTS (
+			SELECT 1 FROM order_installments
+			WHERE unique_code IS NOT NULL
+			AND amount + unique_code = $1
+			AND status = 'pending'
+			AND order_id <> $2
+		)
+	`
+
+	var inUse bool
+	if err := r.db.QueryRow(query, amount, excludeOrderID).Scan(&inUse); err != nil {
+		return false, fmt.Errorf("failed to check unique code: %w", err)
+	}
+
+	return inUse, nil
+}
+
+// GetOrderIDByPaymentToken returns the ID of the order holding the given payment
+// token, either for the whole order or for one of its installments
+func (r *OrderRepository) GetOrderIDByPaymentToken(paymentToken string) (string, error) {
+	var id string
+	err := r.db.QueryRow(`
+		SELECT id FROM orders WHERE payment_token = $1
+		UNION ALL
+		SELECT order_id FROM order_installments WHERE payment_token = $1
+		LIMIT 1
+	`, paymentToken).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("order not found")
+		}
+		return "", fmt.Errorf("failed to get order by payment token: %w", err)
+	}
+
+	return id, nil
+}
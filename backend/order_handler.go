@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,17 +11,21 @@ import (
 
 // OrderHandler handles HTTP requests for orders
 type OrderHandler struct {
-	repo *OrderRepository
+	repo     *OrderRepository
+	payments *PaymentService
 }
 
 // NewOrderHandler creates a new order handler
-func NewOrderHandler(repo *OrderRepository) *OrderHandler {
-	return &OrderHandler{repo: repo}
+func NewOrderHandler(repo *OrderRepository, payments *PaymentService) *OrderHandler {
+	return &OrderHandler{
+		repo:     repo,
+		payments: payments,
+	}
 }
 
 // GetOrders handles GET /api/orders
 func (h *OrderHandler) GetOrders(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	
 	LogInfo("Get orders requested", logrus.Fields{
 		"request_id": requestID,
@@ -82,7 +87,7 @@ func (h *OrderHandler) GetOrders(c *gin.Context) {
 
 // GetOrderByID handles GET /api/orders/:id
 func (h *OrderHandler) GetOrderByID(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	orderID := c.Param("id")
 	
 	LogInfo("Get order by ID requested", logrus.Fields{
@@ -128,7 +133,7 @@ func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 
 // CreateOrder handles POST /api/orders
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	
 	LogInfo("Create order requested", logrus.Fields{
 		"request_id": requestID,
@@ -150,8 +155,39 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
+	for _, item := range req.Items {
+		if item.UnitPrice.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Invalid request format",
+				"details":    "unitPrice must not be negative",
+				"request_id": requestID,
+			})
+			return
+		}
+	}
+
+	// Validate the checkout's gateway choice before the order exists
+	gateway, err := h.payments.ResolveGateway(req.PaymentGateway)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid payment gateway",
+			"details":    err.Error(),
+			"request_id": requestID,
+		})
+		return
+	}
+	req.PaymentGateway = gateway
+
 	// Create order in database
 	order, err := h.repo.CreateOrder(&req)
+	if errors.Is(err, ErrInvalidPaymentSchedule) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid payment schedule",
+			"details":    err.Error(),
+			"request_id": requestID,
+		})
+		return
+	}
 	if err != nil {
 		LogError("Failed to create order", logrus.Fields{
 			"request_id": requestID,
@@ -165,6 +201,19 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// Start the payment right away; the order stays valid without one and
+	// a payment can still be requested later via POST /api/orders/:id/payment-link
+	if h.payments.Enabled() {
+		if _, _, _, paymentErr := h.payments.EnsurePayment(c.Request.Context(), order, "", false); paymentErr != nil {
+			LogWarn("Failed to create payment for new order", logrus.Fields{
+				"request_id": requestID,
+				"order_id":   order.ID,
+				"gateway":    gateway,
+				"error":      paymentErr.Error(),
+			})
+		}
+	}
+
 	LogInfo("Order created successfully", logrus.Fields{
 		"request_id":   requestID,
 		"order_id":     order.ID,
@@ -173,6 +222,8 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		"total":        order.TotalAmount,
 	})
 
+	setAuditResourceID(c, order.ID)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"data":       order,
 		"message":    "Order created successfully",
@@ -182,7 +233,7 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 
 // UpdateOrderStatus handles PUT /api/orders/:id/status
 func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	orderID := c.Param("id")
 	
 	LogInfo("Update order status requested", logrus.Fields{
@@ -215,8 +266,26 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	// Keep the previous status for the audit log
+	previous, _ := h.repo.GetOrderByID(orderID)
+
 	// Update order status in database
 	err := h.repo.UpdateOrderStatus(orderID, &req)
+	if errors.Is(err, ErrDepositNotPaid) || errors.Is(err, ErrBalanceOutstanding) {
+		LogWarn("Order status change blocked by payment schedule", logrus.Fields{
+			"request_id": requestID,
+			"order_id":   orderID,
+			"status":     req.Status,
+			"reason":     err.Error(),
+		})
+
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Order is not paid enough for this status",
+			"details":    err.Error(),
+			"request_id": requestID,
+		})
+		return
+	}
 	if err != nil {
 		LogError("Failed to update order status", logrus.Fields{
 			"request_id": requestID,
@@ -237,6 +306,13 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		"order_id":   orderID,
 		"new_status": req.Status,
 	})
+	if previous != nil {
+		after := gin.H{"status": req.Status}
+		if req.Notes != nil {
+			after["notes"] = *req.Notes
+		}
+		auditChange(c, gin.H{"status": previous.Status}, after)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Order status updated successfully",
@@ -246,7 +322,7 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 
 // GetOrdersByStatus handles GET /api/orders/status/:status
 func (h *OrderHandler) GetOrdersByStatus(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	status := c.Param("status")
 	
 	LogInfo("Get orders by status requested", logrus.Fields{
@@ -316,7 +392,7 @@ func (h *OrderHandler) GetOrdersByStatus(c *gin.Context) {
 
 // GetOrderAnalytics handles GET /api/orders/analytics
 func (h *OrderHandler) GetOrderAnalytics(c *gin.Context) {
-	requestID := GenerateRequestID()
+	requestID := requestIDFromContext(c)
 	
 	LogInfo("Get order analytics requested", logrus.Fields{
 		"request_id": requestID,
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PaymentAttempt is one attempt to pay an order through a gateway. An order
+// can have many attempts, e.g. a failed QRIS payment followed by a transfer.
+type PaymentAttempt struct {
+	ID             string          `json:"id" db:"id"`
+	OrderID        string          `json:"orderId" db:"order_id"`
+	OrderNumber    string          `json:"orderNumber,omitempty"`
+	InstallmentID  *string         `json:"installmentId" db:"installment_id"`
+	Gateway        string          `json:"gateway" db:"gateway"`
+	ExternalID     *string         `json:"externalId" db:"external_id"`
+	Amount         Money           `json:"amount" db:"amount"`
+	RefundedAmount Money           `json:"refundedAmount" db:"refunded_amount"`
+	Status         string          `json:"status" db:"status"`
+	PaymentMethod  *string         `json:"paymentMethod" db:"payment_method"`
+	PaymentURL     *string         `json:"paymentUrl" db:"payment_url"`
+	RawResponse    json.RawMessage `json:"rawResponse,omitempty" db:"raw_response"`
+	ExpiresAt      *time.Time      `json:"expiresAt" db:"expires_at"`
+	PaidAt         *time.Time      `json:"paidAt" db:"paid_at"`
+	FailedAt       *time.Time      `json:"failedAt" db:"failed_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updatedAt" db:"updated_at"`
+}
+
+// PaymentAttemptUpdate records a status change of one of an order's attempts.
+// The attempt is chosen by AttemptID, then by Gateway and ExternalID, and
+// otherwise is the order's latest attempt (of InstallmentID, when set). An
+// update that matches no attempt creates one, so webhooks for payments we did
+// not start are still recorded.
+type PaymentAttemptUpdate struct {
+	AttemptID      string
+	InstallmentID  string
+	Gateway        string
+	ExternalID     string
+	Status         string
+	PaymentMethod  *string
+	At             *time.Time
+	RefundedAmount Money
+	RawResponse    json.RawMessage
+}
+
+// PaymentAttemptFilter narrows the admin list of payment attempts
+type PaymentAttemptFilter struct {
+	OrderID string
+	Gateway string
+	Status  string
+	Limit   int
+	Offset  int
+}
+
+const paymentAttemptColumns = `p.id, p.order_id, o.order_number, p.installment_id, p.gateway, p.external_id, p.amount, p.refunded_amount,
+	p.status, p.payment_method, p.payment_url, p.raw_response, p.expires_at, p.paid_at, p.failed_at,
+	p.created_at, p.updated_at`
+
+func scanPaymentAttempt(row interface{ Scan(...interface{}) error }) (*PaymentAttempt, error) {
+	var attempt PaymentAttempt
+	var raw []byte
+	err := row.Scan(
+		&attempt.ID, &attempt.OrderID, &attempt.OrderNumber, &attempt.InstallmentID, &attempt.Gateway, &attempt.ExternalID,
+		&attempt.Amount, &attempt.RefundedAmount, &attempt.Status, &attempt.PaymentMethod,
+		&attempt.PaymentURL, &raw, &attempt.ExpiresAt, &attempt.PaidAt, &attempt.FailedAt,
+		&attempt.CreatedAt, &attempt.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		attempt.RawResponse = json.RawMessage(raw)
+	}
+	return &attempt, nil
+}
+
+// derivePaymentStatus works out an order's payment status from its attempts,
+// oldest first: any paid attempt makes the order paid, a refunded attempt makes
+// it refunded, and otherwise the latest attempt decides. Attempts are only
+// refunded once refunded in full, so a partial refund leaves the order paid.
+func derivePaymentStatus(attempts []PaymentAttempt) (status string, paymentMethod *string, paidAt *time.Time) {
+	if len(attempts) == 0 {
+		return PaymentStatusPending, nil, nil
+	}
+
+	for _, attempt := range attempts {
+		if attempt.Status == PaymentStatusPaid {
+			return PaymentStatusPaid, attempt.PaymentMethod, attempt.PaidAt
+		}
+	}
+	for _, attempt := range attempts {
+		if attempt.Status == PaymentStatusRefunded {
+			return PaymentStatusRefunded, attempt.PaymentMethod, attempt.PaidAt
+		}
+	}
+
+	latest := attempts[len(attempts)-1]
+	return latest.Status, latest.PaymentMethod, nil
+}
+
+// refundedPaymentStatus is the status of a paid attempt of amount, of which
+// refunded was already refunded, after refunding refund. It stays paid until the
+// refunds add up to the amount; a refund without an amount is a gateway
+// reporting the payment refunded in full.
+func refundedPaymentStatus(amount, refunded, refund Money) string {
+	if refund.IsPositive() && refunded.Add(refund).LessThan(amount) {
+		return PaymentStatusPaid
+	}
+	return PaymentStatusRefunded
+}
+
+// nullableJSON returns raw for a JSONB parameter, or nil when it is empty
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// insertPaymentAttempt adds a pending attempt for a payment created by a gateway,
+// cancelling the other pending attempts for the same order or installment since
+// only one link is live at a time. installmentID is empty for whole-order payments.
+func (r *OrderRepository) insertPaymentAttempt(tx *sql.Tx, orderID, installmentID, gateway string, payment *GatewayPayment) error {
+	var installment *string
+	if installmentID != "" {
+		installment = &installmentID
+	}
+
+	_, err := tx.Exec(`
+		UPDATE payments SET status = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE order_id = $1 AND status = $3 AND installment_id IS NOT DISTINCT FROM $4::uuid
+	`, orderID, PaymentStatusCancelled, PaymentStatusPending, installment)
+	if err != nil {
+		return fmt.Errorf("failed to supersede pending payments: %w", err)
+	}
+
+	var externalID, paymentURL *string
+	if payment.Reference != "" {
+		externalID = &payment.Reference
+	}
+	if payment.PaymentURL != "" {
+		paymentURL = &payment.PaymentURL
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO payments (order_id, installment_id, gateway, external_id, amount, status, payment_url,
+		                      raw_response, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (gateway, external_id) WHERE external_id IS NOT NULL DO UPDATE
+		SET status = EXCLUDED.status, payment_url = EXCLUDED.payment_url,
+		    expires_at = EXCLUDED.expires_at, updated_at = CURRENT_TIMESTAMP
+	`, orderID, installment, gateway, externalID, payment.AmountDue, PaymentStatusPending, paymentURL,
+		nullableJSON(payment.RawResponse), payment.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to record payment attempt: %w", err)
+	}
+
+	return nil
+}
+
+// applyPaymentAttemptUpdate updates (or creates) the attempt an update refers to,
+// and marks the attempt's installment paid or refunded along with it. A partial
+// refund adds to the attempt's refunded amount and leaves it paid.
+func (r *OrderRepository) applyPaymentAttemptUpdate(tx *sql.Tx, orderID string, update PaymentAttemptUpdate) error {
+	var attemptID string
+	var attemptInstallment sql.NullString
+	var attemptAmount, attemptRefunded Money
+	var err error
+	switch {
+	case update.AttemptID != "":
+		err = tx.QueryRow("SELECT id, installment_id, amount, refunded_amount FROM payments WHERE id = $1 AND order_id = $2",
+			update.AttemptID, orderID).Scan(&attemptID, &attemptInstallment, &attemptAmount, &attemptRefunded)
+	case update.ExternalID != "":
+		err = tx.QueryRow(`
+			SELECT id, installment_id, amount, refunded_amount FROM payments
+			WHERE order_id = $1 AND external_id = $2 AND ($3 = '' OR gateway = $3)
+			ORDER BY created_at DESC LIMIT 1
+		`, orderID, update.ExternalID, update.Gateway).Scan(&attemptID, &attemptInstallment, &attemptAmount, &attemptRefunded)
+	default:
+		err = tx.QueryRow(`
+			SELECT id, installment_id, amount, refunded_amount FROM payments
+			WHERE order_id = $1 AND ($2 = '' OR gateway = $2)
+			AND ($3 = '' OR installment_id::text = $3)
+			ORDER BY created_at DESC LIMIT 1
+		`, orderID, update.Gateway, update.InstallmentID).Scan(&attemptID, &attemptInstallment, &attemptAmount, &attemptRefunded)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to find payment attempt: %w", err)
+	}
+
+	installmentID := update.InstallmentID
+	if installmentID == "" {
+		installmentID = attemptInstallment.String
+	}
+	if installmentID == "" && attemptID == "" && update.ExternalID != "" {
+		err = tx.QueryRow("SELECT id FROM order_installments WHERE order_id = $1 AND payment_token = $2",
+			orderID, update.ExternalID).Scan(&installmentID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to find installment: %w", err)
+		}
+	}
+
+	var paidAt, failedAt *time.Time
+	switch update.Status {
+	case PaymentStatusPaid:
+		paidAt = update.At
+	case PaymentStatusFailed, PaymentStatusExpired:
+		failedAt = update.At
+	}
+
+	if attemptID == "" {
+		gateway := update.Gateway
+		if gateway == "" {
+			if scanErr := tx.QueryRow("SELECT COALESCE(payment_gateway, '') FROM orders WHERE id = $1",
+				orderID).Scan(&gateway); scanErr != nil {
+				return fmt.Errorf("failed to get order gateway: %w", scanErr)
+			}
+		}
+		if gateway == "" {
+			// Orders created before gateways were recorded were paid through Mayar
+			gateway = GatewayMayar
+		}
+		var externalID, installment *string
+		if update.ExternalID != "" {
+			externalID = &update.ExternalID
+		}
+		if installmentID != "" {
+			installment = &installmentID
+		}
+
+		// The amount due includes the unique code a bank transfer is matched by
+		var amount Money
+		err = tx.QueryRow(`
+			SELECT COALESCE(i.amount + COALESCE(i.unique_code, 0), o.total_amount + COALESCE(o.unique_code, 0))
+			FROM orders o LEFT JOIN order_installments i ON i.id = $2::uuid AND i.order_id = o.id
+			WHERE o.id = $1
+		`, orderID, installment).Scan(&amount)
+		if err != nil {
+			return fmt.Errorf("failed to get payment amount: %w", err)
+		}
+		status := update.Status
+		if status == PaymentStatusRefunded {
+			status = refundedPaymentStatus(amount, Rupiah(0), update.RefundedAmount)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO payments (order_id, installment_id, gateway, external_id, amount, refunded_amount, status,
+			                      payment_method, raw_response, paid_at, failed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, orderID, installment, gateway, externalID, amount, update.RefundedAmount, status, update.PaymentMethod,
+			nullableJSON(update.RawResponse), paidAt, failedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record payment attempt: %w", err)
+		}
+		return r.applyInstallmentStatus(tx, installmentID, status, paidAt)
+	}
+
+	status := update.Status
+	if status == PaymentStatusRefunded {
+		status = refundedPaymentStatus(attemptAmount, attemptRefunded, update.RefundedAmount)
+	}
+	_, err = tx.Exec(`
+		UPDATE payments
+		SET status = $2,
+		    payment_method = COALESCE($3, payment_method),
+		    refunded_amount = refunded_amount + $4,
+		    raw_response = COALESCE($5, raw_response),
+		    paid_at = COALESCE($6, paid_at),
+		    failed_at = COALESCE($7, failed_at),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, attemptID, status, update.PaymentMethod, update.RefundedAmount,
+		nullableJSON(update.RawResponse), paidAt, failedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update payment attempt: %w", err)
+	}
+
+	return r.applyInstallmentStatus(tx, installmentID, status, paidAt)
+}
+
+// applyInstallmentStatus marks an installment paid or refunded when its payment
+// is; a partially refunded installment stays paid. Failed, expired and cancelled payments leave the installment open for a new link.
+func (r *OrderRepository) applyInstallmentStatus(tx *sql.Tx, installmentID, status string, paidAt *time.Time) error {
+	if installmentID == "" || (status != PaymentStatusPaid && status != PaymentStatusRefunded) {
+		return nil
+	}
+
+	installmentStatus := InstallmentStatusPaid
+	if status == PaymentStatusRefunded {
+		installmentStatus = InstallmentStatusRefunded
+	}
+	_, err := tx.Exec(`
+		UPDATE order_installments
+		SET status = $2, paid_at = COALESCE($3, paid_at), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, installmentID, installmentStatus, paidAt)
+	if err != nil {
+		return fmt.Errorf("failed to update installment status: %w", err)
+	}
+	return nil
+}
+
+// getPaymentAttempts loads an order's attempts, oldest first
+func getPaymentAttempts(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, orderID string) ([]PaymentAttempt, error) {
+	rows, err := q.Query(`
+		SELECT `+paymentAttemptColumns+`
+		FROM payments p JOIN orders o ON o.id = p.order_id
+		WHERE p.order_id = $1
+		ORDER BY p.created_at, p.id
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payment attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []PaymentAttempt{}
+	for rows.Next() {
+		attempt, scanErr := scanPaymentAttempt(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan payment attempt: %w", scanErr)
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// GetPaymentAttempts returns every payment attempt of an order, oldest first
+func (r *OrderRepository) GetPaymentAttempts(orderID string) ([]PaymentAttempt, error) {
+	return getPaymentAttempts(r.db, orderID)
+}
+
+// ListPaymentAttempts returns payment attempts across orders, newest first
+func (r *OrderRepository) ListPaymentAttempts(filter PaymentAttemptFilter) ([]PaymentAttempt, int, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(column, value string) {
+		if value == "" {
+			return
+		}
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	addCondition("p.order_id", filter.OrderID)
+	addCondition("p.gateway", filter.Gateway)
+	addCondition("p.status", filter.Status)
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM payments p " + where
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count payment attempts: %w", err)
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM payments p JOIN orders o ON o.id = p.order_id
+		%s
+		ORDER BY p.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, paymentAttemptColumns, where, len(args)-1, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query payment attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []PaymentAttempt{}
+	for rows.Next() {
+		attempt, scanErr := scanPaymentAttempt(rows)
+		if scanErr != nil {
+			return nil, 0, fmt.Errorf("failed to scan payment attempt: %w", scanErr)
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	return attempts, total, rows.Err()
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestRefundedPaymentStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		refunded Money
+		refund   Money
+		want     string
+	}{
+		{name: "small refund", amount: Rupiah(1000000), refunded: Rupiah(0), refund: Rupiah(10000), want: PaymentStatusPaid},
+		{name: "second partial refund", amount: Rupiah(1000000), refunded: Rupiah(10000), refund: Rupiah(500000), want: PaymentStatusPaid},
+		{name: "refund of the rest", amount: Rupiah(1000000), refunded: Rupiah(10000), refund: Rupiah(990000), want: PaymentStatusRefunded},
+		{name: "full refund", amount: Rupiah(1000123), refunded: Rupiah(0), refund: Rupiah(1000123), want: PaymentStatusRefunded},
+		{name: "gateway reports refunded without an amount", amount: Rupiah(1000000), refunded: Rupiah(0), refund: Rupiah(0), want: PaymentStatusRefunded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundedPaymentStatus(tt.amount, tt.refunded, tt.refund); got != tt.want {
+				t.Fatalf("refundedPaymentStatus = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivePaymentStatusKeepsPartiallyRefundedOrderPaid(t *testing.T) {
+	attempts := []PaymentAttempt{
+		{Status: PaymentStatusFailed},
+		{Status: PaymentStatusPaid, Amount: Rupiah(1000000), RefundedAmount: Rupiah(10000)},
+	}
+	if status, _, _ := derivePaymentStatus(attempts); status != PaymentStatusPaid {
+		t.Fatalf("status with a partial refund = %q, want %q", status, PaymentStatusPaid)
+	}
+
+	attempts[1].Status = PaymentStatusRefunded
+	attempts[1].RefundedAmount = Rupiah(1000000)
+	if status, _, _ := derivePaymentStatus(attempts); status != PaymentStatusRefunded {
+		t.Fatalf("status with a full refund = %q, want %q", status, PaymentStatusRefunded)
+	}
+}
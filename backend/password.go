@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordPolicy is returned, wrapped with the reasons, for passwords the
+// policy rejects. Handlers answer it with 400 and the error text.
+var ErrPasswordPolicy = errors.New("password does not meet the policy")
+
+// Accounts with passwords, named by their table
+const (
+	PasswordAccountAdmin = "admin_users"
+	PasswordAccountUser  = "users"
+)
+
+// bcryptMaxPasswordLength is the number of bytes bcrypt looks at
+const bcryptMaxPasswordLength = 72
+
+// currentHashPrefix identifies hashes made by the current algorithm. A stored
+// hash with another prefix is replaced at the next successful login.
+const currentHashPrefix = "$2a$"
+
+//go:embed common_passwords.txt
+var bundledCommonPasswords string
+
+// dbExecutor is implemented by both *sql.DB and *sql.Tx
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// PasswordPolicy is what a new password must satisfy
+type PasswordPolicy struct {
+	MinLength int
+	// MinCharClasses is how many of lowercase, uppercase, digits and symbols
+	// a password must mix
+	MinCharClasses int
+	// History is how many previous passwords, the current one included, may
+	// not be reused; 0 allows reuse
+	History int
+}
+
+// LoadPasswordPolicy loads the password policy from environment variables
+func LoadPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:      getEnvIntWithDefault("PASSWORD_MIN_LENGTH", 10),
+		MinCharClasses: getEnvIntWithDefault("PASSWORD_MIN_CHAR_CLASSES", 2),
+		History:        getEnvIntWithDefault("PASSWORD_HISTORY", 5),
+	}
+	if policy.MinLength < 8 {
+		policy.MinLength = 8
+	}
+	if policy.MinLength > bcryptMaxPasswordLength {
+		policy.MinLength = bcryptMaxPasswordLength
+	}
+	if policy.MinCharClasses > 4 {
+		policy.MinCharClasses = 4
+	}
+	if policy.History < 0 {
+		policy.History = 0
+	}
+	return policy
+}
+
+// PasswordService hashes and checks passwords for admin users and customers
+type PasswordService struct {
+	policy PasswordPolicy
+	cost   int
+	// common holds lowercased passwords that are too easy to guess
+	common map[string]bool
+	// dummyHash is compared against for unknown accounts so that path costs
+	// as much as a wrong password
+	dummyHash []byte
+}
+
+// NewPasswordService creates a password service configured from environment
+// variables. The bundled common password list is always used;
+// PASSWORD_BLOCKLIST_FILE adds a local list, such as known breached passwords.
+func NewPasswordService() (*PasswordService, error) {
+	cost := getEnvIntWithDefault("BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	s := &PasswordService{
+		policy: LoadPasswordPolicy(),
+		cost:   cost,
+		common: make(map[string]bool),
+	}
+	s.addBlockedPasswords(strings.NewReader(bundledCommonPasswords))
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open password blocklist: %w", err)
+		}
+		defer file.Close()
+		if err := s.addBlockedPasswords(file); err != nil {
+			return nil, fmt.Errorf("failed to read password blocklist: %w", err)
+		}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate dummy password: %w", err)
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword(random, cost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash dummy password: %w", err)
+	}
+	s.dummyHash = dummyHash
+
+	LogInfo("Password policy loaded", logrus.Fields{
+		"min_length":       s.policy.MinLength,
+		"min_char_classes": s.policy.MinCharClasses,
+		"history":          s.policy.History,
+		"blocked":          len(s.common),
+		"bcrypt_cost":      cost,
+	})
+	return s, nil
+}
+
+// addBlockedPasswords reads one password per line, skipping blank lines and # comments
+func (s *PasswordService) addBlockedPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s.common[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+// Policy returns the password policy
+func (s *PasswordService) Policy() PasswordPolicy {
+	return s.policy
+}
+
+// charClasses counts which of lowercase, uppercase, digits and symbols appear
+func charClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Validate checks a new password against the policy, except for reuse, which
+// needs the account's history
+func (s *PasswordService) Validate(password string) error {
+	var problems []string
+	if len([]rune(password)) < s.policy.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", s.policy.MinLength))
+	}
+	if len(password) > bcryptMaxPasswordLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", bcryptMaxPasswordLength))
+	}
+	if charClasses(password) < s.policy.MinCharClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", s.policy.MinCharClasses))
+	}
+	if s.common[strings.ToLower(password)] {
+		problems = append(problems, "is too common")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrPasswordPolicy, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Hash hashes a password with the configured bcrypt cost
+func (s *PasswordService) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Verify checks a password against a stored hash. needsRehash reports that
+// the hash was made with another algorithm or cost and should be replaced.
+func (s *PasswordService) Verify(hash, password string) (ok bool, needsRehash bool) {
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	if !strings.HasPrefix(hash, currentHashPrefix) {
+		return true, true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != s.cost
+}
+
+// CompareDummy spends as long as Verify does, for accounts that do not exist
+func (s *PasswordService) CompareDummy(password string) {
+	bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+}
+
+// checkAccount rejects account types that have no password column
+func checkAccount(account string) error {
+	if account != PasswordAccountAdmin && account != PasswordAccountUser {
+		return fmt.Errorf("unknown password account type %q", account)
+	}
+	return nil
+}
+
+// HashNew validates a new password for an account, rejects recent passwords
+// and returns its hash. accountID is empty for accounts not created yet.
+// Call Remember once the hash is stored.
+func (s *PasswordService) HashNew(q dbExecutor, account, accountID, password string) (string, error) {
+	if err := checkAccount(account); err != nil {
+		return "", err
+	}
+	if err := s.Validate(password); err != nil {
+		return "", err
+	}
+
+	if accountID != "" && s.policy.History > 0 {
+		recent, err := s.recentHashes(q, account, accountID)
+		if err != nil {
+			return "", err
+		}
+		for _, hash := range recent {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+				return "", fmt.Errorf("%w: must not match any of your last %d passwords", ErrPasswordPolicy, s.policy.History)
+			}
+		}
+	}
+
+	return s.Hash(password)
+}
+
+// recentHashes returns the account's current hash and the hashes of its
+// previous passwords, up to the history size
+func (s *PasswordService) recentHashes(q dbExecutor, account, accountID string) ([]string, error) {
+	var hashes []string
+
+	var current sql.NullString
+	err := q.QueryRow(fmt.Sprintf("SELECT password_hash FROM %s WHERE id = $1", account), accountID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get current password: %w", err)
+	}
+	if current.Valid && current.String != "" {
+		hashes = append(hashes, current.String)
+	}
+
+	rows, err := q.Query(`
+		SELECT password_hash FROM password_history
+		WHERE account_type = $1 AND account_id = $2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, account, accountID, s.policy.History)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() && len(hashes) < s.policy.History {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan password history: %w", err)
+		}
+		if len(hashes) == 0 || hash != hashes[0] {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, rows.Err()
+}
+
+// Remember records a newly set password hash in the account's history and
+// forgets entries beyond the history size
+func (s *PasswordService) Remember(q dbExecutor, account, accountID, hash string) error {
+	if err := checkAccount(account); err != nil {
+		return err
+	}
+	if s.policy.History == 0 {
+		return nil
+	}
+
+	_, err := q.Exec(`
+		INSERT INTO password_history (account_type, account_id, password_hash)
+		VALUES ($1, $2, $3)
+	`, account, accountID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to record password history: %w", err)
+	}
+
+	_, err = q.Exec(`
+		DELETE FROM password_history
+		WHERE account_type = $1 AND account_id = $2 AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE account_type = $1 AND account_id = $2
+			ORDER BY created_at DESC
+			LIMIT $3
+		)
+	`, account, accountID, s.policy.History)
+	if err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+	return nil
+}
+
+// Rehash replaces an outdated hash after a successful login. The update only
+// applies while the stored hash is still oldHash, so a password changed in the
+// meantime is never overwritten. Failures are logged, not returned, since the
+// login itself succeeded.
+func (s *PasswordService) Rehash(q dbExecutor, account, accountID, oldHash, password string) {
+	if err := checkAccount(account); err != nil {
+		LogError("Failed to upgrade password hash", logrus.Fields{"account_type": account}, err)
+		return
+	}
+
+	hash, err := s.Hash(password)
+	if err != nil {
+		LogError("Failed to upgrade password hash", logrus.Fields{
+			"account_type": account,
+			"account_id":   accountID,
+		}, err)
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET password_hash = $1 WHERE id = $2 AND password_hash = $3", account)
+	if _, err := q.Exec(query, hash, accountID, oldHash); err != nil {
+		LogError("Failed to upgrade password hash", logrus.Fields{
+			"account_type": account,
+			"account_id":   accountID,
+		}, err)
+		return
+	}
+
+	LogInfo("Password hash upgraded", logrus.Fields{
+		"account_type": account,
+		"account_id":   accountID,
+		"bcrypt_cost":  s.cost,
+	})
+}
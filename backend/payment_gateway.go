@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Payment gateway names, stored in orders.payment_gateway
+const (
+	GatewayMayar          = "mayar"
+	GatewayManualTransfer = "manual_transfer"
+	GatewayFake           = "fake"
+)
+
+// Payment statuses shared by gateways and orders.payment_status
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusPaid      = "paid"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusExpired   = "expired"
+	PaymentStatusCancelled = "cancelled"
+	PaymentStatusRefunded  = "refunded"
+	// PaymentStatusPartiallyPaid is only used on orders with installments
+	PaymentStatusPartiallyPaid = "partially_paid"
+)
+
+var (
+	// ErrPaymentGatewayUnavailable is returned when no payment gateway is configured
+	ErrPaymentGatewayUnavailable = errors.New("payment gateway is not configured")
+	// ErrUnknownPaymentGateway is returned when a checkout selects a gateway that is not registered
+	ErrUnknownPaymentGateway = errors.New("unknown payment gateway")
+	// ErrGatewayOperationUnsupported is returned when a gateway cannot perform an operation
+	ErrGatewayOperationUnsupported = errors.New("operation is not supported by the payment gateway")
+	// ErrOrderAlreadyPaid is returned when a payment is requested for a paid order
+	ErrOrderAlreadyPaid = errors.New("order is already paid")
+	// ErrOrderNotPaid is returned when refunding an order that has not been paid
+	ErrOrderNotPaid = errors.New("order is not paid")
+	// ErrRefundExceedsTotal is returned when a refund is larger than what was
+	// paid and not yet refunded
+	ErrRefundExceedsTotal = errors.New("refund amount exceeds the refundable amount")
+)
+
+// PaymentGateway is implemented by every payment provider an order can be paid through
+type PaymentGateway interface {
+	// Name returns the identifier stored in orders.payment_gateway
+	Name() string
+	// CreatePayment starts a new payment for the order
+	CreatePayment(ctx context.Context, order *OrderModel) (*GatewayPayment, error)
+	// GetPaymentStatus returns the gateway's view of the order's current payment
+	GetPaymentStatus(ctx context.Context, order *OrderModel) (*GatewayPaymentStatus, error)
+	// CancelPayment stops the order's current payment from being completed
+	CancelPayment(ctx context.Context, order *OrderModel) error
+	// RefundPayment returns amount of a paid order to the customer
+	RefundPayment(ctx context.Context, order *OrderModel, amount Money, reason string) error
+	// VerifyWebhook reports whether a webhook request was sent by the gateway
+	VerifyWebhook(payload []byte, headers http.Header) bool
+}
+
+// GatewayPayment describes a payment created by a gateway
+type GatewayPayment struct {
+	Reference    string               `json:"reference"`
+	PaymentURL   string               `json:"paymentUrl,omitempty"`
+	ExpiresAt    *time.Time           `json:"expiresAt,omitempty"`
+	UniqueCode   *int                 `json:"uniqueCode,omitempty"`
+	AmountDue    Money                `json:"amountDue"`
+	Instructions *PaymentInstructions `json:"instructions,omitempty"`
+	// RawResponse is the gateway's response, kept on the payment attempt
+	RawResponse json.RawMessage `json:"-"`
+}
+
+// PaymentInstructions tells the customer where to send a manual payment
+type PaymentInstructions struct {
+	BankName      string `json:"bankName,omitempty"`
+	AccountNumber string `json:"accountNumber,omitempty"`
+	AccountName   string `json:"accountName,omitempty"`
+	QRISImageURL  string `json:"qrisImageUrl,omitempty"`
+}
+
+// GatewayPaymentStatus is the gateway's view of a payment
+type GatewayPaymentStatus struct {
+	Gateway       string     `json:"gateway"`
+	Reference     string     `json:"reference"`
+	Status        string     `json:"status"`
+	PaymentMethod string     `json:"paymentMethod,omitempty"`
+	PaidAt        *time.Time `json:"paidAt,omitempty"`
+}
+
+// paymentInstructor is implemented by gateways whose customers pay by hand
+type paymentInstructor interface {
+	Instructions() *PaymentInstructions
+}
+
+// PaymentGatewayRegistry holds the gateways available at checkout
+type PaymentGatewayRegistry struct {
+	gateways       map[string]PaymentGateway
+	defaultGateway string
+}
+
+// NewPaymentGatewayRegistry creates an empty registry
+func NewPaymentGatewayRegistry() *PaymentGatewayRegistry {
+	return &PaymentGatewayRegistry{
+		gateways: make(map[string]PaymentGateway),
+	}
+}
+
+// Register adds a gateway. The first registered gateway becomes the default.
+func (r *PaymentGatewayRegistry) Register(gateway PaymentGateway) {
+	r.gateways[gateway.Name()] = gateway
+	if r.defaultGateway == "" {
+		r.defaultGateway = gateway.Name()
+	}
+}
+
+// SetDefault selects the gateway used when a checkout does not choose one
+func (r *PaymentGatewayRegistry) SetDefault(name string) error {
+	if _, ok := r.gateways[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPaymentGateway, name)
+	}
+	r.defaultGateway = name
+	return nil
+}
+
+// Default returns the name of the default gateway
+func (r *PaymentGatewayRegistry) Default() string {
+	return r.defaultGateway
+}
+
+// Get returns the named gateway, or the default gateway for an empty name
+func (r *PaymentGatewayRegistry) Get(name string) (PaymentGateway, error) {
+	if name == "" {
+		name = r.defaultGateway
+	}
+	if name == "" {
+		return nil, ErrPaymentGatewayUnavailable
+	}
+
+	gateway, ok := r.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPaymentGateway, name)
+	}
+	return gateway, nil
+}
+
+// Names returns the registered gateway names in alphabetical order
+func (r *PaymentGatewayRegistry) Names() []string {
+	names := make([]string, 0, len(r.gateways))
+	for name := range r.gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPaymentGatewaysFromEnv registers the gateways enabled by configuration.
+// Mayar is registered when mayar is non-nil, manual transfer is always available
+// and the fake gateway is registered when PAYMENT_FAKE_GATEWAY_ENABLED=true.
+func NewPaymentGatewaysFromEnv(mayar *MayarService, orderRepo *OrderRepository) *PaymentGatewayRegistry {
+	registry := NewPaymentGatewayRegistry()
+
+	if mayar != nil {
+		registry.Register(NewMayarGateway(mayar))
+	}
+
+	registry.Register(NewManualTransferGateway(LoadManualTransferConfig(), orderRepo))
+
+	if os.Getenv("PAYMENT_FAKE_GATEWAY_ENABLED") == "true" {
+		registry.Register(NewFakePaymentGateway(os.Getenv("PAYMENT_FAKE_GATEWAY_SECRET")))
+	}
+
+	if name := os.Getenv("PAYMENT_DEFAULT_GATEWAY"); name != "" {
+		if err := registry.SetDefault(name); err != nil {
+			LogWarn("Configured default payment gateway is not available", logrus.Fields{
+				"gateway":  name,
+				"fallback": registry.Default(),
+			})
+		}
+	}
+
+	LogInfo("Payment gateways registered", logrus.Fields{
+		"gateways": registry.Names(),
+		"default":  registry.Default(),
+	})
+
+	return registry
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FakePaymentGateway is an in-memory gateway for local development and tests.
+// Payments stay pending until SetPaymentStatus settles them.
+type FakePaymentGateway struct {
+	mu            sync.Mutex
+	payments      map[string]*FakeGatewayPayment
+	sequence      int
+	failNext      error
+	webhookSecret string
+}
+
+// FakeGatewayPayment is a payment held by the fake gateway
+type FakeGatewayPayment struct {
+	Reference      string
+	OrderID        string
+	Amount         Money
+	Status         string
+	PaidAt         *time.Time
+	RefundedAmount Money
+}
+
+// NewFakePaymentGateway creates a fake gateway whose webhooks are signed with webhookSecret
+func NewFakePaymentGateway(webhookSecret string) *FakePaymentGateway {
+	return &FakePaymentGateway{
+		payments:      make(map[string]*FakeGatewayPayment),
+		webhookSecret: webhookSecret,
+	}
+}
+
+// Name returns the gateway identifier
+func (g *FakePaymentGateway) Name() string {
+	return GatewayFake
+}
+
+// FailNext makes the next gateway call return err
+func (g *FakePaymentGateway) FailNext(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failNext = err
+}
+
+// takeFailure returns and clears the injected failure. Callers must hold g.mu.
+func (g *FakePaymentGateway) takeFailure() error {
+	err := g.failNext
+	g.failNext = nil
+	return err
+}
+
+// CreatePayment records a pending payment for the order
+func (g *FakePaymentGateway) CreatePayment(ctx context.Context, order *OrderModel) (*GatewayPayment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.takeFailure(); err != nil {
+		return nil, err
+	}
+
+	g.sequence++
+	reference := fmt.Sprintf("FAKE-%06d", g.sequence)
+	g.payments[reference] = &FakeGatewayPayment{
+		Reference: reference,
+		OrderID:   order.ID,
+		Amount:    order.TotalAmount,
+		Status:    PaymentStatusPending,
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	return &GatewayPayment{
+		Reference:  reference,
+		PaymentURL: "https://fake-gateway.local/pay/" + reference,
+		ExpiresAt:  &expiresAt,
+		AmountDue:  order.TotalAmount,
+	}, nil
+}
+
+// payment returns the fake payment referenced by the order. Callers must hold g.mu.
+func (g *FakePaymentGateway) payment(order *OrderModel) (*FakeGatewayPayment, error) {
+	if order.PaymentToken == nil {
+		return nil, fmt.Errorf("order has no fake payment")
+	}
+	payment, ok := g.payments[*order.PaymentToken]
+	if !ok {
+		return nil, fmt.Errorf("fake payment not found")
+	}
+	return payment, nil
+}
+
+// GetPaymentStatus returns the status of the order's fake payment
+func (g *FakePaymentGateway) GetPaymentStatus(ctx context.Context, order *OrderModel) (*GatewayPaymentStatus, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.takeFailure(); err != nil {
+		return nil, err
+	}
+	payment, err := g.payment(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GatewayPaymentStatus{
+		Gateway:       GatewayFake,
+		Reference:     payment.Reference,
+		Status:        payment.Status,
+		PaymentMethod: "fake",
+		PaidAt:        payment.PaidAt,
+	}, nil
+}
+
+// CancelPayment cancels the order's pending fake payment
+func (g *FakePaymentGateway) CancelPayment(ctx context.Context, order *OrderModel) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.takeFailure(); err != nil {
+		return err
+	}
+	payment, err := g.payment(order)
+	if err != nil {
+		return err
+	}
+	if payment.Status == PaymentStatusPaid {
+		return ErrOrderAlreadyPaid
+	}
+	payment.Status = PaymentStatusCancelled
+	return nil
+}
+
+// RefundPayment refunds up to the paid amount of the order's fake payment
+func (g *FakePaymentGateway) RefundPayment(ctx context.Context, order *OrderModel, amount Money, reason string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.takeFailure(); err != nil {
+		return err
+	}
+	payment, err := g.payment(order)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentStatusPaid {
+		return ErrOrderNotPaid
+	}
+	if payment.RefundedAmount.Add(amount).GreaterThan(payment.Amount) {
+		return fmt.Errorf("refund exceeds paid amount")
+	}
+	payment.RefundedAmount = payment.RefundedAmount.Add(amount)
+	if payment.RefundedAmount.Equal(payment.Amount) {
+		payment.Status = PaymentStatusRefunded
+	}
+	return nil
+}
+
+// VerifyWebhook checks an X-Fake-Signature header holding the hex HMAC-SHA256 of the payload
+func (g *FakePaymentGateway) VerifyWebhook(payload []byte, headers http.Header) bool {
+	if g.webhookSecret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(headers.Get("X-Fake-Signature")), []byte(g.SignWebhook(payload)))
+}
+
+// SignWebhook returns the X-Fake-Signature value for payload
+func (g *FakePaymentGateway) SignWebhook(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(g.webhookSecret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetPaymentStatus settles a fake payment, as the customer or provider would
+func (g *FakePaymentGateway) SetPaymentStatus(reference, status string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[reference]
+	if !ok {
+		return fmt.Errorf("fake payment not found")
+	}
+	payment.Status = status
+	if status == PaymentStatusPaid {
+		now := time.Now()
+		payment.PaidAt = &now
+	}
+	return nil
+}
+
+// Payments returns copies of all fake payments
+func (g *FakePaymentGateway) Payments() []FakeGatewayPayment {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payments := make([]FakeGatewayPayment, 0, len(g.payments))
+	for _, payment := range g.payments {
+		payments = append(payments, *payment)
+	}
+	return payments
+}
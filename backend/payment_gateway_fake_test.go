@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// settleFakeInstallment pays an installment through the fake gateway the way
+// PaymentService does: a payment for the installment alone, settled by the
+// customer and then read back from the gateway
+func settleFakeInstallment(t *testing.T, gateway *FakePaymentGateway, order *OrderModel, installment *OrderInstallment) {
+	t.Helper()
+	ctx := context.Background()
+
+	payment, err := gateway.CreatePayment(ctx, installmentOrder(order, installment))
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	if !payment.AmountDue.Equal(installment.Amount) {
+		t.Fatalf("payment amount = %s, want the installment amount %s", payment.AmountDue, installment.Amount)
+	}
+	installment.PaymentToken = &payment.Reference
+
+	status, err := gateway.GetPaymentStatus(ctx, installmentOrder(order, installment))
+	if err != nil {
+		t.Fatalf("GetPaymentStatus: %v", err)
+	}
+	if status.Status != PaymentStatusPending {
+		t.Fatalf("unsettled payment status = %q, want %q", status.Status, PaymentStatusPending)
+	}
+
+	if err := gateway.SetPaymentStatus(payment.Reference, PaymentStatusPaid); err != nil {
+		t.Fatalf("SetPaymentStatus: %v", err)
+	}
+	status, err = gateway.GetPaymentStatus(ctx, installmentOrder(order, installment))
+	if err != nil {
+		t.Fatalf("GetPaymentStatus: %v", err)
+	}
+	if status.Status != PaymentStatusPaid || status.PaidAt == nil {
+		t.Fatalf("settled payment = %+v, want paid with a paid time", status)
+	}
+
+	installment.Status = InstallmentStatusPaid
+	installment.PaidAt = status.PaidAt
+}
+
+func TestFakeGatewayInstallmentSettlement(t *testing.T) {
+	gateway := NewFakePaymentGateway("secret")
+	order := &OrderModel{ID: "order-1", OrderNumber: "ORD-001", TotalAmount: Rupiah(1000001), PaymentStatus: PaymentStatusPending}
+
+	installments, err := BuildInstallments(order.TotalAmount, &PaymentScheduleRequest{DepositPercent: 30})
+	if err != nil {
+		t.Fatalf("BuildInstallments: %v", err)
+	}
+	order.Installments = installments
+	deposit, balance := &order.Installments[0], &order.Installments[1]
+
+	if err := checkScheduleAllowsStatus(order.Installments, "in_progress"); !errors.Is(err, ErrDepositNotPaid) {
+		t.Fatalf("starting work before the deposit: got %v, want %v", err, ErrDepositNotPaid)
+	}
+
+	settleFakeInstallment(t, gateway, order, deposit)
+	if err := checkScheduleAllowsStatus(order.Installments, "in_progress"); err != nil {
+		t.Fatalf("starting work after the deposit: %v", err)
+	}
+	if err := checkScheduleAllowsStatus(order.Installments, "completed"); !errors.Is(err, ErrBalanceOutstanding) {
+		t.Fatalf("completing with a balance due: got %v, want %v", err, ErrBalanceOutstanding)
+	}
+	if deliverablesUnlocked(order) {
+		t.Fatal("deliverables unlocked with a balance due")
+	}
+	if next := nextUnpaidInstallment(order.Installments); next == nil || next.Sequence != balance.Sequence {
+		t.Fatalf("next unpaid installment = %+v, want the balance", next)
+	}
+
+	settleFakeInstallment(t, gateway, order, balance)
+	summary := installmentBalance(order.Installments)
+	if !summary.FullyPaid || !summary.Paid.Equal(order.TotalAmount) || !summary.Outstanding.IsZero() {
+		t.Fatalf("balance after both installments = %+v, want paid in full", summary)
+	}
+	if !deliverablesUnlocked(order) {
+		t.Fatal("deliverables locked after the order was paid in full")
+	}
+	if err := checkScheduleAllowsStatus(order.Installments, "completed"); err != nil {
+		t.Fatalf("completing a paid order: %v", err)
+	}
+
+	collected := Rupiah(0)
+	for _, payment := range gateway.Payments() {
+		collected = collected.Add(payment.Amount)
+	}
+	if !collected.Equal(order.TotalAmount) {
+		t.Fatalf("gateway collected %s, want the order total %s", collected, order.TotalAmount)
+	}
+}
+
+func TestDeliverablesUnlockedWithoutSchedule(t *testing.T) {
+	order := &OrderModel{TotalAmount: Rupiah(250000), PaymentStatus: PaymentStatusPending}
+	if deliverablesUnlocked(order) {
+		t.Fatal("deliverables unlocked for an unpaid order")
+	}
+
+	order.PaymentStatus = PaymentStatusPaid
+	if !deliverablesUnlocked(order) {
+		t.Fatal("deliverables locked for a paid order")
+	}
+
+	// A scheduled order follows its installments, not the order status
+	order.Installments = []OrderInstallment{{Milestone: MilestoneDelivery, Amount: Rupiah(250000), Status: InstallmentStatusPending}}
+	if deliverablesUnlocked(order) {
+		t.Fatal("deliverables unlocked with an unpaid installment")
+	}
+}
+
+func TestFakeGatewayRefundsAndCancellation(t *testing.T) {
+	ctx := context.Background()
+	gateway := NewFakePaymentGateway("secret")
+	order := &OrderModel{ID: "order-1", OrderNumber: "ORD-001", TotalAmount: Rupiah(500000)}
+
+	payment, err := gateway.CreatePayment(ctx, order)
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	order.PaymentToken = &payment.Reference
+
+	if err := gateway.RefundPayment(ctx, order, Rupiah(100000), "unpaid"); !errors.Is(err, ErrOrderNotPaid) {
+		t.Fatalf("refunding an unpaid payment: got %v, want %v", err, ErrOrderNotPaid)
+	}
+	if err := gateway.SetPaymentStatus(payment.Reference, PaymentStatusPaid); err != nil {
+		t.Fatalf("SetPaymentStatus: %v", err)
+	}
+	if err := gateway.CancelPayment(ctx, order); !errors.Is(err, ErrOrderAlreadyPaid) {
+		t.Fatalf("cancelling a paid payment: got %v, want %v", err, ErrOrderAlreadyPaid)
+	}
+
+	if err := gateway.RefundPayment(ctx, order, Rupiah(200000), "partial"); err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if err := gateway.RefundPayment(ctx, order, Rupiah(300001), "too much"); err == nil {
+		t.Fatal("refund above the paid amount was accepted")
+	}
+	if err := gateway.RefundPayment(ctx, order, Rupiah(300000), "rest"); err != nil {
+		t.Fatalf("refunding the rest: %v", err)
+	}
+
+	status, err := gateway.GetPaymentStatus(ctx, order)
+	if err != nil {
+		t.Fatalf("GetPaymentStatus: %v", err)
+	}
+	if status.Status != PaymentStatusRefunded {
+		t.Fatalf("status after a full refund = %q, want %q", status.Status, PaymentStatusRefunded)
+	}
+}
+
+func TestFakeGatewayFailNext(t *testing.T) {
+	ctx := context.Background()
+	gateway := NewFakePaymentGateway("secret")
+	order := &OrderModel{ID: "order-1", OrderNumber: "ORD-001", TotalAmount: Rupiah(500000)}
+
+	injected := errors.New("gateway unavailable")
+	gateway.FailNext(injected)
+	if _, err := gateway.CreatePayment(ctx, order); !errors.Is(err, injected) {
+		t.Fatalf("CreatePayment with an injected failure: got %v, want %v", err, injected)
+	}
+	if _, err := gateway.CreatePayment(ctx, order); err != nil {
+		t.Fatalf("the failure was not cleared: %v", err)
+	}
+	if len(gateway.Payments()) != 1 {
+		t.Fatalf("payments = %d, want 1", len(gateway.Payments()))
+	}
+}
+
+func TestFakeGatewayVerifyWebhook(t *testing.T) {
+	gateway := NewFakePaymentGateway("secret")
+	payload := []byte(`{"reference":"FAKE-000001","status":"paid"}`)
+
+	headers := http.Header{}
+	headers.Set("X-Fake-Signature", gateway.SignWebhook(payload))
+	if !gateway.VerifyWebhook(payload, headers) {
+		t.Fatal("correctly signed webhook was rejected")
+	}
+	if gateway.VerifyWebhook([]byte(`{"reference":"FAKE-000001","status":"refunded"}`), headers) {
+		t.Fatal("webhook with a changed payload was accepted")
+	}
+	if gateway.VerifyWebhook(payload, http.Header{}) {
+		t.Fatal("unsigned webhook was accepted")
+	}
+
+	forged := http.Header{}
+	forged.Set("X-Fake-Signature", NewFakePaymentGateway("other").SignWebhook(payload))
+	if gateway.VerifyWebhook(payload, forged) {
+		t.Fatal("webhook signed with another secret was accepted")
+	}
+
+	// Without a secret nothing can be verified, so every webhook is refused
+	unconfigured := NewFakePaymentGateway("")
+	headers.Set("X-Fake-Signature", unconfigured.SignWebhook(payload))
+	if unconfigured.VerifyWebhook(payload, headers) {
+		t.Fatal("gateway without a secret accepted a webhook")
+	}
+}
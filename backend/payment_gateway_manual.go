@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ManualTransferConfig holds the account details customers transfer to
+type ManualTransferConfig struct {
+	BankName      string
+	AccountNumber string
+	AccountName   string
+	QRISImageURL  string
+	PaymentTTL    time.Duration
+	MaxUniqueCode int
+}
+
+// LoadManualTransferConfig loads manual transfer settings from environment variables
+func LoadManualTransferConfig() *ManualTransferConfig {
+	return &ManualTransferConfig{
+		BankName:      getEnvWithDefault("MANUAL_TRANSFER_BANK_NAME", ""),
+		AccountNumber: getEnvWithDefault("MANUAL_TRANSFER_ACCOUNT_NUMBER", ""),
+		AccountName:   getEnvWithDefault("MANUAL_TRANSFER_ACCOUNT_NAME", ""),
+		QRISImageURL:  getEnvWithDefault("MANUAL_TRANSFER_QRIS_IMAGE_URL", ""),
+		PaymentTTL:    getEnvDurationWithDefault("MANUAL_TRANSFER_PAYMENT_TTL", 48*time.Hour),
+		MaxUniqueCode: getEnvIntWithDefault("MANUAL_TRANSFER_MAX_UNIQUE_CODE", 999),
+	}
+}
+
+// uniqueCodeAttempts bounds the search for a free unique code
+const uniqueCodeAttempts = 20
+
+// ManualTransferGateway takes payments by bank transfer or static QRIS. Each
+// order gets a unique code added to its total so the incoming transfer can be
+// matched by amount; an admin confirms the payment once it arrives.
+type ManualTransferGateway struct {
+	config    *ManualTransferConfig
+	orderRepo *OrderRepository
+}
+
+// NewManualTransferGateway creates a manual bank transfer gateway
+func NewManualTransferGateway(config *ManualTransferConfig, orderRepo *OrderRepository) *ManualTransferGateway {
+	return &ManualTransferGateway{
+		config:    config,
+		orderRepo: orderRepo,
+	}
+}
+
+// Name returns the gateway identifier
+func (g *ManualTransferGateway) Name() string {
+	return GatewayManualTransfer
+}
+
+// Instructions returns the account details shown to the customer
+func (g *ManualTransferGateway) Instructions() *PaymentInstructions {
+	return &PaymentInstructions{
+		BankName:      g.config.BankName,
+		AccountNumber: g.config.AccountNumber,
+		AccountName:   g.config.AccountName,
+		QRISImageURL:  g.config.QRISImageURL,
+	}
+}
+
+// CreatePayment assigns the order a unique code whose payable amount does not
+// clash with any other unpaid order. An order keeps its code across regeneration.
+func (g *ManualTransferGateway) CreatePayment(ctx context.Context, order *OrderModel) (*GatewayPayment, error) {
+	code, err := g.assignUniqueCode(order)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(g.config.PaymentTTL)
+	return &GatewayPayment{
+		Reference:    fmt.Sprintf("MANUAL-%s", order.OrderNumber),
+		ExpiresAt:    &expiresAt,
+		UniqueCode:   &code,
+		AmountDue:    order.TotalAmount.Add(Rupiah(int64(code))),
+		Instructions: g.Instructions(),
+	}, nil
+}
+
+// assignUniqueCode returns the order's current code if it is still free, otherwise a new random one
+func (g *ManualTransferGateway) assignUniqueCode(order *OrderModel) (int, error) {
+	if order.UniqueCode != nil && *order.UniqueCode > 0 {
+		inUse, err := g.orderRepo.IsPayableAmountInUse(order.TotalAmount.Add(Rupiah(int64(*order.UniqueCode))), order.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !inUse {
+			return *order.UniqueCode, nil
+		}
+	}
+
+	for attempt := 0; attempt < uniqueCodeAttempts; attempt++ {
+		code := rand.Intn(g.config.MaxUniqueCode) + 1
+		inUse, err := g.orderRepo.IsPayableAmountInUse(order.TotalAmount.Add(Rupiah(int64(code))), order.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !inUse {
+			return code, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free unique code for amount %s", order.TotalAmount)
+}
+
+// GetPaymentStatus reports the status recorded on the order; manual payments
+// only change when an admin confirms them
+func (g *ManualTransferGateway) GetPaymentStatus(ctx context.Context, order *OrderModel) (*GatewayPaymentStatus, error) {
+	status := &GatewayPaymentStatus{
+		Gateway: GatewayManualTransfer,
+		Status:  order.PaymentStatus,
+		PaidAt:  order.PaidAt,
+	}
+	if order.PaymentToken != nil {
+		status.Reference = *order.PaymentToken
+	}
+	if order.PaymentMethod != nil {
+		status.PaymentMethod = *order.PaymentMethod
+	}
+	if status.Status == PaymentStatusPending && order.PaymentExpiresAt != nil && time.Now().After(*order.PaymentExpiresAt) {
+		status.Status = PaymentStatusExpired
+	}
+	return status, nil
+}
+
+// CancelPayment releases nothing on the gateway side; the order's unique code
+// becomes free once its payment status leaves pending
+func (g *ManualTransferGateway) CancelPayment(ctx context.Context, order *OrderModel) error {
+	return nil
+}
+
+// RefundPayment records the refund request; the transfer back is made by hand
+func (g *ManualTransferGateway) RefundPayment(ctx context.Context, order *OrderModel, amount Money, reason string) error {
+	LogInfo("Manual transfer refund recorded", logrus.Fields{
+		"order_id": order.ID,
+		"amount":   amount,
+		"reason":   reason,
+	})
+	return nil
+}
+
+// VerifyWebhook always fails: manual transfers have no webhooks
+func (g *ManualTransferGateway) VerifyWebhook(payload []byte, headers http.Header) bool {
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MayarGateway takes payments through Mayar payment requests
+type MayarGateway struct {
+	service *MayarService
+}
+
+// NewMayarGateway creates a gateway backed by the Mayar API
+func NewMayarGateway(service *MayarService) *MayarGateway {
+	return &MayarGateway{service: service}
+}
+
+// Name returns the gateway identifier
+func (g *MayarGateway) Name() string {
+	return GatewayMayar
+}
+
+// CreatePayment creates a Mayar payment request carrying the order ID in its metadata
+func (g *MayarGateway) CreatePayment(ctx context.Context, order *OrderModel) (*GatewayPayment, error) {
+	expiresAt := time.Now().Add(g.service.config.PaymentLinkTTL)
+	req := CreatePaymentRequestRequest{
+		Amount:      order.TotalAmount,
+		Currency:    "IDR",
+		Description: fmt.Sprintf("Payment for %s", order.OrderNumber),
+		ExpiresAt:   expiresAt,
+		CallbackURL: g.service.config.PaymentCallbackURL,
+		Name:        order.CustomerName,
+		Email:       order.CustomerEmail,
+		Metadata: map[string]string{
+			"order_id":     order.ID,
+			"order_number": order.OrderNumber,
+		},
+	}
+	if order.CustomerPhone != nil {
+		req.Mobile = *order.CustomerPhone
+	}
+
+	result, err := g.service.CreatePaymentRequest(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment request: %w", err)
+	}
+	if result.Data.ID == "" || result.Data.PaymentURL == "" {
+		return nil, fmt.Errorf("payment request response is missing id or payment URL")
+	}
+
+	if !result.Data.ExpiresAt.IsZero() {
+		expiresAt = result.Data.ExpiresAt
+	}
+
+	payment := &GatewayPayment{
+		Reference:  result.Data.ID,
+		PaymentURL: result.Data.PaymentURL,
+		ExpiresAt:  &expiresAt,
+		AmountDue:  order.TotalAmount,
+	}
+	if raw, marshalErr := json.Marshal(result.Data); marshalErr == nil {
+		payment.RawResponse = raw
+	}
+	return payment, nil
+}
+
+// GetPaymentStatus looks up the order's payment request in Mayar
+func (g *MayarGateway) GetPaymentStatus(ctx context.Context, order *OrderModel) (*GatewayPaymentStatus, error) {
+	if order.PaymentToken == nil || *order.PaymentToken == "" {
+		return nil, fmt.Errorf("order has no Mayar payment request")
+	}
+
+	result, err := g.service.GetPaymentRequest(ctx, *order.PaymentToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment request: %w", err)
+	}
+
+	status := &GatewayPaymentStatus{
+		Gateway:   GatewayMayar,
+		Reference: result.Data.ID,
+		Status:    mayarPaymentStatus(result.Data.Status),
+	}
+	if status.Status == PaymentStatusPaid {
+		status.PaidAt = order.PaidAt
+	}
+	return status, nil
+}
+
+// mayarPaymentStatus maps a Mayar payment request status to a payment status
+func mayarPaymentStatus(status string) string {
+	switch status {
+	case "paid", "settled", "completed", "success":
+		return PaymentStatusPaid
+	case "failed":
+		return PaymentStatusFailed
+	case "expired":
+		return PaymentStatusExpired
+	case "cancelled", "closed":
+		return PaymentStatusCancelled
+	default:
+		return PaymentStatusPending
+	}
+}
+
+// CancelPayment is a no-op: Mayar payment requests cannot be cancelled through
+// the API, so the link is dropped locally and left to expire
+func (g *MayarGateway) CancelPayment(ctx context.Context, order *OrderModel) error {
+	return nil
+}
+
+// RefundPayment is not available through the Mayar API and must be done in the Mayar dashboard
+func (g *MayarGateway) RefundPayment(ctx context.Context, order *OrderModel, amount Money, reason string) error {
+	return ErrGatewayOperationUnsupported
+}
+
+// VerifyWebhook checks the Mayar signature, timestamp and replay cache
+func (g *MayarGateway) VerifyWebhook(payload []byte, headers http.Header) bool {
+	_, err := g.service.VerifyWebhook(headers, payload)
+	return err == nil
+}
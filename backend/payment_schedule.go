@@ -0,0 +1,818 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Installment milestones: when an installment falls due
+const (
+	// MilestoneUpfront installments must be paid before work starts
+	MilestoneUpfront = "upfront"
+	// MilestoneProgress installments fall due during the work and block nothing
+	MilestoneProgress = "progress"
+	// MilestoneDelivery installments must be paid before final deliverables are released
+	MilestoneDelivery = "delivery"
+)
+
+// Installment statuses
+const (
+	InstallmentStatusPending  = "pending"
+	InstallmentStatusPaid     = "paid"
+	InstallmentStatusRefunded = "refunded"
+)
+
+// Order statuses gated by the payment schedule
+var (
+	// workStatuses need every upfront installment paid
+	workStatuses = map[string]bool{"processing": true, "in_progress": true}
+	// deliveryStatuses need the order paid in full
+	deliveryStatuses = map[string]bool{"shipped": true, "delivered": true, "completed": true}
+)
+
+var (
+	// ErrInvalidPaymentSchedule is returned for a schedule that does not add up to the order total
+	ErrInvalidPaymentSchedule = errors.New("invalid payment schedule")
+	// ErrPaymentScheduleLocked is returned when changing a schedule after an installment was paid
+	ErrPaymentScheduleLocked = errors.New("payment schedule cannot change after an installment is paid")
+	// ErrInstallmentNotFound is returned for an unknown installment
+	ErrInstallmentNotFound = errors.New("installment not found")
+	// ErrInstallmentAlreadyPaid is returned when a payment is requested for a paid installment
+	ErrInstallmentAlreadyPaid = errors.New("installment is already paid")
+	// ErrDepositNotPaid is returned when work is started before the deposit is paid
+	ErrDepositNotPaid = errors.New("deposit has not been paid")
+	// ErrBalanceOutstanding is returned when an order is delivered before it is paid in full
+	ErrBalanceOutstanding = errors.New("order has an outstanding balance")
+)
+
+// OrderInstallment is one part of an order's payment schedule. Each installment
+// is paid through its own payment link or unique code.
+type OrderInstallment struct {
+	ID               string     `json:"id" db:"id"`
+	OrderID          string     `json:"orderId" db:"order_id"`
+	Sequence         int        `json:"sequence" db:"sequence"`
+	Label            string     `json:"label" db:"label"`
+	Milestone        string     `json:"milestone" db:"milestone"`
+	Percent          int        `json:"percent" db:"percent"`
+	Amount           Money      `json:"amount" db:"amount"`
+	Status           string     `json:"status" db:"status"`
+	PaymentGateway   *string    `json:"paymentGateway" db:"payment_gateway"`
+	PaymentToken     *string    `json:"paymentToken" db:"payment_token"`
+	PaymentURL       *string    `json:"paymentUrl" db:"payment_url"`
+	PaymentExpiresAt *time.Time `json:"paymentExpiresAt" db:"payment_expires_at"`
+	UniqueCode       *int       `json:"uniqueCode" db:"unique_code"`
+	PaidAt           *time.Time `json:"paidAt" db:"paid_at"`
+	CreatedAt        time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// PaymentScheduleRequest sets an order's payment schedule. Milestones take
+// precedence; otherwise DepositPercent splits the order into a deposit paid up
+// front and a balance paid on delivery. An empty request means payment in full.
+type PaymentScheduleRequest struct {
+	DepositPercent int                  `json:"depositPercent"`
+	Milestones     []InstallmentRequest `json:"milestones"`
+}
+
+// InstallmentRequest describes one installment of a schedule
+type InstallmentRequest struct {
+	Label     string `json:"label"`
+	Milestone string `json:"milestone"`
+	Percent   int    `json:"percent"`
+}
+
+// IsEmpty reports whether the request asks for payment in full
+func (r *PaymentScheduleRequest) IsEmpty() bool {
+	return r == nil || (r.DepositPercent == 0 && len(r.Milestones) == 0)
+}
+
+// BuildInstallments splits total according to the schedule. Every installment
+// but the last is rounded to whole rupiah; the last takes the remainder so the
+// installments always add up to the total exactly.
+func BuildInstallments(total Money, schedule *PaymentScheduleRequest) ([]OrderInstallment, error) {
+	if schedule.IsEmpty() {
+		return nil, nil
+	}
+
+	milestones := schedule.Milestones
+	if len(milestones) == 0 {
+		if schedule.DepositPercent <= 0 || schedule.DepositPercent >= 100 {
+			return nil, fmt.Errorf("%w: depositPercent must be between 1 and 99", ErrInvalidPaymentSchedule)
+		}
+		milestones = []InstallmentRequest{
+			{Label: "Down payment", Milestone: MilestoneUpfront, Percent: schedule.DepositPercent},
+			{Label: "Balance", Milestone: MilestoneDelivery, Percent: 100 - schedule.DepositPercent},
+		}
+	}
+
+	sum := 0
+	for i, milestone := range milestones {
+		if milestone.Percent <= 0 {
+			return nil, fmt.Errorf("%w: installment %d has no percentage", ErrInvalidPaymentSchedule, i+1)
+		}
+		switch milestone.Milestone {
+		case MilestoneUpfront, MilestoneProgress, MilestoneDelivery:
+		default:
+			return nil, fmt.Errorf("%w: unknown milestone %q", ErrInvalidPaymentSchedule, milestone.Milestone)
+		}
+		sum += milestone.Percent
+	}
+	if sum != 100 {
+		return nil, fmt.Errorf("%w: percentages add up to %d, not 100", ErrInvalidPaymentSchedule, sum)
+	}
+
+	installments := make([]OrderInstallment, len(milestones))
+	allocated := Rupiah(0)
+	for i, milestone := range milestones {
+		amount := total.Percent(int64(milestone.Percent) * 100)
+		if i == len(milestones)-1 {
+			amount = total.Sub(allocated)
+		}
+		allocated = allocated.Add(amount)
+
+		label := milestone.Label
+		if label == "" {
+			label = fmt.Sprintf("Installment %d", i+1)
+		}
+		installments[i] = OrderInstallment{
+			Sequence:  i + 1,
+			Label:     label,
+			Milestone: milestone.Milestone,
+			Percent:   milestone.Percent,
+			Amount:    amount,
+			Status:    InstallmentStatusPending,
+		}
+	}
+
+	return installments, nil
+}
+
+// InstallmentBalance summarises how much of a scheduled order is paid
+type InstallmentBalance struct {
+	Total       Money `json:"total"`
+	Paid        Money `json:"paid"`
+	Outstanding Money `json:"outstanding"`
+	DepositPaid bool  `json:"depositPaid"`
+	FullyPaid   bool  `json:"fullyPaid"`
+}
+
+// installmentBalance totals an order's installments
+func installmentBalance(installments []OrderInstallment) InstallmentBalance {
+	balance := InstallmentBalance{Total: Rupiah(0), Paid: Rupiah(0), DepositPaid: true}
+	for _, installment := range installments {
+		balance.Total = balance.Total.Add(installment.Amount)
+		if installment.Status == InstallmentStatusPaid {
+			balance.Paid = balance.Paid.Add(installment.Amount)
+		} else if installment.Milestone == MilestoneUpfront {
+			balance.DepositPaid = false
+		}
+	}
+	balance.Outstanding = balance.Total.Sub(balance.Paid)
+	balance.FullyPaid = !balance.Outstanding.IsPositive()
+	return balance
+}
+
+// nextUnpaidInstallment returns the first installment that is not paid
+func nextUnpaidInstallment(installments []OrderInstallment) *OrderInstallment {
+	for i := range installments {
+		if installments[i].Status != InstallmentStatusPaid && installments[i].Status != InstallmentStatusRefunded {
+			return &installments[i]
+		}
+	}
+	return nil
+}
+
+// checkScheduleAllowsStatus blocks starting work before the deposit is paid and
+// delivering before the order is paid in full. Orders without a schedule are not gated.
+func checkScheduleAllowsStatus(installments []OrderInstallment, status string) error {
+	if len(installments) == 0 {
+		return nil
+	}
+
+	balance := installmentBalance(installments)
+	if workStatuses[status] && !balance.DepositPaid {
+		return ErrDepositNotPaid
+	}
+	if deliveryStatuses[status] && !balance.FullyPaid {
+		return ErrBalanceOutstanding
+	}
+	return nil
+}
+
+// FileCategoryDeliverable marks an order file as a final deliverable
+const FileCategoryDeliverable = "deliverable"
+
+// isDeliverable reports whether an order file's category marks a final deliverable
+func isDeliverable(fileCategory *string) bool {
+	return fileCategory != nil && *fileCategory == FileCategoryDeliverable
+}
+
+// deliverablesUnlocked reports whether an order's final deliverables may be
+// released: a scheduled order once every installment is paid, any other order
+// once it is paid. Every path that serves order files must check it.
+func deliverablesUnlocked(order *OrderModel) bool {
+	if len(order.Installments) > 0 {
+		return installmentBalance(order.Installments).FullyPaid
+	}
+	return order.PaymentStatus == PaymentStatusPaid
+}
+
+// =============================================================================
+// REPOSITORY
+// =============================================================================
+
+const installmentColumns = `id, order_id, sequence, label, milestone, percent, amount, status, payment_gateway,
+	payment_token, payment_url, payment_expires_at, unique_code, paid_at, created_at, updated_at`
+
+func scanInstallment(row interface{ Scan(...interface{}) error }) (*OrderInstallment, error) {
+	var installment OrderInstallment
+	err := row.Scan(
+		&installment.ID, &installment.OrderID, &installment.Sequence, &installment.Label,
+		&installment.Milestone, &installment.Percent, &installment.Amount, &installment.Status,
+		&installment.PaymentGateway, &installment.PaymentToken, &installment.PaymentURL,
+		&installment.PaymentExpiresAt, &installment.UniqueCode, &installment.PaidAt,
+		&installment.CreatedAt, &installment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &installment, nil
+}
+
+// getInstallments loads an order's installments in sequence order
+func getInstallments(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, orderID string) ([]OrderInstallment, error) {
+	rows, err := q.Query("SELECT "+installmentColumns+" FROM order_installments WHERE order_id = $1 ORDER BY sequence", orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query installments: %w", err)
+	}
+	defer rows.Close()
+
+	installments := []OrderInstallment{}
+	for rows.Next() {
+		installment, scanErr := scanInstallment(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan installment: %w", scanErr)
+		}
+		installments = append(installments, *installment)
+	}
+
+	return installments, rows.Err()
+}
+
+// GetInstallments returns an order's installments; an empty list means the order is paid in full
+func (r *OrderRepository) GetInstallments(orderID string) ([]OrderInstallment, error) {
+	return getInstallments(r.db, orderID)
+}
+
+// insertInstallments stores a new schedule for an order
+func (r *OrderRepository) insertInstallments(tx *sql.Tx, orderID string, installments []OrderInstallment) error {
+	query := `
+		INSERT INTO order_installments (order_id, sequence, label, milestone, percent, amount, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`
+
+	for i := range installments {
+		installments[i].OrderID = orderID
+		err := tx.QueryRow(query, orderID, installments[i].Sequence, installments[i].Label,
+			installments[i].Milestone, installments[i].Percent, installments[i].Amount, installments[i].Status,
+		).Scan(&installments[i].ID, &installments[i].CreatedAt, &installments[i].UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create installment: %w", err)
+		}
+	}
+	return nil
+}
+
+// productDepositPercent returns the largest default deposit among the given products, or 0
+func productDepositPercent(tx *sql.Tx, productIDs []string) (int, error) {
+	if len(productIDs) == 0 {
+		return 0, nil
+	}
+
+	var deposit sql.NullInt64
+	err := tx.QueryRow("SELECT MAX(deposit_percent) FROM products WHERE id::text = ANY($1)",
+		pq.Array(productIDs)).Scan(&deposit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get product deposit: %w", err)
+	}
+	return int(deposit.Int64), nil
+}
+
+// ReplaceInstallments sets a new payment schedule for an order. It fails once
+// any installment has been paid; an empty schedule returns the order to payment in full.
+func (r *OrderRepository) ReplaceInstallments(orderID string, installments []OrderInstallment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in ReplaceInstallments", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	var paidCount int
+	err = tx.QueryRow("SELECT COUNT(*) FROM order_installments WHERE order_id = $1 AND status <> $2",
+		orderID, InstallmentStatusPending).Scan(&paidCount)
+	if err != nil {
+		return fmt.Errorf("failed to check installments: %w", err)
+	}
+	if paidCount > 0 {
+		return ErrPaymentScheduleLocked
+	}
+
+	if _, err = tx.Exec("DELETE FROM order_installments WHERE order_id = $1", orderID); err != nil {
+		return fmt.Errorf("failed to delete installments: %w", err)
+	}
+	if err = r.insertInstallments(tx, orderID, installments); err != nil {
+		return err
+	}
+
+	notes := "Payment schedule removed, order is paid in full"
+	if len(installments) > 0 {
+		notes = fmt.Sprintf("Payment schedule set to %d installments", len(installments))
+	}
+	if err = r.addStatusHistory(tx, orderID, "payment_schedule_updated", &notes, nil); err != nil {
+		return fmt.Errorf("failed to add status history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SetInstallmentPayment stores the payment created by a gateway on an
+// installment and records it as a payment attempt of the order
+func (r *OrderRepository) SetInstallmentPayment(orderID, installmentID, gateway string, payment *GatewayPayment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in SetInstallmentPayment", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	var paymentURL *string
+	if payment.PaymentURL != "" {
+		paymentURL = &payment.PaymentURL
+	}
+
+	result, err := tx.Exec(`
+		UPDATE order_installments
+		SET payment_gateway = $1, payment_token = $2, payment_url = $3, payment_expires_at = $4,
+		    unique_code = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND order_id = $7
+	`, gateway, payment.Reference, paymentURL, payment.ExpiresAt, payment.UniqueCode, installmentID, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update installment payment: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrInstallmentNotFound
+	}
+
+	if err := r.insertPaymentAttempt(tx, orderID, installmentID, gateway, payment); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// OutstandingBalance is an order with installments still to be paid
+type OutstandingBalance struct {
+	OrderID         string     `json:"orderId"`
+	OrderNumber     string     `json:"orderNumber"`
+	CustomerName    string     `json:"customerName"`
+	CustomerEmail   string     `json:"customerEmail"`
+	Status          string     `json:"status"`
+	Total           Money      `json:"total"`
+	Paid            Money      `json:"paid"`
+	Outstanding     Money      `json:"outstanding"`
+	DepositPaid     bool       `json:"depositPaid"`
+	NextInstallment string     `json:"nextInstallment"`
+	NextAmount      Money      `json:"nextAmount"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastPaidAt      *time.Time `json:"lastPaidAt"`
+}
+
+// GetOutstandingBalances lists scheduled, uncancelled orders that are not yet paid in full,
+// largest outstanding balance first
+func (r *OrderRepository) GetOutstandingBalances() ([]OutstandingBalance, error) {
+	rows, err := r.db.Query(`
+		SELECT o.id, o.order_number, o.customer_name, o.customer_email, o.status, o.created_at,
+		       SUM(i.amount) AS total,
+		       COALESCE(SUM(i.amount) FILTER (WHERE i.status = 'paid'), 0) AS paid,
+		       BOOL_AND(i.status = 'paid' OR i.milestone <> 'upfront') AS deposit_paid,
+		       MAX(i.paid_at) AS last_paid_at,
+		       (ARRAY_AGG(i.label ORDER BY i.sequence) FILTER (WHERE i.status = 'pending'))[1] AS next_label,
+		       (ARRAY_AGG(i.amount ORDER BY i.sequence) FILTER (WHERE i.status = 'pending'))[1] AS next_amount
+		FROM orders o
+		JOIN order_installments i ON i.order_id = o.id
+		WHERE o.status <> 'cancelled'
+		GROUP BY o.id
+		HAVING COUNT(*) FILTER (WHERE i.status = 'pending') > 0
+		ORDER BY SUM(i.amount) FILTER (WHERE i.status = 'pending') DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outstanding balances: %w", err)
+	}
+	defer rows.Close()
+
+	balances := []OutstandingBalance{}
+	for rows.Next() {
+		var b OutstandingBalance
+		var nextLabel sql.NullString
+		scanErr := rows.Scan(&b.OrderID, &b.OrderNumber, &b.CustomerName, &b.CustomerEmail, &b.Status,
+			&b.CreatedAt, &b.Total, &b.Paid, &b.DepositPaid, &b.LastPaidAt, &nextLabel, &b.NextAmount)
+		if scanErr != nil {
+			return nil, fmt.Errorf("failed to scan outstanding balance: %w", scanErr)
+		}
+		b.NextInstallment = nextLabel.String
+		b.Outstanding = b.Total.Sub(b.Paid)
+		balances = append(balances, b)
+	}
+
+	return balances, rows.Err()
+}
+
+// =============================================================================
+// SERVICE
+// =============================================================================
+
+// installmentOrder returns a copy of order that gateways treat as an order for
+// the installment alone: its amount, its own reference and its unique code
+func installmentOrder(order *OrderModel, installment *OrderInstallment) *OrderModel {
+	copied := *order
+	copied.OrderNumber = fmt.Sprintf("%s-%d", order.OrderNumber, installment.Sequence)
+	copied.TotalAmount = installment.Amount
+	copied.PaymentGateway = installment.PaymentGateway
+	copied.PaymentToken = installment.PaymentToken
+	copied.PaymentURL = installment.PaymentURL
+	copied.PaymentExpiresAt = installment.PaymentExpiresAt
+	copied.UniqueCode = installment.UniqueCode
+	copied.PaymentMethod = nil
+	copied.PaidAt = installment.PaidAt
+	copied.PaymentStatus = installment.Status
+	copied.Items = nil
+	copied.Installments = nil
+	return &copied
+}
+
+// installmentForPayment returns the installment an order-level payment
+// operation applies to: the next unpaid one, or nil when the order is paid in full
+func (s *PaymentService) installmentForPayment(order *OrderModel) (*OrderInstallment, error) {
+	installments, err := s.orderRepo.GetInstallments(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(installments) == 0 {
+		return nil, nil
+	}
+	installment := nextUnpaidInstallment(installments)
+	if installment == nil {
+		return nil, ErrOrderAlreadyPaid
+	}
+	return installment, nil
+}
+
+// paymentTarget returns what an order-level payment operation applies to:
+// the order itself, or its next unpaid installment with that installment's ID
+func (s *PaymentService) paymentTarget(order *OrderModel) (*OrderModel, string, error) {
+	installment, err := s.installmentForPayment(order)
+	if err != nil || installment == nil {
+		return order, "", err
+	}
+	return installmentOrder(order, installment), installment.ID, nil
+}
+
+// refundTarget returns what a refund applies to: the order itself, or its most
+// recently paid installment with that installment's ID
+func (s *PaymentService) refundTarget(order *OrderModel) (*OrderModel, string, error) {
+	installments, err := s.orderRepo.GetInstallments(order.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var paid *OrderInstallment
+	for i := range installments {
+		if installments[i].Status == InstallmentStatusPaid &&
+			(paid == nil || paid.PaidAt == nil ||
+				(installments[i].PaidAt != nil && installments[i].PaidAt.After(*paid.PaidAt))) {
+			paid = &installments[i]
+		}
+	}
+	if len(installments) == 0 {
+		return order, "", nil
+	}
+	if paid == nil {
+		return nil, "", ErrOrderNotPaid
+	}
+	return installmentOrder(order, paid), paid.ID, nil
+}
+
+// EnsureInstallmentPayment returns an active payment for an installment,
+// creating one when it has none, it expired, regenerate is set or gatewayName
+// selects a different gateway
+func (s *PaymentService) EnsureInstallmentPayment(ctx context.Context, order *OrderModel, installment *OrderInstallment, gatewayName string, regenerate bool) (*GatewayPayment, bool, error) {
+	if installment.Status == InstallmentStatusPaid {
+		return nil, false, ErrInstallmentAlreadyPaid
+	}
+
+	target := installmentOrder(order, installment)
+	if target.PaymentGateway == nil {
+		target.PaymentGateway = order.PaymentGateway
+	}
+
+	var gateway PaymentGateway
+	var err error
+	if gatewayName != "" {
+		if !s.Enabled() {
+			return nil, false, ErrPaymentGatewayUnavailable
+		}
+		gateway, err = s.gateways.Get(gatewayName)
+	} else {
+		gateway, err = s.gatewayForOrder(target)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	sameGateway := installment.PaymentGateway != nil && *installment.PaymentGateway == gateway.Name()
+	if !regenerate && sameGateway && hasActivePayment(target, time.Now()) {
+		return paymentFromOrder(target, gateway), false, nil
+	}
+
+	payment, err := gateway.CreatePayment(ctx, target)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.orderRepo.SetInstallmentPayment(order.ID, installment.ID, gateway.Name(), payment); err != nil {
+		return nil, false, err
+	}
+
+	gatewayUsed := gateway.Name()
+	installment.PaymentGateway = &gatewayUsed
+	installment.PaymentToken = &payment.Reference
+	installment.PaymentURL = nil
+	if payment.PaymentURL != "" {
+		installment.PaymentURL = &payment.PaymentURL
+	}
+	installment.PaymentExpiresAt = payment.ExpiresAt
+	installment.UniqueCode = payment.UniqueCode
+
+	LogInfo("Payment created for installment", logrus.Fields{
+		"order_id":       order.ID,
+		"installment_id": installment.ID,
+		"sequence":       installment.Sequence,
+		"gateway":        gatewayUsed,
+		"reference":      payment.Reference,
+		"amount":         installment.Amount,
+	})
+
+	return payment, true, nil
+}
+
+// ConfirmManualInstallmentPayment marks a manual transfer for an installment as paid
+func (s *PaymentService) ConfirmManualInstallmentPayment(order *OrderModel, installment *OrderInstallment, paymentMethod string) error {
+	if installment.Status == InstallmentStatusPaid {
+		return ErrInstallmentAlreadyPaid
+	}
+
+	gateway, err := s.gatewayForOrder(installmentOrder(order, installment))
+	if err != nil {
+		return err
+	}
+	if _, ok := gateway.(*ManualTransferGateway); !ok {
+		return ErrGatewayOperationUnsupported
+	}
+
+	now := time.Now()
+	return s.orderRepo.RecordPaymentAttempt(order.ID, PaymentAttemptUpdate{
+		InstallmentID: installment.ID,
+		Gateway:       GatewayManualTransfer,
+		ExternalID:    stringValue(installment.PaymentToken),
+		Status:        PaymentStatusPaid,
+		PaymentMethod: &paymentMethod,
+		At:            &now,
+	})
+}
+
+// =============================================================================
+// HANDLERS
+// =============================================================================
+
+// respondScheduleError maps payment schedule errors to HTTP responses
+func respondScheduleError(c *gin.Context, requestID, orderID string, err error) {
+	status := http.StatusInternalServerError
+	message := "Payment schedule operation failed"
+	switch {
+	case errors.Is(err, ErrInvalidPaymentSchedule):
+		status, message = http.StatusBadRequest, "Invalid payment schedule"
+	case errors.Is(err, ErrInstallmentNotFound):
+		status, message = http.StatusNotFound, "Installment not found"
+	case errors.Is(err, ErrPaymentScheduleLocked), errors.Is(err, ErrInstallmentAlreadyPaid):
+		status, message = http.StatusConflict, "Installment already paid"
+	default:
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"error":      message,
+		"details":    err.Error(),
+		"request_id": requestID,
+	})
+}
+
+// loadInstallment finds the installment named in the URL
+func (h *OrderHandler) loadInstallment(c *gin.Context, requestID string, order *OrderModel) (*OrderInstallment, bool) {
+	sequence, err := strconv.Atoi(c.Param("sequence"))
+	if err == nil {
+		var installments []OrderInstallment
+		installments, err = h.repo.GetInstallments(order.ID)
+		if err == nil {
+			for i := range installments {
+				if installments[i].Sequence == sequence {
+					return &installments[i], true
+				}
+			}
+		}
+	}
+
+	respondScheduleError(c, requestID, order.ID, ErrInstallmentNotFound)
+	return nil, false
+}
+
+// GetInstallments handles GET /api/orders/:id/installments
+func (h *OrderHandler) GetInstallments(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	installments, err := h.repo.GetInstallments(order.ID)
+	if err != nil {
+		respondScheduleError(c, requestID, order.ID, err)
+		return
+	}
+
+	response := gin.H{
+		"data":          installments,
+		"paymentStatus": order.PaymentStatus,
+		"request_id":    requestID,
+	}
+	if len(installments) > 0 {
+		response["balance"] = installmentBalance(installments)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetPaymentSchedule handles PUT /api/orders/:id/installments
+func (h *OrderHandler) SetPaymentSchedule(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	var req PaymentScheduleRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+	if order.PaymentStatus == PaymentStatusPaid {
+		respondPaymentError(c, requestID, order.ID, ErrOrderAlreadyPaid)
+		return
+	}
+
+	installments, err := BuildInstallments(order.TotalAmount, &req)
+	if err == nil {
+		err = h.repo.ReplaceInstallments(order.ID, installments)
+	}
+	if err != nil {
+		respondScheduleError(c, requestID, order.ID, err)
+		return
+	}
+
+	LogInfo("Payment schedule updated", logrus.Fields{
+		"request_id":   requestID,
+		"order_id":     order.ID,
+		"installments": len(installments),
+	})
+
+	if installments == nil {
+		installments = []OrderInstallment{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment schedule updated",
+		"data":       installments,
+		"request_id": requestID,
+	})
+}
+
+// CreateInstallmentPaymentLink handles POST /api/orders/:id/installments/:sequence/payment-link
+func (h *OrderHandler) CreateInstallmentPaymentLink(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	var req CreatePaymentLinkRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+	installment, ok := h.loadInstallment(c, requestID, order)
+	if !ok {
+		return
+	}
+
+	payment, created, err := h.payments.EnsureInstallmentPayment(c.Request.Context(), order, installment, req.Gateway, req.Regenerate)
+	if err != nil {
+		respondScheduleError(c, requestID, order.ID, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"installment": installment,
+		"payment":     payment,
+		"created":     created,
+		"request_id":  requestID,
+	})
+}
+
+// ConfirmInstallmentPayment handles POST /api/orders/:id/installments/:sequence/confirm
+func (h *OrderHandler) ConfirmInstallmentPayment(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	var req ConfirmPaymentRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = "bank_transfer"
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+	installment, ok := h.loadInstallment(c, requestID, order)
+	if !ok {
+		return
+	}
+
+	if err := h.payments.ConfirmManualInstallmentPayment(order, installment, req.PaymentMethod); err != nil {
+		respondScheduleError(c, requestID, order.ID, err)
+		return
+	}
+
+	LogInfo("Installment payment confirmed", logrus.Fields{
+		"request_id": requestID,
+		"order_id":   order.ID,
+		"sequence":   installment.Sequence,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Installment payment confirmed",
+		"request_id": requestID,
+	})
+}
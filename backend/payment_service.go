@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PaymentService creates and manages order payments through the registered gateways
+type PaymentService struct {
+	gateways  *PaymentGatewayRegistry
+	orderRepo *OrderRepository
+}
+
+// NewPaymentService creates a new payment service.
+// A nil registry disables payments.
+func NewPaymentService(gateways *PaymentGatewayRegistry, orderRepo *OrderRepository) *PaymentService {
+	return &PaymentService{
+		gateways:  gateways,
+		orderRepo: orderRepo,
+	}
+}
+
+// Enabled reports whether any payment gateway is available
+func (s *PaymentService) Enabled() bool {
+	return s != nil && s.gateways != nil && s.gateways.Default() != ""
+}
+
+// ResolveGateway validates a checkout's gateway choice and returns the gateway
+// name to record, falling back to the default for an empty choice
+func (s *PaymentService) ResolveGateway(name string) (string, error) {
+	if !s.Enabled() {
+		if name == "" {
+			return "", nil
+		}
+		return "", ErrPaymentGatewayUnavailable
+	}
+
+	gateway, err := s.gateways.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return gateway.Name(), nil
+}
+
+// gatewayForOrder returns the gateway recorded on the order, or the default one
+func (s *PaymentService) gatewayForOrder(order *OrderModel) (PaymentGateway, error) {
+	if !s.Enabled() {
+		return nil, ErrPaymentGatewayUnavailable
+	}
+
+	name := ""
+	if order.PaymentGateway != nil {
+		name = *order.PaymentGateway
+	}
+	return s.gateways.Get(name)
+}
+
+// hasActivePayment reports whether the order carries a payment that can still be paid.
+// Payments stored without an expiry are treated as active.
+func hasActivePayment(order *OrderModel, now time.Time) bool {
+	if order.PaymentToken == nil || *order.PaymentToken == "" {
+		return false
+	}
+	if order.PaymentStatus != PaymentStatusPending {
+		return false
+	}
+	if order.PaymentExpiresAt == nil {
+		return true
+	}
+	return now.Before(*order.PaymentExpiresAt)
+}
+
+// paymentFromOrder describes the payment already stored on an order
+func paymentFromOrder(order *OrderModel, gateway PaymentGateway) *GatewayPayment {
+	payment := &GatewayPayment{
+		ExpiresAt:  order.PaymentExpiresAt,
+		UniqueCode: order.UniqueCode,
+		AmountDue:  order.TotalAmount,
+	}
+	if order.PaymentToken != nil {
+		payment.Reference = *order.PaymentToken
+	}
+	if order.PaymentURL != nil {
+		payment.PaymentURL = *order.PaymentURL
+	}
+	if order.UniqueCode != nil {
+		payment.AmountDue = payment.AmountDue.Add(Rupiah(int64(*order.UniqueCode)))
+	}
+	if instructor, ok := gateway.(paymentInstructor); ok {
+		payment.Instructions = instructor.Instructions()
+	}
+	return payment
+}
+
+// EnsurePayment returns the order with an active payment, creating a new one
+// when the order has none, it expired, regenerate is set or gatewayName selects
+// a different gateway than the order's current one. The boolean result reports
+// whether a new payment was created. Orders with installments get a payment
+// for their next unpaid installment.
+func (s *PaymentService) EnsurePayment(ctx context.Context, order *OrderModel, gatewayName string, regenerate bool) (*OrderModel, *GatewayPayment, bool, error) {
+	if order.PaymentStatus == PaymentStatusPaid {
+		return order, nil, false, ErrOrderAlreadyPaid
+	}
+
+	installment, err := s.installmentForPayment(order)
+	if err != nil {
+		return order, nil, false, err
+	}
+	if installment != nil {
+		payment, created, err := s.EnsureInstallmentPayment(ctx, order, installment, gatewayName, regenerate)
+		return order, payment, created, err
+	}
+
+	var gateway PaymentGateway
+	if gatewayName != "" {
+		if !s.Enabled() {
+			return order, nil, false, ErrPaymentGatewayUnavailable
+		}
+		gateway, err = s.gateways.Get(gatewayName)
+	} else {
+		gateway, err = s.gatewayForOrder(order)
+	}
+	if err != nil {
+		return order, nil, false, err
+	}
+
+	// Orders created before gateways were recorded keep their existing payment
+	sameGateway := (order.PaymentGateway == nil && gatewayName == "") ||
+		(order.PaymentGateway != nil && *order.PaymentGateway == gateway.Name())
+	if !regenerate && sameGateway && hasActivePayment(order, time.Now()) {
+		return order, paymentFromOrder(order, gateway), false, nil
+	}
+
+	payment, err := gateway.CreatePayment(ctx, order)
+	if err != nil {
+		return order, nil, false, err
+	}
+
+	if err := s.orderRepo.SetOrderPayment(order.ID, gateway.Name(), payment); err != nil {
+		return order, nil, false, err
+	}
+
+	gatewayUsed := gateway.Name()
+	order.PaymentGateway = &gatewayUsed
+	order.PaymentToken = &payment.Reference
+	order.PaymentURL = nil
+	if payment.PaymentURL != "" {
+		order.PaymentURL = &payment.PaymentURL
+	}
+	order.PaymentExpiresAt = payment.ExpiresAt
+	order.UniqueCode = payment.UniqueCode
+	order.PaymentStatus = PaymentStatusPending
+
+	LogInfo("Payment created for order", logrus.Fields{
+		"order_id":    order.ID,
+		"gateway":     gatewayUsed,
+		"reference":   payment.Reference,
+		"expires_at":  payment.ExpiresAt,
+		"regenerated": regenerate,
+	})
+
+	return order, payment, true, nil
+}
+
+// GetPaymentStatus asks the order's gateway for the payment status and records
+// a settled or failed payment on the order if it was not yet recorded. For
+// orders with installments it reports the payment of the next unpaid installment.
+func (s *PaymentService) GetPaymentStatus(ctx context.Context, order *OrderModel) (*GatewayPaymentStatus, error) {
+	target, installmentID, err := s.paymentTarget(order)
+	if err != nil {
+		return nil, err
+	}
+
+	gateway, err := s.gatewayForOrder(target)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := gateway.GetPaymentStatus(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	settled := status.Status == PaymentStatusPaid || status.Status == PaymentStatusFailed
+	if settled && status.Status != target.PaymentStatus {
+		paidAt := status.PaidAt
+		if status.Status == PaymentStatusPaid && paidAt == nil {
+			now := time.Now()
+			paidAt = &now
+		}
+		var paymentMethod *string
+		if status.PaymentMethod != "" {
+			paymentMethod = &status.PaymentMethod
+		}
+		err := s.orderRepo.RecordPaymentAttempt(order.ID, PaymentAttemptUpdate{
+			InstallmentID: installmentID,
+			Gateway:       gateway.Name(),
+			ExternalID:    status.Reference,
+			Status:        status.Status,
+			PaymentMethod: paymentMethod,
+			At:            paidAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
+
+// CancelPayment cancels the order's pending payment, or that of its next unpaid installment
+func (s *PaymentService) CancelPayment(ctx context.Context, order *OrderModel) error {
+	if order.PaymentStatus == PaymentStatusPaid {
+		return ErrOrderAlreadyPaid
+	}
+
+	target, installmentID, err := s.paymentTarget(order)
+	if err != nil {
+		return err
+	}
+
+	gateway, err := s.gatewayForOrder(target)
+	if err != nil {
+		return err
+	}
+	if err := gateway.CancelPayment(ctx, target); err != nil {
+		return err
+	}
+
+	return s.orderRepo.RecordPaymentAttempt(order.ID, PaymentAttemptUpdate{
+		InstallmentID: installmentID,
+		Gateway:       gateway.Name(),
+		ExternalID:    stringValue(target.PaymentToken),
+		Status:        PaymentStatusCancelled,
+	})
+}
+
+// RefundPayment refunds a paid order; a zero amount refunds what is left of the
+// payment. For orders with installments it refunds the most recently paid
+// installment. Refunds are capped by the paid attempt's amount, which includes
+// a manual transfer's unique code, less what was refunded before.
+func (s *PaymentService) RefundPayment(ctx context.Context, order *OrderModel, amount Money, reason string) error {
+	if order.PaymentStatus != PaymentStatusPaid && order.PaymentStatus != PaymentStatusPartiallyPaid {
+		return ErrOrderNotPaid
+	}
+
+	target, installmentID, err := s.refundTarget(order)
+	if err != nil {
+		return err
+	}
+
+	// Refund the attempt that was paid, which need not be the latest one
+	attempts, err := s.orderRepo.GetPaymentAttempts(order.ID)
+	if err != nil {
+		return err
+	}
+	var paid *PaymentAttempt
+	for i := range attempts {
+		if attempts[i].Status == PaymentStatusPaid && stringValue(attempts[i].InstallmentID) == installmentID {
+			paid = &attempts[i]
+			break
+		}
+	}
+
+	// Orders paid before attempts were recorded fall back to their total
+	refundable := target.TotalAmount
+	if paid != nil {
+		refundable = paid.Amount.Sub(paid.RefundedAmount)
+	}
+	if !amount.IsPositive() {
+		amount = refundable
+	}
+	if !amount.IsPositive() || amount.GreaterThan(refundable) {
+		return ErrRefundExceedsTotal
+	}
+
+	gateway, err := s.gatewayForOrder(target)
+	if err != nil {
+		return err
+	}
+	if err := gateway.RefundPayment(ctx, target, amount, reason); err != nil {
+		return err
+	}
+
+	update := PaymentAttemptUpdate{
+		InstallmentID:  installmentID,
+		Gateway:        gateway.Name(),
+		ExternalID:     stringValue(target.PaymentToken),
+		Status:         PaymentStatusRefunded,
+		RefundedAmount: amount,
+	}
+	if paid != nil {
+		update.AttemptID = paid.ID
+	}
+
+	return s.orderRepo.RecordPaymentAttempt(order.ID, update)
+}
+
+// ConfirmManualPayment marks a manual transfer order as paid after an admin has
+// seen the transfer arrive. For orders with installments it confirms the next unpaid one.
+func (s *PaymentService) ConfirmManualPayment(order *OrderModel, paymentMethod string) error {
+	if order.PaymentStatus == PaymentStatusPaid {
+		return ErrOrderAlreadyPaid
+	}
+
+	installment, err := s.installmentForPayment(order)
+	if err != nil {
+		return err
+	}
+	if installment != nil {
+		return s.ConfirmManualInstallmentPayment(order, installment, paymentMethod)
+	}
+
+	gateway, err := s.gatewayForOrder(order)
+	if err != nil {
+		return err
+	}
+	if _, ok := gateway.(*ManualTransferGateway); !ok {
+		return ErrGatewayOperationUnsupported
+	}
+
+	now := time.Now()
+	return s.orderRepo.RecordPaymentAttempt(order.ID, PaymentAttemptUpdate{
+		Gateway:       GatewayManualTransfer,
+		ExternalID:    stringValue(order.PaymentToken),
+		Status:        PaymentStatusPaid,
+		PaymentMethod: &paymentMethod,
+		At:            &now,
+	})
+}
+
+// =============================================================================
+// HANDLERS
+// =============================================================================
+
+// CreatePaymentLinkRequest represents the request body for POST /api/orders/:id/payment-link
+type CreatePaymentLinkRequest struct {
+	Regenerate bool   `json:"regenerate"`
+	Gateway    string `json:"gateway"`
+}
+
+// ConfirmPaymentRequest represents the request body for POST /api/orders/:id/payment/confirm
+type ConfirmPaymentRequest struct {
+	PaymentMethod string `json:"paymentMethod" binding:"omitempty,oneof=bank_transfer qris"`
+}
+
+// RefundPaymentRequest represents the request body for POST /api/orders/:id/payment/refund
+type RefundPaymentRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// respondPaymentError maps payment service errors to HTTP responses
+func respondPaymentError(c *gin.Context, requestID, orderID string, err error) {
+	switch {
+	case errors.Is(err, ErrPaymentGatewayUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":      "Payment gateway is not configured",
+			"request_id": requestID,
+		})
+	case errors.Is(err, ErrUnknownPaymentGateway):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Unknown payment gateway",
+			"details":    err.Error(),
+			"request_id": requestID,
+		})
+	case errors.Is(err, ErrOrderAlreadyPaid):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Order is already paid",
+			"request_id": requestID,
+		})
+	case errors.Is(err, ErrOrderNotPaid):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Order is not paid",
+			"request_id": requestID,
+		})
+	case errors.Is(err, ErrRefundExceedsTotal):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Refund amount exceeds what is left of the payment",
+			"request_id": requestID,
+		})
+	case errors.Is(err, ErrGatewayOperationUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error":      "Operation is not supported by the order's payment gateway",
+			"request_id": requestID,
+		})
+	default:
+		LogError("Payment gateway request failed", logrus.Fields{
+			"request_id": requestID,
+			"order_id":   orderID,
+			"error":      err.Error(),
+		}, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":      "Payment gateway request failed",
+			"request_id": requestID,
+		})
+	}
+}
+
+// bindOptionalJSON binds the request body when one was sent
+func bindOptionalJSON(c *gin.Context, requestID string, target interface{}) bool {
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	if err := c.ShouldBindJSON(target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid request format",
+			"details":    err.Error(),
+			"request_id": requestID,
+		})
+		return false
+	}
+	return true
+}
+
+// loadOrderForPayment fetches the order named in the URL or writes a 404
+func (h *OrderHandler) loadOrderForPayment(c *gin.Context, requestID string) (*OrderModel, bool) {
+	order, err := h.repo.GetOrderByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "Order not found",
+			"request_id": requestID,
+		})
+		return nil, false
+	}
+	return order, true
+}
+
+// GetPaymentGateways handles GET /api/payment-gateways
+func (h *OrderHandler) GetPaymentGateways(c *gin.Context) {
+	gateways := []string{}
+	defaultGateway := ""
+	if h.payments.Enabled() {
+		gateways = h.payments.gateways.Names()
+		defaultGateway = h.payments.gateways.Default()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"gateways": gateways,
+			"default":  defaultGateway,
+		},
+	})
+}
+
+// CreatePaymentLink handles POST /api/orders/:id/payment-link
+func (h *OrderHandler) CreatePaymentLink(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+	orderID := c.Param("id")
+
+	LogInfo("Create payment link requested", logrus.Fields{
+		"request_id": requestID,
+		"order_id":   orderID,
+		"client_ip":  c.ClientIP(),
+	})
+
+	var req CreatePaymentLinkRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	order, payment, created, err := h.payments.EnsurePayment(c.Request.Context(), order, req.Gateway, req.Regenerate)
+	if err != nil {
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	c.JSON(status, gin.H{
+		"data": gin.H{
+			"orderId":          order.ID,
+			"paymentGateway":   order.PaymentGateway,
+			"paymentToken":     order.PaymentToken,
+			"paymentUrl":       order.PaymentURL,
+			"paymentExpiresAt": order.PaymentExpiresAt,
+			"uniqueCode":       order.UniqueCode,
+			"amountDue":        payment.AmountDue,
+			"instructions":     payment.Instructions,
+			"created":          created,
+		},
+		"request_id": requestID,
+	})
+}
+
+// GetPaymentStatus handles GET /api/orders/:id/payment
+func (h *OrderHandler) GetPaymentStatus(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+	orderID := c.Param("id")
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	status, err := h.payments.GetPaymentStatus(c.Request.Context(), order)
+	if err != nil {
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       status,
+		"request_id": requestID,
+	})
+}
+
+// ConfirmPayment handles POST /api/orders/:id/payment/confirm for manual transfers
+func (h *OrderHandler) ConfirmPayment(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+	orderID := c.Param("id")
+
+	LogInfo("Manual payment confirmation requested", logrus.Fields{
+		"request_id": requestID,
+		"order_id":   orderID,
+		"client_ip":  c.ClientIP(),
+	})
+
+	var req ConfirmPaymentRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = "bank_transfer"
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	if err := h.payments.ConfirmManualPayment(order, req.PaymentMethod); err != nil {
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment confirmed",
+		"request_id": requestID,
+	})
+}
+
+// CancelPayment handles POST /api/orders/:id/payment/cancel
+func (h *OrderHandler) CancelPayment(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+	orderID := c.Param("id")
+
+	LogInfo("Payment cancellation requested", logrus.Fields{
+		"request_id": requestID,
+		"order_id":   orderID,
+		"client_ip":  c.ClientIP(),
+	})
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	if err := h.payments.CancelPayment(c.Request.Context(), order); err != nil {
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment cancelled",
+		"request_id": requestID,
+	})
+}
+
+// RefundPayment handles POST /api/orders/:id/payment/refund
+func (h *OrderHandler) RefundPayment(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+	orderID := c.Param("id")
+
+	LogInfo("Payment refund requested", logrus.Fields{
+		"request_id": requestID,
+		"order_id":   orderID,
+		"client_ip":  c.ClientIP(),
+	})
+
+	var req RefundPaymentRequest
+	if !bindOptionalJSON(c, requestID, &req) {
+		return
+	}
+	if req.Amount.IsNegative() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid request format",
+			"details":    "amount must not be negative",
+			"request_id": requestID,
+		})
+		return
+	}
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	if err := h.payments.RefundPayment(c.Request.Context(), order, req.Amount, req.Reason); err != nil {
+		respondPaymentError(c, requestID, orderID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment refunded",
+		"request_id": requestID,
+	})
+}
+
+// GetOrderPayments handles GET /api/orders/:id/payments
+func (h *OrderHandler) GetOrderPayments(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	order, ok := h.loadOrderForPayment(c, requestID)
+	if !ok {
+		return
+	}
+
+	attempts, err := h.repo.GetPaymentAttempts(order.ID)
+	if err != nil {
+		LogError("Failed to get payment attempts", logrus.Fields{
+			"request_id": requestID,
+			"order_id":   order.ID,
+			"error":      err.Error(),
+		}, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to retrieve payments",
+			"request_id": requestID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":          attempts,
+		"count":         len(attempts),
+		"paymentStatus": order.PaymentStatus,
+		"request_id":    requestID,
+	})
+}
+
+// ListPayments handles GET /api/admin/payments, listing payment attempts across
+// orders with their gateway references. Filters: orderId, gateway, status.
+func (h *OrderHandler) ListPayments(c *gin.Context) {
+	requestID := requestIDFromContext(c)
+
+	LogInfo("Payment attempts list requested", logrus.Fields{
+		"request_id": requestID,
+		"client_ip":  c.ClientIP(),
+	})
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200 // Max limit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	attempts, total, err := h.repo.ListPaymentAttempts(PaymentAttemptFilter{
+		OrderID: c.Query("orderId"),
+		Gateway: c.Query("gateway"),
+		Status:  c.Query("status"),
+		Limit:   limit,
+		Offset:  offset,
+	})
+	if err != nil {
+		LogError("Failed to list payment attempts", logrus.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		}, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to retrieve payments",
+			"request_id": requestID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       attempts,
+		"count":      len(attempts),
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+		"request_id": requestID,
+	})
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Audiences of portal JWTs. A link token only starts a session and a session
+// token only reads the portal; admin and customer validation reject both.
+const (
+	portalLinkAudience    = "urgent-studio-portal-link"
+	portalSessionAudience = "urgent-studio-portal"
+)
+
+var (
+	// ErrPortalLinkInvalid is returned for a magic link that is malformed, expired or already used
+	ErrPortalLinkInvalid = errors.New("invalid or expired link")
+	// ErrPortalOrderNotFound is returned for an order that does not exist or has another email
+	ErrPortalOrderNotFound = errors.New("order not found")
+)
+
+// PortalClaims are the claims of portal link and session tokens. The subject
+// is the customer email; ID is the portal_links row of a link token.
+type PortalClaims struct {
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// PortalSession is the result of following a magic link
+type PortalSession struct {
+	Token         string    `json:"token"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	CustomerEmail string    `json:"customerEmail"`
+}
+
+// PortalService sends magic links and serves the orders of one email address
+type PortalService struct {
+	db     *sql.DB
+	keys   *JWTKeyRing
+	mailer Mailer
+	orders *OrderRepository
+	// appURL is the storefront base URL the link points at
+	appURL     string
+	linkTTL    time.Duration
+	sessionTTL time.Duration
+	// maxLinksPerHour limits links sent to one email address
+	maxLinksPerHour int
+}
+
+// NewPortalService creates a new portal service
+func NewPortalService(db *sql.DB, keys *JWTKeyRing, mailer Mailer, orders *OrderRepository) *PortalService {
+	return &PortalService{
+		db:              db,
+		keys:            keys,
+		mailer:          mailer,
+		orders:          orders,
+		appURL:          strings.TrimRight(getEnvWithDefault("CUSTOMER_APP_URL", "http://localhost:3000"), "/"),
+		linkTTL:         getEnvDurationWithDefault("PORTAL_LINK_TTL", 15*time.Minute),
+		sessionTTL:      getEnvDurationWithDefault("PORTAL_SESSION_TTL", 24*time.Hour),
+		maxLinksPerHour: getEnvIntWithDefault("PORTAL_LINKS_PER_HOUR", 5),
+	}
+}
+
+// signPortalToken signs claims with the current key
+func (s *PortalService) signPortalToken(claims PortalClaims) (string, error) {
+	key, err := s.keys.Current()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.signingKey())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// parsePortalToken verifies a portal token issued for audience
+func (s *PortalService) parsePortalToken(tokenString, audience string) (*PortalClaims, error) {
+	claims := &PortalClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keys.keyFunc, jwt.WithAudience(audience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	return claims, nil
+}
+
+// SendMagicLink emails a sign-in link when the address has orders. It does
+// nothing otherwise, or when the address has had too many links this hour,
+// so the response cannot be used to find out who has ordered.
+func (s *PortalService) SendMagicLink(email, ipAddress string) error {
+	email = normalizeEmail(email)
+
+	var orderCount, recentLinks int
+	err := s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM orders WHERE LOWER(customer_email) = $1),
+			(SELECT COUNT(*) FROM portal_links WHERE customer_email = $1 AND created_at > $2)
+	`, email, time.Now().Add(-time.Hour)).Scan(&orderCount, &recentLinks)
+	if err != nil {
+		return fmt.Errorf("failed to check portal link request: %w", err)
+	}
+	if orderCount == 0 {
+		return nil
+	}
+	if recentLinks >= s.maxLinksPerHour {
+		LogSecurity("portal_link_rate_limited", logrus.Fields{
+			"customer_email": email,
+			"client_ip":      ipAddress,
+		})
+		return nil
+	}
+
+	linkID, err := generateUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate link ID: %w", err)
+	}
+	expiresAt := time.Now().Add(s.linkTTL)
+	_, err = s.db.Exec(`
+		INSERT INTO portal_links (id, customer_email, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, linkID, email, ipAddress, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store portal link: %w", err)
+	}
+
+	token, err := s.signPortalToken(PortalClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        linkID,
+			Subject:   email,
+			Audience:  jwt.ClaimStrings{portalLinkAudience},
+			Issuer:    "urgent-studio",
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(EmailMessage{
+		To:      email,
+		Subject: "Your Urgent Studio orders",
+		Body: fmt.Sprintf("Hi,\n\nUse this link to see your orders, files and invoices:\n\n%s\n\n"+
+			"The link expires in %s and works once. If you did not ask for it, you can ignore this email.\n",
+			s.appURL+"/portal?token="+url.QueryEscape(token), s.linkTTL),
+	})
+	if err != nil {
+		LogError("Failed to send portal link", logrus.Fields{
+			"customer_email": email,
+			"error":          err.Error(),
+		}, err)
+	}
+	return nil
+}
+
+// StartSession exchanges a magic link token for a portal session. The link's
+// row is marked used in the same statement that checks it, so a link works once.
+func (s *PortalService) StartSession(linkToken string) (*PortalSession, error) {
+	claims, err := s.parsePortalToken(linkToken, portalLinkAudience)
+	if err != nil || claims.ID == "" {
+		return nil, ErrPortalLinkInvalid
+	}
+
+	var email string
+	err = s.db.QueryRow(`
+		UPDATE portal_links SET used_at = CURRENT_TIMESTAMP
+		WHERE id::text = $1 AND customer_email = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING customer_email
+	`, claims.ID, claims.Subject).Scan(&email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPortalLinkInvalid
+		}
+		return nil, fmt.Errorf("failed to use portal link: %w", err)
+	}
+
+	sessionID, err := generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	expiresAt := time.Now().Add(s.sessionTTL)
+	_, err = s.db.Exec("INSERT INTO portal_sessions (id, customer_email, expires_at) VALUES ($1, $2, $3)",
+		sessionID, email, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store portal session: %w", err)
+	}
+
+	token, err := s.signPortalToken(PortalClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   email,
+			Audience:  jwt.ClaimStrings{portalSessionAudience},
+			Issuer:    "urgent-studio",
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortalSession{Token: token, ExpiresAt: expiresAt, CustomerEmail: email}, nil
+}
+
+// ValidateToken checks a portal session token and that its session is still active
+func (s *PortalService) ValidateToken(tokenString string) (*PortalClaims, error) {
+	claims, err := s.parsePortalToken(tokenString, portalSessionAudience)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	var active bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM portal_sessions
+			WHERE id::text = $1 AND customer_email = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		)
+	`, claims.SessionID, claims.Subject).Scan(&active)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate portal session: %w", err)
+	}
+	if !active {
+		return nil, fmt.Errorf("portal session is revoked or expired")
+	}
+	return claims, nil
+}
+
+// EndSession revokes a portal session
+func (s *PortalService) EndSession(sessionID string) error {
+	_, err := s.db.Exec("UPDATE portal_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id::text = $1 AND revoked_at IS NULL", sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke portal session: %w", err)
+	}
+	return nil
+}
+
+// CleanupExpired deletes expired and used magic links and ended portal sessions
+func (s *PortalService) CleanupExpired() error {
+	if _, err := s.db.Exec("DELETE FROM portal_links WHERE expires_at < $1", time.Now().Add(-time.Hour)); err != nil {
+		return fmt.Errorf("failed to delete portal links: %w", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM portal_sessions WHERE expires_at < CURRENT_TIMESTAMP OR revoked_at IS NOT NULL"); err != nil {
+		return fmt.Errorf("failed to delete portal sessions: %w", err)
+	}
+	return nil
+}
+
+// PortalOrder is an order as its customer sees it: no admin notes, payment
+// tokens, priority or source
+type PortalOrder struct {
+	ID               string              `json:"id"`
+	OrderNumber      string              `json:"orderNumber"`
+	CustomerName     string              `json:"customerName"`
+	Status           string              `json:"status"`
+	TotalAmount      Money               `json:"totalAmount"`
+	Subtotal         Money               `json:"subtotal"`
+	TaxAmount        Money               `json:"taxAmount"`
+	DiscountAmount   Money               `json:"discountAmount"`
+	HandlingFee      Money               `json:"handlingFee"`
+	UniqueCode       *int                `json:"uniqueCode"`
+	PaymentStatus    string              `json:"paymentStatus"`
+	PaymentURL       *string             `json:"paymentUrl"`
+	PaymentExpiresAt *time.Time          `json:"paymentExpiresAt"`
+	PaidAt           *time.Time          `json:"paidAt"`
+	Notes            *string             `json:"notes"`
+	CreatedAt        time.Time           `json:"createdAt"`
+	CompletedAt      *time.Time          `json:"completedAt"`
+	CancelledAt      *time.Time          `json:"cancelledAt"`
+	Items            []PortalOrderItem   `json:"items,omitempty"`
+	Installments     []PortalInstallment `json:"installments,omitempty"`
+}
+
+// PortalOrderItem is an order item as its customer sees it
+type PortalOrderItem struct {
+	ID              string     `json:"id"`
+	ItemName        string     `json:"itemName"`
+	ItemDescription *string    `json:"itemDescription"`
+	Quantity        int        `json:"quantity"`
+	UnitPrice       Money      `json:"unitPrice"`
+	TotalPrice      Money      `json:"totalPrice"`
+	DeliveryDate    *time.Time `json:"deliveryDate"`
+	RevisionCount   int        `json:"revisionCount"`
+	MaxRevisions    int        `json:"maxRevisions"`
+}
+
+// PortalInstallment is a payment schedule entry as the customer sees it
+type PortalInstallment struct {
+	Sequence         int        `json:"sequence"`
+	Label            string     `json:"label"`
+	Milestone        string     `json:"milestone"`
+	Percent          int        `json:"percent"`
+	Amount           Money      `json:"amount"`
+	Status           string     `json:"status"`
+	PaymentURL       *string    `json:"paymentUrl"`
+	PaymentExpiresAt *time.Time `json:"paymentExpiresAt"`
+	UniqueCode       *int       `json:"uniqueCode"`
+	PaidAt           *time.Time `json:"paidAt"`
+}
+
+// PortalFile is an order file as the customer sees it. DownloadURL is set only
+// for files stored at a web address; storage paths are not shown. Deliverables
+// are Locked, without a DownloadURL, until the order is paid in full.
+type PortalFile struct {
+	ID           string    `json:"id"`
+	FileName     string    `json:"fileName"`
+	FileType     *string   `json:"fileType"`
+	FileSize     *int64    `json:"fileSize"`
+	FileCategory *string   `json:"fileCategory"`
+	DownloadURL  *string   `json:"downloadUrl"`
+	Locked       bool      `json:"locked"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// PortalInvoice is a payment attempt as the customer sees it, without the
+// gateway reference or raw gateway response
+type PortalInvoice struct {
+	ID               string     `json:"id"`
+	InstallmentLabel *string    `json:"installmentLabel"`
+	Amount           Money      `json:"amount"`
+	RefundedAmount   Money      `json:"refundedAmount"`
+	Status           string     `json:"status"`
+	PaymentMethod    *string    `json:"paymentMethod"`
+	PaymentURL       *string    `json:"paymentUrl"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	PaidAt           *time.Time `json:"paidAt"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+// PortalHistoryEntry is an order status change as the customer sees it; the
+// internal notes and the admin who made the change are left out
+type PortalHistoryEntry struct {
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// openPaymentURL returns a payment link only while it can still be paid
+func openPaymentURL(status string, paymentURL *string, expiresAt *time.Time) *string {
+	if status != "pending" || (expiresAt != nil && expiresAt.Before(time.Now())) {
+		return nil
+	}
+	return paymentURL
+}
+
+// newPortalOrder converts an order to its customer-safe view
+func newPortalOrder(order *OrderModel) PortalOrder {
+	view := PortalOrder{
+		ID:               order.ID,
+		OrderNumber:      order.OrderNumber,
+		CustomerName:     order.CustomerName,
+		Status:           order.Status,
+		TotalAmount:      order.TotalAmount,
+		Subtotal:         order.Subtotal,
+		TaxAmount:        order.TaxAmount,
+		DiscountAmount:   order.DiscountAmount,
+		HandlingFee:      order.HandlingFee,
+		UniqueCode:       order.UniqueCode,
+		PaymentStatus:    order.PaymentStatus,
+		PaymentURL:       openPaymentURL(order.PaymentStatus, order.PaymentURL, order.PaymentExpiresAt),
+		PaymentExpiresAt: order.PaymentExpiresAt,
+		PaidAt:           order.PaidAt,
+		Notes:            order.Notes,
+		CreatedAt:        order.CreatedAt,
+		CompletedAt:      order.CompletedAt,
+		CancelledAt:      order.CancelledAt,
+	}
+	for _, item := range order.Items {
+		view.Items = append(view.Items, PortalOrderItem{
+			ID:              item.ID,
+			ItemName:        item.ItemName,
+			ItemDescription: item.ItemDescription,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			TotalPrice:      item.TotalPrice,
+			DeliveryDate:    item.DeliveryDate,
+			RevisionCount:   item.RevisionCount,
+			MaxRevisions:    item.MaxRevisions,
+		})
+	}
+	for _, installment := range order.Installments {
+		view.Installments = append(view.Installments, PortalInstallment{
+			Sequence:         installment.Sequence,
+			Label:            installment.Label,
+			Milestone:        installment.Milestone,
+			Percent:          installment.Percent,
+			Amount:           installment.Amount,
+			Status:           installment.Status,
+			PaymentURL:       openPaymentURL(installment.Status, installment.PaymentURL, installment.PaymentExpiresAt),
+			PaymentExpiresAt: installment.PaymentExpiresAt,
+			UniqueCode:       installment.UniqueCode,
+			PaidAt:           installment.PaidAt,
+		})
+	}
+	return view
+}
+
+// ListOrders returns the orders placed with email, newest first
+func (s *PortalService) ListOrders(email string, limit, offset int) ([]PortalOrder, error) {
+	orders, err := s.orders.GetOrdersByCustomerEmail(email, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]PortalOrder, 0, len(orders))
+	for i := range orders {
+		views = append(views, newPortalOrder(&orders[i]))
+	}
+	return views, nil
+}
+
+// getOrder returns an order when it was placed with email
+func (s *PortalService) getOrder(email, orderID string) (*OrderModel, error) {
+	if _, err := uuid.Parse(orderID); err != nil {
+		return nil, ErrPortalOrderNotFound
+	}
+	order, err := s.orders.GetOrderByID(orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPortalOrderNotFound
+		}
+		return nil, err
+	}
+	if normalizeEmail(order.CustomerEmail) != email {
+		return nil, ErrPortalOrderNotFound
+	}
+	return order, nil
+}
+
+// GetOrder returns one of email's orders with its items and payment schedule
+func (s *PortalService) GetOrder(email, orderID string) (*PortalOrder, error) {
+	order, err := s.getOrder(email, orderID)
+	if err != nil {
+		return nil, err
+	}
+	view := newPortalOrder(order)
+	return &view, nil
+}
+
+// GetFiles returns the files of one of email's orders
+func (s *PortalService) GetFiles(email, orderID string) ([]PortalFile, error) {
+	order, err := s.getOrder(email, orderID)
+	if err != nil {
+		return nil, err
+	}
+	unlocked := deliverablesUnlocked(order)
+	files, err := s.orders.GetOrderFiles(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]PortalFile, 0, len(files))
+	for _, file := range files {
+		view := PortalFile{
+			ID:           file.ID,
+			FileName:     file.FileName,
+			FileType:     file.FileType,
+			FileSize:     file.FileSize,
+			FileCategory: file.FileCategory,
+			CreatedAt:    file.CreatedAt,
+		}
+		if isDeliverable(file.FileCategory) && !unlocked {
+			view.Locked = true
+		} else if strings.HasPrefix(file.FilePath, "https://") || strings.HasPrefix(file.FilePath, "http://") {
+			downloadURL := file.FilePath
+			view.DownloadURL = &downloadURL
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// GetInvoices returns the payment attempts of one of email's orders
+func (s *PortalService) GetInvoices(email, orderID string) ([]PortalInvoice, error) {
+	order, err := s.getOrder(email, orderID)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := s.orders.GetPaymentAttempts(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(order.Installments))
+	for _, installment := range order.Installments {
+		labels[installment.ID] = installment.Label
+	}
+
+	views := make([]PortalInvoice, 0, len(attempts))
+	for _, attempt := range attempts {
+		view := PortalInvoice{
+			ID:             attempt.ID,
+			Amount:         attempt.Amount,
+			RefundedAmount: attempt.RefundedAmount,
+			Status:         attempt.Status,
+			PaymentMethod:  attempt.PaymentMethod,
+			PaymentURL:     openPaymentURL(attempt.Status, attempt.PaymentURL, attempt.ExpiresAt),
+			ExpiresAt:      attempt.ExpiresAt,
+			PaidAt:         attempt.PaidAt,
+			CreatedAt:      attempt.CreatedAt,
+		}
+		if attempt.InstallmentID != nil {
+			if label, ok := labels[*attempt.InstallmentID]; ok {
+				view.InstallmentLabel = &label
+			}
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// GetHistory returns the status changes of one of email's orders
+func (s *PortalService) GetHistory(email, orderID string) ([]PortalHistoryEntry, error) {
+	if _, err := s.getOrder(email, orderID); err != nil {
+		return nil, err
+	}
+	history, err := s.orders.GetStatusHistory(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]PortalHistoryEntry, 0, len(history))
+	for _, entry := range history {
+		views = append(views, PortalHistoryEntry{Status: entry.Status, CreatedAt: entry.CreatedAt})
+	}
+	return views, nil
+}
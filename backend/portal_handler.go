@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PortalMagicLinkRequest is the body of POST /api/portal/magic-link
+type PortalMagicLinkRequest struct {
+	CustomerEmail string `json:"customer_email" binding:"required,email"`
+}
+
+// PortalSessionRequest is the body of POST /api/portal/session
+type PortalSessionRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// authenticatePortal validates the portal bearer token of a request and
+// stores the session's email in the context
+func authenticatePortal(portal *PortalService, c *gin.Context) bool {
+	authHeader := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		c.Abort()
+		return false
+	}
+
+	claims, err := portal.ValidateToken(authHeader[7:])
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("portal_email", claims.Subject)
+	c.Set("portal_session_id", claims.SessionID)
+	return true
+}
+
+// PortalHandler serves the passwordless customer order portal
+type PortalHandler struct {
+	portal *PortalService
+}
+
+// NewPortalHandler creates a new PortalHandler
+func NewPortalHandler(portal *PortalService) *PortalHandler {
+	return &PortalHandler{portal: portal}
+}
+
+// RegisterRoutes registers the portal routes
+func (h *PortalHandler) RegisterRoutes(r *gin.Engine) {
+	portalGroup := r.Group("/api/portal")
+	{
+		portalGroup.POST("/magic-link", h.RequestMagicLink)
+		portalGroup.POST("/session", h.StartSession)
+		portalGroup.POST("/logout", h.Logout)
+		portalGroup.GET("/orders", h.ListOrders)
+		portalGroup.GET("/orders/:id", h.GetOrder)
+		portalGroup.GET("/orders/:id/files", h.GetFiles)
+		portalGroup.GET("/orders/:id/invoices", h.GetInvoices)
+		portalGroup.GET("/orders/:id/history", h.GetHistory)
+	}
+}
+
+// respondPortalError answers a failed portal lookup
+func respondPortalError(c *gin.Context, logMessage string, err error) {
+	if errors.Is(err, ErrPortalOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Order not found",
+		})
+		return
+	}
+	LogError(logMessage, logrus.Fields{
+		"order_id": c.Param("id"),
+		"error":    err.Error(),
+	}, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success": false,
+		"message": "Failed to load portal data",
+	})
+}
+
+// RequestMagicLink handles POST /api/portal/magic-link
+func (h *PortalHandler) RequestMagicLink(c *gin.Context) {
+	var req PortalMagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A valid customer_email is required",
+		})
+		return
+	}
+
+	if err := h.portal.SendMagicLink(req.CustomerEmail, c.ClientIP()); err != nil {
+		LogError("Failed to send portal magic link", logrus.Fields{
+			"client_ip": c.ClientIP(),
+			"error":     err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to send link",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "If there are orders for this email address, we have sent a link to it",
+	})
+}
+
+// StartSession handles POST /api/portal/session, exchanging a magic link token
+// for a portal session token
+func (h *PortalHandler) StartSession(c *gin.Context) {
+	var req PortalSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Token is required",
+		})
+		return
+	}
+
+	session, err := h.portal.StartSession(req.Token)
+	if err != nil {
+		if errors.Is(err, ErrPortalLinkInvalid) {
+			LogSecurity("portal_link_rejected", logrus.Fields{
+				"client_ip": c.ClientIP(),
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "The link is invalid, expired or already used",
+			})
+			return
+		}
+		LogError("Failed to start portal session", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to start session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    session,
+	})
+}
+
+// Logout handles POST /api/portal/logout
+func (h *PortalHandler) Logout(c *gin.Context) {
+	if err := h.portal.EndSession(c.GetString("portal_session_id")); err != nil {
+		LogError("Failed to end portal session", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to log out",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logout successful",
+	})
+}
+
+// ListOrders handles GET /api/portal/orders
+func (h *PortalHandler) ListOrders(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100 // Max limit
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	orders, err := h.portal.ListOrders(c.GetString("portal_email"), limit, offset)
+	if err != nil {
+		respondPortalError(c, "Failed to list portal orders", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    orders,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"count":  len(orders),
+		},
+	})
+}
+
+// GetOrder handles GET /api/portal/orders/:id
+func (h *PortalHandler) GetOrder(c *gin.Context) {
+	order, err := h.portal.GetOrder(c.GetString("portal_email"), c.Param("id"))
+	if err != nil {
+		respondPortalError(c, "Failed to get portal order", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    order,
+	})
+}
+
+// GetFiles handles GET /api/portal/orders/:id/files
+func (h *PortalHandler) GetFiles(c *gin.Context) {
+	files, err := h.portal.GetFiles(c.GetString("portal_email"), c.Param("id"))
+	if err != nil {
+		respondPortalError(c, "Failed to get portal order files", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    files,
+	})
+}
+
+// GetInvoices handles GET /api/portal/orders/:id/invoices
+func (h *PortalHandler) GetInvoices(c *gin.Context) {
+	invoices, err := h.portal.GetInvoices(c.GetString("portal_email"), c.Param("id"))
+	if err != nil {
+		respondPortalError(c, "Failed to get portal order invoices", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    invoices,
+	})
+}
+
+// GetHistory handles GET /api/portal/orders/:id/history
+func (h *PortalHandler) GetHistory(c *gin.Context) {
+	history, err := h.portal.GetHistory(c.GetString("portal_email"), c.Param("id"))
+	if err != nil {
+		respondPortalError(c, "Failed to get portal order history", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    history,
+	})
+}
@@ -0,0 +1,898 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of data-subject request, stored in data_subject_requests.request_type
+const (
+	DataSubjectExport  = "export"
+	DataSubjectErasure = "erasure"
+)
+
+// Statuses of a data-subject request
+const (
+	DataSubjectProcessing = "processing"
+	DataSubjectCompleted  = "completed"
+	DataSubjectFailed     = "failed"
+)
+
+// erasedCustomerName replaces the name on anonymised orders and accounts
+const erasedCustomerName = "Erased customer"
+
+// ErrDataSubjectNotFound is returned when no account or order belongs to the subject
+var ErrDataSubjectNotFound = errors.New("no personal data found for this subject")
+
+// DataSubject is the customer a request is about. Orders are matched by email;
+// UserID is set when the customer also has an account.
+type DataSubject struct {
+	Email  string
+	UserID string
+}
+
+// key identifies the subject in the request log without storing their email
+func (d *DataSubject) key() string {
+	return hashToken(d.Email)
+}
+
+// DataSubjectRequest is one entry of the request log
+type DataSubjectRequest struct {
+	ID              string          `json:"id"`
+	RequestType     string          `json:"requestType"`
+	DryRun          bool            `json:"dryRun"`
+	SubjectHash     string          `json:"subjectHash"`
+	SubjectUserID   *string         `json:"subjectUserId"`
+	Reason          string          `json:"reason"`
+	Status          string          `json:"status"`
+	Summary         json.RawMessage `json:"summary"`
+	Error           *string         `json:"error"`
+	RequestedBy     *string         `json:"requestedBy"`
+	RequestedByName *string         `json:"requestedByName"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	CompletedAt     *time.Time      `json:"completedAt"`
+}
+
+// DataExportSummary counts what an export contained
+type DataExportSummary struct {
+	Records       map[string]int `json:"records"`
+	Files         int            `json:"files"`
+	ExternalFiles int            `json:"externalFiles"`
+	MissingFiles  int            `json:"missingFiles"`
+	WithheldFiles int            `json:"withheldFiles"`
+}
+
+// DataErasureSummary counts what an erasure changed, or would change in a dry run
+type DataErasureSummary struct {
+	Orders          int  `json:"orders"`
+	OrderItems      int  `json:"orderItems"`
+	Payments        int  `json:"payments"`
+	OrderFiles      int  `json:"orderFiles"`
+	FilesDeleted    int  `json:"filesDeleted"`
+	FilesNotDeleted int  `json:"filesNotDeleted"`
+	PortalRecords   int  `json:"portalRecords"`
+	AccountErased   bool `json:"accountErased"`
+	SessionsEnded   int  `json:"sessionsEnded"`
+}
+
+// PrivacyService answers data-subject requests: exporting everything stored
+// about a customer, and erasing it
+type PrivacyService struct {
+	db *sql.DB
+	// filesDir is where order files with a relative file_path are stored
+	filesDir string
+}
+
+// NewPrivacyService creates a new PrivacyService
+func NewPrivacyService(db *sql.DB) *PrivacyService {
+	return &PrivacyService{
+		db:       db,
+		filesDir: getEnvWithDefault("ORDER_FILES_DIR", "uploads/orders"),
+	}
+}
+
+// ResolveSubject finds the customer named by an email or a user ID
+func (s *PrivacyService) ResolveSubject(email, userID string) (*DataSubject, error) {
+	subject := &DataSubject{Email: normalizeEmail(email)}
+
+	if userID != "" {
+		err := s.db.QueryRow("SELECT id, LOWER(email) FROM users WHERE id::text = $1", userID).
+			Scan(&subject.UserID, &subject.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDataSubjectNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		return subject, nil
+	}
+
+	err := s.db.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", subject.Email).Scan(&subject.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if subject.UserID != "" {
+		return subject, nil
+	}
+
+	var hasOrders bool
+	err = s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE LOWER(customer_email) = $1)", subject.Email).Scan(&hasOrders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up orders: %w", err)
+	}
+	if !hasOrders {
+		return nil, ErrDataSubjectNotFound
+	}
+	return subject, nil
+}
+
+// StartRequest adds a request to the log before it is carried out
+func (s *PrivacyService) StartRequest(requestType string, dryRun bool, subjectHash, subjectUserID, reason, actorID, actorName string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`
+		INSERT INTO data_subject_requests (request_type, dry_run, subject_hash, subject_user_id, reason,
+		                                   requested_by, requested_by_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, requestType, dryRun, subjectHash, stringOrNil(subjectUserID), reason, stringOrNil(actorID), stringOrNil(actorName)).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to log data-subject request: %w", err)
+	}
+	return id, nil
+}
+
+// FinishRequest records the outcome of a logged request
+func (s *PrivacyService) FinishRequest(id string, summary interface{}, requestErr error) {
+	status := DataSubjectCompleted
+	var errorText *string
+	if requestErr != nil {
+		status = DataSubjectFailed
+		message := requestErr.Error()
+		errorText = &message
+	}
+
+	var summaryJSON []byte
+	if summary != nil {
+		var err error
+		if summaryJSON, err = json.Marshal(summary); err != nil {
+			LogError("Failed to encode data-subject request summary", logrus.Fields{
+				"request_id": id,
+				"error":      err.Error(),
+			}, err)
+		}
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE data_subject_requests
+		SET status = $2, summary = $3, error = $4, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, status, nullableJSON(summaryJSON), errorText)
+	if err != nil {
+		LogError("Failed to record data-subject request outcome", logrus.Fields{
+			"request_id": id,
+			"status":     status,
+			"error":      err.Error(),
+		}, err)
+	}
+}
+
+// ListRequests returns the request log, newest first
+func (s *PrivacyService) ListRequests(requestType string, limit, offset int) ([]DataSubjectRequest, int, error) {
+	where := ""
+	args := []interface{}{}
+	if requestType != "" {
+		where = "WHERE request_type = $1"
+		args = append(args, requestType)
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM data_subject_requests "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count data-subject requests: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, request_type, dry_run, subject_hash, subject_user_id, reason, status, summary, error,
+		       requested_by, requested_by_name, created_at, completed_at
+		FROM data_subject_requests %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	rows, err := s.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list data-subject requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []DataSubjectRequest{}
+	for rows.Next() {
+		var request DataSubjectRequest
+		var summary []byte
+		err := rows.Scan(&request.ID, &request.RequestType, &request.DryRun, &request.SubjectHash, &request.SubjectUserID,
+			&request.Reason, &request.Status, &summary, &request.Error, &request.RequestedBy, &request.RequestedByName,
+			&request.CreatedAt, &request.CompletedAt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan data-subject request: %w", err)
+		}
+		if summary != nil {
+			request.Summary = json.RawMessage(summary)
+		}
+		requests = append(requests, request)
+	}
+	return requests, total, rows.Err()
+}
+
+// subjectOrderIDs selects the IDs of the subject's orders; $1 is their email
+const subjectOrderIDs = "SELECT id FROM orders WHERE LOWER(customer_email) = $1"
+
+// exportSection is one JSON file of an export: every row a query returns
+type exportSection struct {
+	name  string
+	query string
+	arg   string
+}
+
+// exportSections lists what is exported about a subject. Rows are exported
+// whole, minus password and token hashes, so new columns are included without
+// changes here.
+func exportSections(subject *DataSubject) []exportSection {
+	sections := []exportSection{
+		{"orders", "SELECT to_jsonb(t) FROM orders t WHERE LOWER(t.customer_email) = $1 ORDER BY t.created_at", subject.Email},
+		{"order_items", "SELECT to_jsonb(t) FROM order_items t WHERE t.order_id IN (" + subjectOrderIDs + ") ORDER BY t.created_at", subject.Email},
+		{"order_status_history", "SELECT to_jsonb(t) FROM order_status_history t WHERE t.order_id IN (" + subjectOrderIDs + ") ORDER BY t.created_at", subject.Email},
+		// Where each file is stored is in the manifest, which withholds locked deliverables
+		{"order_files", "SELECT to_jsonb(t) - 'file_path' FROM order_files t WHERE t.order_id IN (" + subjectOrderIDs + ") ORDER BY t.created_at", subject.Email},
+		{"order_installments", "SELECT to_jsonb(t) FROM order_installments t WHERE t.order_id IN (" + subjectOrderIDs + ") ORDER BY t.order_id, t.sequence", subject.Email},
+		{"payments", "SELECT to_jsonb(t) FROM payments t WHERE t.order_id IN (" + subjectOrderIDs + ") ORDER BY t.created_at", subject.Email},
+		{"portal_links", "SELECT to_jsonb(t) FROM portal_links t WHERE LOWER(t.customer_email) = $1 ORDER BY t.created_at", subject.Email},
+		{"portal_sessions", "SELECT to_jsonb(t) FROM portal_sessions t WHERE LOWER(t.customer_email) = $1 ORDER BY t.created_at", subject.Email},
+	}
+	if subject.UserID != "" {
+		sections = append(sections,
+			exportSection{"account", "SELECT to_jsonb(t) - 'password_hash' FROM users t WHERE t.id = $1", subject.UserID},
+			exportSection{"customer_sessions", "SELECT to_jsonb(t) FROM customer_sessions t WHERE t.user_id = $1 ORDER BY t.created_at", subject.UserID},
+			exportSection{"customer_tokens", "SELECT to_jsonb(t) - 'token_hash' FROM customer_tokens t WHERE t.user_id = $1 ORDER BY t.created_at", subject.UserID},
+			exportSection{"bookings", "SELECT to_jsonb(t) FROM bookings t WHERE t.user_id = $1 ORDER BY t.created_at", subject.UserID},
+		)
+	}
+	return sections
+}
+
+// subjectFile is an order file of the subject
+type subjectFile struct {
+	id          string
+	orderID     string
+	orderNumber string
+	fileName    string
+	filePath    string
+	category    *string
+	// withheld is set for deliverables of orders that are not paid in full
+	withheld bool
+}
+
+// PersonalDataExport is everything stored about a subject, ready to be zipped
+type PersonalDataExport struct {
+	subject  *DataSubject
+	sections map[string][]json.RawMessage
+	order    []string
+	files    []subjectFile
+}
+
+// CollectExport reads everything stored about a subject. Files are only read
+// when the export is written.
+func (s *PrivacyService) CollectExport(subject *DataSubject) (*PersonalDataExport, error) {
+	export := &PersonalDataExport{subject: subject, sections: make(map[string][]json.RawMessage)}
+	for _, section := range exportSections(subject) {
+		rows, err := s.db.Query(section.query, section.arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+		}
+		records := []json.RawMessage{}
+		for rows.Next() {
+			var record []byte
+			if err := rows.Scan(&record); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan %s: %w", section.name, err)
+			}
+			records = append(records, json.RawMessage(record))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+		}
+		export.sections[section.name] = records
+		export.order = append(export.order, section.name)
+	}
+
+	files, err := s.subjectFiles(s.db, subject)
+	if err != nil {
+		return nil, err
+	}
+	unlocked := make(map[string]bool)
+	for i := range files {
+		if !isDeliverable(files[i].category) {
+			continue
+		}
+		orderUnlocked, checked := unlocked[files[i].orderID]
+		if !checked {
+			if orderUnlocked, err = s.orderDeliverablesUnlocked(files[i].orderID); err != nil {
+				return nil, err
+			}
+			unlocked[files[i].orderID] = orderUnlocked
+		}
+		files[i].withheld = !orderUnlocked
+	}
+	export.files = files
+	return export, nil
+}
+
+// orderDeliverablesUnlocked loads what deliverablesUnlocked needs to know about an order
+func (s *PrivacyService) orderDeliverablesUnlocked(orderID string) (bool, error) {
+	order := &OrderModel{ID: orderID}
+	if err := s.db.QueryRow("SELECT payment_status FROM orders WHERE id = $1", orderID).Scan(&order.PaymentStatus); err != nil {
+		return false, fmt.Errorf("failed to get order payment status: %w", err)
+	}
+	installments, err := getInstallments(s.db, orderID)
+	if err != nil {
+		return false, err
+	}
+	order.Installments = installments
+	return deliverablesUnlocked(order), nil
+}
+
+// subjectFiles returns the files attached to the subject's orders
+func (s *PrivacyService) subjectFiles(q dbExecutor, subject *DataSubject) ([]subjectFile, error) {
+	rows, err := q.Query(`
+		SELECT f.id, f.order_id, o.order_number, f.file_name, f.file_path, f.file_category
+		FROM order_files f
+		JOIN orders o ON o.id = f.order_id
+		WHERE LOWER(o.customer_email) = $1
+		ORDER BY o.created_at, f.created_at
+	`, subject.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []subjectFile
+	for rows.Next() {
+		var file subjectFile
+		if err := rows.Scan(&file.id, &file.orderID, &file.orderNumber, &file.fileName, &file.filePath, &file.category); err != nil {
+			return nil, fmt.Errorf("failed to scan order file: %w", err)
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// localFilePath returns where an order file is stored on disk. Files hosted
+// elsewhere (an http or https URL) and paths outside filesDir return false.
+func (s *PrivacyService) localFilePath(filePath string) (string, bool) {
+	if strings.HasPrefix(filePath, "https://") || strings.HasPrefix(filePath, "http://") || filePath == "" {
+		return "", false
+	}
+	root, err := filepath.Abs(s.filesDir)
+	if err != nil {
+		return "", false
+	}
+	full := filePath
+	if !filepath.IsAbs(full) {
+		full = filepath.Join(root, full)
+	}
+	full = filepath.Clean(full)
+	rel, err := filepath.Rel(root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return full, true
+}
+
+// exportManifestFile describes one order file in an export
+type exportManifestFile struct {
+	OrderNumber string `json:"orderNumber"`
+	FileName    string `json:"fileName"`
+	// Included is the path inside the zip; files not stored here are listed by Location
+	Included string `json:"included,omitempty"`
+	Location string `json:"location,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// WriteZip writes the export as a zip: manifest.json, one JSON file per
+// section and the subject's order files under files/
+func (s *PrivacyService) WriteZip(w io.Writer, requestID string, export *PersonalDataExport) (*DataExportSummary, error) {
+	summary := &DataExportSummary{Records: make(map[string]int)}
+	archive := zip.NewWriter(w)
+
+	writeJSON := func(name string, value interface{}) error {
+		entry, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	for _, name := range export.order {
+		summary.Records[name] = len(export.sections[name])
+		if err := writeJSON(name+".json", export.sections[name]); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	manifestFiles := []exportManifestFile{}
+	for _, file := range export.files {
+		entry := exportManifestFile{OrderNumber: file.orderNumber, FileName: file.fileName}
+		if file.withheld {
+			entry.Note = "deliverable withheld until the order is paid in full"
+			summary.WithheldFiles++
+			manifestFiles = append(manifestFiles, entry)
+			continue
+		}
+		local, ok := s.localFilePath(file.filePath)
+		if !ok {
+			entry.Location = file.filePath
+			entry.Note = "stored outside this server"
+			summary.ExternalFiles++
+			manifestFiles = append(manifestFiles, entry)
+			continue
+		}
+
+		name := path.Join("files", file.orderNumber, file.id+"-"+filepath.Base(file.fileName))
+		if err := copyFileToZip(archive, name, local); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to add order file %s: %w", file.id, err)
+			}
+			entry.Note = "file no longer exists"
+			summary.MissingFiles++
+		} else {
+			entry.Included = name
+			summary.Files++
+		}
+		manifestFiles = append(manifestFiles, entry)
+	}
+
+	manifest := gin.H{
+		"requestId":   requestID,
+		"generatedAt": time.Now().UTC(),
+		"subject": gin.H{
+			"email":  export.subject.Email,
+			"userId": stringOrNil(export.subject.UserID),
+		},
+		"records": summary.Records,
+		"files":   manifestFiles,
+	}
+	if err := writeJSON("manifest.json", manifest); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish zip: %w", err)
+	}
+	return summary, nil
+}
+
+// copyFileToZip adds a file from disk to a zip
+func copyFileToZip(archive *zip.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// Erase anonymises a subject's personal data. Orders keep their number,
+// items and amounts for accounting, but lose the customer's name, contact
+// details, notes and briefs; payments lose the gateway's raw response, which
+// repeats the customer's details. Order files are deleted, rows and stored
+// files alike, and the account is anonymised and signed out. With dryRun the
+// changes are counted and rolled back. The append-only audit log is left as it
+// is: it holds no personal data, only IDs and redacted values (auditRedactedFields).
+//
+// It returns the locations of files that could not be deleted here, either
+// hosted elsewhere or failing to delete, for someone to remove by hand.
+func (s *PrivacyService) Erase(subject *DataSubject, dryRun bool) (*DataErasureSummary, []string, error) {
+	summary := &DataErasureSummary{}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in Erase", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	exec := func(count *int, query string, args ...interface{}) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		*count += int(affected)
+		return nil
+	}
+
+	files, err := s.subjectFiles(tx, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything keyed on the order's email goes before the orders themselves are anonymised
+	steps := []struct {
+		count *int
+		query string
+	}{
+		{&summary.OrderFiles, "DELETE FROM order_files WHERE order_id IN (" + subjectOrderIDs + ")"},
+		{&summary.OrderItems, `
+			UPDATE order_items SET brief_details = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE order_id IN (` + subjectOrderIDs + `) AND brief_details IS NOT NULL
+		`},
+		{&summary.Payments, `
+			UPDATE payments SET raw_response = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE order_id IN (` + subjectOrderIDs + `) AND raw_response IS NOT NULL
+		`},
+		{&summary.PortalRecords, "DELETE FROM portal_links WHERE LOWER(customer_email) = $1"},
+		{&summary.PortalRecords, "DELETE FROM portal_sessions WHERE LOWER(customer_email) = $1"},
+	}
+	for _, step := range steps {
+		if err := exec(step.count, step.query, subject.Email); err != nil {
+			return nil, nil, fmt.Errorf("failed to erase order data: %w", err)
+		}
+	}
+
+	// Each order gets its own placeholder address, so erased orders are not grouped under one email
+	err = exec(&summary.Orders, `
+		UPDATE orders
+		SET customer_name = $2, customer_email = 'erased-' || id || '@erased.invalid',
+		    customer_phone = NULL, customer_address = NULL, notes = NULL, admin_notes = NULL,
+		    personal_data_erased_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE LOWER(customer_email) = $1
+	`, subject.Email, erasedCustomerName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymise orders: %w", err)
+	}
+
+	if subject.UserID != "" {
+		var ignored int
+		userSteps := []struct {
+			count *int
+			query string
+		}{
+			{&summary.SessionsEnded, "DELETE FROM customer_sessions WHERE user_id = $1"},
+			{&ignored, "DELETE FROM customer_tokens WHERE user_id = $1"},
+			{&ignored, "DELETE FROM password_history WHERE account_type = 'users' AND account_id = $1"},
+		}
+		for _, step := range userSteps {
+			if err := exec(step.count, step.query, subject.UserID); err != nil {
+				return nil, nil, fmt.Errorf("failed to erase account data: %w", err)
+			}
+		}
+
+		// The row stays because bookings and projects may refer to it; an empty
+		// password hash matches no password, so the account cannot sign in
+		var accounts int
+		err = exec(&accounts, `
+			UPDATE users
+			SET email = 'erased-' || id || '@erased.invalid', full_name = $2, password_hash = '',
+			    is_active = false, email_verified_at = NULL,
+			    personal_data_erased_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, subject.UserID, erasedCustomerName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to anonymise account: %w", err)
+		}
+		summary.AccountErased = accounts > 0
+	}
+
+	var remaining []string
+	if dryRun {
+		for _, file := range files {
+			if _, ok := s.localFilePath(file.filePath); !ok {
+				summary.FilesNotDeleted++
+				remaining = append(remaining, file.filePath)
+			}
+		}
+		return summary, remaining, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// The rows are gone, so the stored files are removed last; a failure leaves
+	// an orphaned file to remove by hand rather than undoing the erasure
+	for _, file := range files {
+		local, ok := s.localFilePath(file.filePath)
+		if !ok {
+			summary.FilesNotDeleted++
+			remaining = append(remaining, file.filePath)
+			continue
+		}
+		if err := os.Remove(local); err != nil && !errors.Is(err, os.ErrNotExist) {
+			LogError("Failed to delete erased order file", logrus.Fields{
+				"file_id": file.id,
+				"error":   err.Error(),
+			}, err)
+			summary.FilesNotDeleted++
+			remaining = append(remaining, file.filePath)
+			continue
+		}
+		summary.FilesDeleted++
+	}
+	return summary, remaining, nil
+}
+
+// PrivacyHandler serves the data-subject request endpoints
+type PrivacyHandler struct {
+	privacy *PrivacyService
+}
+
+// NewPrivacyHandler creates a new PrivacyHandler
+func NewPrivacyHandler(privacy *PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{privacy: privacy}
+}
+
+// RegisterRoutes registers the data-subject request routes
+func (h *PrivacyHandler) RegisterRoutes(r *gin.Engine) {
+	privacyGroup := r.Group("/api/privacy")
+	{
+		privacyGroup.GET("/requests", h.ListRequests)
+		privacyGroup.POST("/exports", h.Export)
+		privacyGroup.POST("/erasures", h.Erase)
+	}
+}
+
+// dataSubjectRequestBody names the subject of a request by email or user ID
+type dataSubjectRequestBody struct {
+	Email  string `json:"email"`
+	UserID string `json:"userId"`
+	// Reason records why the request was made, e.g. a ticket reference
+	Reason string `json:"reason" binding:"required"`
+	DryRun bool   `json:"dryRun"`
+	// Confirm must be true for an erasure that is not a dry run
+	Confirm bool `json:"confirm"`
+}
+
+// beginRequest reads a request, logs it and resolves its subject. Requests
+// naming nobody are logged as failed. On failure it writes the response and
+// returns ok false.
+func (h *PrivacyHandler) beginRequest(c *gin.Context, requestType string) (*DataSubject, string, *dataSubjectRequestBody, bool) {
+	var body dataSubjectRequestBody
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format, a reason is required",
+		})
+		return nil, "", nil, false
+	}
+	if (body.Email == "") == (body.UserID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Give either email or userId",
+		})
+		return nil, "", nil, false
+	}
+	if requestType == DataSubjectExport {
+		body.DryRun = false
+	} else if !body.DryRun && !body.Confirm {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Erasure cannot be undone; send confirm: true, or dryRun: true to preview it",
+		})
+		return nil, "", nil, false
+	}
+
+	subject, resolveErr := h.privacy.ResolveSubject(body.Email, body.UserID)
+	subjectHash, subjectUserID := hashToken(normalizeEmail(body.Email)), ""
+	if body.UserID != "" {
+		subjectHash = hashToken("user:" + body.UserID)
+	}
+	if subject != nil {
+		subjectHash, subjectUserID = subject.key(), subject.UserID
+	}
+	if resolveErr != nil && !errors.Is(resolveErr, ErrDataSubjectNotFound) {
+		LogError("Failed to resolve data subject", logrus.Fields{
+			"request_type": requestType,
+			"error":        resolveErr.Error(),
+		}, resolveErr)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to look up personal data",
+		})
+		return nil, "", nil, false
+	}
+
+	requestID, err := h.privacy.StartRequest(requestType, body.DryRun, subjectHash, subjectUserID,
+		strings.TrimSpace(body.Reason), c.GetString("user_id"), c.GetString("username"))
+	if err != nil {
+		LogError("Failed to log data-subject request", logrus.Fields{
+			"request_type": requestType,
+			"error":        err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to log the request",
+		})
+		return nil, "", nil, false
+	}
+	setAuditResourceID(c, requestID)
+
+	if resolveErr != nil {
+		h.privacy.FinishRequest(requestID, nil, resolveErr)
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "No personal data found for this subject",
+			"data":    gin.H{"requestId": requestID},
+		})
+		return nil, "", nil, false
+	}
+	return subject, requestID, &body, true
+}
+
+// Export handles POST /api/privacy/exports and responds with a zip of
+// everything stored about the subject
+func (h *PrivacyHandler) Export(c *gin.Context) {
+	subject, requestID, _, ok := h.beginRequest(c, DataSubjectExport)
+	if !ok {
+		return
+	}
+
+	export, err := h.privacy.CollectExport(subject)
+	if err != nil {
+		h.privacy.FinishRequest(requestID, nil, err)
+		LogError("Failed to export personal data", logrus.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to export personal data",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=personal-data-"+requestID+".zip")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	summary, err := h.privacy.WriteZip(c.Writer, requestID, export)
+	h.privacy.FinishRequest(requestID, summary, err)
+	if err != nil {
+		// The status is already sent, so the download ends early; the log says why
+		LogError("Failed to write personal data export", logrus.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		}, err)
+		return
+	}
+	auditChange(c, nil, summary)
+
+	LogSecurity("personal_data_exported", logrus.Fields{
+		"request_id": requestID,
+		"user_id":    c.GetString("user_id"),
+	})
+}
+
+// Erase handles POST /api/privacy/erasures
+func (h *PrivacyHandler) Erase(c *gin.Context) {
+	subject, requestID, body, ok := h.beginRequest(c, DataSubjectErasure)
+	if !ok {
+		return
+	}
+
+	summary, remaining, err := h.privacy.Erase(subject, body.DryRun)
+	h.privacy.FinishRequest(requestID, summary, err)
+	if err != nil {
+		LogError("Failed to erase personal data", logrus.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to erase personal data; nothing was changed",
+			"data":    gin.H{"requestId": requestID},
+		})
+		return
+	}
+	auditChange(c, nil, summary)
+
+	message := "Personal data erased"
+	if body.DryRun {
+		message = "Dry run: nothing was changed"
+	} else {
+		LogSecurity("personal_data_erased", logrus.Fields{
+			"request_id": requestID,
+			"user_id":    c.GetString("user_id"),
+		})
+	}
+	if remaining == nil {
+		remaining = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data": gin.H{
+			"requestId": requestID,
+			"dryRun":    body.DryRun,
+			"summary":   summary,
+			// Files hosted elsewhere, or that failed to delete, must be removed by hand
+			"filesToRemoveManually": remaining,
+		},
+	})
+}
+
+// ListRequests handles GET /api/privacy/requests
+func (h *PrivacyHandler) ListRequests(c *gin.Context) {
+	requestType := c.Query("type")
+	if requestType != "" && requestType != DataSubjectExport && requestType != DataSubjectErasure {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "type must be export or erasure",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200 // Max limit
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	requests, total, err := h.privacy.ListRequests(requestType, limit, offset)
+	if err != nil {
+		LogError("Failed to list data-subject requests", logrus.Fields{
+			"error": err.Error(),
+		}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list data-subject requests",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    requests,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"total":  total,
+		},
+	})
+}
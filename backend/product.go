@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,15 +16,22 @@ type Product struct {
 	ProductCode string          `json:"productCode"`
 	Name        string          `json:"name"`
 	Description string          `json:"description"`
-	Price       float64         `json:"price"`
+	Price       Money           `json:"price"`
 	Category    string          `json:"category"`
 	ImageURL    string          `json:"imageUrl"`
 	Features    json.RawMessage `json:"features"`
 	DeliveryTime string         `json:"deliveryTime"`
 	Revisions   int             `json:"revisions"`
 	Popular     bool            `json:"popular"`
+	// IsActive is false once a product is closed; new products start active
+	IsActive    bool            `json:"isActive"`
+	// DepositPercent is the down payment taken up front for orders containing
+	// the product; nil means the product is paid in full
+	DepositPercent *int         `json:"depositPercent,omitempty"`
 	CreatedAt   time.Time       `json:"createdAt"`
 	UpdatedAt   time.Time       `json:"updatedAt"`
+	// DeletedAt is set while a product is deleted; it can be restored until purged
+	DeletedAt   *time.Time      `json:"deletedAt,omitempty"`
 }
 
 // ProductRepository handles database operations for products
@@ -36,16 +44,18 @@ func NewProductRepository(db *sql.DB) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
-// GetAllProducts retrieves all products from the database
-func (r *ProductRepository) GetAllProducts() ([]Product, error) {
+// GetAllProducts retrieves all products from the database, with deleted ones
+// only when includeDeleted is set
+func (r *ProductRepository) GetAllProducts(includeDeleted bool) ([]Product, error) {
 	query := `
 		SELECT id, product_code, name, description, price, category, image_url, 
-		       features, delivery_time, revisions, popular, created_at, updated_at 
+		       features, delivery_time, revisions, popular, is_active, deposit_percent, created_at, updated_at, deleted_at
 		FROM products
+		WHERE ($1 OR deleted_at IS NULL)
 		ORDER BY name ASC
 	`
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.Query(query, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +68,7 @@ func (r *ProductRepository) GetAllProducts() ([]Product, error) {
 
 		scanErr := rows.Scan(
 			&p.ID, &p.ProductCode, &p.Name, &p.Description, &p.Price, &p.Category, &p.ImageURL,
-			&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.CreatedAt, &p.UpdatedAt,
+			&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.IsActive, &p.DepositPercent, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt,
 		)
 		if scanErr != nil {
 			return nil, scanErr
@@ -76,20 +86,20 @@ func (r *ProductRepository) GetAllProducts() ([]Product, error) {
 }
 
 // GetProductByCode retrieves a product by its product code
-func (r *ProductRepository) GetProductByCode(productCode string) (Product, error) {
+func (r *ProductRepository) GetProductByCode(productCode string, includeDeleted bool) (Product, error) {
 	query := `
 		SELECT id, product_code, name, description, price, category, image_url, 
-		       features, delivery_time, revisions, popular, created_at, updated_at 
+		       features, delivery_time, revisions, popular, is_active, deposit_percent, created_at, updated_at, deleted_at
 		FROM products 
-		WHERE product_code = $1
+		WHERE product_code = $1 AND ($2 OR deleted_at IS NULL)
 	`
 
 	var p Product
 	var features []byte
 
-	err := r.db.QueryRow(query, productCode).Scan(
+	err := r.db.QueryRow(query, productCode, includeDeleted).Scan(
 		&p.ID, &p.ProductCode, &p.Name, &p.Description, &p.Price, &p.Category, &p.ImageURL,
-		&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.CreatedAt, &p.UpdatedAt,
+		&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.IsActive, &p.DepositPercent, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt,
 	)
 
 	if err != nil {
@@ -104,20 +114,20 @@ func (r *ProductRepository) GetProductByCode(productCode string) (Product, error
 }
 
 // GetProductByID retrieves a product by its ID
-func (r *ProductRepository) GetProductByID(id string) (Product, error) {
+func (r *ProductRepository) GetProductByID(id string, includeDeleted bool) (Product, error) {
 	query := `
 		SELECT id, product_code, name, description, price, category, image_url, 
-		       features, delivery_time, revisions, popular, created_at, updated_at 
+		       features, delivery_time, revisions, popular, is_active, deposit_percent, created_at, updated_at, deleted_at
 		FROM products 
-		WHERE id = $1
+		WHERE id = $1 AND ($2 OR deleted_at IS NULL)
 	`
 
 	var p Product
 	var features []byte
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRow(query, id, includeDeleted).Scan(
 		&p.ID, &p.ProductCode, &p.Name, &p.Description, &p.Price, &p.Category, &p.ImageURL,
-		&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.CreatedAt, &p.UpdatedAt,
+		&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.IsActive, &p.DepositPercent, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt,
 	)
 
 	if err != nil {
@@ -132,16 +142,16 @@ func (r *ProductRepository) GetProductByID(id string) (Product, error) {
 }
 
 // GetProductsByCategory retrieves products by category
-func (r *ProductRepository) GetProductsByCategory(category string) ([]Product, error) {
+func (r *ProductRepository) GetProductsByCategory(category string, includeDeleted bool) ([]Product, error) {
 	query := `
 		SELECT id, product_code, name, description, price, category, image_url, 
-		       features, delivery_time, revisions, popular, created_at, updated_at 
+		       features, delivery_time, revisions, popular, is_active, deposit_percent, created_at, updated_at, deleted_at
 		FROM products 
-		WHERE category = $1
+		WHERE category = $1 AND ($2 OR deleted_at IS NULL)
 		ORDER BY name ASC
 	`
 
-	rows, err := r.db.Query(query, category)
+	rows, err := r.db.Query(query, category, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +164,7 @@ func (r *ProductRepository) GetProductsByCategory(category string) ([]Product, e
 
 		scanErr := rows.Scan(
 			&p.ID, &p.ProductCode, &p.Name, &p.Description, &p.Price, &p.Category, &p.ImageURL,
-			&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.CreatedAt, &p.UpdatedAt,
+			&features, &p.DeliveryTime, &p.Revisions, &p.Popular, &p.IsActive, &p.DepositPercent, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt,
 		)
 		if scanErr != nil {
 			return nil, scanErr
@@ -175,8 +185,8 @@ func (r *ProductRepository) GetProductsByCategory(category string) ([]Product, e
 func (r *ProductRepository) CreateProduct(product Product) (Product, error) {
 	query := `
 		INSERT INTO products (id, product_code, name, description, price, category, image_url, 
-		                     features, delivery_time, revisions, popular, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		                     features, delivery_time, revisions, popular, is_active, deposit_percent, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, TRUE, $12, $13, $14)
 		RETURNING id
 	`
 
@@ -189,13 +199,14 @@ func (r *ProductRepository) CreateProduct(product Product) (Product, error) {
 	now := time.Now()
 	product.CreatedAt = now
 	product.UpdatedAt = now
+	product.IsActive = true
 
 	// Execute the query
 	err := r.db.QueryRow(
 		query,
 		product.ID, product.ProductCode, product.Name, product.Description, product.Price, product.Category,
 		product.ImageURL, product.Features, product.DeliveryTime, product.Revisions,
-		product.Popular, product.CreatedAt, product.UpdatedAt,
+		product.Popular, product.DepositPercent, product.CreatedAt, product.UpdatedAt,
 	).Scan(&product.ID)
 
 	if err != nil {
@@ -210,22 +221,21 @@ func (r *ProductRepository) UpdateProduct(product Product) (Product, error) {
 	query := `
 		UPDATE products
 		SET product_code = $2, name = $3, description = $4, price = $5, category = $6, image_url = $7,
-		    features = $8, delivery_time = $9, revisions = $10, popular = $11, updated_at = $12
-		WHERE id = $1
-		RETURNING id
+		    features = $8, delivery_time = $9, revisions = $10, popular = $11, deposit_percent = $12, updated_at = $13
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING is_active
 	`
 
 	// Update timestamp
 	product.UpdatedAt = time.Now()
 
 	// Execute the query
-	var id string
 	err := r.db.QueryRow(
 		query,
 		product.ID, product.ProductCode, product.Name, product.Description, product.Price, product.Category,
 		product.ImageURL, product.Features, product.DeliveryTime, product.Revisions,
-		product.Popular, product.UpdatedAt,
-	).Scan(&id)
+		product.Popular, product.DepositPercent, product.UpdatedAt,
+	).Scan(&product.IsActive)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -237,11 +247,54 @@ func (r *ProductRepository) UpdateProduct(product Product) (Product, error) {
 	return product, nil
 }
 
-// DeleteProduct deletes a product by its ID
+// DeleteProduct soft-deletes a product by its ID. The product is also closed,
+// so a restored product stays closed until it is reopened.
 func (r *ProductRepository) DeleteProduct(id string) error {
-	query := "DELETE FROM products WHERE id = $1"
+	query := "UPDATE products SET deleted_at = $2, is_active = FALSE, updated_at = $2 WHERE id = $1 AND deleted_at IS NULL"
+
+	result, err := r.db.Exec(query, id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("product not found")
+	}
+
+	return nil
+}
+
+// SetProductActive closes or reopens a product
+func (r *ProductRepository) SetProductActive(id string, active bool) error {
+	query := "UPDATE products SET is_active = $2, updated_at = $3 WHERE id = $1 AND deleted_at IS NULL"
+
+	result, err := r.db.Exec(query, id, active, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("product not found")
+	}
+
+	return nil
+}
+
+// RestoreProduct undoes the deletion of a product
+func (r *ProductRepository) RestoreProduct(id string) error {
+	query := "UPDATE products SET deleted_at = NULL, updated_at = $2 WHERE id = $1 AND deleted_at IS NOT NULL"
 
-	result, err := r.db.Exec(query, id)
+	result, err := r.db.Exec(query, id, time.Now())
 	if err != nil {
 		return err
 	}
@@ -256,4 +309,18 @@ func (r *ProductRepository) DeleteProduct(id string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// PurgeDeletedProducts permanently removes products deleted before cutoff.
+// Products that order items refer to are kept, so order history stays intact.
+func (r *ProductRepository) PurgeDeletedProducts(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(`
+		DELETE FROM products p
+		WHERE p.deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.product_id = p.id)
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted products: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -2,18 +2,22 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // ProductHandler handles HTTP requests related to products
 type ProductHandler struct {
-	repo *ProductRepository
+	repo        *ProductRepository
+	catalogSync *CatalogSyncService
 }
 
-// NewProductHandler creates a new product handler
-func NewProductHandler(repo *ProductRepository) *ProductHandler {
-	return &ProductHandler{repo: repo}
+// NewProductHandler creates a new product handler. catalogSync may be nil, in
+// which case product state changes are not pushed to Mayar.
+func NewProductHandler(repo *ProductRepository, catalogSync *CatalogSyncService) *ProductHandler {
+	return &ProductHandler{repo: repo, catalogSync: catalogSync}
 }
 
 // RegisterRoutes registers the product routes
@@ -27,12 +31,15 @@ func (h *ProductHandler) RegisterRoutes(router *gin.Engine) {
 		api.POST("/products", h.CreateProduct)
 		api.PUT("/products/:id", h.UpdateProduct)
 		api.DELETE("/products/:id", h.DeleteProduct)
+		api.POST("/products/:id/close", h.CloseProduct)
+		api.POST("/products/:id/reopen", h.ReopenProduct)
+		api.POST("/products/:id/restore", h.RestoreProduct)
 	}
 }
 
 // GetAllProducts handles GET /api/products
 func (h *ProductHandler) GetAllProducts(c *gin.Context) {
-	products, err := h.repo.GetAllProducts()
+	products, err := h.repo.GetAllProducts(includeDeleted(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -44,7 +51,7 @@ func (h *ProductHandler) GetAllProducts(c *gin.Context) {
 // GetProductByID handles GET /api/products/:id
 func (h *ProductHandler) GetProductByID(c *gin.Context) {
 	id := c.Param("id")
-	product, err := h.repo.GetProductByID(id)
+	product, err := h.repo.GetProductByID(id, includeDeleted(c))
 	if err != nil {
 		if err.Error() == "product not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
@@ -60,7 +67,7 @@ func (h *ProductHandler) GetProductByID(c *gin.Context) {
 // GetProductByCode handles GET /api/products/code/:code
 func (h *ProductHandler) GetProductByCode(c *gin.Context) {
 	code := c.Param("code")
-	product, err := h.repo.GetProductByCode(code)
+	product, err := h.repo.GetProductByCode(code, includeDeleted(c))
 	if err != nil {
 		if err.Error() == "product not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
@@ -76,7 +83,7 @@ func (h *ProductHandler) GetProductByCode(c *gin.Context) {
 // GetProductsByCategory handles GET /api/products/category/:category
 func (h *ProductHandler) GetProductsByCategory(c *gin.Context) {
 	category := c.Param("category")
-	products, err := h.repo.GetProductsByCategory(category)
+	products, err := h.repo.GetProductsByCategory(category, includeDeleted(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -94,10 +101,14 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	}
 
 	// Validate required fields
-	if product.Name == "" || product.Description == "" || product.Price <= 0 || product.Category == "" || product.ProductCode == "" {
+	if product.Name == "" || product.Description == "" || !product.Price.IsPositive() || product.Category == "" || product.ProductCode == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
 		return
 	}
+	if product.DepositPercent != nil && (*product.DepositPercent <= 0 || *product.DepositPercent >= 100) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "depositPercent must be between 1 and 99"})
+		return
+	}
 
 	// Create the product
 	createdProduct, err := h.repo.CreateProduct(product)
@@ -105,6 +116,8 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	setAuditResourceID(c, createdProduct.ID)
+	auditChange(c, nil, createdProduct)
 
 	c.JSON(http.StatusCreated, createdProduct)
 }
@@ -114,7 +127,7 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	id := c.Param("id")
 
 	// Check if product exists
-	_, err := h.repo.GetProductByID(id)
+	existing, err := h.repo.GetProductByID(id, false)
 	if err != nil {
 		if err.Error() == "product not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
@@ -135,10 +148,31 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	product.ID = id
 
 	// Validate required fields
-	if product.Name == "" || product.Description == "" || product.Price <= 0 || product.Category == "" || product.ProductCode == "" {
+	if product.Name == "" || product.Description == "" || !product.Price.IsPositive() || product.Category == "" || product.ProductCode == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
 		return
 	}
+	if product.DepositPercent != nil && (*product.DepositPercent <= 0 || *product.DepositPercent >= 100) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "depositPercent must be between 1 and 99"})
+		return
+	}
+
+	// A mapped product's name, description and price come from Mayar; the
+	// next sync would silently revert a local edit, so it is refused
+	if fields := mayarOwnedChanges(existing, product); len(fields) > 0 {
+		mapped, mapErr := h.catalogSync.IsMapped(id)
+		if mapErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": mapErr.Error()})
+			return
+		}
+		if mapped {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "These fields are managed in Mayar for this product: " + strings.Join(fields, ", "),
+				"fields": fields,
+			})
+			return
+		}
+	}
 
 	// Update the product
 	updatedProduct, err := h.repo.UpdateProduct(product)
@@ -146,6 +180,7 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, existing, updatedProduct)
 
 	c.JSON(http.StatusOK, updatedProduct)
 }
@@ -154,7 +189,18 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	id := c.Param("id")
 
-	// Delete the product
+	// Keep the product as it was for the audit log
+	existing, getErr := h.repo.GetProductByID(id, false)
+
+	// Close the Mayar product first; the mapping stays until the product is purged
+	if _, syncErr := h.catalogSync.PropagateProductState(c.Request.Context(), id, false); syncErr != nil {
+		LogWarn("Failed to close Mayar product for deleted product", logrus.Fields{
+			"product_id": id,
+			"error":      syncErr.Error(),
+		})
+	}
+
+	// Soft-delete the product; it can be restored until it is purged
 	err := h.repo.DeleteProduct(id)
 	if err != nil {
 		if err.Error() == "product not found" {
@@ -165,5 +211,85 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
+	if getErr == nil {
+		auditChange(c, existing, nil)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
-}
\ No newline at end of file
+}
+
+// RestoreProduct handles POST /api/products/:id/restore. Deleting closed the
+// product, so it comes back closed and is reopened separately.
+func (h *ProductHandler) RestoreProduct(c *gin.Context) {
+	id := c.Param("id")
+
+	existing, getErr := h.repo.GetProductByID(id, true)
+	if err := h.repo.RestoreProduct(id); err != nil {
+		if err.Error() == "product not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.repo.GetProductByID(id, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if getErr == nil {
+		auditChange(c, existing, product)
+	}
+
+	c.JSON(http.StatusOK, product)
+}
+
+// CloseProduct handles POST /api/products/:id/close
+func (h *ProductHandler) CloseProduct(c *gin.Context) {
+	h.setProductActive(c, false)
+}
+
+// ReopenProduct handles POST /api/products/:id/reopen
+func (h *ProductHandler) ReopenProduct(c *gin.Context) {
+	h.setProductActive(c, true)
+}
+
+// setProductActive closes or reopens a product and pushes the change to its
+// Mayar product. A Mayar failure does not undo the local change.
+func (h *ProductHandler) setProductActive(c *gin.Context, active bool) {
+	id := c.Param("id")
+
+	existing, getErr := h.repo.GetProductByID(id, false)
+	if err := h.repo.SetProductActive(id, active); err != nil {
+		if err.Error() == "product not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.repo.GetProductByID(id, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if getErr == nil {
+		auditChange(c, existing, product)
+	}
+
+	response := gin.H{"product": product}
+	synced, syncErr := h.catalogSync.PropagateProductState(c.Request.Context(), id, active)
+	if syncErr != nil {
+		LogWarn("Failed to push product state to Mayar", logrus.Fields{
+			"product_id": id,
+			"active":     active,
+			"error":      syncErr.Error(),
+		})
+		response["mayarError"] = syncErr.Error()
+	}
+	response["mayarSynced"] = synced
+
+	c.JSON(http.StatusOK, response)
+}
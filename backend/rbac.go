@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Admin roles stored in admin_users.role
+const (
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+	RoleDesigner = "designer"
+	RoleFinance  = "finance"
+	RoleViewer   = "viewer"
+)
+
+// Permission names an action a role may perform, as "area:action"
+type Permission string
+
+// Permissions required by routePolicies and checked by AuthorizeRoutes
+const (
+	PermOrdersRead           Permission = "orders:read"
+	PermOrdersCreate         Permission = "orders:create"
+	PermOrdersUpdateStatus   Permission = "orders:update_status"
+	PermOrdersManageSchedule Permission = "orders:manage_schedule"
+	PermPaymentsRead         Permission = "payments:read"
+	PermPaymentsCreateLink   Permission = "payments:create_link"
+	PermPaymentsConfirm      Permission = "payments:confirm"
+	PermPaymentsRefund       Permission = "payments:refund"
+	PermProductsManage       Permission = "products:manage"
+	PermUsersRead            Permission = "users:read"
+	PermUsersManage          Permission = "users:manage"
+	PermAdminsManage         Permission = "admins:manage"
+	PermAPIKeysManage        Permission = "api_keys:manage"
+	PermAuditRead            Permission = "audit:read"
+	PermPrivacyManage        Permission = "privacy:manage"
+	PermDashboardRead        Permission = "dashboard:read"
+	PermDashboardExport      Permission = "dashboard:export"
+	PermMayarProducts        Permission = "mayar:products"
+	PermMayarInvoices        Permission = "mayar:invoices"
+	PermMayarCustomers       Permission = "mayar:customers"
+	PermMayarTransactions    Permission = "mayar:transactions"
+	PermMayarWebhooks        Permission = "mayar:webhooks"
+	PermMayarLicenses        Permission = "mayar:licenses"
+	PermMayarCoupons         Permission = "mayar:coupons"
+	PermMayarCatalog         Permission = "mayar:catalog"
+)
+
+// allPermissions lists every permission, for roles that hold them all
+var allPermissions = []Permission{
+	PermOrdersRead, PermOrdersCreate, PermOrdersUpdateStatus, PermOrdersManageSchedule,
+	PermPaymentsRead, PermPaymentsCreateLink, PermPaymentsConfirm, PermPaymentsRefund,
+	PermProductsManage, PermUsersRead, PermUsersManage, PermAdminsManage, PermAPIKeysManage, PermAuditRead,
+	PermPrivacyManage, PermDashboardRead, PermDashboardExport,
+	PermMayarProducts, PermMayarInvoices, PermMayarCustomers, PermMayarTransactions,
+	PermMayarWebhooks, PermMayarLicenses, PermMayarCoupons, PermMayarCatalog,
+}
+
+// rolePermissions is the permission matrix. Refunds move money out, so only
+// the owner and finance may issue them. Exporting or erasing a customer's
+// personal data is left to the owner.
+var rolePermissions = map[string][]Permission{
+	RoleOwner: allPermissions,
+	RoleAdmin: withoutPermissions(allPermissions, PermPaymentsRefund, PermPrivacyManage),
+	RoleDesigner: {
+		PermOrdersRead, PermOrdersUpdateStatus, PermDashboardRead,
+	},
+	RoleFinance: {
+		PermOrdersRead, PermOrdersManageSchedule,
+		PermPaymentsRead, PermPaymentsCreateLink, PermPaymentsConfirm, PermPaymentsRefund,
+		PermDashboardRead, PermDashboardExport,
+		PermMayarInvoices, PermMayarCustomers, PermMayarTransactions, PermMayarCoupons,
+	},
+	RoleViewer: {
+		PermOrdersRead, PermPaymentsRead, PermUsersRead, PermDashboardRead,
+	},
+}
+
+// withoutPermissions returns perms minus the excluded ones
+func withoutPermissions(perms []Permission, excluded ...Permission) []Permission {
+	result := make([]Permission, 0, len(perms))
+	for _, perm := range perms {
+		keep := true
+		for _, ex := range excluded {
+			if perm == ex {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, perm)
+		}
+	}
+	return result
+}
+
+// IsValidRole reports whether role is one of the admin roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHasPermission reports whether role grants perm. Unknown roles grant nothing.
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsForRole returns the permissions role grants, sorted
+func PermissionsForRole(role string) []Permission {
+	perms := append([]Permission{}, rolePermissions[role]...)
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
+
+// apiKeyHasScope reports whether the request is not made with an API key or
+// the key's scopes include perm
+func apiKeyHasScope(c *gin.Context, perm Permission) bool {
+	scopes, ok := c.Get("api_key_scopes")
+	if !ok {
+		return true
+	}
+	for _, scope := range scopes.([]Permission) {
+		if scope == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPermission writes a 403 and returns false unless the authenticated role
+// grants perm and, for API keys, perm is one of the key's scopes
+func checkPermission(c *gin.Context, perm Permission) bool {
+	role := c.GetString("role")
+	if RoleHasPermission(role, perm) && apiKeyHasScope(c, perm) {
+		return true
+	}
+
+	LogSecurity("permission_denied", logrus.Fields{
+		"user_id":    c.GetString("user_id"),
+		"role":       role,
+		"api_key_id": c.GetString("api_key_id"),
+		"permission": string(perm),
+		"method":     c.Request.Method,
+		"path":       c.FullPath(),
+		"client_ip":  c.ClientIP(),
+	})
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "permission": perm})
+	c.Abort()
+	return false
+}
+
+// GetMyPermissions handles GET /api/admin/permissions
+func GetMyPermissions(c *gin.Context) {
+	role := c.GetString("role")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"userId":      c.GetString("user_id"),
+			"username":    c.GetString("username"),
+			"role":        role,
+			"permissions": PermissionsForRole(role),
+		},
+	})
+}
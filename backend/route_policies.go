@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route access levels that are not permissions
+const (
+	// AccessPublic routes need no token
+	AccessPublic Permission = "public"
+	// AccessAuthenticated routes need a valid token but no particular permission
+	AccessAuthenticated Permission = "authenticated"
+	// AccessCustomer routes need a customer token; admin tokens are not accepted
+	AccessCustomer Permission = "customer"
+	// AccessPortal routes need an order portal token from a magic link
+	AccessPortal Permission = "portal"
+	// AccessAdminPanel routes are the server-rendered admin pages; they need the
+	// panel's session cookie and send the browser to the login page without one
+	AccessAdminPanel Permission = "admin_panel"
+)
+
+// RoutePolicy is the access rule for one route, matched on method and the
+// route pattern as registered (e.g. "/api/orders/:id/status")
+type RoutePolicy struct {
+	Method     string
+	Path       string
+	Permission Permission
+}
+
+// routePolicies is the protection policy of every route in one table.
+// AuthorizeRoutes rejects routes missing from it, and StartServer refuses to
+// start when a registered route has no entry, so a new route is never public
+// by accident.
+var routePolicies = []RoutePolicy{
+	// Admin login pages and session endpoints
+	{http.MethodGet, "/admin", AccessAdminPanel},
+	{http.MethodGet, "/admin/login", AccessPublic},
+	{http.MethodPost, "/admin/login", AccessPublic},
+	{http.MethodPost, "/admin/login/totp", AccessPublic},
+	{http.MethodPost, "/admin/login/totp/enroll", AccessPublic},
+	{http.MethodPost, "/admin/refresh", AccessPublic},
+	{http.MethodPost, "/admin/logout", AccessPublic},
+	{http.MethodGet, "/admin/logout", AccessPublic},
+	{http.MethodGet, "/admin/sessions", AccessAuthenticated},
+	{http.MethodDelete, "/admin/sessions/:id", AccessAuthenticated},
+	{http.MethodPost, "/admin/sessions/revoke-others", AccessAuthenticated},
+	{http.MethodGet, "/admin/users", AccessAdminPanel}, // HTML shell; its data comes from /api/users
+
+	// Health and public keys
+	{http.MethodGet, "/api/health", AccessPublic},
+	{http.MethodGet, "/api/ping", AccessPublic},
+	{http.MethodGet, "/.well-known/jwks.json", AccessPublic},
+
+	// Customer accounts
+	{http.MethodPost, "/api/auth/register", AccessPublic},
+	{http.MethodPost, "/api/auth/login", AccessPublic},
+	{http.MethodPost, "/api/auth/verify-email", AccessPublic},
+	{http.MethodPost, "/api/auth/resend-verification", AccessPublic},
+	{http.MethodPost, "/api/auth/forgot-password", AccessPublic},
+	{http.MethodPost, "/api/auth/reset-password", AccessPublic},
+	{http.MethodPost, "/api/auth/logout", AccessCustomer},
+	{http.MethodGet, "/api/auth/me", AccessCustomer},
+	{http.MethodGet, "/api/account/orders", AccessCustomer},
+
+	// Passwordless order portal
+	{http.MethodPost, "/api/portal/magic-link", AccessPublic},
+	{http.MethodPost, "/api/portal/session", AccessPublic},
+	{http.MethodPost, "/api/portal/logout", AccessPortal},
+	{http.MethodGet, "/api/portal/orders", AccessPortal},
+	{http.MethodGet, "/api/portal/orders/:id", AccessPortal},
+	{http.MethodGet, "/api/portal/orders/:id/files", AccessPortal},
+	{http.MethodGet, "/api/portal/orders/:id/invoices", AccessPortal},
+	{http.MethodGet, "/api/portal/orders/:id/history", AccessPortal},
+
+	// Storefront catalog
+	{http.MethodGet, "/api/payment-gateways", AccessPublic},
+	{http.MethodGet, "/api/products", AccessPublic},
+	{http.MethodGet, "/api/products/:id", AccessPublic},
+	{http.MethodGet, "/api/products/code/:code", AccessPublic},
+	{http.MethodGet, "/api/products/category/:category", AccessPublic},
+	{http.MethodPost, "/api/products", PermProductsManage},
+	{http.MethodPut, "/api/products/:id", PermProductsManage},
+	{http.MethodDelete, "/api/products/:id", PermProductsManage},
+	{http.MethodPost, "/api/products/:id/close", PermProductsManage},
+	{http.MethodPost, "/api/products/:id/reopen", PermProductsManage},
+	{http.MethodPost, "/api/products/:id/restore", PermProductsManage},
+
+	// Orders and their payments
+	{http.MethodGet, "/api/orders", PermOrdersRead},
+	{http.MethodGet, "/api/orders/:id", PermOrdersRead},
+	{http.MethodPost, "/api/orders", PermOrdersCreate},
+	{http.MethodPut, "/api/orders/:id/status", PermOrdersUpdateStatus},
+	{http.MethodGet, "/api/orders/status/:status", PermOrdersRead},
+	{http.MethodGet, "/api/orders/analytics", PermDashboardRead},
+	{http.MethodPost, "/api/orders/:id/payment-link", PermPaymentsCreateLink},
+	{http.MethodGet, "/api/orders/:id/payment", PermPaymentsRead},
+	{http.MethodPost, "/api/orders/:id/payment/confirm", PermPaymentsConfirm},
+	{http.MethodPost, "/api/orders/:id/payment/cancel", PermPaymentsCreateLink},
+	{http.MethodPost, "/api/orders/:id/payment/refund", PermPaymentsRefund},
+	{http.MethodGet, "/api/orders/:id/payments", PermPaymentsRead},
+	{http.MethodGet, "/api/orders/:id/installments", PermPaymentsRead},
+	{http.MethodPut, "/api/orders/:id/installments", PermOrdersManageSchedule},
+	{http.MethodPost, "/api/orders/:id/installments/:sequence/payment-link", PermPaymentsCreateLink},
+	{http.MethodPost, "/api/orders/:id/installments/:sequence/confirm", PermPaymentsConfirm},
+
+	// Admin
+	{http.MethodGet, "/api/admin/permissions", AccessAuthenticated},
+	{http.MethodGet, "/api/admin/payments", PermPaymentsRead},
+	{http.MethodGet, "/api/admin/login-lockouts", PermAdminsManage},
+	{http.MethodPost, "/api/admin/login-lockouts/unlock", PermAdminsManage},
+	{http.MethodGet, "/api/admin/totp", AccessAuthenticated},
+	{http.MethodPost, "/api/admin/totp/enroll", AccessAuthenticated},
+	{http.MethodPost, "/api/admin/totp/confirm", AccessAuthenticated},
+	{http.MethodPost, "/api/admin/totp/recovery-codes", AccessAuthenticated},
+	{http.MethodDelete, "/api/admin/totp", AccessAuthenticated},
+	{http.MethodPost, "/api/admin/admin-users/:id/totp/reset", PermAdminsManage},
+	{http.MethodGet, "/api/admin/api-keys", PermAPIKeysManage},
+	{http.MethodPost, "/api/admin/api-keys", PermAPIKeysManage},
+	{http.MethodDelete, "/api/admin/api-keys/:id", PermAPIKeysManage},
+	{http.MethodGet, "/api/audit", PermAuditRead},
+	{http.MethodGet, "/api/audit/export", PermAuditRead},
+	{http.MethodGet, "/api/privacy/requests", PermPrivacyManage},
+	{http.MethodPost, "/api/privacy/exports", PermPrivacyManage},
+	{http.MethodPost, "/api/privacy/erasures", PermPrivacyManage},
+
+	// Website users
+	{http.MethodGet, "/api/users", PermUsersRead},
+	{http.MethodGet, "/api/users/export", PermUsersRead},
+	{http.MethodGet, "/api/users/:id", PermUsersRead},
+	{http.MethodPost, "/api/users", PermUsersManage},
+	{http.MethodPost, "/api/users/import", PermUsersManage},
+	{http.MethodPut, "/api/users/:id", PermUsersManage},
+	{http.MethodDelete, "/api/users/:id", PermUsersManage},
+	{http.MethodPatch, "/api/users/:id/toggle-status", PermUsersManage},
+	{http.MethodPatch, "/api/users/:id/change-password", PermUsersManage},
+	{http.MethodPost, "/api/users/:id/restore", PermUsersManage},
+
+	// Dashboard; /ws streams every order's updates and the revenue figures
+	{http.MethodGet, "/ws", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/metrics", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/orders/analytics", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/products/analytics", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/search", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/activity", PermDashboardRead},
+	{http.MethodPost, "/api/dashboard/orders/bulk-update", PermOrdersUpdateStatus},
+	{http.MethodGet, "/api/dashboard/orders/export", PermDashboardExport},
+	{http.MethodGet, "/api/dashboard/real-metrics", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/real-orders/analytics", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/real-search", PermDashboardRead},
+	{http.MethodGet, "/api/dashboard/outstanding-balances", PermPaymentsRead},
+
+	// Mayar; incoming webhooks are verified by their signature instead
+	{http.MethodPost, "/api/mayar/webhooks/incoming", AccessPublic},
+	{http.MethodGet, "/api/mayar/products", PermMayarProducts},
+	{http.MethodGet, "/api/mayar/products/type/:type", PermMayarProducts},
+	{http.MethodGet, "/api/mayar/products/:id", PermMayarProducts},
+	{http.MethodPost, "/api/mayar/products/:id/close", PermMayarProducts},
+	{http.MethodPost, "/api/mayar/products/:id/reopen", PermMayarProducts},
+	{http.MethodPost, "/api/mayar/invoices", PermMayarInvoices},
+	{http.MethodGet, "/api/mayar/invoices/:id", PermMayarInvoices},
+	{http.MethodPut, "/api/mayar/invoices/:id", PermMayarInvoices},
+	{http.MethodDelete, "/api/mayar/invoices/:id", PermMayarInvoices},
+	{http.MethodPost, "/api/mayar/payment-requests", PermMayarInvoices},
+	{http.MethodGet, "/api/mayar/payment-requests/:id", PermMayarInvoices},
+	{http.MethodPost, "/api/mayar/customers", PermMayarCustomers},
+	{http.MethodGet, "/api/mayar/customers/:id", PermMayarCustomers},
+	{http.MethodPut, "/api/mayar/customers/:id", PermMayarCustomers},
+	{http.MethodGet, "/api/mayar/transactions", PermMayarTransactions},
+	{http.MethodGet, "/api/mayar/transactions/:id", PermMayarTransactions},
+	{http.MethodPost, "/api/mayar/webhooks/register", PermMayarWebhooks},
+	{http.MethodGet, "/api/mayar/webhooks/history", PermMayarWebhooks},
+	{http.MethodPost, "/api/mayar/webhooks/:id/test", PermMayarWebhooks},
+	{http.MethodPost, "/api/mayar/webhooks/retry/:historyId", PermMayarWebhooks},
+	{http.MethodGet, "/api/mayar/metrics", PermMayarWebhooks},
+	{http.MethodPost, "/api/mayar/license/verify", PermMayarLicenses},
+	{http.MethodPost, "/api/mayar/license/activate", PermMayarLicenses},
+	{http.MethodPost, "/api/mayar/license/deactivate", PermMayarLicenses},
+	{http.MethodPost, "/api/mayar/coupons", PermMayarCoupons},
+	{http.MethodPost, "/api/mayar/coupons/apply", PermMayarCoupons},
+	{http.MethodGet, "/api/mayar/coupons/:code", PermMayarCoupons},
+	{http.MethodPost, "/api/mayar/catalog/sync", PermMayarCatalog},
+	{http.MethodGet, "/api/mayar/catalog/sync/last", PermMayarCatalog},
+	{http.MethodGet, "/api/mayar/catalog/diff", PermMayarCatalog},
+	{http.MethodGet, "/api/mayar/catalog/mappings", PermMayarCatalog},
+	{http.MethodPut, "/api/mayar/catalog/mappings/:productId", PermMayarCatalog},
+	{http.MethodDelete, "/api/mayar/catalog/mappings/:productId", PermMayarCatalog},
+}
+
+// includeDeletedPolicies is what public routes require instead when a request
+// asks for deleted rows with ?include_deleted=true
+var includeDeletedPolicies = map[string]Permission{
+	"GET /api/products":                    PermProductsManage,
+	"GET /api/products/:id":                PermProductsManage,
+	"GET /api/products/code/:code":         PermProductsManage,
+	"GET /api/products/category/:category": PermProductsManage,
+}
+
+// routePolicyIndex maps "METHOD path" to the policy's permission
+var routePolicyIndex = func() map[string]Permission {
+	index := make(map[string]Permission, len(routePolicies))
+	for _, policy := range routePolicies {
+		index[policy.Method+" "+policy.Path] = policy.Permission
+	}
+	return index
+}()
+
+// routePermission returns the permission a route requires
+func routePermission(method, path string) (Permission, bool) {
+	perm, ok := routePolicyIndex[method+" "+path]
+	return perm, ok
+}
+
+// AuthorizeRoutes is a middleware that enforces routePolicies on every route.
+// It must be added before any route is registered. Requests that match no
+// route fall through to the 404 handler; matched routes without a policy are
+// refused. Admin routes accept a bearer token, the admin panel's session cookie
+// or an X-API-Key header; API keys only reach routes whose permission is one of
+// their scopes, never the AccessAuthenticated routes that act on an admin's own
+// account. Public routes asked for deleted rows are treated as admin routes.
+func AuthorizeRoutes(authService *AuthService, customerAuth *CustomerAuthService, portal *PortalService, apiKeys *APIKeyStore, panel *AdminPanel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if path == "" || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		perm, ok := routePermission(c.Request.Method, path)
+		if !ok {
+			LogError("Route has no access policy", nil, fmt.Errorf("%s %s", c.Request.Method, path))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+		if perm == AccessPublic {
+			elevated, ok := includeDeletedPolicies[c.Request.Method+" "+path]
+			if !ok || !includeDeleted(c) {
+				c.Next()
+				return
+			}
+			perm = elevated
+		}
+		if perm == AccessCustomer {
+			if authenticateCustomer(customerAuth, c) {
+				c.Next()
+			}
+			return
+		}
+		if perm == AccessPortal {
+			if authenticatePortal(portal, c) {
+				c.Next()
+			}
+			return
+		}
+		if perm == AccessAdminPanel {
+			if panel.authenticatePage(c) {
+				c.Next()
+			}
+			return
+		}
+
+		if c.GetHeader("X-API-Key") != "" {
+			if perm == AccessAuthenticated {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot access this route"})
+				c.Abort()
+				return
+			}
+			if !authenticateAPIKeyRequest(apiKeys, c) {
+				return
+			}
+		} else if !authenticateRequest(authService, c) {
+			return
+		}
+		if perm != AccessAuthenticated && !checkPermission(c, perm) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// UnprotectedRoutes returns the registered routes that have no policy in routePolicies
+func UnprotectedRoutes(routes gin.RoutesInfo) []string {
+	var missing []string
+	for _, route := range routes {
+		if _, ok := routePermission(route.Method, route.Path); !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	return missing
+}
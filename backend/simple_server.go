@@ -392,7 +392,7 @@ func RunSimpleServer() {
 
 	// Initialize handlers
 	serviceItemHandler := NewServiceItemHandler(serviceItemRepo)
-	dashboardHandler := NewDashboardHandler(serviceItemRepo)
+	dashboardHandler := NewDashboardHandler(serviceItemRepo, NewOrderRepository(dbConn))
 
 	r := gin.Default()
 
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSoftDeleteRetention is how long deleted users and products can be
+// restored before they are purged
+const defaultSoftDeleteRetention = 30 * 24 * time.Hour
+
+// includeDeleted reports whether a request asked for deleted rows with
+// ?include_deleted=true. On public routes AuthorizeRoutes lets such requests
+// through only with the permission in includeDeletedPolicies.
+func includeDeleted(c *gin.Context) bool {
+	include, _ := strconv.ParseBool(c.Query("include_deleted"))
+	return include
+}
+
+// PurgeDeleted permanently removes users and products deleted more than
+// retention ago, returning how many of each were removed
+func PurgeDeleted(users *UserRepository, products *ProductRepository, retention time.Duration) (int64, int64, error) {
+	cutoff := time.Now().Add(-retention)
+
+	purgedUsers, err := users.PurgeDeletedUsers(cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	purgedProducts, err := products.PurgeDeletedProducts(cutoff)
+	if err != nil {
+		return purgedUsers, 0, err
+	}
+	return purgedUsers, purgedProducts, nil
+}
+
+// RunSoftDeletePurge periodically purges users and products deleted more than
+// retention ago
+func RunSoftDeletePurge(ctx context.Context, interval, retention time.Duration, users *UserRepository, products *ProductRepository) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	LogInfo("Soft delete purge started", logrus.Fields{
+		"interval":  interval.String(),
+		"retention": retention.String(),
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgedUsers, purgedProducts, err := PurgeDeleted(users, products, retention)
+			if err != nil {
+				LogError("Failed to purge deleted users and products", logrus.Fields{"error": err.Error()}, err)
+				continue
+			}
+			if purgedUsers > 0 || purgedProducts > 0 {
+				LogInfo("Purged deleted users and products", logrus.Fields{
+					"users":    purgedUsers,
+					"products": purgedProducts,
+				})
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -10,7 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
 )
 
 // User represents a user in the system
@@ -23,12 +24,14 @@ type User struct {
 	IsActive     bool      `json:"isActive" db:"is_active"`
 	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+	// DeletedAt is set while a user is deleted; they can be restored until purged
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
 }
 
 // CreateUserRequest represents the request body for creating a user
 type CreateUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	FullName string `json:"fullName" binding:"required"`
 	Role     string `json:"role" binding:"required"`
 }
@@ -43,28 +46,31 @@ type UpdateUserRequest struct {
 
 // ChangePasswordRequest represents the request body for changing password
 type ChangePasswordRequest struct {
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 // UserRepository handles database operations for users
 type UserRepository struct {
-	db *sql.DB
+	db        *sql.DB
+	passwords *PasswordService
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+func NewUserRepository(db *sql.DB, passwords *PasswordService) *UserRepository {
+	return &UserRepository{db: db, passwords: passwords}
 }
 
-// GetAllUsers retrieves all users with pagination and search
-func (r *UserRepository) GetAllUsers(page, limit int, search, role string) ([]User, int, error) {
-	offset := (page - 1) * limit
-	
-	// Build WHERE clause
+// userFilterWhere builds the WHERE clause for the user list filters. Deleted
+// users are left out unless includeDeleted is set.
+func userFilterWhere(search, role string, includeDeleted bool) (string, []interface{}) {
 	whereClause := "WHERE 1=1"
 	args := []interface{}{}
 	argIndex := 1
 
+	if !includeDeleted {
+		whereClause += " AND deleted_at IS NULL"
+	}
+
 	if search != "" {
 		whereClause += fmt.Sprintf(" AND (full_name ILIKE $%d OR email ILIKE $%d)", argIndex, argIndex+1)
 		searchPattern := "%" + search + "%"
@@ -75,8 +81,17 @@ func (r *UserRepository) GetAllUsers(page, limit int, search, role string) ([]Us
 	if role != "" {
 		whereClause += fmt.Sprintf(" AND role = $%d", argIndex)
 		args = append(args, role)
-		argIndex++
 	}
+	return whereClause, args
+}
+
+// GetAllUsers retrieves all users with pagination and search
+func (r *UserRepository) GetAllUsers(page, limit int, search, role string, includeDeleted bool) ([]User, int, error) {
+	offset := (page - 1) * limit
+	
+	// Build WHERE clause
+	whereClause, args := userFilterWhere(search, role, includeDeleted)
+	argIndex := len(args) + 1
 
 	// Get total count
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM users %s", whereClause)
@@ -90,7 +105,7 @@ func (r *UserRepository) GetAllUsers(page, limit int, search, role string) ([]Us
 	query := fmt.Sprintf(`
 		SELECT id, email, full_name, role, 
 		       COALESCE(is_active, true) as is_active, 
-		       created_at, updated_at 
+		       created_at, updated_at, deleted_at
 		FROM users %s 
 		ORDER BY created_at DESC 
 		LIMIT $%d OFFSET $%d
@@ -113,7 +128,7 @@ func (r *UserRepository) GetAllUsers(page, limit int, search, role string) ([]Us
 		var user User
 		err := rows.Scan(
 			&user.ID, &user.Email, &user.FullName, &user.Role,
-			&user.IsActive, &user.CreatedAt, &user.UpdatedAt,
+			&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt,
 		)
 		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
@@ -124,20 +139,21 @@ func (r *UserRepository) GetAllUsers(page, limit int, search, role string) ([]Us
 	return users, total, nil
 }
 
-// GetUserByID retrieves a user by ID
-func (r *UserRepository) GetUserByID(id string) (*User, error) {
+// GetUserByID retrieves a user by ID, finding deleted users only when
+// includeDeleted is set
+func (r *UserRepository) GetUserByID(id string, includeDeleted bool) (*User, error) {
 	query := `
 		SELECT id, email, full_name, role, 
 		       COALESCE(is_active, true) as is_active, 
-		       created_at, updated_at 
+		       created_at, updated_at, deleted_at
 		FROM users 
-		WHERE id = $1
+		WHERE id = $1 AND ($2 OR deleted_at IS NULL)
 	`
 
 	var user User
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRow(query, id, includeDeleted).Scan(
 		&user.ID, &user.Email, &user.FullName, &user.Role,
-		&user.IsActive, &user.CreatedAt, &user.UpdatedAt,
+		&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -151,10 +167,10 @@ func (r *UserRepository) GetUserByID(id string) (*User, error) {
 
 // CreateUser creates a new user
 func (r *UserRepository) CreateUser(req CreateUserRequest) (*User, error) {
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// Check the password against the policy and hash it
+	hashedPassword, err := r.passwords.HashNew(r.db, PasswordAccountUser, "", req.Password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, err
 	}
 
 	// Generate UUID
@@ -170,7 +186,7 @@ func (r *UserRepository) CreateUser(req CreateUserRequest) (*User, error) {
 	`
 
 	var user User
-	err = r.db.QueryRow(query, userID, req.Email, string(hashedPassword), req.FullName, req.Role).Scan(
+	err = r.db.QueryRow(query, userID, req.Email, hashedPassword, req.FullName, req.Role).Scan(
 		&user.ID, &user.Email, &user.FullName, &user.Role,
 		&user.IsActive, &user.CreatedAt, &user.UpdatedAt,
 	)
@@ -181,13 +197,17 @@ func (r *UserRepository) CreateUser(req CreateUserRequest) (*User, error) {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	if err := r.passwords.Remember(r.db, PasswordAccountUser, user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
 // UpdateUser updates an existing user
 func (r *UserRepository) UpdateUser(id string, req UpdateUserRequest) (*User, error) {
 	// Check if user exists
-	_, err := r.GetUserByID(id)
+	_, err := r.GetUserByID(id, false)
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +247,7 @@ func (r *UserRepository) UpdateUser(id string, req UpdateUserRequest) (*User, er
 	query := fmt.Sprintf(`
 		UPDATE users 
 		SET %s 
-		WHERE id = $%d
+		WHERE id = $%d AND deleted_at IS NULL
 		RETURNING id, email, full_name, role, 
 		          COALESCE(is_active, true) as is_active, 
 		          created_at, updated_at
@@ -248,16 +268,32 @@ func (r *UserRepository) UpdateUser(id string, req UpdateUserRequest) (*User, er
 	return &user, nil
 }
 
-// DeleteUser deletes a user
+// DeleteUser soft-deletes a user and signs them out. The email stays taken
+// until the user is restored or purged.
 func (r *UserRepository) DeleteUser(id string) error {
 	// Check if user exists
-	_, err := r.GetUserByID(id)
+	_, err := r.GetUserByID(id, false)
 	if err != nil {
 		return err
 	}
 
-	query := "DELETE FROM users WHERE id = $1"
-	result, err := r.db.Exec(query, id)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			// Rollback error is expected when transaction was committed successfully
+			if rollbackErr.Error() != "sql: transaction has already been committed or rolled back" {
+				LogError("Failed to rollback transaction in DeleteUser", logrus.Fields{
+					"error": rollbackErr.Error(),
+				}, rollbackErr)
+			}
+		}
+	}()
+
+	query := "UPDATE users SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL"
+	result, err := tx.Exec(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
@@ -271,13 +307,61 @@ func (r *UserRepository) DeleteUser(id string) error {
 		return fmt.Errorf("user not found")
 	}
 
+	// End their customer sessions and drop unused verification and reset links
+	_, err = tx.Exec("UPDATE customer_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM customer_tokens WHERE user_id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
+// RestoreUser undoes the deletion of a user. Their sessions stay ended.
+func (r *UserRepository) RestoreUser(id string) (*User, error) {
+	query := "UPDATE users SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NOT NULL"
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return r.GetUserByID(id, false)
+}
+
+// PurgeDeletedUsers permanently removes users deleted before cutoff. Users
+// that bookings or projects refer to are kept.
+func (r *UserRepository) PurgeDeletedUsers(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(`
+		DELETE FROM users u
+		WHERE u.deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM bookings b WHERE b.user_id = u.id)
+		AND NOT EXISTS (SELECT 1 FROM projects p WHERE p.client_id = u.id)
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+	return result.RowsAffected()
+}
+
 // ToggleUserStatus toggles user active status
 func (r *UserRepository) ToggleUserStatus(id string) (*User, error) {
 	// Get current user
-	user, err := r.GetUserByID(id)
+	user, err := r.GetUserByID(id, false)
 	if err != nil {
 		return nil, err
 	}
@@ -288,7 +372,7 @@ func (r *UserRepository) ToggleUserStatus(id string) (*User, error) {
 	query := `
 		UPDATE users 
 		SET is_active = $1, updated_at = CURRENT_TIMESTAMP 
-		WHERE id = $2
+		WHERE id = $2 AND deleted_at IS NULL
 		RETURNING id, email, full_name, role, 
 		          COALESCE(is_active, true) as is_active, 
 		          created_at, updated_at
@@ -309,34 +393,35 @@ func (r *UserRepository) ToggleUserStatus(id string) (*User, error) {
 // ChangeUserPassword changes user password
 func (r *UserRepository) ChangeUserPassword(id string, newPassword string) error {
 	// Check if user exists
-	_, err := r.GetUserByID(id)
+	_, err := r.GetUserByID(id, false)
 	if err != nil {
 		return err
 	}
 
-	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	// Check the new password against the policy and recent passwords, and hash it
+	hashedPassword, err := r.passwords.HashNew(r.db, PasswordAccountUser, id, newPassword)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return err
 	}
 
-	query := "UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
-	_, err = r.db.Exec(query, string(hashedPassword), id)
+	query := "UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND deleted_at IS NULL"
+	_, err = r.db.Exec(query, hashedPassword, id)
 	if err != nil {
 		return fmt.Errorf("failed to change password: %w", err)
 	}
 
-	return nil
+	return r.passwords.Remember(r.db, PasswordAccountUser, id, hashedPassword)
 }
 
 // UserHandler handles user-related HTTP requests
 type UserHandler struct {
-	repo *UserRepository
+	repo    *UserRepository
+	invites *CustomerAuthService
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(repo *UserRepository) *UserHandler {
-	return &UserHandler{repo: repo}
+func NewUserHandler(repo *UserRepository, invites *CustomerAuthService) *UserHandler {
+	return &UserHandler{repo: repo, invites: invites}
 }
 
 // GetUsers handles GET /api/users
@@ -360,7 +445,7 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 	}
 
 	fmt.Printf("DEBUG: Calling GetAllUsers...\n")
-	users, total, err := h.repo.GetAllUsers(page, limit, search, role)
+	users, total, err := h.repo.GetAllUsers(page, limit, search, role, includeDeleted(c))
 	if err != nil {
 		fmt.Printf("DEBUG: Error from GetAllUsers: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -392,7 +477,7 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 func (h *UserHandler) GetUser(c *gin.Context) {
 	id := c.Param("id")
 
-	user, err := h.repo.GetUserByID(id)
+	user, err := h.repo.GetUserByID(id, includeDeleted(c))
 	if err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -429,6 +514,13 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 	user, err := h.repo.CreateUser(req)
 	if err != nil {
+		if errors.Is(err, ErrPasswordPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 		if err.Error() == "email already exists" {
 			c.JSON(http.StatusConflict, gin.H{
 				"success": false,
@@ -444,6 +536,9 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	setAuditResourceID(c, user.ID)
+	auditChange(c, nil, user)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
 		"data":    user,
@@ -465,6 +560,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Keep the user as it was for the audit log
+	before, _ := h.repo.GetUserByID(id, false)
+
 	user, err := h.repo.UpdateUser(id, req)
 	if err != nil {
 		if err.Error() == "user not found" {
@@ -489,6 +587,10 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if before != nil {
+		auditChange(c, before, user)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    user,
@@ -500,6 +602,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 
+	// Keep the user as it was for the audit log
+	before, _ := h.repo.GetUserByID(id, false)
+
 	err := h.repo.DeleteUser(id)
 	if err != nil {
 		if err.Error() == "user not found" {
@@ -517,6 +622,10 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if before != nil {
+		auditChange(c, before, nil)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "User deleted successfully",
@@ -527,6 +636,9 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 func (h *UserHandler) ToggleUserStatus(c *gin.Context) {
 	id := c.Param("id")
 
+	// Keep the user as it was for the audit log
+	before, _ := h.repo.GetUserByID(id, false)
+
 	user, err := h.repo.ToggleUserStatus(id)
 	if err != nil {
 		if err.Error() == "user not found" {
@@ -544,6 +656,10 @@ func (h *UserHandler) ToggleUserStatus(c *gin.Context) {
 		return
 	}
 
+	if before != nil {
+		auditChange(c, before, user)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    user,
@@ -567,6 +683,13 @@ func (h *UserHandler) ChangeUserPassword(c *gin.Context) {
 
 	err := h.repo.ChangeUserPassword(id, req.Password)
 	if err != nil {
+		if errors.Is(err, ErrPasswordPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{
 				"success": false,
@@ -586,4 +709,38 @@ func (h *UserHandler) ChangeUserPassword(c *gin.Context) {
 		"success": true,
 		"message": "Password changed successfully",
 	})
-}
\ No newline at end of file
+}
+// RestoreUser handles POST /api/users/:id/restore
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	id := c.Param("id")
+
+	// Keep the user as it was for the audit log
+	before, _ := h.repo.GetUserByID(id, true)
+
+	user, err := h.repo.RestoreUser(id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Deleted user not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to restore user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if before != nil {
+		auditChange(c, before, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    user,
+		"message": "User restored successfully",
+	})
+}
@@ -12,10 +12,19 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
+// newWebSocketUpgrader accepts browsers from origins only. Requests without an
+// Origin header come from non-browser clients, which authenticate with a token.
+func newWebSocketUpgrader(origins []string) *websocket.Upgrader {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || allowed[origin]
+		},
+	}
 }
 
 type Client struct {
@@ -41,7 +50,7 @@ type OrderUpdate struct {
 	ID            string    `json:"id"`
 	Status        string    `json:"status"`
 	PaymentStatus string    `json:"payment_status"`
-	TotalAmount   float64   `json:"total_amount"`
+	TotalAmount   Money     `json:"total_amount"`
 	Customer      string    `json:"customer"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -195,24 +204,29 @@ func (c *Client) writePump() {
 	}
 }
 
-func handleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		logrus.Error("WebSocket upgrade failed", logrus.Fields{
-			"error": err.Error(),
-		})
-		return
-	}
+// WebSocketHandler handles GET /ws, streaming order and dashboard updates to
+// admins. AuthorizeRoutes has already checked the token or panel cookie.
+func WebSocketHandler(origins []string) gin.HandlerFunc {
+	upgrader := newWebSocketUpgrader(origins)
+	return func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			logrus.Error("WebSocket upgrade failed", logrus.Fields{
+				"error": err.Error(),
+			})
+			return
+		}
 
-	client := &Client{
-		conn: conn,
-		send: make(chan []byte, 256),
-	}
+		client := &Client{
+			conn: conn,
+			send: make(chan []byte, 256),
+		}
 
-	hub.register <- client
+		hub.register <- client
 
-	go client.writePump()
-	go client.readPump()
+		go client.writePump()
+		go client.readPump()
+	}
 }
 
 func BroadcastOrderUpdate(orderUpdate OrderUpdate) {
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSocketUpgraderChecksOrigin(t *testing.T) {
+	upgrader := newWebSocketUpgrader([]string{"http://localhost:9005"})
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "http://localhost:9005", want: true},
+		{origin: "", want: true},
+		{origin: "https://evil.example", want: false},
+		{origin: "http://localhost:9005.evil.example", want: false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := upgrader.CheckOrigin(req); got != tt.want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestWebSocketRequiresDashboardPermission(t *testing.T) {
+	perm, ok := routePermission(http.MethodGet, "/ws")
+	if !ok || perm != PermDashboardRead {
+		t.Fatalf("GET /ws policy = %q, want %q", perm, PermDashboardRead)
+	}
+}
@@ -0,0 +1,27 @@
+-- Add payment link expiry to orders
+-- payment_token holds the Mayar payment request ID, payment_url the checkout link
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_name = 'orders'
+        AND column_name = 'payment_expires_at'
+    ) THEN
+        ALTER TABLE orders ADD COLUMN payment_expires_at TIMESTAMP WITH TIME ZONE;
+    END IF;
+END $$;
+
+-- Webhooks resolve orders by their payment request ID
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_indexes
+        WHERE tablename = 'orders'
+        AND indexname = 'idx_orders_payment_token'
+    ) THEN
+        CREATE INDEX idx_orders_payment_token ON orders(payment_token);
+    END IF;
+END $$;
@@ -0,0 +1,27 @@
+-- Record which payment gateway an order is paid through
+-- Values match PaymentGateway.Name(): mayar, manual_transfer, fake
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_name = 'orders'
+        AND column_name = 'payment_gateway'
+    ) THEN
+        ALTER TABLE orders ADD COLUMN payment_gateway VARCHAR(50);
+    END IF;
+END $$;
+
+-- Manual transfers are matched by unique code among unpaid orders
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_indexes
+        WHERE tablename = 'orders'
+        AND indexname = 'idx_orders_unique_code'
+    ) THEN
+        CREATE INDEX idx_orders_unique_code ON orders(unique_code) WHERE unique_code IS NOT NULL;
+    END IF;
+END $$;
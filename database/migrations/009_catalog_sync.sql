@@ -0,0 +1,24 @@
+-- Catalog sync between local products and Mayar products
+-- Closed products stay in the catalog but are marked inactive
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_name = 'products'
+        AND column_name = 'is_active'
+    ) THEN
+        ALTER TABLE products ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT true;
+    END IF;
+END $$;
+
+-- One Mayar product per local product
+CREATE TABLE IF NOT EXISTS mayar_product_mappings (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL UNIQUE REFERENCES products(id) ON DELETE CASCADE,
+    mayar_product_id VARCHAR(255) NOT NULL UNIQUE,
+    last_synced_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
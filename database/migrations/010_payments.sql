@@ -0,0 +1,46 @@
+-- Payment attempts, many per order
+-- orders.payment_status, payment_method and paid_at are derived from these rows
+
+CREATE TABLE IF NOT EXISTS payments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    gateway VARCHAR(50) NOT NULL,
+    external_id VARCHAR(255),
+    amount DECIMAL(12, 2) NOT NULL,
+    refunded_amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    payment_method VARCHAR(100),
+    payment_url TEXT,
+    raw_response JSONB,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    failed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments(order_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_payments_status ON payments(status);
+
+-- Webhooks resolve attempts by their gateway reference
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_indexes
+        WHERE tablename = 'payments'
+        AND indexname = 'idx_payments_gateway_external_id'
+    ) THEN
+        CREATE UNIQUE INDEX idx_payments_gateway_external_id ON payments(gateway, external_id) WHERE external_id IS NOT NULL;
+    END IF;
+END $$;
+
+-- Backfill one attempt for orders that already had a payment
+INSERT INTO payments (order_id, gateway, external_id, amount, status, payment_method, payment_url,
+                      expires_at, paid_at, created_at, updated_at)
+SELECT o.id, COALESCE(o.payment_gateway, 'mayar'), o.payment_token, o.total_amount + COALESCE(o.unique_code, 0),
+       o.payment_status, o.payment_method, o.payment_url, o.payment_expires_at, o.paid_at, o.created_at, o.updated_at
+FROM orders o
+WHERE (o.payment_token IS NOT NULL OR o.payment_status <> 'pending')
+AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id)
+ON CONFLICT DO NOTHING;
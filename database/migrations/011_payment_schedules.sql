@@ -0,0 +1,52 @@
+-- Down payments and installments
+-- An order with installments is paid in parts; without installments it is paid in full
+
+-- Default deposit for orders containing the product, e.g. 50 for branding packages
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_name = 'products'
+        AND column_name = 'deposit_percent'
+    ) THEN
+        ALTER TABLE products ADD COLUMN deposit_percent INTEGER CHECK (deposit_percent > 0 AND deposit_percent < 100);
+    END IF;
+END $$;
+
+-- Each installment has its own payment link or unique code
+CREATE TABLE IF NOT EXISTS order_installments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    sequence INTEGER NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    milestone VARCHAR(50) NOT NULL, -- 'upfront', 'progress', 'delivery'
+    percent INTEGER NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    payment_gateway VARCHAR(50),
+    payment_token VARCHAR(255),
+    payment_url TEXT,
+    payment_expires_at TIMESTAMP WITH TIME ZONE,
+    unique_code INTEGER,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (order_id, sequence)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_installments_payment_token ON order_installments(payment_token);
+CREATE INDEX IF NOT EXISTS idx_order_installments_status ON order_installments(status);
+
+-- Payment attempts for an installment point at it
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_name = 'payments'
+        AND column_name = 'installment_id'
+    ) THEN
+        ALTER TABLE payments ADD COLUMN installment_id UUID REFERENCES order_installments(id) ON DELETE SET NULL;
+    END IF;
+END $$;
@@ -0,0 +1,23 @@
+-- Refresh tokens for admin sessions
+-- Each refresh token is single use; refreshing issues a new one in the same family.
+-- Presenting a used token again revokes the whole family.
+
+CREATE TABLE IF NOT EXISTS admin_refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    admin_user_id UUID NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    access_token_id UUID REFERENCES admin_tokens(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    session_expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    replaced_by UUID REFERENCES admin_refresh_tokens(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_reason VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_refresh_tokens_family_id ON admin_refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_admin_refresh_tokens_admin_user_id ON admin_refresh_tokens(admin_user_id);
+CREATE INDEX IF NOT EXISTS idx_admin_refresh_tokens_access_token_id ON admin_refresh_tokens(access_token_id);
+CREATE INDEX IF NOT EXISTS idx_admin_refresh_tokens_session_expires_at ON admin_refresh_tokens(session_expires_at);
@@ -0,0 +1,20 @@
+-- Admin roles for role-based access control
+-- The permissions each role grants are defined in the backend (rbac.go).
+
+-- Accounts with a role the backend does not know get read-only access
+UPDATE admin_users SET role = 'viewer'
+WHERE role NOT IN ('owner', 'admin', 'designer', 'finance', 'viewer');
+
+-- Make the oldest active admin the owner when there is none, so refunds stay possible
+UPDATE admin_users SET role = 'owner'
+WHERE id = (
+    SELECT id FROM admin_users
+    WHERE is_active = true AND role = 'admin'
+    ORDER BY created_at
+    LIMIT 1
+)
+AND NOT EXISTS (SELECT 1 FROM admin_users WHERE role = 'owner');
+
+ALTER TABLE admin_users DROP CONSTRAINT IF EXISTS admin_users_role_check;
+ALTER TABLE admin_users ADD CONSTRAINT admin_users_role_check
+    CHECK (role IN ('owner', 'admin', 'designer', 'finance', 'viewer'));
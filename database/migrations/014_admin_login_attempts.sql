@@ -0,0 +1,15 @@
+-- Admin login attempts, for brute-force throttling and account lockout
+-- outcome is 'success', 'failure' or 'unlocked'. An 'unlocked' row
+-- is written when an admin clears a lockout; failures before it no longer count.
+-- username is stored lowercased and is kept even when no such account exists.
+
+CREATE TABLE IF NOT EXISTS admin_login_attempts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username VARCHAR(100),
+    client_ip VARCHAR(64),
+    outcome VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_login_attempts_username ON admin_login_attempts(username, created_at);
+CREATE INDEX IF NOT EXISTS idx_admin_login_attempts_client_ip ON admin_login_attempts(client_ip, created_at);
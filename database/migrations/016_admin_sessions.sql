@@ -0,0 +1,25 @@
+-- Admin sessions, one per login; the id is the family_id of the session's refresh tokens
+-- and the sid claim of its access tokens. ip_address and user_agent are the latest seen.
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id UUID PRIMARY KEY,
+    admin_user_id UUID NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    device VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_admin_user_id ON admin_sessions(admin_user_id);
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_expires_at ON admin_sessions(expires_at);
+
+-- Sessions started before this table existed
+INSERT INTO admin_sessions (id, admin_user_id, created_at, last_used_at, expires_at)
+SELECT family_id, admin_user_id, MIN(created_at), MAX(created_at), MAX(session_expires_at)
+FROM admin_refresh_tokens
+GROUP BY family_id, admin_user_id
+HAVING BOOL_OR(revoked_at IS NULL)
+ON CONFLICT (id) DO NOTHING;
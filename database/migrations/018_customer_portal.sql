@@ -0,0 +1,30 @@
+-- Passwordless order portal
+-- A customer asks for a magic link for the email address on their orders; the
+-- link signs them into a portal session that only sees orders with that email.
+
+-- Issued magic links. The link itself is a signed token naming the row's id;
+-- the row makes it single use and lets requests per email be limited.
+CREATE TABLE IF NOT EXISTS portal_links (
+    id UUID PRIMARY KEY,
+    customer_email VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_portal_links_email ON portal_links(customer_email, created_at);
+
+-- Portal sign-ins; a portal token is only valid while its session is
+CREATE TABLE IF NOT EXISTS portal_sessions (
+    id UUID PRIMARY KEY,
+    customer_email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_portal_sessions_expires_at ON portal_sessions(expires_at);
+
+-- Portal lookups match orders by email regardless of case
+CREATE INDEX IF NOT EXISTS idx_orders_customer_email_lower ON orders(LOWER(customer_email));
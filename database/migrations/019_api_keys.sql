@@ -0,0 +1,22 @@
+-- API keys for integrations and automation
+-- A key is "usk_<prefix>_<secret>". Only the prefix, which identifies the key
+-- in lists and logs, and a SHA-256 hash of the whole key are stored.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL UNIQUE,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    -- Permissions the key may use, a subset of its creator's role
+    scopes TEXT[] NOT NULL,
+    -- IP addresses or CIDR ranges the key may be used from; empty allows any
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    created_by UUID NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    last_used_ip VARCHAR(45),
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_created_by ON api_keys(created_by);
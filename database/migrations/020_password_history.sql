@@ -0,0 +1,12 @@
+-- Previous password hashes, so the password policy can refuse recently used passwords
+-- account_type names the table the account lives in (admin_users or users).
+
+CREATE TABLE IF NOT EXISTS password_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    account_type VARCHAR(20) NOT NULL CHECK (account_type IN ('admin_users', 'users')),
+    account_id UUID NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_account ON password_history(account_type, account_id, created_at DESC);
@@ -0,0 +1,6 @@
+-- CSRF tokens of the server-rendered admin panel
+-- The panel keeps its session in cookies, so every form and fetch call also
+-- sends a per-session token that a cross-site request cannot know. Only its
+-- hash is stored, like the session's other tokens.
+
+ALTER TABLE admin_sessions ADD COLUMN IF NOT EXISTS csrf_token_hash VARCHAR(64);
@@ -0,0 +1,29 @@
+-- Data-subject requests under the personal data protection law (UU PDP)
+-- Every export or erasure of a customer's personal data adds one row, including
+-- dry runs and requests that failed. The subject is kept as a SHA-256 hash of
+-- their normalised email, so the log itself does not undo an erasure.
+
+CREATE TABLE IF NOT EXISTS data_subject_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    request_type VARCHAR(20) NOT NULL CHECK (request_type IN ('export', 'erasure')),
+    dry_run BOOLEAN NOT NULL DEFAULT false,
+    subject_hash VARCHAR(64) NOT NULL,
+    subject_user_id UUID,
+    -- Why the request was made, e.g. a ticket reference
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'processing' CHECK (status IN ('processing', 'completed', 'failed')),
+    -- Counts of what was exported or erased
+    summary JSONB,
+    error TEXT,
+    requested_by UUID,
+    requested_by_name VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_subject_requests_created_at ON data_subject_requests(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_data_subject_requests_subject ON data_subject_requests(subject_hash);
+
+-- When an order's or account's personal data was anonymised
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS personal_data_erased_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS personal_data_erased_at TIMESTAMP WITH TIME ZONE;
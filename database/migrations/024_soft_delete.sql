@@ -0,0 +1,11 @@
+-- Soft delete for users and products
+-- Deleting sets deleted_at instead of removing the row, so a deletion can be
+-- undone and order items keep pointing at the product they were sold as.
+-- Rows deleted longer ago than SOFT_DELETE_RETENTION are purged, except
+-- products still referenced by order items and users with bookings or projects.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products(deleted_at) WHERE deleted_at IS NOT NULL;
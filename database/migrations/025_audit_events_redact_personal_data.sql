@@ -0,0 +1,29 @@
+-- Redact personal data in existing audit events
+-- Audit events are never erased, so they must not hold personal data. New
+-- events store contact details and notes as "[redacted]"; this applies the
+-- same to events written before, with the append-only trigger off for this
+-- one update.
+
+ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only;
+
+UPDATE audit_events
+SET changes = (
+    SELECT jsonb_object_agg(
+        field,
+        CASE
+            WHEN lower(field) ~ '(email|fullname|phone|address|notes)' AND jsonb_typeof(change) = 'object' THEN (
+                SELECT jsonb_object_agg(side, CASE WHEN value = 'null'::jsonb THEN value ELSE '"[redacted]"'::jsonb END)
+                FROM jsonb_each(change) AS sides(side, value)
+            )
+            ELSE change
+        END
+    )
+    FROM jsonb_each(changes) AS fields(field, change)
+)
+WHERE jsonb_typeof(changes) = 'object'
+  AND EXISTS (
+    SELECT 1 FROM jsonb_object_keys(changes) AS keys(field)
+    WHERE lower(field) ~ '(email|fullname|phone|address|notes)'
+  );
+
+ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only;